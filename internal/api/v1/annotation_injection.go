@@ -0,0 +1,122 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"errors"
+	"path"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// The annotations that request a proxy for a pod without an AuthProxyWorkload.
+// They are set on the pod template of a workload. The prefix keeps them from
+// colliding with the annotation of an AuthProxyWorkload, see
+// DisableInjectionAnnotation.
+const (
+	// InstancesAnnotation lists the instances, separated by commas. Each
+	// instance is a connection string followed by `=` and either a port
+	// number, the name of the env var that holds the port, or an absolute
+	// unix socket path, like `proj:region:db=5432`.
+	InstancesAnnotation = "injection." + AnnotationPrefix + "/instances"
+
+	// ProxyTypeAnnotation sets the ProxyType, CloudSQL or AlloyDB.
+	ProxyTypeAnnotation = "injection." + AnnotationPrefix + "/proxy-type"
+
+	// ImageAnnotation sets the proxy container image.
+	ImageAnnotation = "injection." + AnnotationPrefix + "/image"
+
+	// AnnotationProxyName is the name of the AuthProxyWorkload built from the
+	// annotations.
+	AnnotationProxyName = "pod-annotations"
+)
+
+// AuthProxyWorkloadFromAnnotations builds an in-memory AuthProxyWorkload in
+// namespace ns from the injection annotations an. It returns nil when
+// InstancesAnnotation is not set. The result is validated with the same rules
+// as the instances and container of an AuthProxyWorkload resource.
+func AuthProxyWorkloadFromAnnotations(ns string, an map[string]string) (*AuthProxyWorkload, error) {
+	v, ok := an[InstancesAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	f := field.NewPath("metadata", "annotations")
+	instances, allErrs := parseInstancesAnnotation(v, f.Key(InstancesAnnotation))
+
+	var c *AuthProxyContainerSpec
+	pt, hasType := an[ProxyTypeAnnotation]
+	img, hasImage := an[ImageAnnotation]
+	if hasType || hasImage {
+		c = &AuthProxyContainerSpec{
+			ProxyType: strings.TrimSpace(pt),
+			Image:     strings.TrimSpace(img),
+		}
+	}
+	if c != nil && c.ProxyType != "" && c.ProxyType != ProxyTypeCloudSQL && c.ProxyType != ProxyTypeAlloyDB {
+		allErrs = append(allErrs, field.NotSupported(f.Key(ProxyTypeAnnotation), c.ProxyType,
+			[]string{ProxyTypeCloudSQL, ProxyTypeAlloyDB}))
+	}
+
+	allErrs = append(allErrs, validateInstances(&instances, nil, proxyType(c), f.Key(InstancesAnnotation))...)
+	if len(allErrs) > 0 {
+		return nil, allErrs.ToAggregate()
+	}
+
+	return &AuthProxyWorkload{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: AnnotationProxyName},
+		Spec: AuthProxyWorkloadSpec{
+			Workload:           WorkloadSelectorSpec{Kind: "Pod"},
+			Instances:          instances,
+			AuthProxyContainer: c,
+		},
+	}, nil
+}
+
+// parseInstancesAnnotation reads the value of InstancesAnnotation.
+func parseInstancesAnnotation(v string, f *field.Path) ([]InstanceSpec, field.ErrorList) {
+	var (
+		instances []InstanceSpec
+		errs      field.ErrorList
+	)
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		cs, target, _ := strings.Cut(item, "=")
+		inst := InstanceSpec{ConnectionString: strings.TrimSpace(cs)}
+		target = strings.TrimSpace(target)
+
+		switch port, err := strconv.ParseInt(target, 10, 32); {
+		case target == "":
+			// validateInstances reports the missing port.
+		case path.IsAbs(target):
+			inst.UnixSocketPath = target
+		case err == nil:
+			p := int32(port)
+			inst.Port = &p
+		case errors.Is(err, strconv.ErrRange):
+			errs = append(errs, field.Invalid(f, item, "port is out of range"))
+		default:
+			inst.PortEnvName = target
+		}
+		instances = append(instances, inst)
+	}
+	return instances, errs
+}
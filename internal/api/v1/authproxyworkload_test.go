@@ -20,9 +20,10 @@ import (
 	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
-func ptr[T int | int32 | int64 | string | bool](i T) *T {
+func ptr[T int | int32 | int64 | string | bool | intstr.IntOrString](i T) *T {
 	return &i
 }
 
@@ -140,6 +141,62 @@ func TestAuthProxyWorkload_ValidateCreate_InstanceSpec(t *testing.T) {
 	}
 
 }
+func TestAuthProxyWorkload_ValidateCreate_ConnectionString(t *testing.T) {
+	data := []struct {
+		desc      string
+		connStrs  []string
+		wantField string
+	}{
+		{desc: "Valid, project:region:instance", connStrs: []string{"my-project:us-central1:my-db"}},
+		{desc: "Valid, domain-scoped project", connStrs: []string{"example.com:my-project:us-central1:my-db"}},
+		{desc: "Valid, DNS name", connStrs: []string{"prod-db.example.com"}},
+		{desc: "Valid, two instances", connStrs: []string{"proj:region:db1", "proj:region:db2"}},
+		{desc: "Invalid, empty", connStrs: []string{""}, wantField: "spec.instances[0].connectionString"},
+		{desc: "Invalid, missing region", connStrs: []string{"project:instance"}, wantField: "spec.instances[0].connectionString"},
+		{desc: "Invalid, empty instance", connStrs: []string{"project:region:"}, wantField: "spec.instances[0].connectionString"},
+		{desc: "Invalid, uppercase", connStrs: []string{"Project:region:db"}, wantField: "spec.instances[0].connectionString"},
+		{desc: "Invalid, too many parts", connStrs: []string{"a:b:c:d:e"}, wantField: "spec.instances[0].connectionString"},
+		{desc: "Invalid, single DNS label", connStrs: []string{"proddb"}, wantField: "spec.instances[0].connectionString"},
+		{desc: "Invalid, second instance", connStrs: []string{"proj:region:db1", "proj:region:"}, wantField: "spec.instances[1].connectionString"},
+		{desc: "Invalid, duplicate", connStrs: []string{"proj:region:db1", "proj:region:db1"}, wantField: "spec.instances[1].connectionString"},
+	}
+	for _, tc := range data {
+		t.Run(tc.desc, func(t *testing.T) {
+			p := cloudsqlapi.AuthProxyWorkload{
+				ObjectMeta: v1.ObjectMeta{Name: "sample"},
+				Spec: cloudsqlapi.AuthProxyWorkloadSpec{
+					Workload: cloudsqlapi.WorkloadSelectorSpec{
+						Kind: "Deployment",
+						Name: "webapp",
+					},
+				},
+			}
+			for _, cs := range tc.connStrs {
+				p.Spec.Instances = append(p.Spec.Instances, cloudsqlapi.InstanceSpec{
+					ConnectionString: cs,
+					PortEnvName:      "DB_PORT",
+				})
+			}
+			_, err := p.ValidateCreate()
+			if tc.wantField == "" {
+				if err != nil {
+					t.Errorf("wants create valid, got error %v", err)
+					printFieldErrors(t, err)
+				}
+				return
+			}
+			statusErr, ok := err.(*apierrors.StatusError)
+			if !ok {
+				t.Fatalf("got error %v, want a StatusError", err)
+			}
+			causes := statusErr.Status().Details.Causes
+			if len(causes) != 1 || causes[0].Field != tc.wantField {
+				t.Errorf("got causes %v, want one error on %s", causes, tc.wantField)
+			}
+		})
+	}
+}
+
 func TestAuthProxyWorkload_ValidateCreate_WorkloadSpec(t *testing.T) {
 	data := []struct {
 		desc      string
@@ -188,6 +245,17 @@ func TestAuthProxyWorkload_ValidateCreate_WorkloadSpec(t *testing.T) {
 			},
 			wantValid: true,
 		},
+		{
+			desc: "Invalid, namespaceSelector set on an AuthProxyWorkload",
+			spec: cloudsqlapi.WorkloadSelectorSpec{
+				Kind: "Deployment",
+				Name: "webapp",
+				NamespaceSelector: &v1.LabelSelector{
+					MatchLabels: map[string]string{"env": "prod"},
+				},
+			},
+			wantValid: false,
+		},
 	}
 
 	for _, tc := range data {
@@ -218,6 +286,159 @@ func TestAuthProxyWorkload_ValidateCreate_WorkloadSpec(t *testing.T) {
 		})
 	}
 }
+func TestClusterAuthProxyWorkload_ValidateCreate_WorkloadSpec(t *testing.T) {
+	data := []struct {
+		desc      string
+		spec      cloudsqlapi.WorkloadSelectorSpec
+		wantValid bool
+	}{
+		{
+			desc: "Valid, no namespaceSelector",
+			spec: cloudsqlapi.WorkloadSelectorSpec{
+				Kind: "Deployment",
+				Selector: &v1.LabelSelector{
+					MatchLabels: map[string]string{"app": "sample"},
+				},
+			},
+			wantValid: true,
+		},
+		{
+			desc: "Valid, namespaceSelector with matchLabels",
+			spec: cloudsqlapi.WorkloadSelectorSpec{
+				Kind: "Deployment",
+				Selector: &v1.LabelSelector{
+					MatchLabels: map[string]string{"app": "sample"},
+				},
+				NamespaceSelector: &v1.LabelSelector{
+					MatchLabels: map[string]string{"env": "prod"},
+				},
+			},
+			wantValid: true,
+		},
+		{
+			desc: "Invalid, namespaceSelector with a bad operator",
+			spec: cloudsqlapi.WorkloadSelectorSpec{
+				Kind: "Deployment",
+				Name: "webapp",
+				NamespaceSelector: &v1.LabelSelector{
+					MatchExpressions: []v1.LabelSelectorRequirement{{
+						Key:      "env",
+						Operator: "Bogus",
+					}},
+				},
+			},
+			wantValid: false,
+		},
+	}
+
+	for _, tc := range data {
+		t.Run(tc.desc, func(t *testing.T) {
+			p := cloudsqlapi.ClusterAuthProxyWorkload{
+				ObjectMeta: v1.ObjectMeta{Name: "sample"},
+				Spec: cloudsqlapi.AuthProxyWorkloadSpec{
+					Workload: tc.spec,
+					Instances: []cloudsqlapi.InstanceSpec{{
+						ConnectionString: "proj:region:db2",
+						Port:             ptr(int32(2443)),
+					}},
+				},
+			}
+			p.Default()
+			_, err := p.ValidateCreate()
+			gotValid := err == nil
+			switch {
+			case tc.wantValid && !gotValid:
+				t.Errorf("wants create valid, got error %v", err)
+				printFieldErrors(t, err)
+			case !tc.wantValid && gotValid:
+				t.Errorf("wants an error on create, got no error")
+			default:
+				t.Logf("create passed %s", tc.desc)
+			}
+		})
+	}
+}
+
+func TestValidateCreate_RevisionHistoryLimit(t *testing.T) {
+	data := []struct {
+		desc      string
+		limit     *int32
+		cluster   bool
+		wantValid bool
+	}{
+		{desc: "Valid, not set", wantValid: true},
+		{desc: "Valid, limit set", limit: ptr(int32(3)), wantValid: true},
+		{desc: "Valid, no history", limit: ptr(int32(0)), wantValid: true},
+		{desc: "Invalid, negative limit", limit: ptr(int32(-1)), wantValid: false},
+		{desc: "Valid, not set on a ClusterAuthProxyWorkload", cluster: true, wantValid: true},
+		{desc: "Invalid, set on a ClusterAuthProxyWorkload", limit: ptr(int32(3)), cluster: true, wantValid: false},
+	}
+
+	for _, tc := range data {
+		t.Run(tc.desc, func(t *testing.T) {
+			spec := cloudsqlapi.AuthProxyWorkloadSpec{
+				Workload: cloudsqlapi.WorkloadSelectorSpec{
+					Kind: "Deployment",
+					Name: "webapp",
+				},
+				Instances: []cloudsqlapi.InstanceSpec{{
+					ConnectionString: "proj:region:db2",
+					Port:             ptr(int32(2443)),
+				}},
+				RevisionHistoryLimit: tc.limit,
+			}
+			var err error
+			if tc.cluster {
+				p := cloudsqlapi.ClusterAuthProxyWorkload{ObjectMeta: v1.ObjectMeta{Name: "sample"}, Spec: spec}
+				_, err = p.ValidateCreate()
+			} else {
+				p := cloudsqlapi.AuthProxyWorkload{ObjectMeta: v1.ObjectMeta{Name: "sample"}, Spec: spec}
+				_, err = p.ValidateCreate()
+			}
+			gotValid := err == nil
+			switch {
+			case tc.wantValid && !gotValid:
+				t.Errorf("wants create valid, got error %v", err)
+				printFieldErrors(t, err)
+			case !tc.wantValid && gotValid:
+				t.Errorf("wants an error on create, got no error")
+			}
+		})
+	}
+}
+
+func TestValidate_AnnotationProxyName(t *testing.T) {
+	spec := cloudsqlapi.AuthProxyWorkloadSpec{
+		Workload: cloudsqlapi.WorkloadSelectorSpec{
+			Kind: "Deployment",
+			Name: "webapp",
+		},
+		Instances: []cloudsqlapi.InstanceSpec{{
+			ConnectionString: "proj:region:db2",
+			Port:             ptr(int32(2443)),
+		}},
+	}
+	meta := v1.ObjectMeta{Name: cloudsqlapi.AnnotationProxyName}
+
+	p := cloudsqlapi.AuthProxyWorkload{ObjectMeta: meta, Spec: spec}
+	if _, err := p.ValidateCreate(); err == nil {
+		t.Errorf("wants an error on create, got no error")
+	}
+	cp := cloudsqlapi.ClusterAuthProxyWorkload{ObjectMeta: meta, Spec: spec}
+	if _, err := cp.ValidateCreate(); err == nil {
+		t.Errorf("wants an error on create of a ClusterAuthProxyWorkload, got no error")
+	}
+
+	// A resource created with the name before it was reserved can still be
+	// updated.
+	newP := p.DeepCopy()
+	newP.Spec.Workload.Name = "webapp2"
+	if _, err := newP.ValidateUpdate(&p); err != nil {
+		t.Errorf("wants update valid, got error %v", err)
+		printFieldErrors(t, err)
+	}
+}
+
 func TestAuthProxyWorkload_ValidateCreate_AuthProxyContainerSpec(t *testing.T) {
 	wantPort := int32(9393)
 
@@ -246,6 +467,24 @@ func TestAuthProxyWorkload_ValidateCreate_AuthProxyContainerSpec(t *testing.T) {
 			},
 			wantValid: true,
 		},
+		{
+			desc: "Valid, CredentialsFileSecret set",
+			spec: cloudsqlapi.AuthProxyContainerSpec{
+				Authentication: &cloudsqlapi.AuthenticationSpec{
+					CredentialsFileSecret: &cloudsqlapi.SecretKeyRef{Name: "proxy-creds", Key: "key.json"},
+				},
+			},
+			wantValid: true,
+		},
+		{
+			desc: "Invalid, CredentialsFileSecret with bad name and key",
+			spec: cloudsqlapi.AuthProxyContainerSpec{
+				Authentication: &cloudsqlapi.AuthenticationSpec{
+					CredentialsFileSecret: &cloudsqlapi.SecretKeyRef{Name: "Bad_Name", Key: "key/json"},
+				},
+			},
+			wantValid: false,
+		},
 		{
 			desc: "Invalid, Debug set without AdminPort",
 			spec: cloudsqlapi.AuthProxyContainerSpec{
@@ -274,6 +513,92 @@ func TestAuthProxyWorkload_ValidateCreate_AuthProxyContainerSpec(t *testing.T) {
 			},
 			wantValid: false,
 		},
+		{
+			desc: "Valid, Staged rollout with default batch size",
+			spec: cloudsqlapi.AuthProxyContainerSpec{
+				RolloutStrategy: cloudsqlapi.StagedStrategy,
+			},
+			wantValid: true,
+		},
+		{
+			desc: "Valid, Staged rollout with batch size",
+			spec: cloudsqlapi.AuthProxyContainerSpec{
+				RolloutStrategy: cloudsqlapi.StagedStrategy,
+				StagedRollout:   &cloudsqlapi.StagedRolloutSpec{BatchSize: ptr(intstr.FromInt32(5))},
+			},
+			wantValid: true,
+		},
+		{
+			desc: "Valid, Staged rollout with batch percentage",
+			spec: cloudsqlapi.AuthProxyContainerSpec{
+				RolloutStrategy: cloudsqlapi.StagedStrategy,
+				StagedRollout:   &cloudsqlapi.StagedRolloutSpec{BatchSize: ptr(intstr.FromString("25%"))},
+			},
+			wantValid: true,
+		},
+		{
+			desc: "Invalid, Staged rollout with zero batch size",
+			spec: cloudsqlapi.AuthProxyContainerSpec{
+				RolloutStrategy: cloudsqlapi.StagedStrategy,
+				StagedRollout:   &cloudsqlapi.StagedRolloutSpec{BatchSize: ptr(intstr.FromInt32(0))},
+			},
+			wantValid: false,
+		},
+		{
+			desc: "Invalid, Staged rollout with bad batch percentage",
+			spec: cloudsqlapi.AuthProxyContainerSpec{
+				RolloutStrategy: cloudsqlapi.StagedStrategy,
+				StagedRollout:   &cloudsqlapi.StagedRolloutSpec{BatchSize: ptr(intstr.FromString("120%"))},
+			},
+			wantValid: false,
+		},
+		{
+			desc: "Invalid, Staged rollout with batch size that is not a percentage",
+			spec: cloudsqlapi.AuthProxyContainerSpec{
+				RolloutStrategy: cloudsqlapi.StagedStrategy,
+				StagedRollout:   &cloudsqlapi.StagedRolloutSpec{BatchSize: ptr(intstr.FromString("five"))},
+			},
+			wantValid: false,
+		},
+		{
+			desc: "Invalid, StagedRollout set without Staged rollout strategy",
+			spec: cloudsqlapi.AuthProxyContainerSpec{
+				RolloutStrategy: cloudsqlapi.WorkloadStrategy,
+				StagedRollout:   &cloudsqlapi.StagedRolloutSpec{BatchSize: ptr(intstr.FromInt32(5))},
+			},
+			wantValid: false,
+		},
+		{
+			desc: "Valid, Evict rollout with default max unavailable",
+			spec: cloudsqlapi.AuthProxyContainerSpec{
+				RolloutStrategy: cloudsqlapi.EvictStrategy,
+			},
+			wantValid: true,
+		},
+		{
+			desc: "Valid, Evict rollout with max unavailable percentage",
+			spec: cloudsqlapi.AuthProxyContainerSpec{
+				RolloutStrategy: cloudsqlapi.EvictStrategy,
+				EvictRollout:    &cloudsqlapi.EvictRolloutSpec{MaxUnavailable: ptr(intstr.FromString("25%"))},
+			},
+			wantValid: true,
+		},
+		{
+			desc: "Invalid, Evict rollout with zero max unavailable",
+			spec: cloudsqlapi.AuthProxyContainerSpec{
+				RolloutStrategy: cloudsqlapi.EvictStrategy,
+				EvictRollout:    &cloudsqlapi.EvictRolloutSpec{MaxUnavailable: ptr(intstr.FromInt32(0))},
+			},
+			wantValid: false,
+		},
+		{
+			desc: "Invalid, EvictRollout set without Evict rollout strategy",
+			spec: cloudsqlapi.AuthProxyContainerSpec{
+				RolloutStrategy: cloudsqlapi.StagedStrategy,
+				EvictRollout:    &cloudsqlapi.EvictRolloutSpec{MaxUnavailable: ptr(intstr.FromInt32(2))},
+			},
+			wantValid: false,
+		},
 	}
 
 	for _, tc := range data {
@@ -309,6 +634,80 @@ func TestAuthProxyWorkload_ValidateCreate_AuthProxyContainerSpec(t *testing.T) {
 	}
 }
 
+func TestAuthProxyWorkload_ValidateCreate_AlloyDB(t *testing.T) {
+	const alloyDBInstance = "projects/proj/locations/us-central1/clusters/c1/instances/i1"
+	alloyDB := &cloudsqlapi.AuthProxyContainerSpec{ProxyType: cloudsqlapi.ProxyTypeAlloyDB}
+
+	data := []struct {
+		desc      string
+		container *cloudsqlapi.AuthProxyContainerSpec
+		inst      cloudsqlapi.InstanceSpec
+		wantValid bool
+	}{
+		{
+			desc:      "Valid, AlloyDB instance URI",
+			container: alloyDB,
+			inst:      cloudsqlapi.InstanceSpec{ConnectionString: alloyDBInstance, PortEnvName: "DB_PORT"},
+			wantValid: true,
+		},
+		{
+			desc:      "Invalid, AlloyDB with a Cloud SQL connection string",
+			container: alloyDB,
+			inst:      cloudsqlapi.InstanceSpec{ConnectionString: "proj:region:db2", PortEnvName: "DB_PORT"},
+			wantValid: false,
+		},
+		{
+			desc:      "Invalid, Cloud SQL with an AlloyDB instance URI",
+			inst:      cloudsqlapi.InstanceSpec{ConnectionString: alloyDBInstance, PortEnvName: "DB_PORT"},
+			wantValid: false,
+		},
+		{
+			desc:      "Invalid, AlloyDB with PrivateIP",
+			container: alloyDB,
+			inst:      cloudsqlapi.InstanceSpec{ConnectionString: alloyDBInstance, PortEnvName: "DB_PORT", PrivateIP: ptr(true)},
+			wantValid: false,
+		},
+		{
+			desc: "Invalid, AlloyDB with QuotaProject",
+			container: &cloudsqlapi.AuthProxyContainerSpec{
+				ProxyType: cloudsqlapi.ProxyTypeAlloyDB,
+				Telemetry: &cloudsqlapi.TelemetrySpec{QuotaProject: ptr("proj")},
+			},
+			inst:      cloudsqlapi.InstanceSpec{ConnectionString: alloyDBInstance, PortEnvName: "DB_PORT"},
+			wantValid: false,
+		},
+	}
+
+	for _, tc := range data {
+		t.Run(tc.desc, func(t *testing.T) {
+			p := cloudsqlapi.AuthProxyWorkload{
+				ObjectMeta: v1.ObjectMeta{Name: "sample"},
+				Spec: cloudsqlapi.AuthProxyWorkloadSpec{
+					Workload: cloudsqlapi.WorkloadSelectorSpec{
+						Kind: "Deployment",
+						Name: "webapp",
+					},
+					AuthProxyContainer: tc.container,
+					Instances:          []cloudsqlapi.InstanceSpec{tc.inst},
+				},
+			}
+			p.Default()
+			_, err := p.ValidateCreate()
+			gotValid := err == nil
+			switch {
+			case tc.wantValid && !gotValid:
+				t.Errorf("wants create valid, got error %v", err)
+				printFieldErrors(t, err)
+			case !tc.wantValid && gotValid:
+				t.Errorf("wants an error on create, got no error")
+			default:
+				t.Logf("create passed %s", tc.desc)
+				// test passes, do nothing.
+			}
+		})
+	}
+}
+
 func TestAuthProxyWorkload_ValidateUpdate(t *testing.T) {
 	data := []struct {
 		desc      string
@@ -347,7 +746,7 @@ func TestAuthProxyWorkload_ValidateUpdate(t *testing.T) {
 			wantValid: true,
 		},
 		{
-			desc: "Invalid, WorkloadSelectorSpec.Kind changed",
+			desc: "Valid, WorkloadSelectorSpec.Kind changed",
 			spec: cloudsqlapi.AuthProxyWorkloadSpec{
 				Workload: cloudsqlapi.WorkloadSelectorSpec{
 					Kind: "Deployment",
@@ -368,10 +767,10 @@ func TestAuthProxyWorkload_ValidateUpdate(t *testing.T) {
 					PortEnvName:      "DB_PORT",
 				}},
 			},
-			wantValid: false,
+			wantValid: true,
 		},
 		{
-			desc: "Invalid, WorkloadSelectorSpec.Name changed",
+			desc: "Valid, WorkloadSelectorSpec.Name changed",
 			spec: cloudsqlapi.AuthProxyWorkloadSpec{
 				Workload: cloudsqlapi.WorkloadSelectorSpec{
 					Kind: "Deployment",
@@ -392,10 +791,10 @@ func TestAuthProxyWorkload_ValidateUpdate(t *testing.T) {
 					PortEnvName:      "DB_PORT",
 				}},
 			},
-			wantValid: false,
+			wantValid: true,
 		},
 		{
-			desc: "Invalid, WorkloadSelectorSpec.Selector changed",
+			desc: "Valid, WorkloadSelectorSpec.Selector changed",
 			spec: cloudsqlapi.AuthProxyWorkloadSpec{
 				Workload: cloudsqlapi.WorkloadSelectorSpec{
 					Kind: "Deployment",
@@ -420,6 +819,7 @@ func TestAuthProxyWorkload_ValidateUpdate(t *testing.T) {
 					PortEnvName:      "DB_PORT",
 				}},
 			},
+			wantValid: true,
 		},
 	}
 
@@ -452,6 +852,101 @@ func TestAuthProxyWorkload_ValidateUpdate(t *testing.T) {
 	}
 }
 
+func TestAuthProxyWorkload_ValidateUpdate_StoredBeforeConnectionStringRules(t *testing.T) {
+	// The resource was stored before connection strings were validated, with
+	// a connection string that has an uppercase letter and a duplicate.
+	stored := []cloudsqlapi.InstanceSpec{
+		{ConnectionString: "Project:region:db", PortEnvName: "DB_PORT"},
+		{ConnectionString: "Project:region:db", PortEnvName: "DB_PORT2"},
+	}
+	data := []struct {
+		desc      string
+		change    func(p *cloudsqlapi.AuthProxyWorkload)
+		wantValid bool
+	}{
+		{
+			desc: "Valid, instances unchanged",
+			change: func(p *cloudsqlapi.AuthProxyWorkload) {
+				p.Spec.Workload.Name = "other"
+			},
+			wantValid: true,
+		},
+		{
+			desc: "Valid, being deleted",
+			change: func(p *cloudsqlapi.AuthProxyWorkload) {
+				now := v1.Now()
+				p.DeletionTimestamp = &now
+				p.Finalizers = nil
+				p.Spec.Instances = append(p.Spec.Instances, cloudsqlapi.InstanceSpec{
+					ConnectionString: "Project:region:db2", PortEnvName: "DB_PORT3",
+				})
+			},
+			wantValid: true,
+		},
+		{
+			desc: "Invalid, new instance does not follow the rules",
+			change: func(p *cloudsqlapi.AuthProxyWorkload) {
+				p.Spec.Instances = append(p.Spec.Instances, cloudsqlapi.InstanceSpec{
+					ConnectionString: "Project:region:db2", PortEnvName: "DB_PORT3",
+				})
+			},
+		},
+		{
+			desc: "Invalid, changed instance does not follow the rules",
+			change: func(p *cloudsqlapi.AuthProxyWorkload) {
+				p.Spec.Instances[1].PortEnvName = "DB_PORT3"
+			},
+		},
+	}
+
+	for _, tc := range data {
+		t.Run(tc.desc, func(t *testing.T) {
+			oldP := cloudsqlapi.AuthProxyWorkload{
+				ObjectMeta: v1.ObjectMeta{Name: "sample", Finalizers: []string{"cloudsql.cloud.google.com/AuthProxyWorkload-finalizer"}},
+				Spec: cloudsqlapi.AuthProxyWorkloadSpec{
+					Workload:  cloudsqlapi.WorkloadSelectorSpec{Kind: "Deployment", Name: "webapp"},
+					Instances: stored,
+				},
+			}
+			p := oldP.DeepCopy()
+			tc.change(p)
+
+			_, err := p.ValidateUpdate(&oldP)
+			if gotValid := err == nil; gotValid != tc.wantValid {
+				t.Errorf("got valid %v, want %v, error %v", gotValid, tc.wantValid, err)
+			}
+		})
+	}
+}
+
+func TestAuthProxyWorkload_ValidateUpdate_DuplicateBeforeUnchangedInstance(t *testing.T) {
+	inst := cloudsqlapi.InstanceSpec{ConnectionString: "proj:region:db", Port: ptr(int32(5432))}
+	oldP := cloudsqlapi.AuthProxyWorkload{
+		ObjectMeta: v1.ObjectMeta{Name: "sample"},
+		Spec: cloudsqlapi.AuthProxyWorkloadSpec{
+			Workload:  cloudsqlapi.WorkloadSelectorSpec{Kind: "Deployment", Name: "webapp"},
+			Instances: []cloudsqlapi.InstanceSpec{inst},
+		},
+	}
+
+	// The new instance has the same connection string as the unchanged
+	// instance, and comes before it.
+	p := oldP.DeepCopy()
+	changed := *inst.DeepCopy()
+	changed.Port = ptr(int32(5433))
+	p.Spec.Instances = []cloudsqlapi.InstanceSpec{changed, inst}
+
+	_, err := p.ValidateUpdate(&oldP)
+	statusErr, ok := err.(*apierrors.StatusError)
+	if !ok {
+		t.Fatalf("got error %v, want a StatusError", err)
+	}
+	causes := statusErr.Status().Details.Causes
+	if want := "spec.instances[1].connectionString"; len(causes) != 1 || causes[0].Field != want {
+		t.Errorf("got causes %v, want one error on %s", causes, want)
+	}
+}
+
 func TestAuthProxyWorkload_ValidateUpdate_AuthProxyContainerSpec(t *testing.T) {
 	data := []struct {
 		desc      string
@@ -460,13 +955,14 @@ func TestAuthProxyWorkload_ValidateUpdate_AuthProxyContainerSpec(t *testing.T) {
 		wantValid bool
 	}{
 		{
-			desc: "Invalid when AuthProxyContainerSpec.RolloutStrategy changes from explict to different default value",
+			desc: "Valid when AuthProxyContainerSpec.RolloutStrategy changes from explict to different default value",
 			spec: &cloudsqlapi.AuthProxyContainerSpec{
 				RolloutStrategy: "None",
 			},
 			oldSpec: &cloudsqlapi.AuthProxyContainerSpec{
 				RolloutStrategy: "Workload",
 			},
+			wantValid: true,
 		},
 		{
 			desc: "Valid when AuthProxyContainerSpec.RolloutStrategy goes from default to same explicit value",
@@ -476,31 +972,31 @@ func TestAuthProxyWorkload_ValidateUpdate_AuthProxyContainerSpec(t *testing.T) {
 			wantValid: true,
 		},
 		{
-			desc: "Invalid when AuthProxyContainerSpec.RolloutStrategy changes from default to different explicit value",
+			desc: "Valid when AuthProxyContainerSpec.RolloutStrategy changes from default to different explicit value",
 			spec: &cloudsqlapi.AuthProxyContainerSpec{
 				RolloutStrategy: "None",
 			},
-			wantValid: false,
+			wantValid: true,
 		},
 		{
-			desc: "Invalid when AuthProxyContainerSpec.RolloutStrategy changes to different explicit value",
+			desc: "Valid when AuthProxyContainerSpec.RolloutStrategy changes to different explicit value",
 			spec: &cloudsqlapi.AuthProxyContainerSpec{
 				RolloutStrategy: "None",
 			},
 			oldSpec: &cloudsqlapi.AuthProxyContainerSpec{
 				RolloutStrategy: "Workload",
 			},
-			wantValid: false,
+			wantValid: true,
 		},
 		{
-			desc: "Invalid when AuthProxyContainerSpec.RolloutStrategy changes from explict to different default value",
+			desc: "Valid when AuthProxyContainerSpec.RolloutStrategy changes from explict to different default value",
 			spec: &cloudsqlapi.AuthProxyContainerSpec{
 				RolloutStrategy: "None",
 			},
 			oldSpec: &cloudsqlapi.AuthProxyContainerSpec{
 				RolloutStrategy: "Workload",
 			},
-			wantValid: false,
+			wantValid: true,
 		},
 	}
 	for _, tc := range data {
@@ -567,3 +1063,115 @@ func printFieldErrors(t *testing.T, err error) {
 		}
 	}
 }
+
+func TestAuthProxyWorkloadFromAnnotations(t *testing.T) {
+	data := []struct {
+		desc      string
+		an        map[string]string
+		wantNil   bool
+		wantValid bool
+		check     func(t *testing.T, p *cloudsqlapi.AuthProxyWorkload)
+	}{
+		{
+			desc:      "No annotations",
+			wantNil:   true,
+			wantValid: true,
+		},
+		{
+			desc:      "Valid, port, env var and unix socket",
+			an:        map[string]string{cloudsqlapi.InstancesAnnotation: "proj:region:db1=5432, proj:region:db2=DB2_PORT,proj:region:db3=/var/run/db3"},
+			wantValid: true,
+			check: func(t *testing.T, p *cloudsqlapi.AuthProxyWorkload) {
+				if p.Name != cloudsqlapi.AnnotationProxyName || p.Namespace != "default" {
+					t.Errorf("got %s/%s, want default/%s", p.Namespace, p.Name, cloudsqlapi.AnnotationProxyName)
+				}
+				is := p.Spec.Instances
+				if len(is) != 3 {
+					t.Fatalf("got %d instances, want 3", len(is))
+				}
+				if is[0].ConnectionString != "proj:region:db1" || is[0].Port == nil || *is[0].Port != 5432 {
+					t.Errorf("got instance %v, want proj:region:db1 on port 5432", is[0])
+				}
+				if is[1].PortEnvName != "DB2_PORT" {
+					t.Errorf("got portEnvName %q, want DB2_PORT", is[1].PortEnvName)
+				}
+				if is[2].UnixSocketPath != "/var/run/db3" {
+					t.Errorf("got unixSocketPath %q, want /var/run/db3", is[2].UnixSocketPath)
+				}
+				if p.Spec.AuthProxyContainer != nil {
+					t.Errorf("got container %v, want nil", p.Spec.AuthProxyContainer)
+				}
+			},
+		},
+		{
+			desc: "Valid, AlloyDB with image",
+			an: map[string]string{
+				cloudsqlapi.InstancesAnnotation: "projects/p/locations/r/clusters/c/instances/i=5432",
+				cloudsqlapi.ProxyTypeAnnotation: cloudsqlapi.ProxyTypeAlloyDB,
+				cloudsqlapi.ImageAnnotation:     "example.com/alloydb:1",
+			},
+			wantValid: true,
+			check: func(t *testing.T, p *cloudsqlapi.AuthProxyWorkload) {
+				c := p.Spec.AuthProxyContainer
+				if c == nil || c.ProxyType != cloudsqlapi.ProxyTypeAlloyDB || c.Image != "example.com/alloydb:1" {
+					t.Errorf("got container %v, want AlloyDB with image example.com/alloydb:1", c)
+				}
+			},
+		},
+		{
+			desc: "Invalid, missing port",
+			an:   map[string]string{cloudsqlapi.InstancesAnnotation: "proj:region:db"},
+		},
+		{
+			desc: "Invalid, empty instances",
+			an:   map[string]string{cloudsqlapi.InstancesAnnotation: " , "},
+		},
+		{
+			desc: "Invalid, port out of range",
+			an:   map[string]string{cloudsqlapi.InstancesAnnotation: "proj:region:db=99999999999"},
+		},
+		{
+			desc: "Invalid, bad port number",
+			an:   map[string]string{cloudsqlapi.InstancesAnnotation: "proj:region:db=70000"},
+		},
+		{
+			desc: "Invalid, bad env var name",
+			an:   map[string]string{cloudsqlapi.InstancesAnnotation: "proj:region:db=1DB_PORT"},
+		},
+		{
+			desc: "Invalid, bad connection string",
+			an:   map[string]string{cloudsqlapi.InstancesAnnotation: "db=5432"},
+		},
+		{
+			desc: "Invalid, unknown proxy type",
+			an: map[string]string{
+				cloudsqlapi.InstancesAnnotation: "proj:region:db=5432",
+				cloudsqlapi.ProxyTypeAnnotation: "Spanner",
+			},
+		},
+	}
+
+	for _, tc := range data {
+		t.Run(tc.desc, func(t *testing.T) {
+			p, err := cloudsqlapi.AuthProxyWorkloadFromAnnotations("default", tc.an)
+			if tc.wantValid && err != nil {
+				t.Fatalf("want no error, got %v", err)
+			}
+			if !tc.wantValid {
+				if err == nil {
+					t.Fatal("want an error, got no error")
+				}
+				return
+			}
+			if tc.wantNil {
+				if p != nil {
+					t.Errorf("got %v, want nil", p)
+				}
+				return
+			}
+			if tc.check != nil {
+				tc.check(t, p)
+			}
+		})
+	}
+}
@@ -18,6 +18,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 const (
@@ -28,10 +29,22 @@ const (
 	// ErrorCodeEnvConflict occurs when an the environment code does not work.
 	ErrorCodeEnvConflict = "EnvVarConflict"
 
+	// ReasonConfigError relates to conditions UpToDate and WorkloadUpToDate,
+	// this reason is set when the proxy configuration can't be applied to a
+	// workload. The pods of the workload are rejected until the errors are
+	// fixed. Each error is reported in a WorkloadStatus condition whose type
+	// and reason is the error code, like ErrorCodePortConflict.
+	ReasonConfigError = "ConfigError"
+
 	// AnnotationPrefix is used as the prefix for all annotations added to a domain object.
 	// to hold metadata related to this operator.
 	AnnotationPrefix = "cloudsql.cloud.google.com"
 
+	// ClusterAnnotationPrefix is used as the prefix for annotations added to
+	// a workload by a ClusterAuthProxyWorkload, so that they never collide with
+	// the annotations of an AuthProxyWorkload with the same name.
+	ClusterAnnotationPrefix = "cluster." + AnnotationPrefix
+
 	// ConditionUpToDate indicates whether the reconciliation loop
 	// has properly processed the latest generation of an AuthProxyInstance
 	ConditionUpToDate = "UpToDate"
@@ -55,6 +68,12 @@ const (
 	// when there are no workloads related to this AuthProxyWorkload resource.
 	ReasonNoWorkloadsFound = "NoWorkloadsFound"
 
+	// ReasonRolloutInProgress relates to conditions UpToDate and
+	// WorkloadUpToDate, this reason is set when the workload's pod template is
+	// up-to-date, but some of the workload's pods do not yet run the current
+	// proxy configuration.
+	ReasonRolloutInProgress = "RolloutInProgress"
+
 	// ConditionWorkloadUpToDate indicates whether the reconciliation loop
 	// has properly processed the latest generation of an AuthProxyInstance
 	ConditionWorkloadUpToDate = "WorkloadUpToDate"
@@ -63,6 +82,48 @@ const (
 	// when there are no workloads related to this AuthProxyWorkload resource.
 	ReasonUpToDate = "UpToDate"
 
+	// ReasonWorkloadExcluded relates to condition WorkloadUpToDate, this
+	// reason is set when the workload, its pod template, one of its owners or
+	// its namespace has the DisableInjectionAnnotation. The operator does not
+	// add the proxy to the workload's pods and does not update the workload.
+	ReasonWorkloadExcluded = "WorkloadExcluded"
+
+	// ReasonInjectionPaused relates to condition WorkloadUpToDate, this reason
+	// is set when the operator's pauseInjection setting is true. The operator
+	// does not add the proxy to any pods and does not update any workloads.
+	ReasonInjectionPaused = "InjectionPaused"
+
+	// ReasonOverridden relates to condition WorkloadUpToDate, this reason is
+	// set on a workload of a ClusterAuthProxyWorkload when an AuthProxyWorkload
+	// that matches the workload uses one of the same instances. The
+	// AuthProxyWorkload takes precedence. The operator does not add the proxy
+	// of the ClusterAuthProxyWorkload to the workload's pods and does not
+	// update the workload.
+	ReasonOverridden = "OverriddenByAuthProxyWorkload"
+
+	// DisableInjectionAnnotation is the annotation that excludes a workload
+	// from all AuthProxyWorkloads when it is set to "true". It may be set on
+	// the workload, the workload's pod template, or the workload's namespace.
+	DisableInjectionAnnotation = "injection." + AnnotationPrefix + "/disabled"
+
+	// ConditionWorkloadRemoved indicates that a workload no longer matches
+	// this AuthProxyWorkload. The workload's entry in WorkloadStatus is removed
+	// on the next reconcile.
+	ConditionWorkloadRemoved = "WorkloadRemoved"
+
+	// ReasonWorkloadDeleted relates to condition WorkloadRemoved, this reason is
+	// set when the workload was deleted.
+	ReasonWorkloadDeleted = "WorkloadDeleted"
+
+	// ReasonWorkloadLabelsChanged relates to condition WorkloadRemoved, this
+	// reason is set when the workload still exists, but no longer matches the
+	// workload selector.
+	ReasonWorkloadLabelsChanged = "WorkloadLabelsChanged"
+
+	// ReasonWorkloadKindNotSupported relates to condition WorkloadRemoved, this
+	// reason is set when the workload's kind is no longer supported or selected.
+	ReasonWorkloadKindNotSupported = "WorkloadKindNotSupported"
+
 	// WorkloadStrategy is the RolloutStrategy value that indicates that
 	// when the AuthProxyWorkload is updated or deleted, the changes should be
 	// applied to affected workloads (Deployments, StatefulSets, etc.) following
@@ -75,9 +136,102 @@ const (
 	// by the operator to update the affected workloads.
 	NoneStrategy = "None"
 
+	// StagedStrategy is the RolloutStrategy value that indicates that
+	// when the AuthProxyWorkload is updated, the changes should be applied to
+	// the affected workloads in batches. The next batch is updated when the
+	// workloads of the previous batches are available. See StagedRolloutSpec.
+	StagedStrategy = "Staged"
+
+	// EvictStrategy is the RolloutStrategy value that indicates that
+	// when the AuthProxyWorkload is updated or deleted, the operator does not
+	// change the affected workloads. Instead, it evicts the workloads' pods
+	// that don't run the current proxy configuration, so that they are
+	// recreated with it. See EvictRolloutSpec.
+	EvictStrategy = "Evict"
+
+	// ResumeRolloutAnnotation resumes a Staged rollout that was paused because
+	// a workload failed. Set it to a new value, like the current time, to
+	// resume the rollout. The rollout continues without waiting for the
+	// workloads that failed.
+	ResumeRolloutAnnotation = "rollout." + AnnotationPrefix + "/resume"
+
+	// RestartRolloutAnnotation restarts the pods of all the workloads that
+	// match the AuthProxyWorkload once, like `kubectl rollout restart` does
+	// for one Deployment, whatever the RolloutStrategy. Set it to a new value,
+	// like the current time, to restart the pods. The new pods run the current
+	// proxy configuration. See AuthProxyWorkloadStatus.Restarted.
+	RestartRolloutAnnotation = "rollout." + AnnotationPrefix + "/restart"
+
+	// RollbackAnnotation restores the spec of an AuthProxyWorkload from one
+	// of its previous revisions, like `kubectl rollout undo` does for a
+	// Deployment. Set it to the number of the revision, or to `0` for the
+	// revision before the current one. The operator replaces the spec,
+	// removes the annotation, and rolls out the restored proxy configuration
+	// following the RolloutStrategy. See AuthProxyWorkloadStatus.Revision.
+	RollbackAnnotation = "rollout." + AnnotationPrefix + "/rollback-to"
+
+	// RevisionOwnerLabel is set on the ControllerRevisions that record the
+	// revisions of an AuthProxyWorkload spec. Its value is the UID of the
+	// AuthProxyWorkload.
+	RevisionOwnerLabel = AnnotationPrefix + "/authproxyworkload-uid"
+
+	// ReasonRolledBack is the reason of the Event emitted when the spec of an
+	// AuthProxyWorkload was restored from a previous revision.
+	ReasonRolledBack = "RolledBack"
+
+	// ReasonRollbackFailed is the reason of the Event emitted when the
+	// revision requested by the RollbackAnnotation can't be restored.
+	ReasonRollbackFailed = "RollbackFailed"
+
+	// ReasonOutOfDate relates to conditions UpToDate and WorkloadUpToDate,
+	// this reason is set when the RolloutStrategy is `None` and the
+	// workload's pods don't run the current proxy configuration. The operator
+	// does not update the workload. See RestartRolloutAnnotation.
+	ReasonOutOfDate = "OutOfDate"
+
+	// ReasonRolloutPaused relates to condition UpToDate, this reason is set
+	// when a Staged rollout is paused because a workload failed after it was
+	// updated. See ResumeRolloutAnnotation.
+	ReasonRolloutPaused = "RolloutPaused"
+
+	// ReasonRolloutQueued relates to conditions UpToDate and WorkloadUpToDate,
+	// this reason is set when the workload needs the current proxy
+	// configuration, but waits because the operator's maxConcurrentRollouts
+	// workloads are already rolling out. See WorkloadStatus.QueuePosition.
+	ReasonRolloutQueued = "RolloutQueued"
+
+	// ReasonRolloutFailed relates to condition WorkloadUpToDate, this reason
+	// is set when a workload updated by a Staged rollout has failing pods
+	// or can't make progress.
+	ReasonRolloutFailed = "RolloutFailed"
+
+	// ReasonEvictionBlocked relates to conditions UpToDate and
+	// WorkloadUpToDate, this reason is set when an Evict rollout can't evict
+	// some of the workload's pods because a PodDisruptionBudget does not
+	// allow it. See WorkloadStatus.EvictionBlockedPods.
+	ReasonEvictionBlocked = "EvictionBlocked"
+
 	// RefreshStrategyLazy is the RefreshStrategy value indicating that the
 	// proxy should be configured with the --lazy-refresh flag.
 	RefreshStrategyLazy = "lazy"
+
+	// SidecarTypeContainer is the SidecarType value indicating that the proxy
+	// should be added to the workload's PodSpec.Containers, running alongside
+	// the application containers.
+	SidecarTypeContainer = "Container"
+
+	// SidecarTypeInit is the SidecarType value indicating that the proxy
+	// should be added to the workload's PodSpec.InitContainers as a native
+	// Kubernetes sidecar with `restartPolicy: Always`.
+	SidecarTypeInit = "Init"
+
+	// ProxyTypeCloudSQL is the ProxyType value indicating that the operator
+	// should add the Cloud SQL Auth Proxy to the workload.
+	ProxyTypeCloudSQL = "CloudSQL"
+
+	// ProxyTypeAlloyDB is the ProxyType value indicating that the operator
+	// should add the AlloyDB Auth Proxy to the workload.
+	ProxyTypeAlloyDB = "AlloyDB"
 )
 
 // AuthProxyWorkload declares how a Cloud SQL Proxy container should be applied
@@ -107,6 +261,16 @@ type AuthProxyWorkloadSpec struct {
 	// AuthProxyContainer describes the resources and config for the Auth Proxy container.
 	//+kubebuilder:validation:Optional
 	AuthProxyContainer *AuthProxyContainerSpec `json:"authProxyContainer,omitempty"`
+
+	// RevisionHistoryLimit is the number of previous revisions of this spec
+	// that the operator keeps, so that the resource can be rolled back to one
+	// of them with the RollbackAnnotation. Each revision is stored in a
+	// ControllerRevision owned by the AuthProxyWorkload. Defaults to 10. This
+	// may not be set on a ClusterAuthProxyWorkload, which does not keep a
+	// revision history.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:Minimum=0
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
 }
 
 // WorkloadSelectorSpec describes which workloads should be configured with this
@@ -128,6 +292,13 @@ type WorkloadSelectorSpec struct {
 	// Name specifies the name of the resource to select.
 	//+kubebuilder:validation:Optional
 	Name string `json:"name,omitempty"`
+
+	// NamespaceSelector (optional) selects the namespaces containing the
+	// workloads using labels. This may only be set on a ClusterAuthProxyWorkload.
+	// When it is not set, a ClusterAuthProxyWorkload selects workloads in
+	// all namespaces.
+	//+kubebuilder:validation:Optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
 }
 
 // LabelsSelector converts the Selector field into a controller-runtime labels.Selector
@@ -141,6 +312,17 @@ func (s *WorkloadSelectorSpec) LabelsSelector() (labels.Selector, error) {
 	return metav1.LabelSelectorAsSelector(s.Selector)
 }
 
+// NamespaceLabelsSelector converts the NamespaceSelector field into a
+// controller-runtime labels.Selector. If the NamespaceSelector field is nil,
+// returns an empty selector which will match all namespaces.
+func (s *WorkloadSelectorSpec) NamespaceLabelsSelector() (labels.Selector, error) {
+	if s.NamespaceSelector == nil {
+		return labels.NewSelector(), nil
+	}
+
+	return metav1.LabelSelectorAsSelector(s.NamespaceSelector)
+}
+
 // AuthProxyContainerSpec describes how to configure global proxy configuration and
 // kubernetes-specific container configuration.
 type AuthProxyContainerSpec struct {
@@ -188,7 +370,8 @@ type AuthProxyContainerSpec struct {
 
 	// Image is the URL to the proxy image. Optional, by default the operator
 	// will use the latest Cloud SQL Auth Proxy version as of the release of the
-	// operator.
+	// operator, or the latest AlloyDB Auth Proxy version when ProxyType is
+	// `AlloyDB`.
 	//
 	// The operator ensures that all workloads configured with the default proxy
 	// image are upgraded automatically to use to the latest released proxy image.
@@ -201,19 +384,49 @@ type AuthProxyContainerSpec struct {
 	//+kubebuilder:validation:Optional
 	Image string `json:"image,omitempty"`
 
+	// ProxyType selects which proxy the operator adds to the workload.
+	// When this is omitted or set to `CloudSQL`, the operator adds the
+	// Cloud SQL Auth Proxy, and each instance's connectionString is a
+	// Cloud SQL instance connection name like `project:region:instance`.
+	// When this is set to `AlloyDB`, the operator adds the AlloyDB Auth Proxy,
+	// and each instance's connectionString is an AlloyDB instance URI like
+	// `projects/<project>/locations/<region>/clusters/<cluster>/instances/<instance>`.
+	//
+	// Changes to ProxyType are applied in accordance with the RolloutStrategy.
+	// See: https://cloud.google.com/alloydb/docs/auth-proxy/overview
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:Enum=CloudSQL;AlloyDB
+	//+kubebuilder:default=CloudSQL
+	ProxyType string `json:"proxyType,omitempty"`
+
 	// RolloutStrategy indicates the strategy to use when rolling out changes to
 	// the workloads affected by the results. When this is set to
 	// `Workload`, changes to this resource will be automatically applied
 	// to a running Deployment, StatefulSet, DaemonSet, or ReplicaSet in
 	// accordance with the Strategy set on that workload. When this is set to
 	// `None`, the operator will take no action to roll out changes to affected
-	// workloads. `Workload` will be used by default if no value is set.
+	// workloads, and reports the workloads that are out of date, see
+	// RestartRolloutAnnotation. When this is set to `Staged`, the changes are applied to the
+	// workloads in batches, see StagedRollout. When this is set to `Evict`,
+	// the operator does not change the workloads, and evicts their pods
+	// instead, see EvictRollout. `Workload` will be used by default if no
+	// value is set.
 	// See: https://kubernetes.io/docs/concepts/workloads/controllers/deployment/#strategy
 	//+kubebuilder:validation:Optional
-	//+kubebuilder:validation:Enum=Workload;None
+	//+kubebuilder:validation:Enum=Workload;None;Staged;Evict
 	//+kubebuilder:default=Workload
 	RolloutStrategy string `json:"rolloutStrategy,omitempty"`
 
+	// StagedRollout configures the batches of a `Staged` RolloutStrategy.
+	// It may only be set when the RolloutStrategy is `Staged`.
+	//+kubebuilder:validation:Optional
+	StagedRollout *StagedRolloutSpec `json:"stagedRollout,omitempty"`
+
+	// EvictRollout configures the pace of an `Evict` RolloutStrategy.
+	// It may only be set when the RolloutStrategy is `Evict`.
+	//+kubebuilder:validation:Optional
+	EvictRollout *EvictRolloutSpec `json:"evictRollout,omitempty"`
+
 	// RefreshStrategy indicates which refresh strategy the proxy should use.
 	// When this is set to `lazy`, the proxy will use a lazy refresh strategy,
 	// and will be configured to run with the --lazy-refresh flag. When this
@@ -228,6 +441,61 @@ type AuthProxyContainerSpec struct {
 	// Quiet configures the proxy's --quiet flag to limit the amount of
 	// logging generated by the proxy container.
 	Quiet bool `json:"quiet,omitempty"`
+
+	// SidecarType indicates how the proxy container is added to the workload.
+	// When this is set to `Init`, the proxy is added to the pod's init
+	// containers as a native Kubernetes sidecar with `restartPolicy: Always`.
+	// The proxy will start and become ready before the application containers
+	// start, and will shut down after the application containers exit, so
+	// Jobs and CronJobs complete normally. This requires Kubernetes 1.29 or
+	// later. When this is omitted or set to `Container`, the proxy is added
+	// to the pod's containers.
+	//
+	// Changes to SidecarType are applied in accordance with the RolloutStrategy.
+	// See: https://kubernetes.io/docs/concepts/workloads/pods/sidecar-containers/
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:Enum=Container;Init
+	//+kubebuilder:default=Container
+	SidecarType string `json:"sidecarType,omitempty"`
+}
+
+// StagedRolloutSpec configures a Staged rollout. The operator updates the
+// matching workloads in batches, in order of namespace, kind and name. Each
+// batch is updated when the workloads of the previous batches are available
+// and run the new proxy configuration. When an updated workload's pods fail,
+// or its rollout can't make progress, the rollout is paused. See
+// ResumeRolloutAnnotation.
+//
+// The proxy is removed from all workloads at once when the AuthProxyWorkload
+// is deleted.
+type StagedRolloutSpec struct {
+	// BatchSize is the number of workloads updated in each batch, or a
+	// percentage of the matching workloads like `25%`. A percentage is rounded
+	// up. Defaults to 1.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:XIntOrString
+	BatchSize *intstr.IntOrString `json:"batchSize,omitempty"`
+}
+
+// EvictRolloutSpec configures an Evict rollout. The operator leaves the
+// workload's spec unchanged, so that tools like Argo CD and Flux don't find
+// the workload out of sync. Instead, it evicts the pods that don't run the
+// current proxy configuration using the Eviction API, and the pod webhook
+// adds the current configuration to the pods that replace them. Evictions
+// respect the PodDisruptionBudgets of the pods.
+//
+// Only the pods of Deployments, StatefulSets, DaemonSets and ReplicaSets are
+// evicted. The proxy is removed from the pods of a deleted AuthProxyWorkload
+// the same way, before its finalizer is removed. The pods of a workload that
+// no longer matches keep the proxy until they are recreated.
+type EvictRolloutSpec struct {
+	// MaxUnavailable is the maximum number of the workload's pods that may be
+	// unavailable while its pods are evicted, or a percentage of the
+	// workload's pods like `25%`. A percentage is rounded down, and at least
+	// one pod is evicted at a time. Defaults to 1.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:XIntOrString
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
 }
 
 // AdminServerSpec specifies how to start the proxy's admin server:
@@ -252,7 +520,7 @@ type AdminServerSpec struct {
 
 // AuthenticationSpec specifies how the proxy is authenticated with the
 // Google Cloud SQL Admin API. This configures proxy's
-// --impersonate-service-account flag.
+// --impersonate-service-account and --credentials-file flags.
 type AuthenticationSpec struct {
 	// ImpersonationChain is a list of one or more service
 	// accounts. The first entry in the chain is the impersonation target. Any
@@ -261,6 +529,29 @@ type AuthenticationSpec struct {
 	// that will be impersonated. This sets the --impersonate-service-account
 	// flag on the proxy.
 	ImpersonationChain []string `json:"impersonationChain,omitempty"`
+
+	// CredentialsFileSecret (optional) references a key in a Secret that holds
+	// a Google Cloud credentials file, for example a service account key JSON
+	// file. Use this when the proxy can't use Workload Identity. The Secret
+	// must be in the same namespace as the workload. The operator mounts the
+	// key read-only into the proxy container and sets the --credentials-file
+	// flag on the proxy.
+	//
+	// When the Secret changes, the operator rolls out the new credentials
+	// to the workloads in accordance with the RolloutStrategy.
+	//+kubebuilder:validation:Optional
+	CredentialsFileSecret *SecretKeyRef `json:"credentialsFileSecret,omitempty"`
+}
+
+// SecretKeyRef references a key in a Secret.
+type SecretKeyRef struct {
+	// Name is the name of the Secret.
+	//+kubebuilder:validation:Required
+	Name string `json:"name"`
+
+	// Key is the key in the Secret's data.
+	//+kubebuilder:validation:Required
+	Key string `json:"key"`
 }
 
 // TelemetrySpec specifies how the proxy container will expose telemetry.
@@ -335,9 +626,14 @@ type TelemetrySpec struct {
 type InstanceSpec struct {
 
 	// ConnectionString is the connection string for the Cloud SQL Instance
-	// in the format `project_id:region:instance_name`
+	// in the format `project_id:region:instance_name`. The project may be a
+	// domain-scoped project like `example.com:project_id`. The instance may
+	// also be set by a DNS name, like `prod-db.example.com`, that the proxy
+	// resolves to the instance. When the ProxyType is `AlloyDB`, this is the
+	// AlloyDB instance URI in the format
+	// `projects/<project>/locations/<region>/clusters/<cluster>/instances/<instance>`
 	//+kubebuilder:validation:Required
-	//+kubebuilder:validation:Pattern:="^([^:]+(:[^:]+)?):([^:]+):([^:]+)$"
+	//+kubebuilder:validation:Pattern:="^(([^:]+(:[^:]+)?):([^:]+):([^:]+)|projects/[^/]+/locations/[^/]+/clusters/[^/]+/instances/[^/]+|[a-z0-9]([-a-z0-9.]*[a-z0-9])?)$"
 	ConnectionString string `json:"connectionString,omitempty"`
 
 	// Port (optional) sets the tcp port for this instance. If not set, a value will
@@ -354,7 +650,9 @@ type InstanceSpec struct {
 	AutoIAMAuthN *bool `json:"autoIAMAuthN,omitempty"`
 
 	// PrivateIP (optional) Enable connection to the Cloud SQL instance's private ip for this instance.
-	// Default value is false.
+	// Default value is false. This may not be set when the ProxyType is
+	// `AlloyDB`, the AlloyDB Auth Proxy connects to the instance's private ip
+	// by default.
 	//+kubebuilder:validation:Optional
 	PrivateIP *bool `json:"privateIP,omitempty"`
 
@@ -398,6 +696,87 @@ type AuthProxyWorkloadStatus struct {
 	// WorkloadStatus presents the observed status of individual workloads that match
 	// this AuthProxyWorkload resource.
 	WorkloadStatus []*WorkloadStatus `json:"WorkloadStatus,omitempty"`
+
+	// ConfigGeneration is the generation of this resource that last changed
+	// the proxy configuration. Changes to spec.workload or to
+	// spec.authProxyContainer.rolloutStrategy, stagedRollout and evictRollout
+	// only change which workloads get the proxy and how, so they don't change
+	// ConfigGeneration, and the workloads that keep matching are not rolled
+	// out again. Neither do changes to spec.revisionHistoryLimit.
+	//+kubebuilder:validation:Optional
+	ConfigGeneration int64 `json:"configGeneration,omitempty"`
+
+	// ConfigHash is the hash of the proxy configuration at ConfigGeneration.
+	//+kubebuilder:validation:Optional
+	ConfigHash string `json:"configHash,omitempty"`
+
+	// Rollout presents the progress of a Staged rollout. It is only set when
+	// the RolloutStrategy is `Staged`.
+	//+kubebuilder:validation:Optional
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+
+	// OutOfDateWorkloads lists the workloads whose pods don't run the current
+	// proxy configuration when the RolloutStrategy is `None`, as
+	// `Kind namespace/name`. The number of outdated pods of each workload is
+	// in its WorkloadStatus. See ReasonOutOfDate.
+	//+kubebuilder:validation:Optional
+	OutOfDateWorkloads []string `json:"outOfDateWorkloads,omitempty"`
+
+	// Restarted is the value of the RestartRolloutAnnotation that the operator
+	// last finished handling. The pods of the matching workloads were
+	// restarted once for this value.
+	//+kubebuilder:validation:Optional
+	Restarted string `json:"restarted,omitempty"`
+
+	// Revision is the number of the ControllerRevision that records the
+	// current spec. A spec that is the same as a previous revision, for
+	// example after a rollback, reuses that revision with a new number.
+	// See RollbackAnnotation.
+	//+kubebuilder:validation:Optional
+	Revision int64 `json:"revision,omitempty"`
+}
+
+// RolloutStatus presents the progress of a Staged rollout of the proxy
+// configuration to the matching workloads.
+type RolloutStatus struct {
+	// ConfigGeneration is the ConfigGeneration being rolled out. A new
+	// rollout starts when the proxy configuration changes.
+	ConfigGeneration int64 `json:"configGeneration,omitempty"`
+
+	// BatchSize is the number of workloads updated in each batch.
+	BatchSize int32 `json:"batchSize,omitempty"`
+
+	// TotalWorkloads is the number of matching workloads that the rollout
+	// updates. Excluded workloads and workloads with configuration errors are
+	// not counted.
+	TotalWorkloads int32 `json:"totalWorkloads,omitempty"`
+
+	// UpdatedWorkloads is the number of workloads whose pod template has the
+	// current proxy configuration.
+	UpdatedWorkloads int32 `json:"updatedWorkloads,omitempty"`
+
+	// AvailableWorkloads is the number of updated workloads whose pods all
+	// run the current proxy configuration and are available.
+	AvailableWorkloads int32 `json:"availableWorkloads,omitempty"`
+
+	// Paused is true when the rollout stopped because an updated workload
+	// failed. See ResumeRolloutAnnotation.
+	Paused bool `json:"paused,omitempty"`
+
+	// Message explains why the rollout is paused.
+	//+kubebuilder:validation:Optional
+	Message string `json:"message,omitempty"`
+
+	// FailedWorkloads lists the workloads that failed during this rollout,
+	// as `Kind namespace/name`. After the rollout is resumed, it does not wait
+	// for these workloads.
+	//+kubebuilder:validation:Optional
+	FailedWorkloads []string `json:"failedWorkloads,omitempty"`
+
+	// Resumed is the value of the ResumeRolloutAnnotation that the operator
+	// last acted on.
+	//+kubebuilder:validation:Optional
+	Resumed string `json:"resumed,omitempty"`
 }
 
 // WorkloadStatus presents the status for how this AuthProxyWorkload resource
@@ -411,14 +790,113 @@ type WorkloadStatus struct {
 	Namespace string `json:"namespace,omitempty,"`
 	Name      string `json:"name,omitempty,"`
 
+	// ObservedGeneration is the generation of the workload observed by the
+	// workload's controller. This is only set for Deployment, StatefulSet,
+	// and DaemonSet workloads.
+	//+kubebuilder:validation:Optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Replicas is the desired number of pods for the workload. This is only
+	// set for Deployment, StatefulSet, and DaemonSet workloads.
+	//+kubebuilder:validation:Optional
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// UpdatedReplicas is the number of the workload's pods created from the
+	// latest pod template. This is only set for Deployment, StatefulSet, and
+	// DaemonSet workloads.
+	//+kubebuilder:validation:Optional
+	UpdatedReplicas int32 `json:"updatedReplicas,omitempty"`
+
+	// AvailableReplicas is the number of the workload's pods that are
+	// available. This is only set for Deployment, StatefulSet, and DaemonSet
+	// workloads.
+	//+kubebuilder:validation:Optional
+	AvailableReplicas int32 `json:"availableReplicas,omitempty"`
+
+	// ProxyConfigHash is the hash of the proxy configuration that the operator
+	// adds to the workload's pods, rendered from the current configuration.
+	// The operator sets it as the value of this resource's annotation on the
+	// pods, so pods with a different value run an outdated configuration.
+	// It is not set when the proxy is not added to the workload's pods.
+	//+kubebuilder:validation:Optional
+	ProxyConfigHash string `json:"proxyConfigHash,omitempty"`
+
+	// PodTemplateConfigHash is the hash of the proxy configuration recorded in
+	// this resource's annotation on the workload's pod template, the
+	// configuration of the pods that the workload creates. It is not set when
+	// the workload's pod template is not annotated, for example when the
+	// RolloutStrategy is `None` or `Evict`.
+	//+kubebuilder:validation:Optional
+	PodTemplateConfigHash string `json:"podTemplateConfigHash,omitempty"`
+
+	// UpdatedPods is the number of running pods that have the current proxy
+	// configuration.
+	//+kubebuilder:validation:Optional
+	UpdatedPods int32 `json:"updatedPods,omitempty"`
+
+	// OutdatedPods is the number of running pods that do not have the current
+	// proxy configuration.
+	//+kubebuilder:validation:Optional
+	OutdatedPods int32 `json:"outdatedPods,omitempty"`
+
+	// FailedPods is the number of running pods that have the current proxy
+	// configuration, and have a container that terminated with an error or is
+	// in CrashLoopBackOff.
+	//+kubebuilder:validation:Optional
+	FailedPods int32 `json:"failedPods,omitempty"`
+
+	// QueuePosition is the position of the workload in the operator's queue
+	// of workloads waiting to roll out a new proxy configuration, starting at
+	// 1. It is only set when the workload waits. See ReasonRolloutQueued.
+	//+kubebuilder:validation:Optional
+	QueuePosition int32 `json:"queuePosition,omitempty"`
+
+	// EvictionBlockedPods lists the workload's pods that an Evict rollout
+	// could not evict because a PodDisruptionBudget does not allow it. It is
+	// only set when the RolloutStrategy is `Evict`. See ReasonEvictionBlocked.
+	//+kubebuilder:validation:Optional
+	EvictionBlockedPods []string `json:"evictionBlockedPods,omitempty"`
+
+	// ProxyContainer is the proxy container that the operator adds to the
+	// workload's pods, rendered from the current configuration. It is not set
+	// when the configuration can't be applied to the workload, or when an
+	// AuthProxyWorkload takes precedence for this workload, see
+	// ReasonOverridden.
+	//+kubebuilder:validation:Optional
+	ProxyContainer *corev1.Container `json:"proxyContainer,omitempty"`
+
+	// Ports are the TCP ports that the proxy listens on in the workload's
+	// pods, one for each instance that does not use a unix socket. The
+	// operator records the ports on the workload's pod template, so that
+	// instances keep their ports when AuthProxyWorkloads are added or changed.
+	//+kubebuilder:validation:Optional
+	Ports []InstancePort `json:"ports,omitempty"`
+
 	// Conditions show the status of the AuthProxyWorkload resource on this
 	// matching workload.
 	//
 	// The "UpToDate" condition indicates that the proxy was successfully
 	// applied to all matching workloads. See ConditionUpToDate.
+	//
+	// The "WorkloadRemoved" condition indicates that the workload no longer
+	// matches and records the reason. See ConditionWorkloadRemoved.
+	//
+	// The "PortConflict" and "EnvVarConflict" conditions indicate that the
+	// proxy configuration can't be applied to the workload's pods, and describe
+	// the errors. See ReasonConfigError.
 	Conditions []*metav1.Condition `json:"conditions"`
 }
 
+// InstancePort is the port that the proxy listens on for an instance.
+type InstancePort struct {
+	// ConnectionString is the connection string of the instance.
+	ConnectionString string `json:"connectionString"`
+
+	// Port is the port on localhost where the proxy accepts connections to
+	// the instance.
+	Port int32 `json:"port"`
+}
+
 // AuthProxyWorkloadList contains a list of AuthProxyWorkload and is part of the
 // authproxyworkloads API.
 // +kubebuilder:object:root=true
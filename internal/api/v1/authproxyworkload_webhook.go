@@ -18,14 +18,18 @@ import (
 	"fmt"
 	"path"
 	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/validation"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	apivalidation "k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -36,9 +40,13 @@ import (
 // log is for logging in this package.
 var authproxyworkloadlog = logf.Log.WithName("authproxyworkload-resource")
 
-func (r *AuthProxyWorkload) SetupWebhookWithManager(mgr ctrl.Manager) error {
+// SetupWebhookWithManager registers the webhooks for the AuthProxyWorkload type.
+// When v is not nil, it validates the resources in place of ValidateCreate and
+// ValidateUpdate, so that they can be checked against other resources.
+func (r *AuthProxyWorkload) SetupWebhookWithManager(mgr ctrl.Manager, v admission.CustomValidator) error {
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(r).
+		WithValidator(v).
 		Complete()
 }
 
@@ -59,7 +67,7 @@ var _ webhook.Validator = &AuthProxyWorkload{}
 
 // ValidateCreate implements webhook.Validator so a webhook will be registered for the type
 func (r *AuthProxyWorkload) ValidateCreate() (admission.Warnings, error) {
-	allErrs := r.validate()
+	allErrs := r.validate(nil)
 	if len(allErrs) > 0 {
 		return nil, apierrors.NewInvalid(
 			schema.GroupKind{
@@ -78,8 +86,16 @@ func (r *AuthProxyWorkload) ValidateUpdate(old runtime.Object) (admission.Warnin
 		return nil, fmt.Errorf("bad request, expected old to be an AuthProxyWorkload")
 	}
 
-	allErrs := r.validate()
-	allErrs = append(allErrs, r.validateUpdateFrom(o)...)
+	// A resource that is being deleted is not validated, so that the
+	// operator can always remove its finalizer.
+	if r.DeletionTimestamp != nil {
+		return nil, nil
+	}
+
+	// The workload selector and the rollout strategy may change on update.
+	// The reconciler moves the proxy from the workloads that no longer match
+	// to the ones that match now.
+	allErrs := r.validate(&o.Spec)
 	if len(allErrs) > 0 {
 		return nil, apierrors.NewInvalid(
 			schema.GroupKind{
@@ -96,14 +112,27 @@ func (r *AuthProxyWorkload) ValidateDelete() (admission.Warnings, error) {
 	return nil, nil
 }
 
-func (r *AuthProxyWorkload) validate() field.ErrorList {
+// validate checks the AuthProxyWorkload. On update, old is the spec before
+// the update.
+func (r *AuthProxyWorkload) validate(old *AuthProxyWorkloadSpec) field.ErrorList {
 	var allErrs field.ErrorList
 
-	allErrs = append(allErrs, validation.ValidateLabelName(r.Name, field.NewPath("metadata", "name"))...)
+	allErrs = append(allErrs, validateName(r.Name, old, field.NewPath("metadata", "name"))...)
 	allErrs = append(allErrs, validateWorkload(&r.Spec.Workload, field.NewPath("spec", "workload"))...)
-	allErrs = append(allErrs, validateInstances(&r.Spec.Instances, field.NewPath("spec", "instances"))...)
+	allErrs = append(allErrs, validateInstances(&r.Spec.Instances, acceptedInstances(&r.Spec, old), proxyType(r.Spec.AuthProxyContainer), field.NewPath("spec", "instances"))...)
 	allErrs = append(allErrs, validateContainer(r.Spec.AuthProxyContainer, field.NewPath("spec", "authProxyContainer"))...)
 
+	if r.Spec.Workload.NamespaceSelector != nil {
+		allErrs = append(allErrs, field.Invalid(
+			field.NewPath("spec", "workload", "namespaceSelector"), r.Spec.Workload.NamespaceSelector,
+			"namespaceSelector may only be set on a ClusterAuthProxyWorkload"))
+	}
+	if r.Spec.RevisionHistoryLimit != nil && *r.Spec.RevisionHistoryLimit < 0 {
+		allErrs = append(allErrs, field.Invalid(
+			field.NewPath("spec", "revisionHistoryLimit"), *r.Spec.RevisionHistoryLimit,
+			"must be at least 0"))
+	}
+
 	return allErrs
 
 }
@@ -136,72 +165,68 @@ func validateContainer(spec *AuthProxyContainerSpec, f *field.Path) field.ErrorL
 				spec.AdminServer.Port, e))
 		}
 	}
-
-	return allErrs
-}
-
-// validateUpdateFrom checks that an update to an AuthProxyWorkload resource
-// adheres to these rules:
-// - No changes to the workload selector
-// - No changes to the RolloutStrategy
-func (r *AuthProxyWorkload) validateUpdateFrom(op *AuthProxyWorkload) field.ErrorList {
-	var allErrs field.ErrorList
-
-	if r.Spec.Workload.Kind != op.Spec.Workload.Kind {
+	if proxyType(spec) == ProxyTypeAlloyDB && spec.Telemetry != nil && spec.Telemetry.QuotaProject != nil {
 		allErrs = append(allErrs, field.Invalid(
-			field.NewPath("spec", "workload", "kind"), r.Spec.Workload.Kind,
-			"kind cannot be changed on update"))
+			f.Child("telemetry", "quotaProject"), *spec.Telemetry.QuotaProject,
+			"quotaProject is not supported when proxyType is AlloyDB"))
 	}
-	if r.Spec.Workload.Name != op.Spec.Workload.Name {
-		allErrs = append(allErrs, field.Invalid(
-			field.NewPath("spec", "workload", "name"), r.Spec.Workload.Name,
-			"kind cannot be changed on update"))
+	if spec.Authentication != nil && spec.Authentication.CredentialsFileSecret != nil {
+		allErrs = append(allErrs, validateSecretKeyRef(spec.Authentication.CredentialsFileSecret,
+			f.Child("authentication", "credentialsFileSecret"))...)
 	}
-	if selectorNotEqual(r.Spec.Workload.Selector, op.Spec.Workload.Selector) {
-		allErrs = append(allErrs, field.Invalid(
-			field.NewPath("spec", "workload", "selector"), r.Spec.Workload.Selector,
-			"selector cannot be changed on update"))
+	if spec.StagedRollout != nil {
+		if spec.RolloutStrategy != StagedStrategy {
+			allErrs = append(allErrs, field.Invalid(
+				f.Child("stagedRollout"), spec.StagedRollout,
+				"stagedRollout may only be set when rolloutStrategy is Staged"))
+		}
+		if spec.StagedRollout.BatchSize != nil {
+			allErrs = append(allErrs, validateCountOrPercent(spec.StagedRollout.BatchSize,
+				f.Child("stagedRollout", "batchSize"))...)
+		}
+	}
+	if spec.EvictRollout != nil {
+		if spec.RolloutStrategy != EvictStrategy {
+			allErrs = append(allErrs, field.Invalid(
+				f.Child("evictRollout"), spec.EvictRollout,
+				"evictRollout may only be set when rolloutStrategy is Evict"))
+		}
+		if spec.EvictRollout.MaxUnavailable != nil {
+			allErrs = append(allErrs, validateCountOrPercent(spec.EvictRollout.MaxUnavailable,
+				f.Child("evictRollout", "maxUnavailable"))...)
+		}
 	}
-
-	allErrs = append(allErrs, validateRolloutStrategyChange(r.Spec.AuthProxyContainer, op.Spec.AuthProxyContainer)...)
 
 	return allErrs
 }
 
-// validateRolloutStrategyChange ensures that the rollout strategy does not
-// change on update, taking default values into account.
-func validateRolloutStrategyChange(c *AuthProxyContainerSpec, oc *AuthProxyContainerSpec) []*field.Error {
-	var allErrs field.ErrorList
-	var (
-		s  = WorkloadStrategy
-		os = WorkloadStrategy
-	)
-	if c != nil && c.RolloutStrategy != "" {
-		s = c.RolloutStrategy
-	}
-	if oc != nil && oc.RolloutStrategy != "" {
-		os = oc.RolloutStrategy
-	}
-	if s != os {
-		allErrs = append(allErrs, field.Invalid(
-			field.NewPath("spec", "authProxyContainer", "rolloutStrategy"), s,
-			fmt.Sprintf("rolloutStrategy cannot be changed on update from %s", os)))
+// validateCountOrPercent checks that the field is a positive number or a
+// percentage between 1% and 100%, like a batchSize or a maxUnavailable.
+func validateCountOrPercent(v *intstr.IntOrString, f *field.Path) field.ErrorList {
+	if v.Type == intstr.Int {
+		if v.IntVal < 1 {
+			return field.ErrorList{field.Invalid(f, v.IntVal, "must be at least 1")}
+		}
+		return nil
 	}
 
-	return allErrs
-
+	n, err := strconv.Atoi(strings.TrimSuffix(v.StrVal, "%"))
+	if !strings.HasSuffix(v.StrVal, "%") || err != nil || n < 1 || n > 100 {
+		return field.ErrorList{field.Invalid(f, v.StrVal,
+			"must be a number or a percentage between 1% and 100%")}
+	}
+	return nil
 }
 
-func selectorNotEqual(s *metav1.LabelSelector, os *metav1.LabelSelector) bool {
-	if s == nil && os == nil {
-		return false
+func validateSecretKeyRef(ref *SecretKeyRef, f *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for _, e := range apivalidation.IsDNS1123Subdomain(ref.Name) {
+		allErrs = append(allErrs, field.Invalid(f.Child("name"), ref.Name, e))
 	}
-
-	if s != nil && os != nil {
-		return !reflect.DeepEqual(s, os)
+	for _, e := range apivalidation.IsConfigMapKey(ref.Key) {
+		allErrs = append(allErrs, field.Invalid(f.Child("key"), ref.Key, e))
 	}
-
-	return true
+	return allErrs
 }
 
 var supportedKinds = []string{"CronJob", "Job", "StatefulSet", "Deployment", "DaemonSet", "ReplicaSet", "Pod"}
@@ -210,13 +235,18 @@ var supportedKinds = []string{"CronJob", "Job", "StatefulSet", "Deployment", "Da
 //   - Either Name or Selector is set
 //   - Kind is one of the supported kinds: "CronJob", "Job", "StatefulSet",
 //     "Deployment", "DaemonSet", "ReplicaSet", "Pod"
-//   - Selector is valid according to the k8s validation rules for LabelSelector
+//   - Selector and NamespaceSelector are valid according to the k8s validation
+//     rules for LabelSelector
 func validateWorkload(spec *WorkloadSelectorSpec, f *field.Path) field.ErrorList {
 	var errs field.ErrorList
 	if spec.Selector != nil {
 		verr := validation.ValidateLabelSelector(spec.Selector, validation.LabelSelectorValidationOptions{}, f.Child("selector"))
 		errs = append(errs, verr...)
 	}
+	if spec.NamespaceSelector != nil {
+		verr := validation.ValidateLabelSelector(spec.NamespaceSelector, validation.LabelSelectorValidationOptions{}, f.Child("namespaceSelector"))
+		errs = append(errs, verr...)
+	}
 
 	if spec.Name != "" && spec.Selector != nil {
 		errs = append(errs, field.Invalid(f.Child("name"), spec,
@@ -253,7 +283,16 @@ func validateWorkload(spec *WorkloadSelectorSpec, f *field.Path) field.ErrorList
 //   - UnixSocketPath contains an absolute path.
 //   - The configuration clearly specifies either a TCP or a Unix socket but not
 //     both.
-func validateInstances(spec *[]InstanceSpec, f *field.Path) field.ErrorList {
+//   - ConnectionString has the format used by the proxyType, and PrivateIP is
+//     not set for AlloyDB.
+//   - Each ConnectionString is used only once.
+//
+// The ConnectionString format rule is not applied to the instances that are
+// in accepted, the instances that were stored before the update, and a
+// duplicate is only reported when one of the two instances is not in
+// accepted. This way a resource stored before these rules were added can still
+// be updated, as long as its instances are not changed.
+func validateInstances(spec *[]InstanceSpec, accepted []InstanceSpec, proxyType string, f *field.Path) field.ErrorList {
 	var errs field.ErrorList
 	if len(*spec) == 0 {
 		errs = append(errs, field.Invalid(f,
@@ -261,8 +300,34 @@ func validateInstances(spec *[]InstanceSpec, f *field.Path) field.ErrorList {
 			"at least one database instance must be declared"))
 		return errs
 	}
+	// Find the changed instances and the instances of each connection string
+	// first, so that a changed instance that duplicates an unchanged one is
+	// found in any order.
+	changed := make([]bool, len(*spec))
+	connStrs := make(map[string][]int, len(*spec))
 	for i, inst := range *spec {
-		ff := f.Child(fmt.Sprintf("%d", i))
+		changed[i] = !slices.ContainsFunc(accepted, func(a InstanceSpec) bool {
+			return reflect.DeepEqual(a, inst)
+		})
+		connStrs[inst.ConnectionString] = append(connStrs[inst.ConnectionString], i)
+	}
+	for i, inst := range *spec {
+		ff := f.Index(i)
+		if changed[i] {
+			errs = append(errs, validateConnectionString(ff.Child("connectionString"), inst.ConnectionString, proxyType)...)
+		}
+		// A duplicate is reported on the later instance of the pair, unless
+		// both instances are unchanged.
+		dup := slices.ContainsFunc(connStrs[inst.ConnectionString], func(j int) bool {
+			return j < i && (changed[i] || changed[j])
+		})
+		if inst.ConnectionString != "" && dup {
+			errs = append(errs, field.Duplicate(ff.Child("connectionString"), inst.ConnectionString))
+		}
+		if proxyType == ProxyTypeAlloyDB && inst.PrivateIP != nil {
+			errs = append(errs, field.Invalid(ff.Child("privateIP"), *inst.PrivateIP,
+				"privateIP may not be set when proxyType is AlloyDB"))
+		}
 		if inst.Port != nil {
 			for _, s := range apivalidation.IsValidPortNum(int(*inst.Port)) {
 				errs = append(errs, field.Invalid(ff.Child("port"), inst.Port, s))
@@ -293,6 +358,79 @@ func validateInstances(spec *[]InstanceSpec, f *field.Path) field.ErrorList {
 	return errs
 }
 
+var (
+	// gcpNamePattern matches the project ID, region and instance name of a
+	// Cloud SQL instance: lowercase letters, numbers and hyphens, starting
+	// with a letter and not ending with a hyphen.
+	gcpNamePattern = `[a-z](?:[-a-z0-9]*[a-z0-9])?`
+
+	// cloudSQLConnectionStringRE matches project:region:instance, where the
+	// project may be a domain-scoped project like example.com:project.
+	cloudSQLConnectionStringRE = regexp.MustCompile(
+		`^(?:[a-z0-9](?:[-a-z0-9.]*[a-z0-9])?:)?` + gcpNamePattern + `:` + gcpNamePattern + `:` + gcpNamePattern + `$`)
+	alloyDBInstanceURIRE = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/clusters/[^/]+/instances/[^/]+$`)
+)
+
+// validateConnectionString ensures that the connection string has the format
+// expected by the proxy selected by proxyType. A Cloud SQL instance may also be
+// set by a DNS name, like prod-db.example.com, that the proxy resolves to the
+// instance.
+func validateConnectionString(f *field.Path, connStr, proxyType string) field.ErrorList {
+	if connStr == "" {
+		return field.ErrorList{field.Required(f, "the instance's connection string must be set")}
+	}
+	if proxyType == ProxyTypeAlloyDB {
+		if !alloyDBInstanceURIRE.MatchString(connStr) {
+			return field.ErrorList{field.Invalid(f, connStr,
+				"must be an AlloyDB instance URI in the format projects/<project>/locations/<region>/clusters/<cluster>/instances/<instance>")}
+		}
+		return nil
+	}
+	if cloudSQLConnectionStringRE.MatchString(connStr) || isInstanceDNSName(connStr) {
+		return nil
+	}
+	return field.ErrorList{field.Invalid(f, connStr,
+		"must be a Cloud SQL instance connection name in the format project:region:instance, "+
+			"where the project may be domain-scoped like example.com:project, or a DNS name for the instance")}
+}
+
+// isInstanceDNSName returns true when s is a DNS name with at least two labels.
+func isInstanceDNSName(s string) bool {
+	return strings.Contains(s, ".") && len(apivalidation.IsDNS1123Subdomain(s)) == 0
+}
+
+// validateName checks the name of the resource. On create, when old is nil,
+// the name may not be AnnotationProxyName, because the proxy requested by
+// the pod annotations would get the same containers and volumes. Resources
+// created with that name before can still be updated.
+func validateName(name string, old *AuthProxyWorkloadSpec, f *field.Path) field.ErrorList {
+	allErrs := validation.ValidateLabelName(name, f)
+	if old == nil && name == AnnotationProxyName {
+		allErrs = append(allErrs, field.Invalid(f, name,
+			"the name is reserved for the proxy requested by the pod annotations"))
+	}
+	return allErrs
+}
+
+// acceptedInstances returns the instances of old, the spec before an update
+// to spec, that were already accepted with the same proxyType. It returns
+// nil on create, or when the proxyType changed.
+func acceptedInstances(spec, old *AuthProxyWorkloadSpec) []InstanceSpec {
+	if old == nil || proxyType(old.AuthProxyContainer) != proxyType(spec.AuthProxyContainer) {
+		return nil
+	}
+	return old.Instances
+}
+
+// proxyType returns the ProxyType of the container spec, taking the default
+// value into account.
+func proxyType(spec *AuthProxyContainerSpec) string {
+	if spec == nil || spec.ProxyType == "" {
+		return ProxyTypeCloudSQL
+	}
+	return spec.ProxyType
+}
+
 func validateEnvName(f *field.Path, envName string) field.ErrorList {
 	var errs field.ErrorList
 	if envName != "" {
@@ -0,0 +1,98 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterAuthProxyWorkloadKind is the kind of the ClusterAuthProxyWorkload
+// resource.
+const ClusterAuthProxyWorkloadKind = "ClusterAuthProxyWorkload"
+
+// ClusterAuthProxyWorkload declares how a Cloud SQL Proxy container should be
+// applied to a matching set of workloads in any namespace, and shows the status
+// of those proxy containers. Use `spec.workloadSelector.namespaceSelector` to
+// limit the namespaces where the proxy is applied.
+//
+// When an AuthProxyWorkload and a ClusterAuthProxyWorkload both match the
+// same workload and use one of the same instances, the AuthProxyWorkload
+// takes precedence and the ClusterAuthProxyWorkload is not applied to that
+// workload.
+//
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+type ClusterAuthProxyWorkload struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AuthProxyWorkloadSpec   `json:"spec,omitempty"`
+	Status AuthProxyWorkloadStatus `json:"status,omitempty"`
+}
+
+// AuthProxyWorkload returns an AuthProxyWorkload with the same metadata, spec
+// and status as this ClusterAuthProxyWorkload. The operator uses this to
+// configure workloads in the same way for both kinds of resources. The
+// returned AuthProxyWorkload keeps the ClusterAuthProxyWorkload kind, see
+// AuthProxyWorkload.IsClusterScoped.
+func (r *ClusterAuthProxyWorkload) AuthProxyWorkload() *AuthProxyWorkload {
+	p := &AuthProxyWorkload{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       ClusterAuthProxyWorkloadKind,
+			APIVersion: GroupVersion.String(),
+		},
+	}
+	r.ObjectMeta.DeepCopyInto(&p.ObjectMeta)
+	r.Spec.DeepCopyInto(&p.Spec)
+	r.Status.DeepCopyInto(&p.Status)
+	return p
+}
+
+// NewClusterAuthProxyWorkload returns a ClusterAuthProxyWorkload with the same
+// metadata, spec and status as the cluster-scoped AuthProxyWorkload p.
+// This is the inverse of ClusterAuthProxyWorkload.AuthProxyWorkload.
+func NewClusterAuthProxyWorkload(p *AuthProxyWorkload) *ClusterAuthProxyWorkload {
+	r := &ClusterAuthProxyWorkload{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       ClusterAuthProxyWorkloadKind,
+			APIVersion: GroupVersion.String(),
+		},
+	}
+	p.ObjectMeta.DeepCopyInto(&r.ObjectMeta)
+	p.Spec.DeepCopyInto(&r.Spec)
+	p.Status.DeepCopyInto(&r.Status)
+	return r
+}
+
+// IsClusterScoped returns true when this AuthProxyWorkload was created from
+// a ClusterAuthProxyWorkload using ClusterAuthProxyWorkload.AuthProxyWorkload.
+func (r *AuthProxyWorkload) IsClusterScoped() bool {
+	return r.Kind == ClusterAuthProxyWorkloadKind
+}
+
+// ClusterAuthProxyWorkloadList contains a list of ClusterAuthProxyWorkload and
+// is part of the clusterauthproxyworkloads API.
+// +kubebuilder:object:root=true
+type ClusterAuthProxyWorkloadList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterAuthProxyWorkload `json:"items"`
+}
+
+// init registers these resource definitions with the controller-runtime framework.
+func init() {
+	SchemeBuilder.Register(&ClusterAuthProxyWorkload{}, &ClusterAuthProxyWorkloadList{})
+}
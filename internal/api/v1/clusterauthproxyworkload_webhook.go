@@ -0,0 +1,119 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var clusterauthproxyworkloadlog = logf.Log.WithName("clusterauthproxyworkload-resource")
+
+// SetupWebhookWithManager registers the webhooks for the ClusterAuthProxyWorkload type.
+// When v is not nil, it validates the resources in place of ValidateCreate and
+// ValidateUpdate, so that they can be checked against other resources.
+func (r *ClusterAuthProxyWorkload) SetupWebhookWithManager(mgr ctrl.Manager, v admission.CustomValidator) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithValidator(v).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-cloudsql-cloud-google-com-v1-clusterauthproxyworkload,mutating=true,failurePolicy=fail,sideEffects=None,groups=cloudsql.cloud.google.com,resources=clusterauthproxyworkloads,verbs=create;update,versions=v1,name=mclusterauthproxyworkload.kb.io,admissionReviewVersions=v1
+var _ webhook.Defaulter = &ClusterAuthProxyWorkload{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *ClusterAuthProxyWorkload) Default() {
+	clusterauthproxyworkloadlog.Info("default", "name", r.Name)
+	if r.Spec.AuthProxyContainer != nil &&
+		r.Spec.AuthProxyContainer.RolloutStrategy == "" {
+		r.Spec.AuthProxyContainer.RolloutStrategy = WorkloadStrategy
+	}
+}
+
+// +kubebuilder:webhook:path=/validate-cloudsql-cloud-google-com-v1-clusterauthproxyworkload,mutating=false,failurePolicy=fail,sideEffects=None,groups=cloudsql.cloud.google.com,resources=clusterauthproxyworkloads,verbs=create;update,versions=v1,name=vclusterauthproxyworkload.kb.io,admissionReviewVersions=v1
+var _ webhook.Validator = &ClusterAuthProxyWorkload{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *ClusterAuthProxyWorkload) ValidateCreate() (admission.Warnings, error) {
+	allErrs := r.validate(nil)
+	if len(allErrs) > 0 {
+		return nil, apierrors.NewInvalid(
+			schema.GroupKind{
+				Group: GroupVersion.Group,
+				Kind:  "ClusterAuthProxyWorkload"},
+			r.Name, allErrs)
+	}
+	return nil, nil
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *ClusterAuthProxyWorkload) ValidateUpdate(old runtime.Object) (admission.Warnings, error) {
+	o, ok := old.(*ClusterAuthProxyWorkload)
+	if !ok {
+		return nil, fmt.Errorf("bad request, expected old to be a ClusterAuthProxyWorkload")
+	}
+
+	// A resource that is being deleted is not validated, so that the
+	// operator can always remove its finalizer.
+	if r.DeletionTimestamp != nil {
+		return nil, nil
+	}
+
+	allErrs := r.validate(&o.Spec)
+	if len(allErrs) > 0 {
+		return nil, apierrors.NewInvalid(
+			schema.GroupKind{
+				Group: GroupVersion.Group,
+				Kind:  "ClusterAuthProxyWorkload"},
+			r.Name, allErrs)
+	}
+	return nil, nil
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *ClusterAuthProxyWorkload) ValidateDelete() (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validate checks the ClusterAuthProxyWorkload using the same rules as an
+// AuthProxyWorkload, except that spec.workload.namespaceSelector is allowed
+// and spec.revisionHistoryLimit is not. On update, old is the spec before the
+// update.
+func (r *ClusterAuthProxyWorkload) validate(old *AuthProxyWorkloadSpec) field.ErrorList {
+	var allErrs field.ErrorList
+
+	allErrs = append(allErrs, validateName(r.Name, old, field.NewPath("metadata", "name"))...)
+	allErrs = append(allErrs, validateWorkload(&r.Spec.Workload, field.NewPath("spec", "workload"))...)
+	allErrs = append(allErrs, validateInstances(&r.Spec.Instances, acceptedInstances(&r.Spec, old), proxyType(r.Spec.AuthProxyContainer), field.NewPath("spec", "instances"))...)
+	allErrs = append(allErrs, validateContainer(r.Spec.AuthProxyContainer, field.NewPath("spec", "authProxyContainer"))...)
+
+	if r.Spec.RevisionHistoryLimit != nil {
+		allErrs = append(allErrs, field.Invalid(
+			field.NewPath("spec", "revisionHistoryLimit"), *r.Spec.RevisionHistoryLimit,
+			"revisionHistoryLimit may only be set on an AuthProxyWorkload"))
+	}
+
+	return allErrs
+}
@@ -17,18 +17,32 @@ package controller
 import (
 	"context"
 	"fmt"
+	"maps"
+	"reflect"
+	"strings"
 	"sync"
 	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
 	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/workload"
@@ -37,6 +51,15 @@ import (
 
 const finalizerName = cloudsqlapi.AnnotationPrefix + "/AuthProxyWorkload-finalizer"
 
+// workloadKindField is the name of the field index holding the kind of
+// workload selected by an AuthProxyWorkload or ClusterAuthProxyWorkload.
+const workloadKindField = "spec.workloadSelector.kind"
+
+// credentialsSecretField is the name of the field index holding the name of
+// the credentials file Secret used by an AuthProxyWorkload or
+// ClusterAuthProxyWorkload.
+const credentialsSecretField = "spec.authProxyContainer.authentication.credentialsFileSecret.name"
+
 var (
 	requeueNow       = ctrl.Result{Requeue: true}
 	requeueWithDelay = ctrl.Result{Requeue: true, RequeueAfter: 30 * time.Second}
@@ -72,42 +95,337 @@ type AuthProxyWorkloadReconciler struct {
 	Scheme          *runtime.Scheme
 	recentlyDeleted *recentlyDeletedCache
 	updater         *workload.Updater
+	recorder        record.EventRecorder
+
+	// apiReader reads from the API server instead of the cache. It reads the
+	// revision history when the cache is behind the resource's status, or
+	// does not have a revision that already exists.
+	apiReader client.Reader
+
+	// defaultsChanged and clusterDefaultsChanged receive the AuthProxyWorkload
+	// and ClusterAuthProxyWorkload resources to reconcile when the operator
+	// defaults change.
+	defaultsChanged        chan event.GenericEvent
+	clusterDefaultsChanged chan event.GenericEvent
+
+	// rollouts limits the number of workloads rolling out a new proxy
+	// configuration at the same time.
+	rollouts rolloutLimiter
 }
 
 // NewAuthProxyWorkloadManager constructs an AuthProxyWorkloadReconciler
 func NewAuthProxyWorkloadReconciler(mgr ctrl.Manager, u *workload.Updater) (*AuthProxyWorkloadReconciler, error) {
 	r := &AuthProxyWorkloadReconciler{
-		Client:          mgr.GetClient(),
-		Scheme:          mgr.GetScheme(),
-		recentlyDeleted: &recentlyDeletedCache{},
-		updater:         u,
+		Client:                 mgr.GetClient(),
+		Scheme:                 mgr.GetScheme(),
+		recentlyDeleted:        &recentlyDeletedCache{},
+		updater:                u,
+		recorder:               mgr.GetEventRecorderFor("cloud-sql-proxy-operator"),
+		apiReader:              mgr.GetAPIReader(),
+		defaultsChanged:        make(chan event.GenericEvent),
+		clusterDefaultsChanged: make(chan event.GenericEvent),
 	}
 	err := r.SetupWithManager(mgr)
 	return r, err
 }
 
+// fieldIndex is a field index on the operator's cache.
+type fieldIndex struct {
+	obj     client.Object
+	field   string
+	extract client.IndexerFunc
+}
+
+// fieldIndexes returns the field indexes that the operator uses to find
+// AuthProxyWorkload and ClusterAuthProxyWorkload resources. The pod webhook
+// uses the workloadKindField index to list only the resources that select the
+// kind of the pod or one of its owners.
+func fieldIndexes() []fieldIndex {
+	return []fieldIndex{
+		{&cloudsqlapi.AuthProxyWorkload{}, workloadKindField, indexWorkloadKind},
+		{&cloudsqlapi.ClusterAuthProxyWorkload{}, workloadKindField, indexWorkloadKind},
+		{&cloudsqlapi.AuthProxyWorkload{}, credentialsSecretField, indexCredentialsSecret},
+		{&cloudsqlapi.ClusterAuthProxyWorkload{}, credentialsSecretField, indexCredentialsSecret},
+	}
+}
+
+// FieldIndexValues returns the values that the operator's field index named
+// field holds for obj. It returns false when the operator has no index with
+// that name for the type of obj. The preview command uses it to list
+// resources by a field index without the operator's cache.
+func FieldIndexValues(obj client.Object, field string) ([]string, bool) {
+	for _, fi := range fieldIndexes() {
+		if fi.field == field && reflect.TypeOf(fi.obj) == reflect.TypeOf(obj) {
+			return fi.extract(obj), true
+		}
+	}
+	return nil, false
+}
+
 // SetupWithManager adds this AuthProxyWorkload controller to the controller-runtime
-// manager.
+// manager. The controller also watches the supported workload kinds, so that
+// the status and annotations are updated when a matching workload is created,
+// deleted, or changes its labels, annotations or pod template. Pods are not
+// watched, the pod webhook and the podDeleteController handle them. The
+// progress of a rollout is read when a resource with a rollout in progress is
+// requeued.
 func (r *AuthProxyWorkloadReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	ctx := context.Background()
+	for _, fi := range fieldIndexes() {
+		err := mgr.GetFieldIndexer().IndexField(ctx, fi.obj, fi.field, fi.extract)
+		if err != nil {
+			return err
+		}
+	}
+
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&cloudsqlapi.AuthProxyWorkload{})
+	for _, kind := range workload.WorkloadKinds {
+		if kind == "Pod" {
+			continue
+		}
+		wl, err := workload.WorkloadForKind(kind)
+		if err != nil {
+			return err
+		}
+		b = b.Watches(wl.Object(), handler.EnqueueRequestsFromMapFunc(r.requestsForWorkload(kind)),
+			builder.WithPredicates(workloadChangedPredicate()))
+	}
+	// Only the metadata of Secrets is watched, so that the operator does not
+	// cache the contents of the Secrets in the cluster.
+	b = b.WatchesMetadata(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.requestsForSecret))
+	// A namespace may be excluded from proxy injection by an annotation.
+	b = b.Watches(&corev1.Namespace{}, handler.EnqueueRequestsFromMapFunc(r.requestsForNamespace),
+		builder.WithPredicates(predicate.AnnotationChangedPredicate{}))
+	b = b.WatchesRawSource(&source.Channel{Source: r.defaultsChanged}, &handler.EnqueueRequestForObject{})
+	err := b.Complete(r)
+	if err != nil {
+		return err
+	}
+
+	// ClusterAuthProxyWorkload resources are reconciled by the same reconciler.
+	// Their requests have an empty namespace.
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&cloudsqlapi.AuthProxyWorkload{}).
+		For(&cloudsqlapi.ClusterAuthProxyWorkload{}).
+		WatchesRawSource(&source.Channel{Source: r.clusterDefaultsChanged}, &handler.EnqueueRequestForObject{}).
 		Complete(r)
 }
 
+// workloadChangedPredicate passes the events of a workload, except the updates
+// that change neither its labels, its annotations nor its pod template, like
+// the updates of its status. Only those can change which AuthProxyWorkloads
+// match the workload, or the configuration of its pods.
+func workloadChangedPredicate() predicate.Funcs {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return !maps.Equal(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels()) ||
+				!maps.Equal(e.ObjectOld.GetAnnotations(), e.ObjectNew.GetAnnotations()) ||
+				!equality.Semantic.DeepEqual(podTemplate(e.ObjectOld), podTemplate(e.ObjectNew))
+		},
+	}
+}
+
+// podTemplate returns the pod template of a workload object o, or nil when o
+// does not have one.
+func podTemplate(o client.Object) *corev1.PodTemplateSpec {
+	switch w := o.(type) {
+	case *appsv1.Deployment:
+		return &w.Spec.Template
+	case *appsv1.StatefulSet:
+		return &w.Spec.Template
+	case *appsv1.DaemonSet:
+		return &w.Spec.Template
+	case *appsv1.ReplicaSet:
+		return &w.Spec.Template
+	case *batchv1.Job:
+		return &w.Spec.Template
+	case *batchv1.CronJob:
+		return &w.Spec.JobTemplate.Spec.Template
+	default:
+		return nil
+	}
+}
+
+// indexCredentialsSecret returns the name of the credentials file Secret
+// used by an AuthProxyWorkload or ClusterAuthProxyWorkload.
+func indexCredentialsSecret(o client.Object) []string {
+	var name string
+	switch p := o.(type) {
+	case *cloudsqlapi.AuthProxyWorkload:
+		name = workload.CredentialsFileSecretName(p)
+	case *cloudsqlapi.ClusterAuthProxyWorkload:
+		name = workload.CredentialsFileSecretName(p.AuthProxyWorkload())
+	}
+	if name == "" {
+		return nil
+	}
+	return []string{name}
+}
+
+// requestsForSecret finds the AuthProxyWorkload and ClusterAuthProxyWorkload
+// resources that use a Secret as the proxy credentials file, using the
+// credentialsSecretField index.
+func (r *AuthProxyWorkloadReconciler) requestsForSecret(ctx context.Context, o client.Object) []reconcile.Request {
+	l := log.FromContext(ctx)
+	var reqs []reconcile.Request
+
+	pl := &cloudsqlapi.AuthProxyWorkloadList{}
+	err := r.List(ctx, pl, client.InNamespace(o.GetNamespace()), client.MatchingFields{credentialsSecretField: o.GetName()})
+	if err != nil {
+		l.Error(err, "Unable to list AuthProxyWorkloads for secret",
+			"ns", o.GetNamespace(), "name", o.GetName())
+		return nil
+	}
+	for i := range pl.Items {
+		reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&pl.Items[i])})
+	}
+
+	cpl := &cloudsqlapi.ClusterAuthProxyWorkloadList{}
+	err = r.List(ctx, cpl, client.MatchingFields{credentialsSecretField: o.GetName()})
+	if err != nil {
+		l.Error(err, "Unable to list ClusterAuthProxyWorkloads for secret",
+			"ns", o.GetNamespace(), "name", o.GetName())
+		return reqs
+	}
+	if len(cpl.Items) == 0 {
+		return reqs
+	}
+	ns := &corev1.Namespace{}
+	err = r.Get(ctx, client.ObjectKey{Name: o.GetNamespace()}, ns)
+	if err != nil {
+		l.Error(err, "Unable to get namespace for secret",
+			"ns", o.GetNamespace(), "name", o.GetName())
+		return reqs
+	}
+	for i := range cpl.Items {
+		if workload.NamespaceMatches(&cpl.Items[i], ns) {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&cpl.Items[i])})
+		}
+	}
+	return reqs
+}
+
+// requestsForNamespace finds the AuthProxyWorkload resources in a namespace
+// and the ClusterAuthProxyWorkload resources that select the namespace.
+func (r *AuthProxyWorkloadReconciler) requestsForNamespace(ctx context.Context, o client.Object) []reconcile.Request {
+	l := log.FromContext(ctx)
+	var reqs []reconcile.Request
+
+	pl := &cloudsqlapi.AuthProxyWorkloadList{}
+	err := r.List(ctx, pl, client.InNamespace(o.GetName()))
+	if err != nil {
+		l.Error(err, "Unable to list AuthProxyWorkloads for namespace", "ns", o.GetName())
+		return nil
+	}
+	for i := range pl.Items {
+		reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&pl.Items[i])})
+	}
+
+	ns, ok := o.(*corev1.Namespace)
+	if !ok {
+		return reqs
+	}
+	cpl := &cloudsqlapi.ClusterAuthProxyWorkloadList{}
+	err = r.List(ctx, cpl)
+	if err != nil {
+		l.Error(err, "Unable to list ClusterAuthProxyWorkloads for namespace", "ns", o.GetName())
+		return reqs
+	}
+	for i := range cpl.Items {
+		if workload.NamespaceMatches(&cpl.Items[i], ns) {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&cpl.Items[i])})
+		}
+	}
+	return reqs
+}
+
+// indexWorkloadKind returns the kind of workload selected by an
+// AuthProxyWorkload or ClusterAuthProxyWorkload, without the version or group.
+func indexWorkloadKind(o client.Object) []string {
+	var sel cloudsqlapi.WorkloadSelectorSpec
+	switch p := o.(type) {
+	case *cloudsqlapi.AuthProxyWorkload:
+		sel = p.Spec.Workload
+	case *cloudsqlapi.ClusterAuthProxyWorkload:
+		sel = p.Spec.Workload
+	default:
+		return nil
+	}
+	_, gk := schema.ParseKindArg(sel.Kind)
+	return []string{gk.Kind}
+}
+
+// requestsForWorkload returns a handler.MapFunc that finds the
+// AuthProxyWorkload and ClusterAuthProxyWorkload resources matching a
+// workload of the specified kind, using the workloadKindField index.
+func (r *AuthProxyWorkloadReconciler) requestsForWorkload(kind string) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		l := log.FromContext(ctx)
+		var reqs []reconcile.Request
+
+		pl := &cloudsqlapi.AuthProxyWorkloadList{}
+		err := r.List(ctx, pl, client.InNamespace(o.GetNamespace()), client.MatchingFields{workloadKindField: kind})
+		if err != nil {
+			l.Error(err, "Unable to list AuthProxyWorkloads for workload",
+				"kind", kind, "ns", o.GetNamespace(), "name", o.GetName())
+			return nil
+		}
+		for i := range pl.Items {
+			if workload.AuthProxyWorkloadMatches(o, kind, &pl.Items[i]) {
+				reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&pl.Items[i])})
+			}
+		}
+
+		cpl := &cloudsqlapi.ClusterAuthProxyWorkloadList{}
+		err = r.List(ctx, cpl, client.MatchingFields{workloadKindField: kind})
+		if err != nil {
+			l.Error(err, "Unable to list ClusterAuthProxyWorkloads for workload",
+				"kind", kind, "ns", o.GetNamespace(), "name", o.GetName())
+			return reqs
+		}
+		if len(cpl.Items) == 0 {
+			return reqs
+		}
+		ns := &corev1.Namespace{}
+		err = r.Get(ctx, client.ObjectKey{Name: o.GetNamespace()}, ns)
+		if err != nil {
+			l.Error(err, "Unable to get namespace for workload",
+				"kind", kind, "ns", o.GetNamespace(), "name", o.GetName())
+			return reqs
+		}
+		for i := range cpl.Items {
+			cp := &cpl.Items[i]
+			if workload.NamespaceMatches(cp, ns) && workload.AuthProxyWorkloadMatches(o, kind, cp.AuthProxyWorkload()) {
+				reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(cp)})
+			}
+		}
+		return reqs
+	}
+}
+
 //+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets;replicasets,verbs=update;patch
+//+kubebuilder:rbac:groups=apps,resources=controllerrevisions,verbs=create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=*,verbs=get;list;watch
 //+kubebuilder:rbac:groups=batch,resources=*,verbs=get;list;watch
 //+kubebuilder:rbac:groups="",resources=*,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 //+kubebuilder:rbac:groups=cloudsql.cloud.google.com,resources=authproxyworkloads,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=cloudsql.cloud.google.com,resources=authproxyworkloads/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=cloudsql.cloud.google.com,resources=authproxyworkloads/finalizers,verbs=update
+//+kubebuilder:rbac:groups=cloudsql.cloud.google.com,resources=clusterauthproxyworkloads,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cloudsql.cloud.google.com,resources=clusterauthproxyworkloads/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=cloudsql.cloud.google.com,resources=clusterauthproxyworkloads/finalizers,verbs=update
 
 //+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=pods/eviction,verbs=create
 
 // Reconcile updates the state of the cluster so that AuthProxyWorkload instances
 // have their configuration reflected correctly on workload PodSpec configuration.
 // This reconcile loop runs when an AuthProxyWorkload is added, modified or deleted.
+// ClusterAuthProxyWorkload resources are reconciled here too. A request with an
+// empty namespace is for a ClusterAuthProxyWorkload, which is converted to a
+// cluster-scoped AuthProxyWorkload.
 // It updates annotations on matching workloads indicating those workload that
 // need to be updated.
 //
@@ -128,10 +446,9 @@ func (r *AuthProxyWorkloadReconciler) Reconcile(ctx context.Context, req ctrl.Re
 	l := log.FromContext(ctx)
 	var err error
 
-	resource := &cloudsqlapi.AuthProxyWorkload{}
-
 	l.Info("Reconcile loop started AuthProxyWorkload", "name", req.NamespacedName)
-	if err = r.Get(ctx, req.NamespacedName, resource); err != nil {
+	resource, err := r.loadResource(ctx, req.NamespacedName)
+	if err != nil {
 		// The resource can't be loaded.
 		// If it was recently deleted, then ignore the error and don't requeue.
 		if r.recentlyDeleted.get(req.NamespacedName) {
@@ -167,6 +484,40 @@ func (r *AuthProxyWorkloadReconciler) Reconcile(ctx context.Context, req ctrl.Re
 	return r.doCreateUpdate(ctx, l, resource)
 }
 
+// loadResource loads the AuthProxyWorkload for key. When key has no namespace,
+// it loads the ClusterAuthProxyWorkload and converts it to a cluster-scoped
+// AuthProxyWorkload.
+func (r *AuthProxyWorkloadReconciler) loadResource(ctx context.Context, key types.NamespacedName) (*cloudsqlapi.AuthProxyWorkload, error) {
+	if key.Namespace == "" {
+		cr := &cloudsqlapi.ClusterAuthProxyWorkload{}
+		if err := r.Get(ctx, key, cr); err != nil {
+			return nil, err
+		}
+		return cr.AuthProxyWorkload(), nil
+	}
+
+	resource := &cloudsqlapi.AuthProxyWorkload{}
+	if err := r.Get(ctx, key, resource); err != nil {
+		return nil, err
+	}
+	return resource, nil
+}
+
+// updateResource saves the resource, converting it back to a
+// ClusterAuthProxyWorkload when it is cluster-scoped.
+func (r *AuthProxyWorkloadReconciler) updateResource(ctx context.Context, resource *cloudsqlapi.AuthProxyWorkload) error {
+	if !resource.IsClusterScoped() {
+		return r.Update(ctx, resource)
+	}
+
+	cr := cloudsqlapi.NewClusterAuthProxyWorkload(resource)
+	if err := r.Update(ctx, cr); err != nil {
+		return err
+	}
+	resource.ObjectMeta = *cr.ObjectMeta.DeepCopy()
+	return nil
+}
+
 // doDelete removes our finalizer and updates the related workloads
 // when the reconcile loop receives an AuthProxyWorkload that was deleted.
 func (r *AuthProxyWorkloadReconciler) doDelete(ctx context.Context, resource *cloudsqlapi.AuthProxyWorkload) (ctrl.Result, error) {
@@ -182,14 +533,28 @@ func (r *AuthProxyWorkloadReconciler) doDelete(ctx context.Context, resource *cl
 		return requeueNow, err
 	}
 
+	// With the Evict strategy, keep the finalizer until the pods with the
+	// proxy were evicted.
+	if isRolloutStrategyEvict(resource) {
+		pending, err := r.updateWorkloadEvictions(ctx, resource, allWorkloads)
+		if err != nil {
+			return requeueNow, err
+		}
+		if pending > 0 {
+			return requeueWithDelay, nil
+		}
+	}
+
 	// Remove the finalizer so that the object can be fully deleted
 	if controllerutil.ContainsFinalizer(resource, finalizerName) {
 		controllerutil.RemoveFinalizer(resource, finalizerName)
-		err = r.Update(ctx, resource)
+		err = r.updateResource(ctx, resource)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
 	}
+	r.updater.ForgetAuthProxyWorkload(resource)
+	r.rollouts.releaseProxy(types.NamespacedName{Namespace: resource.Namespace, Name: resource.Name})
 
 	return ctrl.Result{}, nil
 }
@@ -199,28 +564,51 @@ func (r *AuthProxyWorkloadReconciler) doDelete(ctx context.Context, resource *cl
 //
 // This is implemented as a state machine. The current state is determined using
 // - the absence or presence of this controller's finalizer
+// - the absence or presence of the RollbackAnnotation
 // - the success or error when retrieving workloads related to this resource
 // - the number of workloads needing updates
+// - the number of workloads with pods that don't run the current proxy configuration
+// - the number of workloads where the proxy configuration has errors
 // - the condition `UpToDate` status and reason
 //
 // States:
-// |  state  | finalizer| fetch err | len(wl) | outOfDateCount | Name                                  |
-// |---------|----------|-----------|---------|----------------|---------------------------------------|
-// | 0       | *        | *         | *       |                | start                                 |
-// | 1.1     | absent   | *         | *       |                | needs finalizer                       |
-// | 1.2     | present  | error     | *       |                | can't list workloads                  |
-// | 2.1     | present  | nil       | == 0    |                | no workloads to reconcile             |
-// | 3.1     | present  | nil       | > 0     | > 0 , err      | workload update needed, and failed    |
-// | 3.2     | present  | nil       | > 0     | > 0            | workload update needed, and succeeded |
-// | 3.3     | present  | nil       | > 0     | == 0           | workloads reconciled                  |
+// |  state  | finalizer| fetch err | len(wl) | configErrors | outOfDateCount | rollingOut | Name                                  |
+// |---------|----------|-----------|---------|--------------|----------------|------------|---------------------------------------|
+// | 0       | *        | *         | *       |              |                |            | start                                 |
+// | 1.1     | absent   | *         | *       |              |                |            | needs finalizer                       |
+// | 1.2     | present  | error     | *       |              |                |            | can't list workloads                  |
+// | 1.3     | present  | *         | *       |              |                |            | rollback requested                    |
+// | 1.4     | present  | *         | *       |              |                |            | can't record revision                 |
+// | 2.1     | present  | nil       | == 0    |              |                |            | no workloads to reconcile             |
+// | 3.1     | present  | nil       | > 0     |              | > 0 , err      |            | workload update needed, and failed    |
+// | 3.5     | present  | nil       | > 0     | > 0          | *              |            | workload configuration errors         |
+// | 3.2     | present  | nil       | > 0     | == 0         | > 0            |            | workload update needed, and succeeded |
+// | 3.3     | present  | nil       | > 0     | == 0         | == 0           | == 0       | workloads reconciled                  |
+// | 3.4     | present  | nil       | > 0     | == 0         | == 0           | > 0        | workload rollout in progress          |
+// | 3.6     | present  | nil       | > 0     | *            | *              | *          | staged rollout paused                 |
+// | 3.7     | present  | nil       | > 0     | == 0         | > 0            | *          | staged rollout batch in progress      |
+// | 3.8     | present  | nil       | > 0     | == 0         | > 0, queued    | *          | workload rollouts queued              |
+// | 3.9     | present  | nil       | > 0     | == 0         | == 0           | blocked    | pod evictions blocked                 |
+// | 3.10    | present  | nil       | > 0     | == 0         | restarted      | *          | workload pods restarted               |
+// | 3.11    | present  | nil       | > 0     | == 0         | == 0           | == 0       | workloads out of date, strategy None  |
 //
 //		start ----x
 //		          |---> 1.1 --> (requeue, goto start)
+//		          |---> 1.3 --> (requeue, goto start)
+//		          |---> 1.4 --> (requeue after delay, goto start)
 //		          |---> 1.2 --> (requeue, goto start)
 //		          |---> 2.1 --> (end)
 //		          |
 //	            |---> 3.1 ---> (requeue, goto start)
+//	            |---> 3.6 ---> (requeue after delay, goto start)
+//	            |---> 3.5 ---> (requeue after delay, goto start)
+//	            |---> 3.8 ---> (requeue after delay, goto start)
+//	            |---> 3.10 --> (requeue, goto start)
+//	            |---> 3.7 ---> (requeue after delay, goto start)
 //	            |---> 3.2 ---> (requeue, goto start)
+//	            |---> 3.9 ---> (requeue after delay, goto start)
+//	            |---> 3.4 ---> (requeue after delay, goto start)
+//	            |---> 3.11 --> (end)
 //	            |---> 3.3 ---> (end)
 func (r *AuthProxyWorkloadReconciler) doCreateUpdate(ctx context.Context, l logr.Logger, resource *cloudsqlapi.AuthProxyWorkload) (ctrl.Result, error) {
 	orig := resource.DeepCopy()
@@ -235,12 +623,43 @@ func (r *AuthProxyWorkloadReconciler) doCreateUpdate(ctx context.Context, l logr
 		return r.applyFinalizer(ctx, l, resource)
 	}
 
+	// State 1.3: The RollbackAnnotation requests a rollback. Restore the spec
+	// of the requested revision and requeue. The restored proxy configuration
+	// is rolled out like any other change to the spec.
+	if _, ok := resource.GetAnnotations()[cloudsqlapi.RollbackAnnotation]; ok {
+		return r.rollback(ctx, l, resource)
+	}
+
+	// Record the spec in the revision history, so that the resource can be
+	// rolled back to it later.
+	revision, err := r.recordRevision(ctx, resource)
+	if err != nil {
+		// State 1.4 - unable to record the revision, try again after a delay.
+		return requeueWithDelay, err
+	}
+	resource.Status.Revision = revision
+
+	// Record the generation of the last change to the proxy configuration.
+	// When only the workload selector or the rollout strategy changed, this
+	// does not start a new Staged rollout.
+	configGeneration, err := workload.ConfigGeneration(resource)
+	if err != nil {
+		return requeueWithDelay, err
+	}
+	configHash, err := workload.ConfigHash(resource)
+	if err != nil {
+		return requeueWithDelay, err
+	}
+	resource.Status.ConfigGeneration = configGeneration
+	resource.Status.ConfigHash = configHash
+
 	// find all workloads that relate to this AuthProxyWorkload resource
 	allWorkloads, err := r.updateWorkloadStatus(ctx, resource)
 	if err != nil {
 		// State 1.2 - unable to read workloads, abort and try again after a delay.
 		return requeueWithDelay, err
 	}
+	resource.Status.OutOfDateWorkloads = outOfDateWorkloads(resource)
 
 	// State 2: If workload reconcile has not yet started, then start it.
 
@@ -252,46 +671,277 @@ func (r *AuthProxyWorkloadReconciler) doCreateUpdate(ctx context.Context, l logr
 
 	// State 3.*: Workloads already exist. Some may need to be updated to roll out
 	// changes.
-	outOfDateCount, err := r.updateWorkloadAnnotations(ctx, resource, allWorkloads)
+
+	// Restart the workloads' pods first when the RestartRolloutAnnotation
+	// changed. The restarted workloads get the current proxy configuration
+	// too, so they are not updated again below.
+	restarted, err := r.restartWorkloads(ctx, resource, allWorkloads)
 	if err != nil {
 		return requeueNow, err
 	}
 
+	var outOfDateCount int
+	switch {
+	case isRolloutStrategyStaged(resource):
+		outOfDateCount, err = r.updateWorkloadBatch(ctx, resource, allWorkloads)
+	case isRolloutStrategyEvict(resource):
+		// The workloads are not updated, so none are out of date. Their pods
+		// are rolling out while they are evicted.
+		resource.Status.Rollout = nil
+		_, err = r.updateWorkloadEvictions(ctx, resource, allWorkloads)
+	default:
+		resource.Status.Rollout = nil
+		outOfDateCount, err = r.updateWorkloadAnnotations(ctx, resource, allWorkloads)
+	}
+	if err != nil {
+		return requeueNow, err
+	}
+
+	// State 3.6 The Staged rollout is paused because a workload failed. Check
+	// again after a delay.
+	if rs := resource.Status.Rollout; rs != nil && rs.Paused {
+		message := fmt.Sprintf("Reconciled %d matching workloads. %d of %d workloads updated. %s",
+			len(allWorkloads), rs.UpdatedWorkloads, rs.TotalWorkloads, rs.Message)
+		_, err = r.reconcileResult(ctx, l, resource, orig, cloudsqlapi.ReasonRolloutPaused, message, false)
+		return requeueWithDelay, err
+	}
+
+	// State 3.5 Some workloads can't be configured. The errors are reported in
+	// their WorkloadStatus. Check again after a delay.
+	if errCount := countConfigErrors(resource); errCount > 0 {
+		message := fmt.Sprintf("Reconciled %d matching workloads. %d workloads have proxy configuration errors", len(allWorkloads), errCount)
+		_, err = r.reconcileResult(ctx, l, resource, orig, cloudsqlapi.ReasonConfigError, message, false)
+		return requeueWithDelay, err
+	}
+
+	// State 3.8 Some workloads wait for the operator's limit on concurrent
+	// rollouts. Check again after a delay.
+	if queued := countQueued(resource); queued > 0 {
+		message := fmt.Sprintf("Reconciled %d matching workloads. %d workloads are queued, waiting for other rollouts to finish", len(allWorkloads), queued)
+		_, err = r.reconcileResult(ctx, l, resource, orig, cloudsqlapi.ReasonRolloutQueued, message, false)
+		return requeueWithDelay, err
+	}
+
+	// State 3.10 The pods of some workloads were restarted because the
+	// RestartRolloutAnnotation changed, requeue
+	if restarted > 0 {
+		message := fmt.Sprintf("Reconciled %d matching workloads. Restarted the pods of %d workloads", len(allWorkloads), restarted)
+		return r.reconcileResult(ctx, l, resource, orig, cloudsqlapi.ReasonWorkloadNeedsUpdate, message, false)
+	}
+
+	// State 3.7 The Staged rollout updated a batch of workloads, or waits for
+	// the updated workloads to become available. Check again after a delay.
+	if rs := resource.Status.Rollout; rs != nil && outOfDateCount > 0 {
+		message := fmt.Sprintf("Reconciled %d matching workloads. %d of %d workloads updated, %d available, in batches of %d",
+			len(allWorkloads), rs.UpdatedWorkloads, rs.TotalWorkloads, rs.AvailableWorkloads, rs.BatchSize)
+		_, err = r.reconcileResult(ctx, l, resource, orig, cloudsqlapi.ReasonRolloutInProgress, message, false)
+		return requeueWithDelay, err
+	}
+
 	// State 3.2 Successfully updated all workload PodTemplateSpec annotations, requeue
 	if outOfDateCount > 0 {
 		message := fmt.Sprintf("Reconciled %d matching workloads. %d workloads need updates", len(allWorkloads), outOfDateCount)
 		return r.reconcileResult(ctx, l, resource, orig, cloudsqlapi.ReasonWorkloadNeedsUpdate, message, false)
 	}
 
+	// State 3.9 Some pods can't be evicted because of their
+	// PodDisruptionBudgets. Check again after a delay.
+	if blocked := countEvictionBlocked(resource); blocked > 0 {
+		message := fmt.Sprintf("Reconciled %d matching workloads. %d pods can't be evicted because a PodDisruptionBudget does not allow it", len(allWorkloads), blocked)
+		_, err = r.reconcileResult(ctx, l, resource, orig, cloudsqlapi.ReasonEvictionBlocked, message, false)
+		return requeueWithDelay, err
+	}
+
+	// State 3.4 Workload PodTemplateSpec annotations are all up to date, but
+	// some workloads have pods that don't yet run the current proxy
+	// configuration. Check again after a delay.
+	if rollingOut := countRollingOut(resource); rollingOut > 0 {
+		message := fmt.Sprintf("Reconciled %d matching workloads. %d workloads are rolling out the proxy configuration", len(allWorkloads), rollingOut)
+		_, err = r.reconcileResult(ctx, l, resource, orig, cloudsqlapi.ReasonRolloutInProgress, message, false)
+		return requeueWithDelay, err
+	}
+
+	// State 3.11 The RolloutStrategy is None, and some workloads have pods
+	// that don't run the current proxy configuration. The operator does not
+	// update them. Don't requeue, the reconcile runs again when the workloads
+	// change.
+	if outOfDate := resource.Status.OutOfDateWorkloads; len(outOfDate) > 0 {
+		message := fmt.Sprintf("Reconciled %d matching workloads. %d workloads run an outdated proxy configuration. The RolloutStrategy is None, set the %s annotation to restart them",
+			len(allWorkloads), len(outOfDate), cloudsqlapi.RestartRolloutAnnotation)
+		_, err = r.reconcileResult(ctx, l, resource, orig, cloudsqlapi.ReasonOutOfDate, message, false)
+		return ctrl.Result{}, err
+	}
+
 	// State 3.3 Workload PodTemplateSpec annotations are all up to date
 	message := fmt.Sprintf("Reconciled %d matching workloads complete", len(allWorkloads))
+	if excluded := countExcluded(resource); excluded > 0 {
+		message = fmt.Sprintf("%s. %d workloads are excluded from proxy injection", message, excluded)
+	}
 	return r.reconcileResult(ctx, l, resource, orig, cloudsqlapi.ReasonFinishedReconcile, message, true)
 }
 
-// needsAnnotationUpdate returns true when the workload was annotated with
-// a different generation of the resource.
-func (r *AuthProxyWorkloadReconciler) needsAnnotationUpdate(wl workload.Workload, resource *cloudsqlapi.AuthProxyWorkload) bool {
+// configErrorCodes are the error codes of ConfigErrorDetail that are
+// reported as WorkloadStatus conditions.
+var configErrorCodes = map[string]bool{
+	cloudsqlapi.ErrorCodePortConflict: true,
+	cloudsqlapi.ErrorCodeEnvConflict:  true,
+}
+
+// updateConfigErrorConditions replaces the configuration error conditions in
+// conds with one condition for each ErrorCode in errs. It emits Events on the
+// resource and the workload when an error is found or changes.
+func (r *AuthProxyWorkloadReconciler) updateConfigErrorConditions(resource *cloudsqlapi.AuthProxyWorkload, wl workload.Workload, conds []*metav1.Condition, errs []workload.ConfigErrorDetail) []*metav1.Condition {
+	var codes []string
+	messages := map[string][]string{}
+	for _, e := range errs {
+		if _, ok := messages[e.ErrorCode]; !ok {
+			codes = append(codes, e.ErrorCode)
+		}
+		messages[e.ErrorCode] = append(messages[e.ErrorCode],
+			fmt.Sprintf("%s: %s", proxyDisplayName(e.AuthProxyNamespace, e.AuthProxyName), e.Description))
+	}
+
+	// Remove the conditions for errors that were fixed.
+	var result []*metav1.Condition
+	for _, c := range conds {
+		if _, found := messages[c.Type]; configErrorCodes[c.Type] && !found {
+			continue
+		}
+		result = append(result, c)
+	}
+
+	for _, code := range codes {
+		msg := strings.Join(messages[code], "; ")
+		if old := findCondition(result, code); old == nil || old.Message != msg {
+			r.recordConfigError(resource, wl, code, msg)
+		}
+		result = replaceCondition(result, &metav1.Condition{
+			Type:               code,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: resource.GetGeneration(),
+			Reason:             code,
+			Message:            msg,
+		})
+	}
+	return result
+}
+
+// recordConfigError emits a warning Event on both the resource and the
+// workload, so that the owners of either can see why the pods are rejected.
+func (r *AuthProxyWorkloadReconciler) recordConfigError(resource *cloudsqlapi.AuthProxyWorkload, wl workload.Workload, code, msg string) {
+	wlo := wl.Object()
+	r.recorder.Eventf(eventObject(resource), corev1.EventTypeWarning, code,
+		"Proxy configuration error on %s %s/%s: %s",
+		wlo.GetObjectKind().GroupVersionKind().Kind, wlo.GetNamespace(), wlo.GetName(), msg)
+	r.recorder.Eventf(wlo, corev1.EventTypeWarning, code,
+		"Proxy configuration error, pods will be rejected: %s", msg)
+}
+
+// eventObject returns the object to record Events about the resource on,
+// converting it back to a ClusterAuthProxyWorkload when it is cluster-scoped.
+func eventObject(resource *cloudsqlapi.AuthProxyWorkload) runtime.Object {
+	if resource.IsClusterScoped() {
+		return cloudsqlapi.NewClusterAuthProxyWorkload(resource)
+	}
+	return resource
+}
+
+// proxyDisplayName returns a name for an AuthProxyWorkload or
+// ClusterAuthProxyWorkload to use in messages.
+func proxyDisplayName(ns, name string) string {
+	if ns == "" {
+		return "ClusterAuthProxyWorkload " + name
+	}
+	return "AuthProxyWorkload " + ns + "/" + name
+}
+
+// hasConfigError returns true when the WorkloadStatus of wl reports that the
+// proxy configuration can't be applied to the workload.
+func hasConfigError(resource *cloudsqlapi.AuthProxyWorkload, wl workload.Workload) bool {
+	s := findStatus(resource.Status.WorkloadStatus, newStatus(wl))
+	if s == nil {
+		return false
+	}
+	c := findCondition(s.Conditions, cloudsqlapi.ConditionWorkloadUpToDate)
+	return c != nil && c.Reason == cloudsqlapi.ReasonConfigError
+}
+
+// isExcluded returns true when the WorkloadStatus of wl shows that the
+// workload is excluded from proxy injection.
+func isExcluded(resource *cloudsqlapi.AuthProxyWorkload, wl workload.Workload) bool {
+	s := findStatus(resource.Status.WorkloadStatus, newStatus(wl))
+	if s == nil {
+		return false
+	}
+	c := findCondition(s.Conditions, cloudsqlapi.ConditionWorkloadUpToDate)
+	return c != nil && isExcludedReason(c.Reason)
+}
+
+// isExcludedReason returns true for the WorkloadUpToDate reasons of a
+// workload that is excluded from proxy injection.
+func isExcludedReason(reason string) bool {
+	return reason == cloudsqlapi.ReasonWorkloadExcluded || reason == cloudsqlapi.ReasonInjectionPaused ||
+		reason == cloudsqlapi.ReasonOverridden
+}
+
+// countExcluded returns the number of workloads that are excluded from proxy
+// injection.
+func countExcluded(resource *cloudsqlapi.AuthProxyWorkload) int {
+	var n int
+	for _, s := range resource.Status.WorkloadStatus {
+		c := findCondition(s.Conditions, cloudsqlapi.ConditionWorkloadUpToDate)
+		if c != nil && isExcludedReason(c.Reason) {
+			n++
+		}
+	}
+	return n
+}
+
+// countConfigErrors returns the number of workloads that can't be configured.
+func countConfigErrors(resource *cloudsqlapi.AuthProxyWorkload) int {
+	var n int
+	for _, s := range resource.Status.WorkloadStatus {
+		c := findCondition(s.Conditions, cloudsqlapi.ConditionWorkloadUpToDate)
+		if c != nil && c.Reason == cloudsqlapi.ReasonConfigError {
+			n++
+		}
+	}
+	return n
+}
+
+// needsAnnotationUpdate returns true when the workload's pod template does not
+// have the value v in the resource's pod annotation, the hash of the proxy
+// configuration that the workload's pods should run. See podAnnotationValue.
+func (r *AuthProxyWorkloadReconciler) needsAnnotationUpdate(wl workload.Workload, resource *cloudsqlapi.AuthProxyWorkload, v string) bool {
 	// This workload is not mutable. Ignore it.
 	if _, ok := wl.(workload.WithMutablePodTemplate); !ok {
 		return false
 	}
 
-	if isRolloutStrategyNone(resource) {
+	// The user has set "None" or "Evict" as the rollout strategy. The
+	// workload is not changed.
+	if isRolloutStrategyNone(resource) || isRolloutStrategyEvict(resource) {
+		return false
+	}
+
+	// The proxy is not added to the workload's pods. There is nothing to
+	// roll out.
+	if v == "" {
 		return false
 	}
 
-	k, v := r.updater.PodAnnotation(resource)
 	// Check if the correct annotation exists
 	an := wl.PodTemplateAnnotations()
-	if an != nil && an[k] == v {
+	if an != nil && an[workload.PodAnnotationKey(resource)] == v {
 		return false
 	}
 
 	return true
 }
 
-// updateAnnotation applies an annotation to the workload for the resource.
-func (r *AuthProxyWorkloadReconciler) updateAnnotation(wl workload.Workload, resource *cloudsqlapi.AuthProxyWorkload) {
+// updateAnnotation sets the resource's pod annotation on the workload's pod
+// template to the value v.
+func (r *AuthProxyWorkloadReconciler) updateAnnotation(wl workload.Workload, resource *cloudsqlapi.AuthProxyWorkload, v string) {
 	mpt, ok := wl.(workload.WithMutablePodTemplate)
 
 	// This workload is not mutable. Ignore it.
@@ -299,20 +949,52 @@ func (r *AuthProxyWorkloadReconciler) updateAnnotation(wl workload.Workload, res
 		return
 	}
 
-	// The user has set "None" as the rollout strategy. Ignore it.
-	if isRolloutStrategyNone(resource) {
+	// The user has set "None" or "Evict" as the rollout strategy. Ignore it.
+	if isRolloutStrategyNone(resource) || isRolloutStrategyEvict(resource) {
 		return
 	}
 
-	k, v := r.updater.PodAnnotation(resource)
-
 	// add the annotation if needed...
 	an := wl.PodTemplateAnnotations()
 	if an == nil {
 		an = make(map[string]string)
 	}
 
-	an[k] = v
+	an[workload.PodAnnotationKey(resource)] = v
+	mpt.SetPodTemplateAnnotations(an)
+}
+
+// podAnnotationValue returns the value of the resource's pod annotation on the
+// pods that run the current proxy configuration of the workload with the
+// status s, the ProxyConfigHash recorded by workloadStatus. When the resource
+// was deleted, it is the DeletedPodAnnotationValue, so that the workload's
+// pods are recreated without the proxy.
+func podAnnotationValue(resource *cloudsqlapi.AuthProxyWorkload, s *cloudsqlapi.WorkloadStatus) string {
+	if !resource.GetDeletionTimestamp().IsZero() {
+		return workload.DeletedPodAnnotationValue(resource)
+	}
+	if s == nil {
+		return ""
+	}
+	return s.ProxyConfigHash
+}
+
+// updatePortsAnnotation copies the instance ports from the preview of the
+// workload's pods to the workload's pod template.
+func updatePortsAnnotation(wl workload.Workload, pv *WorkloadPreview) {
+	mpt, ok := wl.(workload.WithMutablePodTemplate)
+	if !ok || pv.ConfiguredPod == nil {
+		return
+	}
+	an := wl.PodTemplateAnnotations()
+	if an == nil {
+		an = make(map[string]string)
+	}
+	if v, ok := pv.ConfiguredPod.Annotations[workload.PortsAnnotation]; ok {
+		an[workload.PortsAnnotation] = v
+	} else {
+		delete(an, workload.PortsAnnotation)
+	}
 	mpt.SetPodTemplateAnnotations(an)
 }
 
@@ -322,6 +1004,18 @@ func isRolloutStrategyNone(resource *cloudsqlapi.AuthProxyWorkload) bool {
 		resource.Spec.AuthProxyContainer.RolloutStrategy == cloudsqlapi.NoneStrategy
 }
 
+// isRolloutStrategyStaged returns true when user has set "Staged" as the rollout strategy.
+func isRolloutStrategyStaged(resource *cloudsqlapi.AuthProxyWorkload) bool {
+	return resource.Spec.AuthProxyContainer != nil &&
+		resource.Spec.AuthProxyContainer.RolloutStrategy == cloudsqlapi.StagedStrategy
+}
+
+// isRolloutStrategyEvict returns true when user has set "Evict" as the rollout strategy.
+func isRolloutStrategyEvict(resource *cloudsqlapi.AuthProxyWorkload) bool {
+	return resource.Spec.AuthProxyContainer != nil &&
+		resource.Spec.AuthProxyContainer.RolloutStrategy == cloudsqlapi.EvictStrategy
+}
+
 // workloadsReconciled  State 3.1: If workloads are all up to date, mark the condition
 // "UpToDate" true and do not requeue.
 func (r *AuthProxyWorkloadReconciler) reconcileResult(ctx context.Context, l logr.Logger, resource, orig *cloudsqlapi.AuthProxyWorkload, reason, message string, upToDate bool) (ctrl.Result, error) {
@@ -361,7 +1055,7 @@ func (r *AuthProxyWorkloadReconciler) applyFinalizer(
 	// the finalizer, exit the reconcile loop and requeue.
 	controllerutil.AddFinalizer(resource, finalizerName)
 
-	err := r.Update(ctx, resource)
+	err := r.updateResource(ctx, resource)
 	if err != nil {
 		l.Info("Error adding finalizer. Will requeue for reconcile.", "err", err)
 		return requeueNow, err
@@ -375,6 +1069,9 @@ func (r *AuthProxyWorkloadReconciler) applyFinalizer(
 // the AuthProxyWorkload.Status field.
 func (r *AuthProxyWorkloadReconciler) patchAuthProxyWorkloadStatus(
 	ctx context.Context, resource *cloudsqlapi.AuthProxyWorkload, orig *cloudsqlapi.AuthProxyWorkload) error {
+	if resource.IsClusterScoped() {
+		return r.patchClusterAuthProxyWorkloadStatus(ctx, resource, orig)
+	}
 	err := r.Client.Status().Patch(ctx, resource, client.MergeFrom(orig))
 	if err != nil {
 		return err
@@ -386,31 +1083,416 @@ func (r *AuthProxyWorkloadReconciler) patchAuthProxyWorkloadStatus(
 	return err
 }
 
+// patchClusterAuthProxyWorkloadStatus updates the status of the
+// ClusterAuthProxyWorkload for a cluster-scoped AuthProxyWorkload.
+func (r *AuthProxyWorkloadReconciler) patchClusterAuthProxyWorkloadStatus(
+	ctx context.Context, resource *cloudsqlapi.AuthProxyWorkload, orig *cloudsqlapi.AuthProxyWorkload) error {
+	cr := cloudsqlapi.NewClusterAuthProxyWorkload(resource)
+	err := r.Client.Status().Patch(ctx, cr, client.MergeFrom(cloudsqlapi.NewClusterAuthProxyWorkload(orig)))
+	if err != nil {
+		return err
+	}
+	err = r.Get(ctx, types.NamespacedName{Name: resource.GetName()}, cr)
+	if err != nil {
+		return err
+	}
+	*orig = *cr.AuthProxyWorkload()
+	return nil
+}
+
 // updateWorkloadStatus lists all workloads related to a cloudsql instance and
 // updates the needs update annotations using internal.UpdateWorkloadAnnotation.
 // Once the workload is saved, the workload admission mutate webhook will
 // apply the correct containers to this instance.
 func (r *AuthProxyWorkloadReconciler) updateWorkloadStatus(ctx context.Context, resource *cloudsqlapi.AuthProxyWorkload) (matching []workload.Workload, retErr error) {
 
-	matching, err := r.listWorkloads(ctx, resource.Spec.Workload, resource.GetNamespace())
+	var err error
+	if resource.IsClusterScoped() {
+		matching, err = r.listClusterWorkloads(ctx, resource)
+	} else {
+		matching, err = r.listWorkloads(ctx, resource.Spec.Workload, resource.GetNamespace())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resource.Status.WorkloadStatus, err = r.pruneWorkloadStatus(ctx, resource, matching)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, wl := range matching {
 		// update the status condition for a workload
-		s := newStatus(wl)
+		s, err := r.workloadStatus(ctx, resource, wl)
+		if err != nil {
+			return nil, err
+		}
+		resource.Status.WorkloadStatus = replaceStatus(resource.Status.WorkloadStatus, s)
+	}
+
+	return matching, nil
+}
+
+// workloadStatus reports the progress of the rollout of the current proxy
+// configuration to the workload and its pods.
+func (r *AuthProxyWorkloadReconciler) workloadStatus(ctx context.Context, resource *cloudsqlapi.AuthProxyWorkload, wl workload.Workload) (*cloudsqlapi.WorkloadStatus, error) {
+	s := newStatus(wl)
+
+	// Keep the existing conditions so that their LastTransitionTime is preserved.
+	if old := findStatus(resource.Status.WorkloadStatus, s); old != nil {
+		for _, c := range old.Conditions {
+			if c.Type != cloudsqlapi.ConditionWorkloadRemoved {
+				s.Conditions = append(s.Conditions, c)
+			}
+		}
+	}
+
+	rs, hasRollout := workload.WorkloadRolloutStatus(wl)
+	if hasRollout {
+		s.ObservedGeneration = rs.ObservedGeneration
+		s.Replicas = rs.Replicas
+		s.UpdatedReplicas = rs.UpdatedReplicas
+		s.AvailableReplicas = rs.AvailableReplicas
+	}
+
+	// Render the proxy container as the pod webhook would add it to the
+	// workload's pods.
+	pv, err := PreviewWorkload(ctx, r.Client, r.updater, wl)
+	if err != nil {
+		return nil, err
+	}
+	s.ProxyConfigHash = pv.PodAnnotationValue(resource)
+	s.PodTemplateConfigHash = wl.PodTemplateAnnotations()[workload.PodAnnotationKey(resource)]
+	v := podAnnotationValue(resource, s)
+
+	s.UpdatedPods, s.OutdatedPods, s.FailedPods, err = r.countPods(ctx, resource, wl, v)
+	if err != nil {
+		return nil, err
+	}
+
+	s.ProxyContainer = pv.ProxyContainer(resource)
+	s.Ports = pv.InstancePorts(resource)
+	s.Conditions = r.updateConfigErrorConditions(resource, wl, s.Conditions, pv.Errors)
+
+	cond := &metav1.Condition{
+		Type:               cloudsqlapi.ConditionWorkloadUpToDate,
+		ObservedGeneration: resource.GetGeneration(),
+	}
+	overriding := workload.OverridingAuthProxyWorkload(resource, pv.AuthProxyWorkloads)
+	switch {
+	case pv.ExcludedReason != "":
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = pv.ExcludedReason
+		cond.Message = pv.ExcludedMessage + ", the operator does not add the proxy to its pods"
+	case overriding != nil:
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = cloudsqlapi.ReasonOverridden
+		cond.Message = fmt.Sprintf("The AuthProxyWorkload %s matches the workload and takes precedence, the operator does not add this proxy to its pods",
+			proxyDisplayName(overriding.Namespace, overriding.Name))
+	case len(pv.Errors) > 0:
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = cloudsqlapi.ReasonConfigError
+		cond.Message = fmt.Sprintf("The proxy configuration has %d errors, the workload's pods are rejected until they are fixed", len(pv.Errors))
+	case needsRestart(resource, wl):
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = cloudsqlapi.ReasonWorkloadNeedsUpdate
+		cond.Message = fmt.Sprintf("Workload pods need a restart, requested by the %s annotation", cloudsqlapi.RestartRolloutAnnotation)
+	case isRolloutStrategyNone(resource) && s.PodTemplateConfigHash != v && s.OutdatedPods > 0:
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = cloudsqlapi.ReasonOutOfDate
+		cond.Message = fmt.Sprintf("%d of %d pods run an outdated proxy configuration. The RolloutStrategy is None, set the %s annotation to restart them",
+			s.OutdatedPods, s.UpdatedPods+s.OutdatedPods, cloudsqlapi.RestartRolloutAnnotation)
+	case isRolloutStrategyNone(resource) && s.PodTemplateConfigHash != v:
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = cloudsqlapi.ReasonUpToDate
+		cond.Message = "No update needed for this workload, the RolloutStrategy is None"
+	case isRolloutStrategyEvict(resource) && !canEvict(wl):
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = cloudsqlapi.ReasonUpToDate
+		cond.Message = "No update needed for this workload, the RolloutStrategy is Evict and its pods are not evicted"
+	case r.needsAnnotationUpdate(wl, resource, v):
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = cloudsqlapi.ReasonWorkloadNeedsUpdate
+		cond.Message = "Workload pod template needs the current proxy configuration"
+	case isRolloutStrategyStaged(resource) && (rs.Failed || s.FailedPods > 0):
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = cloudsqlapi.ReasonRolloutFailed
+		cond.Message = fmt.Sprintf("%d of %d pods with the current proxy configuration are failing", s.FailedPods, s.UpdatedPods)
+		if rs.Failed {
+			cond.Message = "The workload's rollout of the current proxy configuration can't make progress"
+		}
+	case (hasRollout && !rs.Complete) || s.OutdatedPods > 0:
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = cloudsqlapi.ReasonRolloutInProgress
+		cond.Message = fmt.Sprintf("%d of %d pods run the current proxy configuration", s.UpdatedPods, s.UpdatedPods+s.OutdatedPods)
+	default:
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = cloudsqlapi.ReasonUpToDate
+		cond.Message = "All pods run the current proxy configuration"
+	}
+	s.Conditions = replaceCondition(s.Conditions, cond)
+
+	// A workload holds its place in the operator's rollout limit until its
+	// pods run the current proxy configuration. With the Evict strategy, the
+	// workload's outdated pods are rolling out before they are evicted, so
+	// the place is only taken when updateWorkloadEvictions starts evicting.
+	// A restart of the workload's pods holds its place too.
+	switch {
+	case cond.Reason == cloudsqlapi.ReasonWorkloadNeedsUpdate:
+	case cond.Reason == cloudsqlapi.ReasonRolloutInProgress && isRolloutStrategyEvict(resource):
+	case cond.Reason == cloudsqlapi.ReasonRolloutInProgress:
+		r.rollouts.track(newRolloutKey(resource, wl))
+	default:
+		r.rollouts.release(newRolloutKey(resource, wl))
+	}
+
+	return s, nil
+}
+
+// startRollout returns true when the rollout of the resource's configuration
+// to wl may start within the operator's MaxConcurrentRollouts limit.
+// Otherwise, it records the workload's position in the queue in its
+// WorkloadStatus. Removing the proxy from the workloads of a deleted resource
+// is not limited.
+func (r *AuthProxyWorkloadReconciler) startRollout(resource *cloudsqlapi.AuthProxyWorkload, wl workload.Workload) bool {
+	if !resource.GetDeletionTimestamp().IsZero() {
+		return true
+	}
+	max := r.updater.Config().MaxConcurrentRollouts
+	ok, pos := r.rollouts.acquire(newRolloutKey(resource, wl), int(max))
+	if ok {
+		return true
+	}
+
+	if s := findStatus(resource.Status.WorkloadStatus, newStatus(wl)); s != nil {
+		s.QueuePosition = int32(pos)
 		s.Conditions = replaceCondition(s.Conditions, &metav1.Condition{
 			Type:               cloudsqlapi.ConditionWorkloadUpToDate,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: resource.GetGeneration(),
+			Reason:             cloudsqlapi.ReasonRolloutQueued,
+			Message: fmt.Sprintf("Waiting at position %d in the queue, the operator rolls out to %d workloads at a time",
+				pos, max),
+		})
+	}
+	return false
+}
+
+// countQueued returns the number of workloads waiting to start their rollout.
+func countQueued(resource *cloudsqlapi.AuthProxyWorkload) int {
+	var n int
+	for _, s := range resource.Status.WorkloadStatus {
+		c := findCondition(s.Conditions, cloudsqlapi.ConditionWorkloadUpToDate)
+		if c != nil && c.Reason == cloudsqlapi.ReasonRolloutQueued {
+			n++
+		}
+	}
+	return n
+}
+
+// countPods counts the workload's running pods that have and don't have the
+// value v in the resource's pod annotation, and the pods with the value v that
+// have failed containers. Pods that have finished are not counted.
+func (r *AuthProxyWorkloadReconciler) countPods(ctx context.Context, resource *cloudsqlapi.AuthProxyWorkload, wl workload.Workload, v string) (updated, outdated, failed int32, err error) {
+	pods, err := r.listWorkloadPods(ctx, wl)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	k := workload.PodAnnotationKey(resource)
+	for i, p := range pods {
+		if p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if p.Annotations[k] == v {
+			updated++
+			if workload.HasFailedContainers(&pods[i]) {
+				failed++
+			}
+		} else {
+			outdated++
+		}
+	}
+	return updated, outdated, failed, nil
+}
+
+// listWorkloadPods lists the pods of the workload. For a Pod workload, it
+// returns the pod itself.
+func (r *AuthProxyWorkloadReconciler) listWorkloadPods(ctx context.Context, wl workload.Workload) ([]corev1.Pod, error) {
+	if pw, ok := wl.(*workload.PodWorkload); ok {
+		return []corev1.Pod{*pw.Pod}, nil
+	}
+	sel, err := workload.PodSelector(wl)
+	if err != nil {
+		return nil, err
+	}
+	if sel == nil {
+		return nil, nil
+	}
+	pl := &corev1.PodList{}
+	err = r.List(ctx, pl, client.InNamespace(wl.Object().GetNamespace()), client.MatchingLabelsSelector{Selector: sel})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list pods for workload %s/%s: %v", wl.Object().GetNamespace(), wl.Object().GetName(), err)
+	}
+	return pl.Items, nil
+}
+
+// countRollingOut counts the workloads in the resource's status that are
+// rolling out the proxy configuration to their pods, including the workloads
+// whose Staged rollout failed.
+func countRollingOut(resource *cloudsqlapi.AuthProxyWorkload) int {
+	var n int
+	for _, s := range resource.Status.WorkloadStatus {
+		c := findCondition(s.Conditions, cloudsqlapi.ConditionWorkloadUpToDate)
+		if c != nil && c.Status == metav1.ConditionFalse &&
+			(c.Reason == cloudsqlapi.ReasonRolloutInProgress || c.Reason == cloudsqlapi.ReasonRolloutFailed) {
+			n++
+		}
+	}
+	return n
+}
+
+// findStatus finds the status with the same name, namespace, kind, and version
+// as s.
+func findStatus(statuses []*cloudsqlapi.WorkloadStatus, s *cloudsqlapi.WorkloadStatus) *cloudsqlapi.WorkloadStatus {
+	for _, old := range statuses {
+		if old.Name == s.Name &&
+			old.Namespace == s.Namespace &&
+			old.Kind == s.Kind &&
+			old.Version == s.Version {
+			return old
+		}
+	}
+	return nil
+}
+
+// pruneWorkloadStatus returns the resource's WorkloadStatus without the entries
+// for workloads that no longer match. When a workload first leaves the set of
+// matching workloads, its entry is kept with the WorkloadRemoved condition
+// recording the reason, and the proxy is rolled off the workload if it still
+// exists. The entry is removed on the next reconcile.
+func (r *AuthProxyWorkloadReconciler) pruneWorkloadStatus(ctx context.Context, resource *cloudsqlapi.AuthProxyWorkload, matching []workload.Workload) ([]*cloudsqlapi.WorkloadStatus, error) {
+	var result []*cloudsqlapi.WorkloadStatus
+	for _, s := range resource.Status.WorkloadStatus {
+		if statusMatchesAny(s, matching) {
+			result = append(result, s)
+			continue
+		}
+
+		r.rollouts.release(rolloutKey{
+			proxy:     types.NamespacedName{Namespace: resource.Namespace, Name: resource.Name},
+			kind:      s.Kind,
+			namespace: s.Namespace,
+			name:      s.Name,
+		})
+		if c := findCondition(s.Conditions, cloudsqlapi.ConditionWorkloadRemoved); c != nil && c.Status == metav1.ConditionTrue {
+			// The removal was already recorded, drop the entry.
+			continue
+		}
+
+		reason, message, wl, err := r.removedReason(ctx, resource, s)
+		if err != nil {
+			return nil, err
+		}
+		if wl != nil {
+			err = r.rollOffWorkload(ctx, resource, wl)
+			if err != nil {
+				return nil, err
+			}
+		}
+		s.Conditions = replaceCondition(s.Conditions, &metav1.Condition{
+			Type:               cloudsqlapi.ConditionWorkloadRemoved,
 			Status:             metav1.ConditionTrue,
 			ObservedGeneration: resource.GetGeneration(),
-			Reason:             cloudsqlapi.ReasonUpToDate,
-			Message:            "No update needed for this workload",
+			Reason:             reason,
+			Message:            message,
 		})
-		resource.Status.WorkloadStatus = replaceStatus(resource.Status.WorkloadStatus, s)
+		result = append(result, s)
 	}
+	return result, nil
+}
 
-	return matching, nil
+// statusMatchesAny returns true when the status s identifies one of the workloads.
+func statusMatchesAny(s *cloudsqlapi.WorkloadStatus, workloads []workload.Workload) bool {
+	for _, wl := range workloads {
+		ws := newStatus(wl)
+		if s.Name == ws.Name &&
+			s.Namespace == ws.Namespace &&
+			s.Kind == ws.Kind &&
+			s.Version == ws.Version {
+			return true
+		}
+	}
+	return false
+}
+
+// removedReason determines why the workload identified by s no longer
+// matches the resource. It also returns the workload when it still exists.
+func (r *AuthProxyWorkloadReconciler) removedReason(ctx context.Context, resource *cloudsqlapi.AuthProxyWorkload, s *cloudsqlapi.WorkloadStatus) (string, string, workload.Workload, error) {
+	_, gk := schema.ParseKindArg(resource.Spec.Workload.Kind)
+	kind := s.Kind
+	if kind == "" {
+		kind = gk.Kind
+	}
+	wl, err := workload.WorkloadForKind(kind)
+	if err != nil {
+		return cloudsqlapi.ReasonWorkloadKindNotSupported,
+			fmt.Sprintf("Workload kind %s is not supported", kind), nil, nil
+	}
+
+	err = r.Get(ctx, client.ObjectKey{Namespace: s.Namespace, Name: s.Name}, wl.Object())
+	if errors.IsNotFound(err) {
+		wl = nil
+	} else if err != nil {
+		return "", "", nil, fmt.Errorf("unable to load workload %s/%s: %v", s.Namespace, s.Name, err)
+	}
+
+	switch {
+	case kind != gk.Kind:
+		return cloudsqlapi.ReasonWorkloadKindNotSupported,
+			fmt.Sprintf("Workload kind %s is no longer selected", kind), wl, nil
+	case wl == nil:
+		return cloudsqlapi.ReasonWorkloadDeleted, "Workload was deleted", nil, nil
+	}
+	return cloudsqlapi.ReasonWorkloadLabelsChanged, "Workload no longer matches the workload selector", wl, nil
+}
+
+// rollOffWorkload removes the resource's annotation from the pod template of
+// a workload that no longer matches the resource, so that the workload
+// recreates its pods without the proxy. The ports annotation is updated to
+// hold only the ports of the AuthProxyWorkloads that still match.
+func (r *AuthProxyWorkloadReconciler) rollOffWorkload(ctx context.Context, resource *cloudsqlapi.AuthProxyWorkload, wl workload.Workload) error {
+	mpt, ok := wl.(workload.WithMutablePodTemplate)
+
+	// This workload is not mutable, or the user has set "None" or "Evict" as
+	// the rollout strategy. Ignore it.
+	if !ok || isRolloutStrategyNone(resource) || isRolloutStrategyEvict(resource) {
+		return nil
+	}
+
+	k := workload.PodAnnotationKey(resource)
+	if _, ok := wl.PodTemplateAnnotations()[k]; !ok {
+		return nil
+	}
+
+	pv, err := PreviewWorkload(ctx, r.Client, r.updater, wl)
+	if err != nil {
+		return err
+	}
+
+	_, err = controllerutil.CreateOrPatch(ctx, r.Client, wl.Object(), func() error {
+		an := wl.PodTemplateAnnotations()
+		delete(an, k)
+		delete(an, workload.RestartAnnotationKey(resource))
+		mpt.SetPodTemplateAnnotations(an)
+		updatePortsAnnotation(wl, pv)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to remove proxy from workload %s/%s: %v",
+			wl.Object().GetNamespace(), wl.Object().GetName(), err)
+	}
+	return nil
 }
 
 // replaceStatus replace a status with the same name, namespace, kind, and version,
@@ -488,6 +1570,32 @@ func (r *AuthProxyWorkloadReconciler) listWorkloads(ctx context.Context, workloa
 	return r.loadByLabelSelector(ctx, workloadSelector, ns)
 }
 
+// listClusterWorkloads produces a list of Workloads that match a cluster-scoped
+// AuthProxyWorkload in all namespaces selected by its namespaceSelector. The
+// workloads where a namespaced AuthProxyWorkload takes precedence are listed
+// too, so that their WorkloadStatus shows ReasonOverridden.
+func (r *AuthProxyWorkloadReconciler) listClusterWorkloads(ctx context.Context, resource *cloudsqlapi.AuthProxyWorkload) ([]workload.Workload, error) {
+	nsSel, err := resource.Spec.Workload.NamespaceLabelsSelector()
+	if err != nil {
+		return nil, err
+	}
+	nsList := &corev1.NamespaceList{}
+	err = r.List(ctx, nsList, client.MatchingLabelsSelector{Selector: nsSel})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list namespaces: %v", err)
+	}
+
+	var matching []workload.Workload
+	for _, ns := range nsList.Items {
+		wls, err := r.listWorkloads(ctx, resource.Spec.Workload, ns.Name)
+		if err != nil {
+			return nil, err
+		}
+		matching = append(matching, wls...)
+	}
+	return matching, nil
+}
+
 // loadByName loads a single workload by name.
 func (r *AuthProxyWorkloadReconciler) loadByName(ctx context.Context, workloadSelector cloudsqlapi.WorkloadSelectorSpec, ns string) ([]workload.Workload, error) {
 	var wl workload.Workload
@@ -536,14 +1644,25 @@ func (r *AuthProxyWorkloadReconciler) loadByLabelSelector(ctx context.Context, w
 func (r *AuthProxyWorkloadReconciler) updateWorkloadAnnotations(ctx context.Context, resource *cloudsqlapi.AuthProxyWorkload, workloads []workload.Workload) (int, error) {
 	var outOfDate int
 	for _, wl := range workloads {
-		if r.needsAnnotationUpdate(wl, resource) {
-			outOfDate++
+		// Don't roll out a configuration that can't be applied. The workload's
+		// new pods would be rejected.
+		if hasConfigError(resource, wl) {
+			continue
+		}
 
-			_, err := controllerutil.CreateOrPatch(ctx, r.Client, wl.Object(), func() error {
-				r.updateAnnotation(wl, resource)
-				return nil
-			})
+		// Don't update a workload that is excluded from proxy injection.
+		if isExcluded(resource, wl) {
+			continue
+		}
 
+		v := podAnnotationValue(resource, findStatus(resource.Status.WorkloadStatus, newStatus(wl)))
+		if r.needsAnnotationUpdate(wl, resource, v) {
+			outOfDate++
+			if !r.startRollout(resource, wl) {
+				continue
+			}
+
+			err := r.patchWorkloadAnnotations(ctx, resource, wl, v)
 			// Failed to update one of the workloads PodTemplateSpec annotations.
 			if err != nil {
 				return 0, fmt.Errorf("reconciled %d matching workloads. Error removing proxy from workload %v: %v", len(workloads), wl.Object().GetName(), err)
@@ -554,3 +1673,22 @@ func (r *AuthProxyWorkloadReconciler) updateWorkloadAnnotations(ctx context.Cont
 	return outOfDate, nil
 
 }
+
+// patchWorkloadAnnotations sets the resource's annotation on the workload's
+// pod template to the value v.
+func (r *AuthProxyWorkloadReconciler) patchWorkloadAnnotations(ctx context.Context, resource *cloudsqlapi.AuthProxyWorkload, wl workload.Workload, v string) error {
+	// Record the ports of the instances on the pod template together
+	// with the new configuration, so that they are kept by later
+	// changes without another rollout.
+	pv, err := PreviewWorkload(ctx, r.Client, r.updater, wl)
+	if err != nil {
+		return err
+	}
+
+	_, err = controllerutil.CreateOrPatch(ctx, r.Client, wl.Object(), func() error {
+		r.updateAnnotation(wl, resource, v)
+		updatePortsAnnotation(wl, pv)
+		return nil
+	})
+	return err
+}
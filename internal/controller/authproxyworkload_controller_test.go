@@ -18,6 +18,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
 
@@ -28,9 +30,13 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
@@ -156,6 +162,7 @@ func TestReconcileState32(t *testing.T) {
 	// mimic a pod that was updated by the webhook
 	reqName := cloudsqlapi.AnnotationPrefix + "/" + p.Name
 	pod := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "thing",
 			Namespace: "default",
@@ -182,8 +189,16 @@ func TestReconcileState32(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if got, want := d.Spec.Template.ObjectMeta.Annotations[reqName], "2"; !strings.HasPrefix(got, "2") {
-		t.Fatalf("got %v, wants annotation value to have prefix %v", got, want)
+	_, want := podAnnotation(t, p, d.Spec.Template.Spec, workload.DefaultProxyImage)
+	if got := d.Spec.Template.ObjectMeta.Annotations[reqName]; got != want {
+		t.Fatalf("got %v, wants annotation value %v", got, want)
+	}
+	got := &cloudsqlapi.AuthProxyWorkload{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(p), got); err != nil {
+		t.Fatal(err)
+	}
+	if s := got.Status.WorkloadStatus[0]; s.ProxyConfigHash != want || s.PodTemplateConfigHash != "1" {
+		t.Errorf("got proxy config hash %v on pod template hash %v, want %v on 1", s.ProxyConfigHash, s.PodTemplateConfigHash, want)
 	}
 
 }
@@ -210,6 +225,7 @@ func TestReconcileState32RolloutStrategyNone(t *testing.T) {
 
 	// mimic a deployment that was updated by the webhook
 	deployment := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "thing",
 			Namespace: "default",
@@ -222,66 +238,1498 @@ func TestReconcileState32RolloutStrategyNone(t *testing.T) {
 		}},
 	}
 
-	c, ctx, err := runReconcileTestcase(p, []client.Object{p, deployment}, wantRequeue, wantStatus, wantReason)
+	c, ctx, err := runReconcileTestcase(p, []client.Object{p, deployment}, wantRequeue, wantStatus, wantReason)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fetch the deployment and make sure the annotations show the
+	// deleted resource.
+	d := &appsv1.Deployment{}
+	err = c.Get(ctx, types.NamespacedName{
+		Namespace: deployment.GetNamespace(),
+		Name:      deployment.GetName(),
+	}, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(d.Spec.Template.ObjectMeta.Annotations), 1; got != want {
+		t.Fatalf("got %v annotations, wants %v annotations", got, want)
+	}
+
+}
+
+func TestReconcileRolloutStrategyNoneOutOfDate(t *testing.T) {
+	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: "test"}, "project:region:db")
+	p.Generation = 1
+	p.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{RolloutStrategy: cloudsqlapi.NoneStrategy}
+	addFinalizers(p)
+	addSelectorWorkload(p, "Deployment", "app", "web")
+
+	// One of the deployment's pods was created before the proxy
+	// configuration changed.
+	d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "web"}, "web")
+	d.Status = appsv1.DeploymentStatus{Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2}
+	k, v := podAnnotation(t, p, d.Spec.Template.Spec, workload.DefaultProxyImage)
+	newPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: "web-a", Namespace: "default",
+		Labels:      d.Spec.Selector.MatchLabels,
+		Annotations: map[string]string{k: v},
+	}}
+	oldPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: "web-b", Namespace: "default",
+		Labels:      d.Spec.Selector.MatchLabels,
+		Annotations: map[string]string{k: "outdated"},
+	}}
+
+	c, ctx, err := runReconcileTestcase(p, []client.Object{p, d, newPod, oldPod}, false, metav1.ConditionFalse, cloudsqlapi.ReasonOutOfDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"Deployment default/web"}; !reflect.DeepEqual(p.Status.OutOfDateWorkloads, want) {
+		t.Errorf("got out of date workloads %v, want %v", p.Status.OutOfDateWorkloads, want)
+	}
+	s := p.Status.WorkloadStatus[0]
+	if s.UpdatedPods != 1 || s.OutdatedPods != 1 {
+		t.Errorf("got %d updated and %d outdated pods, want 1 and 1", s.UpdatedPods, s.OutdatedPods)
+	}
+	cond := findCondition(s.Conditions, cloudsqlapi.ConditionWorkloadUpToDate)
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != cloudsqlapi.ReasonOutOfDate {
+		t.Errorf("got %v, want WorkloadUpToDate condition false with reason %v", cond, cloudsqlapi.ReasonOutOfDate)
+	}
+
+	// The deployment is not updated.
+	got := &appsv1.Deployment{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(d), got); err != nil {
+		t.Fatal(err)
+	}
+	if gotV, ok := got.Spec.Template.Annotations[k]; ok {
+		t.Errorf("got annotation %v, want the deployment not updated", gotV)
+	}
+}
+
+func TestReconcileRestartRollout(t *testing.T) {
+	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: "test"}, "project:region:db")
+	p.Generation = 1
+	p.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{RolloutStrategy: cloudsqlapi.NoneStrategy}
+	p.Annotations = map[string]string{cloudsqlapi.RestartRolloutAnnotation: "1"}
+	addFinalizers(p)
+	addSelectorWorkload(p, "Deployment", "app", "web")
+
+	d1 := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "d1"}, "busybox")
+	d1.Labels = map[string]string{"app": "web"}
+	d2 := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "d2"}, "busybox")
+	d2.Labels = map[string]string{"app": "web"}
+
+	cb, _, err := clientBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cb.WithObjects(p, d1).WithStatusSubresource(p).Build()
+	r, req, ctx := reconciler(p, c, workload.DefaultProxyImage)
+	k, wantV := podAnnotation(t, p, d1.Spec.Template.Spec, workload.DefaultProxyImage)
+	restartK := workload.RestartAnnotationKey(p)
+
+	reconcile := func() *cloudsqlapi.AuthProxyWorkload {
+		t.Helper()
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+		got := &cloudsqlapi.AuthProxyWorkload{}
+		if err := c.Get(ctx, req.NamespacedName, got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	wantRestarted := func(name, want string) {
+		t.Helper()
+		d := &appsv1.Deployment{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, d); err != nil {
+			t.Fatal(err)
+		}
+		if got := d.Spec.Template.Annotations[restartK]; got != want {
+			t.Errorf("got restart annotation %q on %s, want %q", got, name, want)
+		}
+		if want != "" && d.Spec.Template.Annotations[k] != wantV {
+			t.Errorf("got annotation %v on %s, want the current proxy configuration %v", d.Spec.Template.Annotations[k], name, wantV)
+		}
+	}
+
+	// The restart is applied to the deployment, even though the
+	// RolloutStrategy is None.
+	reconcile()
+	wantRestarted("d1", "1")
+
+	// When all the workloads were restarted, the restart is recorded.
+	got := reconcile()
+	if got.Status.Restarted != "1" {
+		t.Errorf("got restarted %q, want %q", got.Status.Restarted, "1")
+	}
+
+	// A workload that matches later is not restarted.
+	if err := c.Create(ctx, d2); err != nil {
+		t.Fatal(err)
+	}
+	got = reconcile()
+	wantRestarted("d2", "")
+
+	// A new value restarts all the workloads again.
+	got.Annotations[cloudsqlapi.RestartRolloutAnnotation] = "2"
+	if err := c.Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	reconcile()
+	wantRestarted("d1", "2")
+	wantRestarted("d2", "2")
+	if got := reconcile(); got.Status.Restarted != "2" {
+		t.Errorf("got restarted %q, want %q", got.Status.Restarted, "2")
+	}
+}
+
+func TestReconcileRevisionHistory(t *testing.T) {
+	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: "test"}, "project:region:db")
+	p.UID = "test-uid"
+	p.Generation = 1
+	limit := int32(2)
+	p.Spec.RevisionHistoryLimit = &limit
+	addFinalizers(p)
+	addSelectorWorkload(p, "Deployment", "app", "web")
+
+	d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "d1"}, "busybox")
+	d.Labels = map[string]string{"app": "web"}
+
+	cb, _, err := clientBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cb.WithObjects(p, d).WithStatusSubresource(p).Build()
+	r, req, ctx := reconciler(p, c, workload.DefaultProxyImage)
+
+	reconcile := func() *cloudsqlapi.AuthProxyWorkload {
+		t.Helper()
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+		got := &cloudsqlapi.AuthProxyWorkload{}
+		if err := c.Get(ctx, req.NamespacedName, got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	wantRevisions := func(got *cloudsqlapi.AuthProxyWorkload, want ...int64) {
+		t.Helper()
+		revs, err := r.listRevisions(ctx, c, got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gotRevs []int64
+		for _, rev := range revs {
+			gotRevs = append(gotRevs, rev.Revision)
+		}
+		if !reflect.DeepEqual(gotRevs, want) {
+			t.Errorf("got revisions %v, want %v", gotRevs, want)
+		}
+		if got.Status.Revision != want[len(want)-1] {
+			t.Errorf("got status revision %d, want %d", got.Status.Revision, want[len(want)-1])
+		}
+	}
+
+	got := reconcile()
+	wantRevisions(got, 1)
+
+	// Each change to the spec is a new revision. Only the last 2 previous
+	// revisions are kept.
+	for i, img := range []string{"example.com/proxy:1", "example.com/proxy:2", "example.com/proxy:3"} {
+		got.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{Image: img}
+		if err := c.Update(ctx, got); err != nil {
+			t.Fatal(err)
+		}
+		got = reconcile()
+		if i == 0 {
+			wantRevisions(got, 1, 2)
+		}
+	}
+	wantRevisions(got, 2, 3, 4)
+
+	// Changing the RevisionHistoryLimit is not a new revision.
+	limit = 0
+	got.Spec.RevisionHistoryLimit = &limit
+	if err := c.Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	got = reconcile()
+	wantRevisions(got, 4)
+}
+
+func TestRecordRevisionAlreadyExists(t *testing.T) {
+	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: "test"}, "project:region:db")
+	p.UID = "test-uid"
+
+	cb, _, err := clientBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cb.WithObjects(p).Build()
+	r, _, ctx := reconciler(p, c, workload.DefaultProxyImage)
+
+	got, err := r.recordRevision(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 1 {
+		t.Errorf("got revision %d, want 1", got)
+	}
+
+	// The cache does not have the revision created above yet. Recording the
+	// same spec again uses the existing revision.
+	stale, _, err := clientBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Client = stale.WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, _ client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			return c.Create(ctx, obj, opts...)
+		},
+	}).Build()
+	got, err = r.recordRevision(ctx, p)
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+	if got != 1 {
+		t.Errorf("got revision %d, want 1", got)
+	}
+
+	// When the status has a newer revision than the cache, the revisions are
+	// read from the API server, so that a new spec gets the next number.
+	p.Status.Revision = 1
+	p.Spec.Instances[0].ConnectionString = "project:region:db2"
+	got, err = r.recordRevision(ctx, p)
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+	if got != 2 {
+		t.Errorf("got revision %d, want 2", got)
+	}
+}
+
+func TestReconcileRollback(t *testing.T) {
+	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: "test"}, "project:region:db")
+	p.UID = "test-uid"
+	p.Generation = 1
+	p.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{Image: "example.com/proxy:1"}
+	addFinalizers(p)
+	addSelectorWorkload(p, "Deployment", "app", "web")
+
+	d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "d1"}, "busybox")
+	d.Labels = map[string]string{"app": "web"}
+
+	cb, _, err := clientBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cb.WithObjects(p, d).WithStatusSubresource(p).Build()
+	r, req, ctx := reconciler(p, c, workload.DefaultProxyImage)
+	recorder := r.recorder.(*record.FakeRecorder)
+
+	reconcile := func() *cloudsqlapi.AuthProxyWorkload {
+		t.Helper()
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+		got := &cloudsqlapi.AuthProxyWorkload{}
+		if err := c.Get(ctx, req.NamespacedName, got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	rollback := func(got *cloudsqlapi.AuthProxyWorkload, v, wantEvent string) *cloudsqlapi.AuthProxyWorkload {
+		t.Helper()
+		got.Annotations = map[string]string{cloudsqlapi.RollbackAnnotation: v}
+		if err := c.Update(ctx, got); err != nil {
+			t.Fatal(err)
+		}
+		got = reconcile()
+		if _, ok := got.Annotations[cloudsqlapi.RollbackAnnotation]; ok {
+			t.Errorf("got the rollback annotation %q, want it removed", v)
+		}
+		if e := <-recorder.Events; !strings.HasPrefix(e, wantEvent) {
+			t.Errorf("got event %q, want %q", e, wantEvent)
+		}
+		return got
+	}
+	wantImage := func(got *cloudsqlapi.AuthProxyWorkload, img string, rev int64) {
+		t.Helper()
+		if got.Spec.AuthProxyContainer.Image != img {
+			t.Errorf("got image %q, want %q", got.Spec.AuthProxyContainer.Image, img)
+		}
+		if got.Status.Revision != rev {
+			t.Errorf("got status revision %d, want %d", got.Status.Revision, rev)
+		}
+		k, wantV := podAnnotation(t, got, d.Spec.Template.Spec, workload.DefaultProxyImage)
+		gotD := &appsv1.Deployment{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(d), gotD); err != nil {
+			t.Fatal(err)
+		}
+		if gotV := gotD.Spec.Template.Annotations[k]; gotV != wantV {
+			t.Errorf("got annotation %v on the deployment, want %v", gotV, wantV)
+		}
+	}
+
+	got := reconcile()
+	wantImage(got, "example.com/proxy:1", 1)
+
+	got.Spec.AuthProxyContainer.Image = "example.com/proxy:2"
+	if err := c.Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	got = reconcile()
+	wantImage(got, "example.com/proxy:2", 2)
+
+	// Rolling back restores the spec of revision 1, which becomes revision 3,
+	// and rolls it out to the deployment.
+	got = rollback(got, "1", "Normal RolledBack")
+	if got.Spec.AuthProxyContainer.Image != "example.com/proxy:1" {
+		t.Errorf("got image %q, want the image of revision 1", got.Spec.AuthProxyContainer.Image)
+	}
+	got = reconcile()
+	wantImage(got, "example.com/proxy:1", 3)
+
+	// 0 rolls back to the previous revision.
+	got = rollback(got, "0", "Normal RolledBack")
+	got = reconcile()
+	wantImage(got, "example.com/proxy:2", 4)
+
+	// A revision that does not exist is reported, and the spec is not
+	// changed.
+	got = rollback(got, "7", "Warning RollbackFailed")
+	got = reconcile()
+	wantImage(got, "example.com/proxy:2", 4)
+}
+
+func TestReconcileState33(t *testing.T) {
+	const (
+		wantRequeue = false
+		wantStatus  = metav1.ConditionTrue
+		wantReason  = cloudsqlapi.ReasonFinishedReconcile
+		labelK      = "app"
+		labelV      = "things"
+	)
+
+	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{
+		Namespace: "default",
+		Name:      "test",
+	}, "project:region:db")
+	p.Generation = 1
+	addFinalizers(p)
+	addSelectorWorkload(p, "Deployment", labelK, labelV)
+
+	// mimic a pod that was updated by the webhook, and has finished rolling out
+	reqName, reqVal := podAnnotation(t, p, corev1.PodSpec{}, workload.DefaultProxyImage)
+	pod := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "thing",
+			Namespace: "default",
+			Labels:    map[string]string{labelK: labelV},
+		},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{reqName: reqVal}},
+		}},
+		Status: appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+	}
+
+	_, _, err := runReconcileTestcase(p, []client.Object{p, pod}, wantRequeue, wantStatus, wantReason)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+}
+
+func TestReconcileSameProxyConfig(t *testing.T) {
+	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{
+		Namespace: "default",
+		Name:      "test",
+	}, "project:region:db")
+	p.Generation = 1
+	addFinalizers(p)
+	addSelectorWorkload(p, "Deployment", "app", "web")
+
+	// The deployment and its pod run the configuration of generation 1.
+	d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "web"}, "web")
+	k, v := podAnnotation(t, p, d.Spec.Template.Spec, workload.DefaultProxyImage)
+	d.Spec.Template.Annotations = map[string]string{k: v}
+	d.Status = appsv1.DeploymentStatus{Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: "web-a", Namespace: "default",
+		Labels:      d.Spec.Selector.MatchLabels,
+		Annotations: map[string]string{k: v},
+	}}
+
+	// Generation 2 sets the image to the default image, which renders the
+	// same proxy container.
+	p.Generation = 2
+	p.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{Image: workload.DefaultProxyImage}
+
+	c, ctx, err := runReconcileTestcase(p, []client.Object{p, d, pod}, false, metav1.ConditionTrue, cloudsqlapi.ReasonFinishedReconcile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := &appsv1.Deployment{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(d), got); err != nil {
+		t.Fatal(err)
+	}
+	if gotV := got.Spec.Template.Annotations[k]; gotV != v {
+		t.Errorf("got annotation %v, want %v unchanged", gotV, v)
+	}
+	s := p.Status.WorkloadStatus[0]
+	if s.ProxyConfigHash != v || s.PodTemplateConfigHash != v {
+		t.Errorf("got proxy config hash %v on pod template hash %v, want %v", s.ProxyConfigHash, s.PodTemplateConfigHash, v)
+	}
+	if s.UpdatedPods != 1 || s.OutdatedPods != 0 {
+		t.Errorf("got %d updated and %d outdated pods, want 1 and 0", s.UpdatedPods, s.OutdatedPods)
+	}
+}
+
+func TestReconcileState34(t *testing.T) {
+	const (
+		wantRequeue = true
+		wantStatus  = metav1.ConditionFalse
+		wantReason  = cloudsqlapi.ReasonRolloutInProgress
+		labelK      = "app"
+		labelV      = "things"
+	)
+
+	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{
+		Namespace: "default",
+		Name:      "test",
+	}, "project:region:db")
+	p.Generation = 1
+	addFinalizers(p)
+	addSelectorWorkload(p, "Deployment", labelK, labelV)
+
+	// The deployment pod template is up-to-date, but one of the two pods still
+	// has the old proxy configuration.
+	d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "thing"}, "busybox")
+	reqName, reqVal := podAnnotation(t, p, d.Spec.Template.Spec, workload.DefaultProxyImage)
+	d.Labels = map[string]string{labelK: labelV}
+	d.Spec.Template.Annotations = map[string]string{reqName: reqVal}
+	d.Status = appsv1.DeploymentStatus{Replicas: 2, UpdatedReplicas: 1, AvailableReplicas: 2}
+	newPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: "thing-new", Namespace: "default",
+		Labels:      d.Spec.Selector.MatchLabels,
+		Annotations: map[string]string{reqName: reqVal},
+	}}
+	oldPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: "thing-old", Namespace: "default",
+		Labels:      d.Spec.Selector.MatchLabels,
+		Annotations: map[string]string{reqName: "outdated"},
+	}}
+
+	_, _, err := runReconcileTestcase(p, []client.Object{p, d, newPod, oldPod}, wantRequeue, wantStatus, wantReason)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(p.Status.WorkloadStatus); got != 1 {
+		t.Fatalf("got %d workload statuses, want 1", got)
+	}
+	s := p.Status.WorkloadStatus[0]
+	if s.UpdatedPods != 1 || s.OutdatedPods != 1 {
+		t.Errorf("got %d updated and %d outdated pods, want 1 and 1", s.UpdatedPods, s.OutdatedPods)
+	}
+	if s.UpdatedReplicas != 1 || s.AvailableReplicas != 2 {
+		t.Errorf("got %d updated and %d available replicas, want 1 and 2", s.UpdatedReplicas, s.AvailableReplicas)
+	}
+	cond := findCondition(s.Conditions, cloudsqlapi.ConditionWorkloadUpToDate)
+	if cond == nil || cond.Reason != cloudsqlapi.ReasonRolloutInProgress {
+		t.Errorf("got %v, want WorkloadUpToDate condition with reason %v", cond, cloudsqlapi.ReasonRolloutInProgress)
+	}
+}
+
+func TestReconcileRendersProxyContainer(t *testing.T) {
+	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{
+		Namespace: "default",
+		Name:      "test",
+	}, "project:region:db")
+	p.Generation = 1
+	addFinalizers(p)
+	addSelectorWorkload(p, "Deployment", "app", "things")
+
+	d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "thing"}, "busybox")
+	d.Labels = map[string]string{"app": "things"}
+
+	_, _, err := runReconcileTestcase(p, []client.Object{p, d}, true, metav1.ConditionFalse, cloudsqlapi.ReasonWorkloadNeedsUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(p.Status.WorkloadStatus); got != 1 {
+		t.Fatalf("got %d workload statuses, want 1", got)
+	}
+	c := p.Status.WorkloadStatus[0].ProxyContainer
+	if c == nil {
+		t.Fatal("got nil, want the rendered proxy container")
+	}
+	if c.Name != workload.ContainerName(p) {
+		t.Errorf("got container name %v, want %v", c.Name, workload.ContainerName(p))
+	}
+	if c.Image != workload.DefaultProxyImage {
+		t.Errorf("got image %v, want %v", c.Image, workload.DefaultProxyImage)
+	}
+}
+
+func TestReconcileKeepsInstancePorts(t *testing.T) {
+	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{
+		Namespace: "default",
+		Name:      "test",
+	}, "project:region:db")
+	p.Generation = 1
+	addFinalizers(p)
+	addSelectorWorkload(p, "Deployment", "app", "things")
+
+	// A new AuthProxyWorkload that is configured before p
+	other := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{
+		Namespace: "default",
+		Name:      "added",
+	}, "project:region:db2")
+	other.Spec.Instances[0].PortEnvName = "DB2_PORT"
+	addSelectorWorkload(other, "Deployment", "app", "things")
+
+	// The deployment recorded port 5005 for p's instance
+	d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "thing"}, "busybox")
+	d.Labels = map[string]string{"app": "things"}
+	d.Spec.Template.Annotations = map[string]string{
+		workload.PortsAnnotation: `{"default/test":{"project:region:db":5005}}`,
+	}
+
+	c, ctx, err := runReconcileTestcase(p, []client.Object{p, other, d}, true, metav1.ConditionFalse, cloudsqlapi.ReasonWorkloadNeedsUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(p.Status.WorkloadStatus); got != 1 {
+		t.Fatalf("got %d workload statuses, want 1", got)
+	}
+	wantPorts := []cloudsqlapi.InstancePort{{ConnectionString: "project:region:db", Port: 5005}}
+	if got := p.Status.WorkloadStatus[0].Ports; !reflect.DeepEqual(got, wantPorts) {
+		t.Errorf("got ports %v, want %v", got, wantPorts)
+	}
+
+	// The deployment's pod template records the ports of both instances
+	err = c.Get(ctx, client.ObjectKeyFromObject(d), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"default/added":{"project:region:db2":5000},"default/test":{"project:region:db":5005}}`
+	if got := d.Spec.Template.Annotations[workload.PortsAnnotation]; got != want {
+		t.Errorf("got ports annotation %v, want %v", got, want)
+	}
+}
+
+func TestReconcileWorkloadExcluded(t *testing.T) {
+	disabled := map[string]string{cloudsqlapi.DisableInjectionAnnotation: "true"}
+	data := []struct {
+		name       string
+		annotate   func(d *appsv1.Deployment, ns *corev1.Namespace)
+		pause      bool
+		wantReason string
+	}{
+		{
+			name: "deployment annotation",
+			annotate: func(d *appsv1.Deployment, ns *corev1.Namespace) {
+				d.Annotations = disabled
+			},
+			wantReason: cloudsqlapi.ReasonWorkloadExcluded,
+		},
+		{
+			name: "pod template annotation",
+			annotate: func(d *appsv1.Deployment, ns *corev1.Namespace) {
+				d.Spec.Template.Annotations = disabled
+			},
+			wantReason: cloudsqlapi.ReasonWorkloadExcluded,
+		},
+		{
+			name: "namespace annotation",
+			annotate: func(d *appsv1.Deployment, ns *corev1.Namespace) {
+				ns.Annotations = disabled
+			},
+			wantReason: cloudsqlapi.ReasonWorkloadExcluded,
+		},
+		{
+			name:       "injection paused",
+			annotate:   func(d *appsv1.Deployment, ns *corev1.Namespace) {},
+			pause:      true,
+			wantReason: cloudsqlapi.ReasonInjectionPaused,
+		},
+	}
+	for _, tc := range data {
+		t.Run(tc.name, func(t *testing.T) {
+			p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{
+				Namespace: "default",
+				Name:      "test",
+			}, "project:region:db")
+			p.Generation = 1
+			addFinalizers(p)
+			addSelectorWorkload(p, "Deployment", "app", "things")
+
+			d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "thing"}, "busybox")
+			d.Labels = map[string]string{"app": "things"}
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+			tc.annotate(d, ns)
+
+			cb, _, err := clientBuilder()
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := cb.WithObjects(p, d, ns).WithStatusSubresource(p).Build()
+			r, req, ctx := reconciler(p, c, workload.DefaultProxyImage)
+			if tc.pause {
+				cfg := r.updater.Config()
+				cfg.PauseInjection = true
+				r.updater.SetConfig(cfg)
+			}
+
+			res, err := r.Reconcile(ctx, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Requeue || res.RequeueAfter != 0 {
+				t.Errorf("got %v, want no requeue", res)
+			}
+
+			err = c.Get(ctx, client.ObjectKeyFromObject(p), p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cond := findCondition(p.Status.Conditions, cloudsqlapi.ConditionUpToDate); cond == nil || cond.Status != metav1.ConditionTrue {
+				t.Errorf("got %v, want UpToDate condition true", cond)
+			}
+			if got := len(p.Status.WorkloadStatus); got != 1 {
+				t.Fatalf("got %d workload statuses, want 1", got)
+			}
+			cond := findCondition(p.Status.WorkloadStatus[0].Conditions, cloudsqlapi.ConditionWorkloadUpToDate)
+			if cond == nil || cond.Reason != tc.wantReason {
+				t.Errorf("got %v, want WorkloadUpToDate condition with reason %v", cond, tc.wantReason)
+			}
+
+			// The deployment is not updated
+			err = c.Get(ctx, client.ObjectKeyFromObject(d), d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			k := workload.PodAnnotationKey(p)
+			if v, ok := d.Spec.Template.Annotations[k]; ok {
+				t.Errorf("got annotation %s=%s, want no annotation on excluded deployment", k, v)
+			}
+		})
+	}
+}
+
+func TestReconcileConfigError(t *testing.T) {
+	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{
+		Namespace: "default",
+		Name:      "test",
+	}, "project:region:db")
+	p.Generation = 1
+	addFinalizers(p)
+	addSelectorWorkload(p, "Deployment", "app", "things")
+	port := int32(5000)
+	p.Spec.Instances[0].Port = &port
+
+	// The workload already uses the port requested by the AuthProxyWorkload.
+	d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "thing"}, "busybox")
+	d.Labels = map[string]string{"app": "things"}
+	d.Spec.Template.Spec.Containers[0].Ports = []corev1.ContainerPort{{ContainerPort: port}}
+
+	cb, _, err := clientBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cb.WithObjects(p, d).WithStatusSubresource(p, d).Build()
+	r, req, ctx := reconciler(p, c, workload.DefaultProxyImage)
+	recorder := r.recorder.(*record.FakeRecorder)
+
+	for i := 0; i < 2; i++ {
+		res, err := r.Reconcile(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != requeueWithDelay {
+			t.Errorf("got %v, want %v", res, requeueWithDelay)
+		}
+	}
+
+	err = c.Get(ctx, req.NamespacedName, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cond := findCondition(p.Status.Conditions, cloudsqlapi.ConditionUpToDate)
+	if cond == nil || cond.Reason != cloudsqlapi.ReasonConfigError {
+		t.Errorf("got %v, want UpToDate condition with reason %v", cond, cloudsqlapi.ReasonConfigError)
+	}
+	if got := len(p.Status.WorkloadStatus); got != 1 {
+		t.Fatalf("got %d workload statuses, want 1", got)
+	}
+	s := p.Status.WorkloadStatus[0]
+	cond = findCondition(s.Conditions, cloudsqlapi.ConditionWorkloadUpToDate)
+	if cond == nil || cond.Reason != cloudsqlapi.ReasonConfigError {
+		t.Errorf("got %v, want WorkloadUpToDate condition with reason %v", cond, cloudsqlapi.ReasonConfigError)
+	}
+	cond = findCondition(s.Conditions, cloudsqlapi.ErrorCodePortConflict)
+	if cond == nil || cond.Status != metav1.ConditionTrue || !strings.Contains(cond.Message, "AuthProxyWorkload default/test") {
+		t.Errorf("got %v, want PortConflict condition for AuthProxyWorkload default/test", cond)
+	}
+
+	// The configuration is not rolled out to the workload.
+	err = c.Get(ctx, client.ObjectKeyFromObject(d), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k := workload.PodAnnotationKey(p); d.Spec.Template.Annotations[k] != "" {
+		t.Errorf("got annotation %v, want the workload not updated", d.Spec.Template.Annotations[k])
+	}
+
+	// One event on the AuthProxyWorkload and one on the workload, only for
+	// the first reconcile.
+	if got := len(recorder.Events); got != 2 {
+		t.Fatalf("got %d events, want 2", got)
+	}
+	for i := 0; i < 2; i++ {
+		if e := <-recorder.Events; !strings.HasPrefix(e, "Warning PortConflict") {
+			t.Errorf("got event %q, want a PortConflict warning", e)
+		}
+	}
+
+	// When the error is fixed, the condition is removed and the workload is
+	// updated.
+	p.Spec.Instances[0].Port = nil
+	p.Generation = 2
+	err = c.Update(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Get(ctx, req.NamespacedName, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cond := findCondition(p.Status.WorkloadStatus[0].Conditions, cloudsqlapi.ErrorCodePortConflict); cond != nil {
+		t.Errorf("got %v, want no PortConflict condition", cond)
+	}
+	cond = findCondition(p.Status.Conditions, cloudsqlapi.ConditionUpToDate)
+	if cond == nil || cond.Reason != cloudsqlapi.ReasonWorkloadNeedsUpdate {
+		t.Errorf("got %v, want UpToDate condition with reason %v", cond, cloudsqlapi.ReasonWorkloadNeedsUpdate)
+	}
+}
+
+func TestReconcileDeleteUpdatesWorkload(t *testing.T) {
+	const (
+		labelK = "app"
+		labelV = "things"
+	)
+	resource := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{
+		Namespace: "default",
+		Name:      "test",
+	}, "project:region:db")
+	resource.Generation = 1
+	addFinalizers(resource)
+	addSelectorWorkload(resource, "Deployment", labelK, labelV)
+
+	k, v := podAnnotation(t, resource, corev1.PodSpec{}, workload.DefaultProxyImage)
+
+	// mimic a deployment that was updated by the webhook
+	deployment := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "thing",
+			Namespace: "default",
+			Labels:    map[string]string{labelK: labelV},
+		},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{k: v}},
+		}},
+	}
+
+	// Build a client with the resource and deployment
+	cb, _, err := clientBuilder()
+	if err != nil {
+		t.Error(err) // shouldn't ever happen
+	}
+	c := cb.WithObjects(resource, deployment).Build()
+	r, req, ctx := reconciler(resource, c, workload.DefaultProxyImage)
+
+	// Delete the resource
+	c.Delete(ctx, resource)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Run Reconcile on the deleted resource
+	res, err := r.Reconcile(ctx, req)
+	if err != nil {
+		t.Error(err)
+	}
+	if res.Requeue {
+		t.Errorf("got %v, want %v for requeue", res.Requeue, false)
+	}
+
+	// Check that the resource doesn't exist anymore
+	err = c.Get(ctx, types.NamespacedName{
+		Namespace: resource.GetNamespace(),
+		Name:      resource.GetName(),
+	}, resource)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			t.Errorf("wants not found error, got %v", err)
+		}
+	} else {
+		t.Error("wants not found error, got no error")
+	}
+
+	// Fetch the deployment and make sure the annotations show the
+	// deleted resource.
+	d := &appsv1.Deployment{}
+	err = c.Get(ctx, types.NamespacedName{
+		Namespace: deployment.GetNamespace(),
+		Name:      deployment.GetName(),
+	}, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := d.Spec.Template.ObjectMeta.Annotations[k], "1-deleted-"; !strings.HasPrefix(got, want) {
+		t.Fatalf("got %v, wants annotation value to have prefix %v", got, want)
+	}
+
+}
+
+func TestReconcilePrunesWorkloadStatus(t *testing.T) {
+	const (
+		labelK = "app"
+		labelV = "things"
+	)
+	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{
+		Namespace: "default",
+		Name:      "test",
+	}, "project:region:db")
+	p.Generation = 1
+	addFinalizers(p)
+	addSelectorWorkload(p, "Deployment", labelK, labelV)
+
+	matching := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "matching"}, "busybox")
+	matching.Labels = map[string]string{labelK: labelV}
+	relabeled := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "relabeled"}, "busybox")
+	relabeled.Labels = map[string]string{labelK: "other"}
+
+	p.Status.WorkloadStatus = []*cloudsqlapi.WorkloadStatus{
+		{Kind: "Deployment", Version: "apps/v1", Namespace: "default", Name: "deleted"},
+		{Kind: "Deployment", Version: "apps/v1", Namespace: "default", Name: "relabeled"},
+		{Kind: "StatefulSet", Version: "apps/v1", Namespace: "default", Name: "stateful"},
+	}
+
+	cb, _, err := clientBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cb.WithObjects(p, matching, relabeled).WithStatusSubresource(p).Build()
+	r, req, ctx := reconciler(p, c, workload.DefaultProxyImage)
+
+	// The first reconcile records why each workload was removed.
+	_, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Get(ctx, req.NamespacedName, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantReasons := map[string]string{
+		"deleted":   cloudsqlapi.ReasonWorkloadDeleted,
+		"relabeled": cloudsqlapi.ReasonWorkloadLabelsChanged,
+		"stateful":  cloudsqlapi.ReasonWorkloadKindNotSupported,
+	}
+	if got, want := len(p.Status.WorkloadStatus), 4; got != want {
+		t.Fatalf("got %d workload statuses, want %d", got, want)
+	}
+	for _, s := range p.Status.WorkloadStatus {
+		cond := findCondition(s.Conditions, cloudsqlapi.ConditionWorkloadRemoved)
+		wantReason, removed := wantReasons[s.Name]
+		if !removed {
+			if cond != nil {
+				t.Errorf("got WorkloadRemoved condition on %s, want none", s.Name)
+			}
+			continue
+		}
+		if cond == nil {
+			t.Errorf("got no WorkloadRemoved condition on %s, want reason %s", s.Name, wantReason)
+			continue
+		}
+		if cond.Reason != wantReason {
+			t.Errorf("got reason %s on %s, want %s", cond.Reason, s.Name, wantReason)
+		}
+	}
+
+	// The next reconcile removes the entries.
+	_, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Get(ctx, req.NamespacedName, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(p.Status.WorkloadStatus), 1; got != want {
+		t.Fatalf("got %d workload statuses, want %d", got, want)
+	}
+	if got, want := p.Status.WorkloadStatus[0].Name, "matching"; got != want {
+		t.Errorf("got workload status for %s, want %s", got, want)
+	}
+}
+
+func TestReconcileWorkloadSelectorChanged(t *testing.T) {
+	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{
+		Namespace: "default",
+		Name:      "test",
+	}, "project:region:db")
+	p.Generation = 1
+	addFinalizers(p)
+	addSelectorWorkload(p, "Deployment", "app", "web")
+
+	stay := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "stay"}, "busybox")
+	stay.Labels = map[string]string{"app": "web", "tier": "a"}
+	leaving := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "leaving"}, "busybox")
+	leaving.Labels = map[string]string{"app": "web", "tier": "b"}
+	joining := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "joining"}, "busybox")
+	joining.Labels = map[string]string{"app": "api", "tier": "a"}
+
+	cb, _, err := clientBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cb.WithObjects(p, stay, leaving, joining).WithStatusSubresource(p).Build()
+	r, req, ctx := reconciler(p, c, workload.DefaultProxyImage)
+	k, wantV := podAnnotation(t, p, stay.Spec.Template.Spec, workload.DefaultProxyImage)
+
+	reconcileTwice := func() {
+		t.Helper()
+		for i := 0; i < 2; i++ {
+			if _, err := r.Reconcile(ctx, req); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	annotation := func(name string) (string, bool) {
+		t.Helper()
+		d := &appsv1.Deployment{}
+		err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, ok := d.Spec.Template.Annotations[k]
+		return v, ok
+	}
+
+	reconcileTwice()
+	for _, name := range []string{"stay", "leaving"} {
+		if got, _ := annotation(name); got != wantV {
+			t.Fatalf("got annotation %q on %s, want %q", got, name, wantV)
+		}
+	}
+
+	// Change the selector and the rollout strategy.
+	err = c.Get(ctx, req.NamespacedName, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addSelectorWorkload(p, "Deployment", "tier", "a")
+	p.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{RolloutStrategy: cloudsqlapi.WorkloadStrategy}
+	p.Generation = 2
+	err = c.Update(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reconcileTwice()
+
+	if got, _ := annotation("stay"); got != wantV {
+		t.Errorf("got annotation %q on stay, want it unchanged %q", got, wantV)
+	}
+	if got, _ := annotation("joining"); got != wantV {
+		t.Errorf("got annotation %q on joining, want %q", got, wantV)
+	}
+	if got, ok := annotation("leaving"); ok {
+		t.Errorf("got annotation %q on leaving, want it removed", got)
+	}
+
+	err = c.Get(ctx, req.NamespacedName, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.Status.ConfigGeneration, int64(1); got != want {
+		t.Errorf("got ConfigGeneration %d, want %d", got, want)
+	}
+}
+
+func TestReconcileStagedRollout(t *testing.T) {
+	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{
+		Namespace: "default",
+		Name:      "test",
+	}, "project:region:db")
+	p.Generation = 1
+	addFinalizers(p)
+	addSelectorWorkload(p, "Deployment", "app", "web")
+	batchSize := intstr.FromInt32(2)
+	p.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{
+		RolloutStrategy: cloudsqlapi.StagedStrategy,
+		StagedRollout:   &cloudsqlapi.StagedRolloutSpec{BatchSize: &batchSize},
+	}
+
+	names := []string{"d1", "d2", "d3", "d4", "d5"}
+	objs := []client.Object{p}
+	for _, name := range names {
+		d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: name}, "busybox")
+		d.Labels = map[string]string{"app": "web"}
+		objs = append(objs, d)
+	}
+
+	cb, _, err := clientBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cb.WithObjects(objs...).WithStatusSubresource(p).Build()
+	r, req, ctx := reconciler(p, c, workload.DefaultProxyImage)
+	k, wantV := podAnnotation(t, p, objs[1].(*appsv1.Deployment).Spec.Template.Spec, workload.DefaultProxyImage)
+
+	reconcile := func() {
+		t.Helper()
+		res, err := r.Reconcile(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Requeue {
+			t.Fatal("got no requeue, want requeue")
+		}
+	}
+	wantUpdated := func(want ...string) {
+		t.Helper()
+		for _, name := range names {
+			d := &appsv1.Deployment{}
+			err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := d.Spec.Template.Annotations[k] == wantV
+			if got != slices.Contains(want, name) {
+				t.Errorf("got %s updated %v, want %v", name, got, !got)
+			}
+		}
+	}
+	setAvailable := func(name string, conds ...appsv1.DeploymentCondition) {
+		t.Helper()
+		d := &appsv1.Deployment{}
+		err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Status = appsv1.DeploymentStatus{
+			ObservedGeneration: d.Generation,
+			Replicas:           2,
+			UpdatedReplicas:    2,
+			AvailableReplicas:  2,
+			Conditions:         conds,
+		}
+		err = c.Status().Update(ctx, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	wantCondition := func(reason string) *cloudsqlapi.RolloutStatus {
+		t.Helper()
+		err := c.Get(ctx, req.NamespacedName, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cond := findCondition(p.Status.Conditions, cloudsqlapi.ConditionUpToDate)
+		if cond == nil || cond.Reason != reason {
+			t.Fatalf("got UpToDate condition %v, want reason %v", cond, reason)
+		}
+		if p.Status.Rollout == nil {
+			t.Fatal("got no rollout status, want rollout status")
+		}
+		return p.Status.Rollout
+	}
+
+	// The first batch is updated
+	reconcile()
+	wantUpdated("d1", "d2")
+	rs := wantCondition(cloudsqlapi.ReasonRolloutInProgress)
+	if rs.UpdatedWorkloads != 2 || rs.TotalWorkloads != 5 || rs.BatchSize != 2 {
+		t.Errorf("got rollout status %+v, want 2 of 5 workloads updated in batches of 2", rs)
+	}
+
+	// The next batch waits for the first batch to be available
+	reconcile()
+	wantUpdated("d1", "d2")
+
+	setAvailable("d1")
+	setAvailable("d2")
+	reconcile()
+	wantUpdated("d1", "d2", "d3", "d4")
+
+	// The rollout is paused when a workload of the batch fails
+	setAvailable("d3", appsv1.DeploymentCondition{
+		Type:   appsv1.DeploymentProgressing,
+		Status: corev1.ConditionFalse,
+		Reason: "ProgressDeadlineExceeded",
+	})
+	setAvailable("d4")
+	reconcile()
+	reconcile()
+	wantUpdated("d1", "d2", "d3", "d4")
+	rs = wantCondition(cloudsqlapi.ReasonRolloutPaused)
+	if !rs.Paused || !reflect.DeepEqual(rs.FailedWorkloads, []string{"Deployment default/d3"}) {
+		t.Errorf("got rollout status %+v, want paused with d3 failed", rs)
+	}
+
+	// The rollout continues when it is resumed
+	p.Annotations = map[string]string{cloudsqlapi.ResumeRolloutAnnotation: "1"}
+	err = c.Update(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reconcile()
+	wantUpdated("d1", "d2", "d3", "d4", "d5")
+	rs = wantCondition(cloudsqlapi.ReasonRolloutInProgress)
+	if rs.Paused || rs.Resumed != "1" {
+		t.Errorf("got rollout status %+v, want resumed", rs)
+	}
+}
+
+func TestReconcileRolloutLimit(t *testing.T) {
+	p1 := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: "p1"}, "project:region:db")
+	p1.Generation = 1
+	addFinalizers(p1)
+	addSelectorWorkload(p1, "Deployment", "proxy", "p1")
+	p2 := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: "p2"}, "project:region:db2")
+	p2.Generation = 1
+	addFinalizers(p2)
+	addSelectorWorkload(p2, "Deployment", "proxy", "p2")
+
+	objs := []client.Object{p1, p2}
+	for name, proxy := range map[string]string{"d1": "p1", "d2": "p1", "d3": "p1", "d4": "p2"} {
+		d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: name}, "busybox")
+		d.Labels = map[string]string{"proxy": proxy}
+		objs = append(objs, d)
+	}
+
+	cb, _, err := clientBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cb.WithObjects(objs...).WithStatusSubresource(p1, p2).Build()
+	r, req1, ctx := reconciler(p1, c, workload.DefaultProxyImage)
+	req2 := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "p2"}}
+	cfg := r.updater.Config()
+	cfg.MaxConcurrentRollouts = 2
+	r.updater.SetConfig(cfg)
+
+	reconcile := func(req ctrl.Request) {
+		t.Helper()
+		res, err := r.Reconcile(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Requeue {
+			t.Fatal("got no requeue, want requeue")
+		}
+	}
+	wantUpdated := func(p *cloudsqlapi.AuthProxyWorkload, name string, want bool) {
+		t.Helper()
+		d := &appsv1.Deployment{}
+		err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, v := podAnnotation(t, p, d.Spec.Template.Spec, workload.DefaultProxyImage)
+		if got := d.Spec.Template.Annotations[k] == v; got != want {
+			t.Errorf("got %s updated %v, want %v", name, got, want)
+		}
+	}
+	wantQueued := func(req ctrl.Request, name string, wantPos int32) {
+		t.Helper()
+		p := &cloudsqlapi.AuthProxyWorkload{}
+		err := c.Get(ctx, req.NamespacedName, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cond := findCondition(p.Status.Conditions, cloudsqlapi.ConditionUpToDate); cond.Reason != cloudsqlapi.ReasonRolloutQueued {
+			t.Errorf("got UpToDate reason %v, want %v", cond.Reason, cloudsqlapi.ReasonRolloutQueued)
+		}
+		for _, s := range p.Status.WorkloadStatus {
+			if s.Name != name {
+				continue
+			}
+			cond := findCondition(s.Conditions, cloudsqlapi.ConditionWorkloadUpToDate)
+			if s.QueuePosition != wantPos || cond.Reason != cloudsqlapi.ReasonRolloutQueued {
+				t.Errorf("got %s queue position %d reason %v, want %d %v", name, s.QueuePosition, cond.Reason, wantPos, cloudsqlapi.ReasonRolloutQueued)
+			}
+			return
+		}
+		t.Errorf("got no status for %s", name)
+	}
+
+	// Two workloads start rolling out, the third is queued.
+	reconcile(req1)
+	wantUpdated(p1, "d1", true)
+	wantUpdated(p1, "d2", true)
+	wantUpdated(p1, "d3", false)
+	wantQueued(req1, "d3", 1)
+
+	// The limit applies to the workloads of all AuthProxyWorkloads.
+	reconcile(req2)
+	wantUpdated(p2, "d4", false)
+	wantQueued(req2, "d4", 2)
+
+	// When a rollout finishes, the first workload in the queue starts.
+	d := &appsv1.Deployment{}
+	err = c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "d1"}, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Status = appsv1.DeploymentStatus{Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2}
+	err = c.Status().Update(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reconcile(req1)
+	wantUpdated(p1, "d3", true)
+
+	reconcile(req2)
+	wantUpdated(p2, "d4", false)
+	wantQueued(req2, "d4", 1)
+}
+
+func TestReconcileEvictRollout(t *testing.T) {
+	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: "test"}, "project:region:db")
+	p.Generation = 1
+	addFinalizers(p)
+	addSelectorWorkload(p, "Deployment", "app", "web")
+	p.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{RolloutStrategy: cloudsqlapi.EvictStrategy}
+
+	var three int32 = 3
+	d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "web"}, "web")
+	d.Spec.Replicas = &three
+	readyPod := func(name string, annotations map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "default",
+				Name:        name,
+				Labels:      map[string]string{"app": "web"},
+				Annotations: annotations,
+			},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			},
+		}
+	}
+
+	// Evictions are refused as if a PodDisruptionBudget did not allow them
+	// while pdbBlocks is true.
+	var pdbBlocks bool
+	cb, _, err := clientBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cb.WithObjects(p, d, readyPod("web-a", nil), readyPod("web-b", nil), readyPod("web-c", nil)).
+		WithStatusSubresource(p).
+		WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceCreate: func(ctx context.Context, c client.Client, sub string, obj, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+				if sub == "eviction" && pdbBlocks {
+					return errors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 10)
+				}
+				return c.SubResource(sub).Create(ctx, obj, subResource, opts...)
+			},
+		}).Build()
+	r, req, ctx := reconciler(p, c, workload.DefaultProxyImage)
+
+	reconcile := func() *cloudsqlapi.AuthProxyWorkload {
+		t.Helper()
+		res, err := r.Reconcile(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Requeue && res.RequeueAfter == 0 {
+			t.Fatal("got no requeue, want requeue")
+		}
+		got := &cloudsqlapi.AuthProxyWorkload{}
+		err = c.Get(ctx, req.NamespacedName, got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	wantPods := func(want ...string) {
+		t.Helper()
+		pl := &corev1.PodList{}
+		err := c.List(ctx, pl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, pod := range pl.Items {
+			got = append(got, pod.Name)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got pods %v, want %v", got, want)
+		}
+	}
+
+	// One outdated pod is evicted, and the workload is not changed.
+	got := reconcile()
+	wantPods("web-b", "web-c")
+	if cond := findCondition(got.Status.Conditions, cloudsqlapi.ConditionUpToDate); cond.Reason != cloudsqlapi.ReasonRolloutInProgress {
+		t.Errorf("got UpToDate reason %v, want %v", cond.Reason, cloudsqlapi.ReasonRolloutInProgress)
+	}
+	gotD := &appsv1.Deployment{}
+	err = c.Get(ctx, client.ObjectKeyFromObject(d), gotD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotD.Spec, d.Spec) {
+		t.Errorf("got deployment spec changed, want unchanged")
+	}
+
+	// No more pods are evicted until the evicted pod is replaced.
+	reconcile()
+	wantPods("web-b", "web-c")
+
+	k, v := podAnnotation(t, got, d.Spec.Template.Spec, workload.DefaultProxyImage)
+	err = c.Create(ctx, readyPod("web-d", map[string]string{k: v}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reconcile()
+	wantPods("web-c", "web-d")
+
+	// An eviction blocked by a PodDisruptionBudget is reported.
+	err = c.Create(ctx, readyPod("web-e", map[string]string{k: v}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdbBlocks = true
+	got = reconcile()
+	wantPods("web-c", "web-d", "web-e")
+	if cond := findCondition(got.Status.Conditions, cloudsqlapi.ConditionUpToDate); cond.Reason != cloudsqlapi.ReasonEvictionBlocked {
+		t.Errorf("got UpToDate reason %v, want %v", cond.Reason, cloudsqlapi.ReasonEvictionBlocked)
+	}
+	if len(got.Status.WorkloadStatus) != 1 {
+		t.Fatalf("got %d workload statuses, want 1", len(got.Status.WorkloadStatus))
+	}
+	s := got.Status.WorkloadStatus[0]
+	if !reflect.DeepEqual(s.EvictionBlockedPods, []string{"web-c"}) {
+		t.Errorf("got eviction blocked pods %v, want [web-c]", s.EvictionBlockedPods)
+	}
+	if cond := findCondition(s.Conditions, cloudsqlapi.ConditionWorkloadUpToDate); cond.Reason != cloudsqlapi.ReasonEvictionBlocked {
+		t.Errorf("got WorkloadUpToDate reason %v, want %v", cond.Reason, cloudsqlapi.ReasonEvictionBlocked)
+	}
+
+	// When the eviction is allowed, the rollout finishes.
+	pdbBlocks = false
+	reconcile()
+	wantPods("web-d", "web-e")
+	err = c.Create(ctx, readyPod("web-f", map[string]string{k: v}))
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	// Fetch the deployment and make sure the annotations show the
-	// deleted resource.
-	d := &appsv1.Deployment{}
-	err = c.Get(ctx, types.NamespacedName{
-		Namespace: deployment.GetNamespace(),
-		Name:      deployment.GetName(),
-	}, d)
+	gotD.Status = appsv1.DeploymentStatus{ObservedGeneration: gotD.Generation, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3}
+	err = c.Status().Update(ctx, gotD)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	if got, want := len(d.Spec.Template.ObjectMeta.Annotations), 1; got != want {
-		t.Fatalf("got %v annotations, wants %v annotations", got, want)
+	res, err := r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Requeue {
+		t.Errorf("got requeue, want rollout finished")
 	}
-
 }
 
-func TestReconcileState33(t *testing.T) {
-	const (
-		wantRequeue = false
-		wantStatus  = metav1.ConditionTrue
-		wantReason  = cloudsqlapi.ReasonFinishedReconcile
-		labelK      = "app"
-		labelV      = "things"
-	)
-
-	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{
-		Namespace: "default",
-		Name:      "test",
-	}, "project:region:db")
+func TestReconcileDeleteEvictRollout(t *testing.T) {
+	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: "test"}, "project:region:db")
 	p.Generation = 1
 	addFinalizers(p)
-	addSelectorWorkload(p, "Deployment", labelK, labelV)
-
-	// mimic a pod that was updated by the webhook
-	reqName, reqVal := workload.PodAnnotation(p, workload.DefaultProxyImage)
-	pod := &appsv1.Deployment{
+	addSelectorWorkload(p, "Deployment", "app", "web")
+	p.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{RolloutStrategy: cloudsqlapi.EvictStrategy}
+	d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "web"}, "web")
+	k, v := podAnnotation(t, p, d.Spec.Template.Spec, workload.DefaultProxyImage)
+	pod := &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "thing",
-			Namespace: "default",
-			Labels:    map[string]string{labelK: labelV},
+			Namespace:   "default",
+			Name:        "web-a",
+			Labels:      map[string]string{"app": "web"},
+			Annotations: map[string]string{k: v},
 		},
-		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
-			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{reqName: reqVal}},
-		}},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
 	}
 
-	_, _, err := runReconcileTestcase(p, []client.Object{p, pod}, wantRequeue, wantStatus, wantReason)
+	cb, _, err := clientBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cb.WithObjects(p, d, pod).Build()
+	r, req, ctx := reconciler(p, c, workload.DefaultProxyImage)
+	err = c.Delete(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The pod with the proxy is evicted before the finalizer is removed.
+	res, err := r.Reconcile(ctx, req)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if res.RequeueAfter == 0 {
+		t.Error("got no requeue, want requeue after delay")
+	}
+	err = c.Get(ctx, client.ObjectKeyFromObject(pod), &corev1.Pod{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("got %v, want pod evicted", err)
+	}
+	err = c.Get(ctx, req.NamespacedName, &cloudsqlapi.AuthProxyWorkload{})
+	if err != nil {
+		t.Errorf("got %v, want resource kept by the finalizer", err)
+	}
 
+	res, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Requeue || res.RequeueAfter != 0 {
+		t.Errorf("got requeue %v, want no requeue", res)
+	}
+	err = c.Get(ctx, req.NamespacedName, &cloudsqlapi.AuthProxyWorkload{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("got %v, want resource deleted", err)
+	}
+	gotD := &appsv1.Deployment{}
+	err = c.Get(ctx, client.ObjectKeyFromObject(d), gotD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotD.Spec, d.Spec) {
+		t.Errorf("got deployment spec changed, want unchanged")
+	}
 }
 
-func TestReconcileDeleteUpdatesWorkload(t *testing.T) {
+func TestWorkloadUpdatedAfterDefaultProxyImageChanged(t *testing.T) {
 	const (
 		labelK = "app"
 		labelV = "things"
@@ -294,10 +1742,14 @@ func TestReconcileDeleteUpdatesWorkload(t *testing.T) {
 	addFinalizers(resource)
 	addSelectorWorkload(resource, "Deployment", labelK, labelV)
 
-	k, v := workload.PodAnnotation(resource, workload.DefaultProxyImage)
+	// Deployment annotation should be updated to this after reconcile:
+	_, wantV := podAnnotation(t, resource, corev1.PodSpec{}, "gcr.io/cloud-sql-connectors/cloud-sql-proxy:999.9.9")
 
 	// mimic a deployment that was updated by the webhook
+	// annotate the deployment with the default image
+	k, v := podAnnotation(t, resource, corev1.PodSpec{}, "gcr.io/cloud-sql-connectors/cloud-sql-proxy:1.1.1")
 	deployment := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "thing",
 			Namespace: "default",
@@ -313,35 +1765,19 @@ func TestReconcileDeleteUpdatesWorkload(t *testing.T) {
 	if err != nil {
 		t.Error(err) // shouldn't ever happen
 	}
-	c := cb.WithObjects(resource, deployment).Build()
-	r, req, ctx := reconciler(resource, c, workload.DefaultProxyImage)
+	c := cb.WithObjects(resource, deployment).WithStatusSubresource(resource, deployment).Build()
 
-	// Delete the resource
-	c.Delete(ctx, resource)
-	if err != nil {
-		t.Error(err)
-	}
+	// Create a reconciler with the default proxy image at a different version
+	r, req, ctx := reconciler(resource, c, "gcr.io/cloud-sql-connectors/cloud-sql-proxy:999.9.9")
 
-	// Run Reconcile on the deleted resource
+	// Run Reconcile on the resource
 	res, err := r.Reconcile(ctx, req)
 	if err != nil {
 		t.Error(err)
 	}
-	if res.Requeue {
-		t.Errorf("got %v, want %v for requeue", res.Requeue, false)
-	}
 
-	// Check that the resource doesn't exist anymore
-	err = c.Get(ctx, types.NamespacedName{
-		Namespace: resource.GetNamespace(),
-		Name:      resource.GetName(),
-	}, resource)
-	if err != nil {
-		if !errors.IsNotFound(err) {
-			t.Errorf("wants not found error, got %v", err)
-		}
-	} else {
-		t.Error("wants not found error, got no error")
+	if !res.Requeue {
+		t.Errorf("got %v, want %v for requeue", res.Requeue, true)
 	}
 
 	// Fetch the deployment and make sure the annotations show the
@@ -355,13 +1791,13 @@ func TestReconcileDeleteUpdatesWorkload(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if got, want := d.Spec.Template.ObjectMeta.Annotations[k], "1-deleted-"; !strings.HasPrefix(got, want) {
-		t.Fatalf("got %v, wants annotation value to have prefix %v", got, want)
+	if got := d.Spec.Template.ObjectMeta.Annotations[k]; got != wantV {
+		t.Fatalf("got %v, wants annotation value %v", got, wantV)
 	}
 
 }
 
-func TestWorkloadUpdatedAfterDefaultProxyImageChanged(t *testing.T) {
+func TestWorkloadUpdatedAfterCredentialsSecretRotated(t *testing.T) {
 	const (
 		labelK = "app"
 		labelV = "things"
@@ -371,61 +1807,378 @@ func TestWorkloadUpdatedAfterDefaultProxyImageChanged(t *testing.T) {
 		Name:      "test",
 	}, "project:region:db")
 	resource.Generation = 1
+	resource.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{
+		Authentication: &cloudsqlapi.AuthenticationSpec{
+			CredentialsFileSecret: &cloudsqlapi.SecretKeyRef{Name: "creds", Key: "key.json"},
+		},
+	}
 	addFinalizers(resource)
 	addSelectorWorkload(resource, "Deployment", labelK, labelV)
 
-	// Deployment annotation should be updated to this after reconcile:
-	_, wantV := workload.PodAnnotation(resource, "gcr.io/cloud-sql-connectors/cloud-sql-proxy:999.9.9")
-
-	// mimic a deployment that was updated by the webhook
-	// annotate the deployment with the default image
-	k, v := workload.PodAnnotation(resource, "gcr.io/cloud-sql-connectors/cloud-sql-proxy:1.1.1")
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "default"},
+		Data:       map[string][]byte{"key.json": []byte("{}")},
+	}
 	deployment := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "thing",
 			Namespace: "default",
 			Labels:    map[string]string{labelK: labelV},
 		},
-		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
-			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{k: v}},
-		}},
 	}
 
-	// Build a client with the resource and deployment
 	cb, _, err := clientBuilder()
 	if err != nil {
-		t.Error(err) // shouldn't ever happen
+		t.Fatal(err) // shouldn't ever happen
 	}
-	c := cb.WithObjects(resource, deployment).WithStatusSubresource(resource, deployment).Build()
+	c := cb.WithObjects(resource, deployment, secret).WithStatusSubresource(resource, deployment).Build()
+	r, req, ctx := reconciler(resource, c, workload.DefaultProxyImage)
 
-	// Create a reconciler with the default proxy image at a different version
-	r, req, ctx := reconciler(resource, c, "gcr.io/cloud-sql-connectors/cloud-sql-proxy:999.9.9")
+	// annotation returns the deployment's pod template annotation for the
+	// resource after a reconcile.
+	annotation := func() string {
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+		d := &appsv1.Deployment{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(deployment), d); err != nil {
+			t.Fatal(err)
+		}
+		k := workload.PodAnnotationKey(resource)
+		return d.Spec.Template.Annotations[k]
+	}
 
-	// Run Reconcile on the resource
-	res, err := r.Reconcile(ctx, req)
+	before := annotation()
+	if before == "" {
+		t.Fatal("got no annotation, want the deployment to be annotated")
+	}
+
+	// Rotate the secret
+	if err := c.Get(ctx, client.ObjectKeyFromObject(secret), secret); err != nil {
+		t.Fatal(err)
+	}
+	secret.Data["key.json"] = []byte(`{"rotated":true}`)
+	if err := c.Update(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+
+	if after := annotation(); after == before {
+		t.Errorf("got annotation %v, want it to change after the secret was rotated", after)
+	}
+}
+
+func TestReconcileClusterAuthProxyWorkload(t *testing.T) {
+	const (
+		labelK = "app"
+		labelV = "things"
+	)
+	cp := &cloudsqlapi.ClusterAuthProxyWorkload{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Generation: 1},
+		Spec: cloudsqlapi.AuthProxyWorkloadSpec{
+			Workload: cloudsqlapi.WorkloadSelectorSpec{
+				Kind:     "Deployment",
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{labelK: labelV}},
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"env": "prod"},
+				},
+			},
+			Instances: []cloudsqlapi.InstanceSpec{{ConnectionString: "project:region:db"}},
+		},
+	}
+	cp.Finalizers = []string{finalizerName}
+
+	prodNs := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "prod", Labels: map[string]string{"env": "prod"}}}
+	devNs := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dev", Labels: map[string]string{"env": "dev"}}}
+	prodD := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "prod", Name: "thing"}, "busybox")
+	prodD.Labels = map[string]string{labelK: labelV}
+	devD := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "dev", Name: "thing"}, "busybox")
+	devD.Labels = map[string]string{labelK: labelV}
+
+	cb, _, err := clientBuilder()
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
+	c := cb.WithObjects(cp, prodNs, devNs, prodD, devD).WithStatusSubresource(cp).Build()
+	r, req, ctx := reconciler(cp.AuthProxyWorkload(), c, workload.DefaultProxyImage)
 
+	res, err := r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if !res.Requeue {
-		t.Errorf("got %v, want %v for requeue", res.Requeue, true)
+		t.Errorf("got %v, want true for requeue", res.Requeue)
 	}
 
-	// Fetch the deployment and make sure the annotations show the
-	// deleted resource.
-	d := &appsv1.Deployment{}
-	err = c.Get(ctx, types.NamespacedName{
-		Namespace: deployment.GetNamespace(),
-		Name:      deployment.GetName(),
-	}, d)
+	err = c.Get(ctx, client.ObjectKeyFromObject(cp), cp)
 	if err != nil {
 		t.Fatal(err)
 	}
+	cond := findCondition(cp.Status.Conditions, cloudsqlapi.ConditionUpToDate)
+	if cond == nil || cond.Reason != cloudsqlapi.ReasonWorkloadNeedsUpdate {
+		t.Fatalf("got %v, want UpToDate condition with reason %v", cond, cloudsqlapi.ReasonWorkloadNeedsUpdate)
+	}
 
-	if got := d.Spec.Template.ObjectMeta.Annotations[k]; got != wantV {
-		t.Fatalf("got %v, wants annotation value %v", got, wantV)
+	annKey := cloudsqlapi.ClusterAnnotationPrefix + "/" + cp.Name
+	for _, tc := range []struct {
+		d    *appsv1.Deployment
+		want bool
+	}{{d: prodD, want: true}, {d: devD, want: false}} {
+		got := &appsv1.Deployment{}
+		err = c.Get(ctx, client.ObjectKeyFromObject(tc.d), got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, gotAnn := got.Spec.Template.Annotations[annKey]
+		if gotAnn != tc.want {
+			t.Errorf("got %v, want %v for annotation on deployment in %s", gotAnn, tc.want, tc.d.Namespace)
+		}
+	}
+}
+
+func TestReconcileClusterAuthProxyWorkloadOverridden(t *testing.T) {
+	cp := &cloudsqlapi.ClusterAuthProxyWorkload{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Generation: 1},
+		Spec: cloudsqlapi.AuthProxyWorkloadSpec{
+			Workload: cloudsqlapi.WorkloadSelectorSpec{
+				Kind:     "Deployment",
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "things"}},
+			},
+			Instances: []cloudsqlapi.InstanceSpec{{ConnectionString: "project:region:db"}},
+		},
+	}
+	cp.Finalizers = []string{finalizerName}
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "thing"}, "busybox")
+	d.Labels = map[string]string{"app": "things"}
+
+	// A namespaced AuthProxyWorkload for the same instance matches the
+	// Deployment and takes precedence.
+	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: "team"}, "project:region:db")
+	addSelectorWorkload(p, "Deployment", "app", "things")
+
+	cb, _, err := clientBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cb.WithObjects(cp, ns, d, p).WithStatusSubresource(cp).Build()
+	r, req, ctx := reconciler(cp.AuthProxyWorkload(), c, workload.DefaultProxyImage)
+
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(cp), cp); err != nil {
+		t.Fatal(err)
+	}
+	if len(cp.Status.WorkloadStatus) != 1 {
+		t.Fatalf("got %d workload statuses, want 1", len(cp.Status.WorkloadStatus))
+	}
+	cond := findCondition(cp.Status.WorkloadStatus[0].Conditions, cloudsqlapi.ConditionWorkloadUpToDate)
+	if cond == nil || cond.Reason != cloudsqlapi.ReasonOverridden {
+		t.Errorf("got %v, want WorkloadUpToDate condition with reason %v", cond, cloudsqlapi.ReasonOverridden)
+	}
+
+	got := &appsv1.Deployment{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(d), got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got.Spec.Template.Annotations[cloudsqlapi.ClusterAnnotationPrefix+"/"+cp.Name]; ok {
+		t.Errorf("got the annotation of the ClusterAuthProxyWorkload on the Deployment, want none")
+	}
+}
+
+func TestRequestsForWorkload(t *testing.T) {
+	match := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: "match"}, "project:region:db")
+	addSelectorWorkload(match, "Deployment", "app", "webapp")
+	otherKind := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: "other-kind"}, "project:region:db")
+	addSelectorWorkload(otherKind, "StatefulSet", "app", "webapp")
+	otherLabel := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: "other-label"}, "project:region:db")
+	addSelectorWorkload(otherLabel, "Deployment", "app", "other")
+	otherNs := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "other", Name: "other-ns"}, "project:region:db")
+	addSelectorWorkload(otherNs, "Deployment", "app", "webapp")
+
+	clusterSel := cloudsqlapi.WorkloadSelectorSpec{
+		Kind:     "Deployment.v1.apps",
+		Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "webapp"}},
+	}
+	clusterMatch := &cloudsqlapi.ClusterAuthProxyWorkload{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-match"},
+		Spec:       cloudsqlapi.AuthProxyWorkloadSpec{Workload: clusterSel},
+	}
+	clusterOtherNs := &cloudsqlapi.ClusterAuthProxyWorkload{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-other-ns"},
+		Spec:       cloudsqlapi.AuthProxyWorkloadSpec{Workload: clusterSel},
 	}
+	clusterOtherNs.Spec.Workload.NamespaceSelector = &metav1.LabelSelector{
+		MatchLabels: map[string]string{"env": "prod"},
+	}
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"env": "dev"}}}
+	d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "webapp"}, "busybox")
+	d.Labels = map[string]string{"app": "webapp"}
+	// Objects received by the watch may not have TypeMeta set.
+	d.TypeMeta = metav1.TypeMeta{}
+
+	cb, _, err := clientBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cb.WithObjects(match, otherKind, otherLabel, otherNs, clusterMatch, clusterOtherNs, ns, d).Build()
+	r, _, ctx := reconciler(match, c, workload.DefaultProxyImage)
+
+	reqs := r.requestsForWorkload("Deployment")(ctx, d)
+
+	want := []types.NamespacedName{
+		{Namespace: "default", Name: "match"},
+		{Name: "cluster-match"},
+	}
+	if len(reqs) != len(want) {
+		t.Fatalf("got %v, want %v", reqs, want)
+	}
+	for i := range want {
+		if reqs[i].NamespacedName != want[i] {
+			t.Errorf("got %v, want %v", reqs[i].NamespacedName, want[i])
+		}
+	}
+}
+
+func TestRequestsForNamespace(t *testing.T) {
+	inNs := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: "in-ns"}, "project:region:db")
+	otherNs := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "other", Name: "other-ns"}, "project:region:db")
+	clusterMatch := &cloudsqlapi.ClusterAuthProxyWorkload{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-match"},
+	}
+	clusterOtherNs := &cloudsqlapi.ClusterAuthProxyWorkload{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-other-ns"},
+		Spec: cloudsqlapi.AuthProxyWorkloadSpec{Workload: cloudsqlapi.WorkloadSelectorSpec{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+		}},
+	}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"env": "dev"}}}
+
+	cb, _, err := clientBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cb.WithObjects(inNs, otherNs, clusterMatch, clusterOtherNs, ns).Build()
+	r, _, ctx := reconciler(inNs, c, workload.DefaultProxyImage)
+
+	reqs := r.requestsForNamespace(ctx, ns)
+
+	want := []types.NamespacedName{
+		{Namespace: "default", Name: "in-ns"},
+		{Name: "cluster-match"},
+	}
+	if len(reqs) != len(want) {
+		t.Fatalf("got %v, want %v", reqs, want)
+	}
+	for i := range want {
+		if reqs[i].NamespacedName != want[i] {
+			t.Errorf("got %v, want %v", reqs[i].NamespacedName, want[i])
+		}
+	}
+}
+
+func TestWorkloadChangedPredicate(t *testing.T) {
+	d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "webapp"}, "busybox")
+	d.Labels = map[string]string{"app": "webapp"}
+
+	data := []struct {
+		desc   string
+		change func(d *appsv1.Deployment)
+		want   bool
+	}{
+		{
+			desc:   "status changed",
+			change: func(d *appsv1.Deployment) { d.Status.UpdatedReplicas = 1 },
+		},
+		{
+			desc: "replicas changed",
+			change: func(d *appsv1.Deployment) {
+				three := int32(3)
+				d.Spec.Replicas = &three
+			},
+		},
+		{
+			desc:   "labels changed",
+			change: func(d *appsv1.Deployment) { d.Labels["app"] = "other" },
+			want:   true,
+		},
+		{
+			desc: "annotations changed",
+			change: func(d *appsv1.Deployment) {
+				d.Annotations = map[string]string{cloudsqlapi.DisableInjectionAnnotation: "true"}
+			},
+			want: true,
+		},
+		{
+			desc:   "pod template changed",
+			change: func(d *appsv1.Deployment) { d.Spec.Template.Spec.Containers[0].Image = "busybox:2" },
+			want:   true,
+		},
+	}
+	for _, tc := range data {
+		t.Run(tc.desc, func(t *testing.T) {
+			newD := d.DeepCopy()
+			tc.change(newD)
+			got := workloadChangedPredicate().Update(event.UpdateEvent{ObjectOld: d, ObjectNew: newD})
+			if got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRequestsForSecret(t *testing.T) {
+	creds := &cloudsqlapi.AuthProxyContainerSpec{
+		Authentication: &cloudsqlapi.AuthenticationSpec{
+			CredentialsFileSecret: &cloudsqlapi.SecretKeyRef{Name: "creds", Key: "key.json"},
+		},
+	}
+	match := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: "match"}, "project:region:db")
+	match.Spec.AuthProxyContainer = creds
+	noSecret := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: "no-secret"}, "project:region:db")
+	otherNs := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "other", Name: "other-ns"}, "project:region:db")
+	otherNs.Spec.AuthProxyContainer = creds
+
+	clusterMatch := &cloudsqlapi.ClusterAuthProxyWorkload{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-match"},
+		Spec: cloudsqlapi.AuthProxyWorkloadSpec{
+			Workload:           cloudsqlapi.WorkloadSelectorSpec{Kind: "Deployment", Name: "webapp"},
+			AuthProxyContainer: creds,
+		},
+	}
+	clusterOtherNs := clusterMatch.DeepCopy()
+	clusterOtherNs.Name = "cluster-other-ns"
+	clusterOtherNs.Spec.Workload.NamespaceSelector = &metav1.LabelSelector{
+		MatchLabels: map[string]string{"env": "prod"},
+	}
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"env": "dev"}}}
+	secret := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "creds"}}
+
+	cb, _, err := clientBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cb.WithObjects(match, noSecret, otherNs, clusterMatch, clusterOtherNs, ns).Build()
+	r, _, ctx := reconciler(match, c, workload.DefaultProxyImage)
+
+	reqs := r.requestsForSecret(ctx, secret)
 
+	want := []types.NamespacedName{
+		{Namespace: "default", Name: "match"},
+		{Name: "cluster-match"},
+	}
+	if len(reqs) != len(want) {
+		t.Fatalf("got %v, want %v", reqs, want)
+	}
+	for i := range want {
+		if reqs[i].NamespacedName != want[i] {
+			t.Errorf("got %v, want %v", reqs[i].NamespacedName, want[i])
+		}
+	}
 }
 
 func runReconcileTestcase(p *cloudsqlapi.AuthProxyWorkload, clientObjects []client.Object, wantRequeue bool, wantStatus metav1.ConditionStatus, wantReason string) (client.WithWatch, context.Context, error) {
@@ -481,16 +2234,28 @@ func clientBuilder() (*fake.ClientBuilder, *runtime.Scheme, error) {
 	if err != nil {
 		return nil, nil, err
 	}
-	return fake.NewClientBuilder().WithScheme(scheme), scheme, nil
+	cb := withFieldIndexes(fake.NewClientBuilder().WithScheme(scheme))
+	return cb, scheme, nil
 
 }
 
+// withFieldIndexes adds the operator's field indexes to a fake client, like
+// SetupWithManager adds them to the operator's cache.
+func withFieldIndexes(b *fake.ClientBuilder) *fake.ClientBuilder {
+	for _, fi := range fieldIndexes() {
+		b = b.WithIndex(fi.obj, fi.field, fi.extract)
+	}
+	return b
+}
+
 func reconciler(p *cloudsqlapi.AuthProxyWorkload, cb client.Client, defaultProxyImage string) (*AuthProxyWorkloadReconciler, ctrl.Request, context.Context) {
 	ctx := log.IntoContext(context.Background(), logger)
 	r := &AuthProxyWorkloadReconciler{
 		Client:          cb,
 		recentlyDeleted: &recentlyDeletedCache{},
 		updater:         workload.NewUpdater("cloud-sql-proxy-operator/dev", defaultProxyImage),
+		recorder:        record.NewFakeRecorder(100),
+		apiReader:       cb,
 	}
 	req := ctrl.Request{
 		NamespacedName: types.NamespacedName{
@@ -501,6 +2266,24 @@ func reconciler(p *cloudsqlapi.AuthProxyWorkload, cb client.Client, defaultProxy
 	return r, req, ctx
 }
 
+// podAnnotation returns the pod annotation that the operator sets on pods
+// with the pod spec ps for the AuthProxyWorkload p, when the default proxy
+// image is img.
+func podAnnotation(t *testing.T, p *cloudsqlapi.AuthProxyWorkload, ps corev1.PodSpec, img string) (string, string) {
+	t.Helper()
+	u := workload.NewUpdater("cloud-sql-proxy-operator/dev", img)
+	wl := &workload.PodWorkload{Pod: &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: p.Namespace},
+		Spec:       *ps.DeepCopy(),
+	}}
+	err := u.ConfigureWorkload(wl, []*cloudsqlapi.AuthProxyWorkload{p}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := workload.PodAnnotationKey(p)
+	return k, wl.Pod.Annotations[k]
+}
+
 func addFinalizers(p *cloudsqlapi.AuthProxyWorkload) {
 	p.Finalizers = []string{finalizerName}
 }
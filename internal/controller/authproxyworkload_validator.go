@@ -0,0 +1,303 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
+	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/workload"
+)
+
+// authProxyWorkloadValidator validates AuthProxyWorkload and
+// ClusterAuthProxyWorkload resources. After the checks of the resource by
+// itself, it applies the resource together with the other AuthProxyWorkloads
+// to the workloads that exist now. The resource is rejected when that causes
+// new configuration errors, like a port conflict, on any of the workloads.
+type authProxyWorkloadValidator struct {
+	r *AuthProxyWorkloadReconciler
+}
+
+var _ admission.CustomValidator = &authProxyWorkloadValidator{}
+
+// ValidateCreate implements admission.CustomValidator.
+func (v *authProxyWorkloadValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	val, ok := obj.(admission.Validator)
+	if !ok {
+		return nil, fmt.Errorf("bad request, expected an AuthProxyWorkload, got %T", obj)
+	}
+	w, err := val.ValidateCreate()
+	if err != nil {
+		return w, err
+	}
+	return v.validateOverlaps(ctx, obj)
+}
+
+// ValidateUpdate implements admission.CustomValidator.
+func (v *authProxyWorkloadValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	val, ok := newObj.(admission.Validator)
+	if !ok {
+		return nil, fmt.Errorf("bad request, expected an AuthProxyWorkload, got %T", newObj)
+	}
+	w, err := val.ValidateUpdate(oldObj)
+	if err != nil {
+		return w, err
+	}
+	// A resource that is being deleted is not checked against the workloads,
+	// so that the operator can always remove its finalizer.
+	if o, ok := newObj.(metav1.Object); ok && o.GetDeletionTimestamp() != nil {
+		return w, nil
+	}
+	return v.validateOverlaps(ctx, newObj)
+}
+
+// ValidateDelete implements admission.CustomValidator.
+func (v *authProxyWorkloadValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validateOverlaps checks the resource obj against the other
+// AuthProxyWorkloads that match the same workloads. Errors that already exist
+// without this version of obj are not reported. When the workloads can't be
+// read, the resource is accepted with a warning, so that a problem with the
+// operator's cache does not block changes to AuthProxyWorkloads.
+func (v *authProxyWorkloadValidator) validateOverlaps(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	var (
+		p    *cloudsqlapi.AuthProxyWorkload
+		kind string
+	)
+	switch o := obj.(type) {
+	case *cloudsqlapi.AuthProxyWorkload:
+		p, kind = o, "AuthProxyWorkload"
+	case *cloudsqlapi.ClusterAuthProxyWorkload:
+		p, kind = o.AuthProxyWorkload(), cloudsqlapi.ClusterAuthProxyWorkloadKind
+	default:
+		return nil, fmt.Errorf("bad request, expected an AuthProxyWorkload, got %T", obj)
+	}
+
+	errs, warnings, err := v.checkWorkloads(ctx, p)
+	if err != nil {
+		logf.FromContext(ctx).Info("Unable to check AuthProxyWorkload for conflicts",
+			"ns", p.Namespace, "name", p.Name, "error", err.Error())
+		warnings = append(warnings, fmt.Sprintf("unable to check for conflicts with other AuthProxyWorkloads, %v", err))
+		return warnings, nil
+	}
+	if len(errs) > 0 {
+		return warnings, apierrors.NewInvalid(
+			schema.GroupKind{Group: cloudsqlapi.GroupVersion.Group, Kind: kind},
+			p.Name, errs)
+	}
+	return warnings, nil
+}
+
+// checkWorkloads applies p to each existing workload that it matches.
+func (v *authProxyWorkloadValidator) checkWorkloads(ctx context.Context, p *cloudsqlapi.AuthProxyWorkload) (field.ErrorList, admission.Warnings, error) {
+	var (
+		wls []workload.Workload
+		err error
+	)
+	if p.IsClusterScoped() {
+		wls, err = v.r.listClusterWorkloads(ctx, p)
+	} else {
+		wls, err = v.r.listWorkloads(ctx, p.Spec.Workload, p.Namespace)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		allErrs  field.ErrorList
+		warnings admission.Warnings
+	)
+	for _, wl := range wls {
+		errs, w, err := v.checkWorkload(ctx, p, wl)
+		if err != nil {
+			return nil, nil, err
+		}
+		allErrs = append(allErrs, errs...)
+		warnings = append(warnings, w...)
+	}
+	return allErrs, warnings, nil
+}
+
+// checkWorkload configures a pod of wl with the AuthProxyWorkloads that match
+// it before and after p is applied, and reports the configuration errors that
+// are new. It also warns when p sets an env var that another
+// AuthProxyWorkload on the same workload sets too.
+func (v *authProxyWorkloadValidator) checkWorkload(ctx context.Context, p *cloudsqlapi.AuthProxyWorkload, wl workload.Workload) (field.ErrorList, admission.Warnings, error) {
+	owners, err := templatePodOwners(ctx, v.r.Client, wl)
+	if err != nil {
+		return nil, nil, err
+	}
+	pl, err := listProxies(ctx, v.r.Client, wl.Object().GetNamespace())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	u := v.r.updater
+	before := u.FindMatchingAuthProxyWorkloads(pl, workload.TemplatePod(wl), owners)
+	after := u.FindMatchingAuthProxyWorkloads(withProxy(pl, p), workload.TemplatePod(wl), owners)
+	if findProxy(after, p) == nil {
+		// Another AuthProxyWorkload takes precedence over p.
+		return nil, nil, nil
+	}
+
+	oldErrs, err := configErrors(u, wl, before)
+	if err != nil {
+		return nil, nil, err
+	}
+	newErrs, err := configErrors(u, wl, after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, gk := schema.ParseKindArg(p.Spec.Workload.Kind)
+	wlName := fmt.Sprintf("%s %s/%s", gk.Kind, wl.Object().GetNamespace(), wl.Object().GetName())
+	var errs field.ErrorList
+	for _, e := range newErrs {
+		if hasConfigErrorDetail(oldErrs, e) {
+			continue
+		}
+		errs = append(errs, field.Forbidden(configErrorField(p, e),
+			fmt.Sprintf("%s on %s: %s", e.ErrorCode, wlName, e.Description)))
+	}
+
+	return errs, duplicateEnvNames(p, after, wlName), nil
+}
+
+// configErrorField returns the path of the field of p that causes the
+// configuration error e: the port or env var name of one of its instances.
+// It returns spec.instances when e is not caused by a field of an instance
+// of p, like a conflict with a port that the operator chose.
+func configErrorField(p *cloudsqlapi.AuthProxyWorkload, e workload.ConfigErrorDetail) *field.Path {
+	f := field.NewPath("spec", "instances")
+	for i, is := range p.Spec.Instances {
+		switch {
+		case e.Port != 0 && is.Port != nil && *is.Port == e.Port:
+			return f.Index(i).Child("port")
+		case e.EnvName == "":
+		case is.PortEnvName == e.EnvName:
+			return f.Index(i).Child("portEnvName")
+		case is.HostEnvName == e.EnvName:
+			return f.Index(i).Child("hostEnvName")
+		case is.UnixSocketPathEnvName == e.EnvName:
+			return f.Index(i).Child("unixSocketPathEnvName")
+		}
+	}
+	return f
+}
+
+// configErrors returns the configuration errors when a pod from the pod
+// template of wl is configured with proxies.
+func configErrors(u *workload.Updater, wl workload.Workload, proxies []*cloudsqlapi.AuthProxyWorkload) ([]workload.ConfigErrorDetail, error) {
+	if len(proxies) == 0 {
+		return nil, nil
+	}
+	err := u.ConfigureWorkload(workload.TemplatePod(wl), proxies, nil)
+	var cfgErr *workload.ConfigError
+	if errors.As(err, &cfgErr) {
+		return cfgErr.DetailedErrors(), nil
+	}
+	return nil, err
+}
+
+// hasConfigErrorDetail returns true when errs holds an error with the same
+// code and description as e.
+func hasConfigErrorDetail(errs []workload.ConfigErrorDetail, e workload.ConfigErrorDetail) bool {
+	for _, o := range errs {
+		if o.ErrorCode == e.ErrorCode && o.Description == e.Description {
+			return true
+		}
+	}
+	return false
+}
+
+// withProxy returns a copy of pl where p replaces the stored version of p,
+// or is added when it is new.
+func withProxy(pl *cloudsqlapi.AuthProxyWorkloadList, p *cloudsqlapi.AuthProxyWorkload) *cloudsqlapi.AuthProxyWorkloadList {
+	n := &cloudsqlapi.AuthProxyWorkloadList{}
+	for i := range pl.Items {
+		if !sameProxy(&pl.Items[i], p) {
+			n.Items = append(n.Items, pl.Items[i])
+		}
+	}
+	n.Items = append(n.Items, *p)
+	return n
+}
+
+// findProxy returns the entry of pl for the AuthProxyWorkload p, or nil.
+func findProxy(pl []*cloudsqlapi.AuthProxyWorkload, p *cloudsqlapi.AuthProxyWorkload) *cloudsqlapi.AuthProxyWorkload {
+	for _, o := range pl {
+		if sameProxy(o, p) {
+			return o
+		}
+	}
+	return nil
+}
+
+// sameProxy returns true when a and b are versions of the same
+// AuthProxyWorkload or ClusterAuthProxyWorkload.
+func sameProxy(a, b *cloudsqlapi.AuthProxyWorkload) bool {
+	return a.IsClusterScoped() == b.IsClusterScoped() &&
+		a.Namespace == b.Namespace && a.Name == b.Name
+}
+
+// duplicateEnvNames returns a warning for each env var name set by an
+// instance of p that is also set by another AuthProxyWorkload in proxies.
+// Env vars set to different values are also reported as an EnvVarConflict
+// error, but the same value set twice is usually a mistake too.
+func duplicateEnvNames(p *cloudsqlapi.AuthProxyWorkload, proxies []*cloudsqlapi.AuthProxyWorkload, wlName string) admission.Warnings {
+	others := map[string]*cloudsqlapi.AuthProxyWorkload{}
+	for _, o := range proxies {
+		if sameProxy(o, p) {
+			continue
+		}
+		for _, is := range o.Spec.Instances {
+			for _, name := range []string{is.PortEnvName, is.HostEnvName, is.UnixSocketPathEnvName} {
+				if name != "" {
+					others[name] = o
+				}
+			}
+		}
+	}
+
+	var warnings admission.Warnings
+	for i, is := range p.Spec.Instances {
+		f := field.NewPath("spec", "instances").Index(i)
+		for _, ev := range []struct{ field, name string }{
+			{"portEnvName", is.PortEnvName},
+			{"hostEnvName", is.HostEnvName},
+			{"unixSocketPathEnvName", is.UnixSocketPathEnvName},
+		} {
+			o, ok := others[ev.name]
+			if ev.name == "" || !ok {
+				continue
+			}
+			warnings = append(warnings, fmt.Sprintf("%s: %s is also set by %s on %s",
+				f.Child(ev.field), ev.name, proxyDisplayName(o.Namespace, o.Name), wlName))
+		}
+	}
+	return warnings
+}
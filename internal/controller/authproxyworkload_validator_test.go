@@ -0,0 +1,174 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
+	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/testhelpers"
+	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/workload"
+)
+
+// validatorProxy builds an AuthProxyWorkload for the webapp Deployment with
+// one instance on an explicit port.
+func validatorProxy(name, app string, port int32, portEnvName, hostEnvName string) *cloudsqlapi.AuthProxyWorkload {
+	p := testhelpers.NewAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: name})
+	p.Spec.Instances = []cloudsqlapi.InstanceSpec{{
+		ConnectionString: "project:region:" + name,
+		Port:             &port,
+		PortEnvName:      portEnvName,
+		HostEnvName:      hostEnvName,
+	}}
+	addSelectorWorkload(p, "Deployment", "app", app)
+	return p
+}
+
+func TestValidateOverlappingAuthProxyWorkloads(t *testing.T) {
+	existing := validatorProxy("existing", "webapp", 5000, "DB_PORT", "DB_HOST")
+
+	data := []struct {
+		desc         string
+		objs         []client.Object
+		p            *cloudsqlapi.AuthProxyWorkload
+		wantErr      string
+		wantField    string
+		wantWarnings int
+	}{
+		{
+			desc: "no conflict",
+			p:    validatorProxy("new", "webapp", 5001, "DB2_PORT", ""),
+		},
+		{
+			desc:      "explicit port conflict",
+			p:         validatorProxy("new", "webapp", 5000, "DB2_PORT", ""),
+			wantErr:   cloudsqlapi.ErrorCodePortConflict,
+			wantField: "spec.instances[0].port",
+		},
+		{
+			desc:         "duplicate portEnvName",
+			p:            validatorProxy("new", "webapp", 5001, "DB_PORT", ""),
+			wantErr:      cloudsqlapi.ErrorCodeEnvConflict,
+			wantField:    "spec.instances[0].portEnvName",
+			wantWarnings: 1,
+		},
+		{
+			desc:         "duplicate hostEnvName with the same value",
+			p:            validatorProxy("new", "webapp", 5001, "DB2_PORT", "DB_HOST"),
+			wantWarnings: 1,
+		},
+		{
+			desc: "no overlapping workloads",
+			p:    validatorProxy("new", "other", 5000, "DB_PORT", ""),
+		},
+		{
+			desc: "update does not conflict with the stored version",
+			p:    validatorProxy("existing", "webapp", 5000, "DB_PORT", "DB_HOST"),
+		},
+		{
+			desc: "existing conflicts are not reported",
+			objs: []client.Object{validatorProxy("conflict", "webapp", 5000, "OTHER_PORT", "")},
+			p:    validatorProxy("new", "webapp", 5001, "DB2_PORT", ""),
+		},
+	}
+
+	for _, tc := range data {
+		t.Run(tc.desc, func(t *testing.T) {
+			cb, _, err := clientBuilder()
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "webapp"}, "webapp")
+			objs := append([]client.Object{existing.DeepCopy(), d}, tc.objs...)
+			c := cb.WithObjects(objs...).Build()
+			r, _, ctx := reconciler(tc.p, c, workload.DefaultProxyImage)
+			v := &authProxyWorkloadValidator{r: r}
+
+			warnings, err := v.ValidateCreate(ctx, tc.p)
+			if tc.wantErr == "" && err != nil {
+				t.Fatalf("got error %v, want no error", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Fatalf("got error %v, want an error containing %s", err, tc.wantErr)
+			}
+			if tc.wantField != "" {
+				causes := err.(*apierrors.StatusError).Status().Details.Causes
+				if len(causes) != 1 || causes[0].Field != tc.wantField {
+					t.Errorf("got causes %v, want one error on %s", causes, tc.wantField)
+				}
+			}
+			if len(warnings) != tc.wantWarnings {
+				t.Errorf("got warnings %v, want %d warnings", warnings, tc.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestValidateClusterAuthProxyWorkloadOverlaps(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"env": "prod"}}}
+	d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "webapp"}, "webapp")
+	existing := validatorProxy("existing", "webapp", 5000, "DB_PORT", "")
+
+	cp := validatorProxy("cluster", "webapp", 5000, "DB2_PORT", "")
+	cp.Namespace = ""
+	cp.Spec.Workload.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}
+	cp.Spec.Instances[0].ConnectionString = existing.Spec.Instances[0].ConnectionString
+	cluster := cloudsqlapi.NewClusterAuthProxyWorkload(cp)
+
+	// The namespaced AuthProxyWorkload uses the same instance and takes
+	// precedence, so the ports don't conflict.
+	cb, _, err := clientBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cb.WithObjects(ns, d, existing).Build()
+	r, _, ctx := reconciler(existing, c, workload.DefaultProxyImage)
+	v := &authProxyWorkloadValidator{r: r}
+	if _, err := v.ValidateCreate(ctx, cluster); err != nil {
+		t.Errorf("got error %v, want no error", err)
+	}
+
+	// For another instance, both proxies are added to the workload, and the
+	// ports conflict.
+	otherInstance := cloudsqlapi.NewClusterAuthProxyWorkload(cp.DeepCopy())
+	otherInstance.Spec.Instances[0].ConnectionString = "project:region:cluster"
+	_, err = v.ValidateCreate(ctx, otherInstance)
+	if err == nil || !strings.Contains(err.Error(), cloudsqlapi.ErrorCodePortConflict) {
+		t.Errorf("got error %v, want a %s error", err, cloudsqlapi.ErrorCodePortConflict)
+	}
+
+	// A second ClusterAuthProxyWorkload on the same port conflicts.
+	other := cloudsqlapi.NewClusterAuthProxyWorkload(cp.DeepCopy())
+	other.Name = "other"
+	other.Spec.Instances[0].ConnectionString = "project:region:other"
+	cb, _, err = clientBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = cb.WithObjects(ns, d, other).Build()
+	r, _, ctx = reconciler(existing, c, workload.DefaultProxyImage)
+	v = &authProxyWorkloadValidator{r: r}
+	_, err = v.ValidateCreate(ctx, cluster)
+	if err == nil || !strings.Contains(err.Error(), cloudsqlapi.ErrorCodePortConflict) {
+		t.Errorf("got error %v, want a %s error", err, cloudsqlapi.ErrorCodePortConflict)
+	}
+}
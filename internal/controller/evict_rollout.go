@@ -0,0 +1,223 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
+	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/workload"
+)
+
+// updateWorkloadEvictions rolls out the proxy configuration to the workloads'
+// pods when the RolloutStrategy is Evict. The workloads are not changed.
+// Instead, the pods that don't run the current proxy configuration are
+// evicted, and the pod webhook configures the pods that replace them. When
+// the resource was deleted, the pods that still have the proxy are evicted.
+//
+// The pods blocked by a PodDisruptionBudget are recorded in the workload's
+// WorkloadStatus. It returns the number of workloads that still have pods
+// to evict.
+func (r *AuthProxyWorkloadReconciler) updateWorkloadEvictions(ctx context.Context, resource *cloudsqlapi.AuthProxyWorkload, workloads []workload.Workload) (int, error) {
+	var pending int
+	for _, wl := range workloads {
+		if !canEvict(wl) || hasConfigError(resource, wl) || isExcluded(resource, wl) {
+			continue
+		}
+
+		v := podAnnotationValue(resource, findStatus(resource.Status.WorkloadStatus, newStatus(wl)))
+		if v == "" {
+			continue
+		}
+		pods, err := r.listWorkloadPods(ctx, wl)
+		if err != nil {
+			return 0, err
+		}
+		outdated := outdatedPods(resource, pods, v)
+		if len(outdated) == 0 {
+			continue
+		}
+
+		pending++
+		if !r.startRollout(resource, wl) {
+			continue
+		}
+		blocked, err := r.evictPods(ctx, resource, wl, pods, outdated)
+		if err != nil {
+			return 0, err
+		}
+
+		s := findStatus(resource.Status.WorkloadStatus, newStatus(wl))
+		if s == nil {
+			continue
+		}
+		s.EvictionBlockedPods = blocked
+		if len(blocked) > 0 {
+			s.Conditions = replaceCondition(s.Conditions, &metav1.Condition{
+				Type:               cloudsqlapi.ConditionWorkloadUpToDate,
+				Status:             metav1.ConditionFalse,
+				ObservedGeneration: resource.GetGeneration(),
+				Reason:             cloudsqlapi.ReasonEvictionBlocked,
+				Message: fmt.Sprintf("%d pods can't be evicted because a PodDisruptionBudget does not allow it: %s",
+					len(blocked), strings.Join(blocked, ", ")),
+			})
+		}
+	}
+	return pending, nil
+}
+
+// outdatedPods returns the running pods that don't have the value v in the
+// resource's pod annotation, sorted by name. When the resource was deleted,
+// it returns the running pods that still have the proxy. Pods that are being
+// deleted are not returned.
+func outdatedPods(resource *cloudsqlapi.AuthProxyWorkload, pods []corev1.Pod, v string) []*corev1.Pod {
+	deleted := !resource.GetDeletionTimestamp().IsZero()
+	k := workload.PodAnnotationKey(resource)
+
+	var outdated []*corev1.Pod
+	for i, p := range pods {
+		if p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed ||
+			!p.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+		got, ok := p.Annotations[k]
+		if (deleted && ok) || (!deleted && got != v) {
+			outdated = append(outdated, &pods[i])
+		}
+	}
+	sort.Slice(outdated, func(i, j int) bool {
+		return outdated[i].Name < outdated[j].Name
+	})
+	return outdated
+}
+
+// evictPods evicts the outdated pods of the workload, so that no more than
+// the resource's MaxUnavailable pods of the workload are unavailable. Pods
+// that were evicted and not yet replaced are unavailable, so the next pods
+// are evicted when the replacements are ready. Outdated pods that are not
+// available are always evicted. It returns the names of the pods whose
+// eviction was refused because of a PodDisruptionBudget.
+func (r *AuthProxyWorkloadReconciler) evictPods(ctx context.Context, resource *cloudsqlapi.AuthProxyWorkload, wl workload.Workload, pods []corev1.Pod, outdated []*corev1.Pod) ([]string, error) {
+	l := log.FromContext(ctx)
+	desired := desiredPods(wl)
+	var available int
+	for i := range pods {
+		if podAvailable(&pods[i]) {
+			available++
+		}
+	}
+	unavailable := desired - available
+	maxUnavailable := evictMaxUnavailable(resource, desired)
+
+	var blocked []string
+	for _, p := range outdated {
+		// Evicting an unavailable pod does not make the workload less
+		// available.
+		if podAvailable(p) && unavailable >= maxUnavailable {
+			continue
+		}
+		err := r.SubResource("eviction").Create(ctx, p, &policyv1.Eviction{
+			ObjectMeta: metav1.ObjectMeta{Namespace: p.Namespace, Name: p.Name},
+		})
+		switch {
+		case apierrors.IsTooManyRequests(err):
+			// The eviction would violate a PodDisruptionBudget.
+			blocked = append(blocked, p.Name)
+		case apierrors.IsNotFound(err):
+		case err != nil:
+			return nil, fmt.Errorf("unable to evict pod %s/%s: %v", p.Namespace, p.Name, err)
+		default:
+			l.Info("Evicted pod to roll out the proxy configuration",
+				"AuthProxyWorkload", proxyDisplayName(resource.Namespace, resource.Name),
+				"Namespace", p.Namespace, "Name", p.Name)
+			if podAvailable(p) {
+				unavailable++
+			}
+		}
+	}
+	return blocked, nil
+}
+
+// canEvict returns true when the workload's controller replaces its evicted
+// pods, so that the Evict RolloutStrategy applies to it. Evicted pods of a Job
+// may count as failures, and evicted Pods are not replaced.
+func canEvict(wl workload.Workload) bool {
+	switch wl.(type) {
+	case *workload.DeploymentWorkload, *workload.StatefulSetWorkload,
+		*workload.DaemonSetWorkload, *workload.ReplicaSetWorkload:
+		return true
+	}
+	return false
+}
+
+// desiredPods returns the number of pods that the workload's controller
+// keeps running.
+func desiredPods(wl workload.Workload) int {
+	if rs, ok := workload.WorkloadRolloutStatus(wl); ok {
+		return int(rs.Replicas)
+	}
+	if w, ok := wl.(*workload.ReplicaSetWorkload); ok && w.ReplicaSet.Spec.Replicas != nil {
+		return int(*w.ReplicaSet.Spec.Replicas)
+	}
+	return 1
+}
+
+// podAvailable returns true when the pod is running, ready, and not being
+// deleted.
+func podAvailable(p *corev1.Pod) bool {
+	if p.Status.Phase != corev1.PodRunning || !p.GetDeletionTimestamp().IsZero() {
+		return false
+	}
+	for _, c := range p.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// evictMaxUnavailable returns the number of the workload's desired pods that
+// may be unavailable while its pods are evicted.
+func evictMaxUnavailable(resource *cloudsqlapi.AuthProxyWorkload, desired int) int {
+	mu := intstr.FromInt32(1)
+	if er := resource.Spec.AuthProxyContainer.EvictRollout; er != nil && er.MaxUnavailable != nil {
+		mu = *er.MaxUnavailable
+	}
+	n, err := intstr.GetScaledValueFromIntOrPercent(&mu, desired, false)
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+// countEvictionBlocked returns the number of pods that can't be evicted
+// because of a PodDisruptionBudget.
+func countEvictionBlocked(resource *cloudsqlapi.AuthProxyWorkload) int {
+	var n int
+	for _, s := range resource.Status.WorkloadStatus {
+		n += len(s.EvictionBlockedPods)
+	}
+	return n
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
+	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/workload"
+)
+
+// injectionDisabled checks whether the operator may add the proxy to the pods
+// of the workload wl, and update wl. When it may not, it returns the reason,
+// ReasonInjectionPaused or ReasonWorkloadExcluded, and a message. The owners
+// of wl are checked for the DisableInjectionAnnotation too. Only their own
+// annotations are checked: the pod template annotations of an owner are
+// copied to the objects it creates, like the ReplicaSets of a Deployment and
+// their pods, so they are checked on wl itself.
+func injectionDisabled(ctx context.Context, c client.Reader, u *workload.Updater, wl workload.Workload) (string, string, error) {
+	if u.Config().PauseInjection {
+		return cloudsqlapi.ReasonInjectionPaused,
+			"Proxy injection is paused by the operator's pauseInjection setting", nil
+	}
+
+	o := wl.Object()
+	if isDisabled(o.GetAnnotations()) {
+		return cloudsqlapi.ReasonWorkloadExcluded,
+			fmt.Sprintf("The workload has the annotation %s", cloudsqlapi.DisableInjectionAnnotation), nil
+	}
+	if isDisabled(wl.PodTemplateAnnotations()) {
+		return cloudsqlapi.ReasonWorkloadExcluded,
+			fmt.Sprintf("The workload's pod template has the annotation %s", cloudsqlapi.DisableInjectionAnnotation), nil
+	}
+
+	owners, err := listOwners(ctx, c, o)
+	if err != nil {
+		return "", "", err
+	}
+	for _, owner := range owners {
+		if isDisabled(owner.Object().GetAnnotations()) {
+			return cloudsqlapi.ReasonWorkloadExcluded,
+				fmt.Sprintf("The workload's owner %s has the annotation %s",
+					owner.Object().GetName(), cloudsqlapi.DisableInjectionAnnotation), nil
+		}
+	}
+
+	ns := &corev1.Namespace{}
+	err = c.Get(ctx, client.ObjectKey{Name: o.GetNamespace()}, ns)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", "", fmt.Errorf("unable to read namespace %s, %v", o.GetNamespace(), err)
+	}
+	if err == nil && isDisabled(ns.GetAnnotations()) {
+		return cloudsqlapi.ReasonWorkloadExcluded,
+			fmt.Sprintf("The namespace %s has the annotation %s", ns.GetName(), cloudsqlapi.DisableInjectionAnnotation), nil
+	}
+
+	return "", "", nil
+}
+
+// isDisabled returns true when the DisableInjectionAnnotation is set to true.
+func isDisabled(an map[string]string) bool {
+	return strings.EqualFold(an[cloudsqlapi.DisableInjectionAnnotation], "true")
+}
+
+// annotationProxy returns the AuthProxyWorkload requested by the injection
+// annotations of the pod, see cloudsqlapi.InstancesAnnotation. It returns nil
+// when the pod has no injection annotations, or when the operator's
+// annotationInjectionNamespaces setting does not select the pod's namespace.
+func annotationProxy(ctx context.Context, c client.Reader, u *workload.Updater, wl *workload.PodWorkload) (*cloudsqlapi.AuthProxyWorkload, error) {
+	an := wl.Pod.GetAnnotations()
+	if _, ok := an[cloudsqlapi.InstancesAnnotation]; !ok {
+		return nil, nil
+	}
+
+	l := logf.FromContext(ctx)
+	cfg := u.Config()
+	ns := &corev1.Namespace{}
+	if cfg.AnnotationInjectionNamespaces != "" {
+		err := c.Get(ctx, client.ObjectKey{Name: wl.Pod.Namespace}, ns)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read namespace %s, %v", wl.Pod.Namespace, err)
+		}
+	}
+	if !cfg.AllowsAnnotationInjection(ns) {
+		l.Info("Ignoring the proxy annotations, annotation injection is not allowed in the namespace",
+			"ns", wl.Pod.Namespace, "name", wl.Pod.Name)
+		return nil, nil
+	}
+
+	p, err := cloudsqlapi.AuthProxyWorkloadFromAnnotations(wl.Pod.Namespace, an)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy annotations, %v", err)
+	}
+	return p, nil
+}
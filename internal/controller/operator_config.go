@@ -0,0 +1,168 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
+	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/workload"
+)
+
+// configMapController watches the ConfigMap holding the operator defaults
+// for the proxy container. When the defaults change, it triggers Reconcile on
+// the AuthProxyWorkload and ClusterAuthProxyWorkload resources that use them,
+// which then update their workloads in accordance with the RolloutStrategy.
+type configMapController struct {
+	client.Client
+	updater *workload.Updater
+
+	// base holds the defaults set on the operator's command line. The values
+	// in the ConfigMap are applied on top of them.
+	base workload.Config
+
+	// key is the namespace and name of the ConfigMap.
+	key types.NamespacedName
+
+	proxies        chan<- event.GenericEvent
+	clusterProxies chan<- event.GenericEvent
+}
+
+// newConfigMapController constructs a configMapController, and loads the
+// ConfigMap so that the defaults are in place before the webhooks start.
+func newConfigMapController(mgr ctrl.Manager, u *workload.Updater, base workload.Config, key types.NamespacedName, r *AuthProxyWorkloadReconciler) (*configMapController, error) {
+	c := &configMapController{
+		Client:         mgr.GetClient(),
+		updater:        u,
+		base:           base,
+		key:            key,
+		proxies:        r.defaultsChanged,
+		clusterProxies: r.clusterDefaultsChanged,
+	}
+
+	cfg, err := c.loadConfig(context.Background(), mgr.GetAPIReader())
+	if err != nil {
+		return nil, err
+	}
+	u.SetConfig(cfg)
+
+	err = ctrl.NewControllerManagedBy(mgr).
+		Named("operator-config").
+		For(&corev1.ConfigMap{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(o client.Object) bool {
+			return o.GetNamespace() == key.Namespace && o.GetName() == key.Name
+		}))).
+		Complete(c)
+	return c, err
+}
+
+// Reconcile applies the defaults from the ConfigMap to the Updater. When
+// the ConfigMap is deleted, the defaults from the command line are used.
+func (c *configMapController) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
+
+	cfg, err := c.loadConfig(ctx, c.Client)
+	if err != nil {
+		// Keep using the current defaults until the ConfigMap is fixed.
+		l.Error(err, "Unable to load the operator defaults, keeping the current defaults",
+			"ns", c.key.Namespace, "name", c.key.Name)
+		return ctrl.Result{}, nil
+	}
+
+	if !c.updater.SetConfig(cfg) {
+		return ctrl.Result{}, nil
+	}
+
+	l.Info("Operator defaults changed, updating AuthProxyWorkloads",
+		"ns", c.key.Namespace, "name", c.key.Name)
+	return ctrl.Result{}, c.rolloutDefaults(ctx)
+}
+
+// loadConfig reads the ConfigMap and applies it to the base defaults.
+func (c *configMapController) loadConfig(ctx context.Context, r client.Reader) (workload.Config, error) {
+	cm := &corev1.ConfigMap{}
+	err := r.Get(ctx, c.key, cm)
+	if apierrors.IsNotFound(err) {
+		return c.base, nil
+	}
+	if err != nil {
+		return c.base, fmt.Errorf("unable to read ConfigMap %v, %v", c.key, err)
+	}
+	cfg, err := c.base.Apply(cm.Data)
+	if err != nil {
+		return c.base, fmt.Errorf("invalid operator defaults in ConfigMap %v, %v", c.key, err)
+	}
+	return cfg, nil
+}
+
+// rolloutDefaults triggers Reconcile on all AuthProxyWorkload and
+// ClusterAuthProxyWorkload resources that use the operator defaults.
+func (c *configMapController) rolloutDefaults(ctx context.Context) error {
+	pl := &cloudsqlapi.AuthProxyWorkloadList{}
+	err := c.List(ctx, pl)
+	if err != nil {
+		return fmt.Errorf("can't list AuthProxyWorkloads, %v", err)
+	}
+	for i := range pl.Items {
+		if !usesOperatorDefaults(pl.Items[i].Spec.AuthProxyContainer) {
+			continue
+		}
+		err = sendEvent(ctx, c.proxies, &pl.Items[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	cpl := &cloudsqlapi.ClusterAuthProxyWorkloadList{}
+	err = c.List(ctx, cpl)
+	if err != nil {
+		return fmt.Errorf("can't list ClusterAuthProxyWorkloads, %v", err)
+	}
+	for i := range cpl.Items {
+		if !usesOperatorDefaults(cpl.Items[i].Spec.AuthProxyContainer) {
+			continue
+		}
+		err = sendEvent(ctx, c.clusterProxies, &cpl.Items[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// usesOperatorDefaults returns false when the proxy container is fully
+// overridden, and so does not use any of the operator defaults.
+func usesOperatorDefaults(spec *cloudsqlapi.AuthProxyContainerSpec) bool {
+	return spec == nil || spec.Container == nil
+}
+
+func sendEvent(ctx context.Context, ch chan<- event.GenericEvent, o client.Object) error {
+	select {
+	case ch <- event.GenericEvent{Object: o}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
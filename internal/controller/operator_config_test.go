@@ -0,0 +1,138 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
+	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/testhelpers"
+	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/workload"
+)
+
+func TestConfigMapController(t *testing.T) {
+	key := types.NamespacedName{Namespace: "cloud-sql-proxy-operator-system", Name: "operator-defaults"}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+		Data: map[string]string{
+			workload.ConfigRequests: "cpu=100m,memory=128Mi",
+		},
+	}
+	usesDefaults := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: "defaults"}, "project:region:db")
+	overridden := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: "overridden"}, "project:region:db")
+	overridden.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{Container: &corev1.Container{}}
+	clusterUsesDefaults := &cloudsqlapi.ClusterAuthProxyWorkload{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-defaults"},
+		Spec: cloudsqlapi.AuthProxyWorkloadSpec{
+			Workload: cloudsqlapi.WorkloadSelectorSpec{Kind: "Deployment", Name: "webapp"},
+		},
+	}
+
+	cb, _, err := clientBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := cb.WithObjects(cm, usesDefaults, overridden, clusterUsesDefaults).Build()
+	c, proxies, clusterProxies := configMapControllerForTest(cl, key)
+	ctx := log.IntoContext(context.Background(), logger)
+
+	_, err = c.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.updater.Config().Resources.Requests["memory"]; got.String() != "128Mi" {
+		t.Errorf("got %v, want 128Mi for the memory request", got.String())
+	}
+	assertEventsSent(t, proxies, "defaults")
+	assertEventsSent(t, clusterProxies, "cluster-defaults")
+
+	// Reconcile without a change does not trigger the AuthProxyWorkloads.
+	_, err = c.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEventsSent(t, proxies)
+
+	// An invalid ConfigMap keeps the current defaults.
+	cm.Data = map[string]string{workload.ConfigRequests: "cpu"}
+	err = cl.Update(ctx, cm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.updater.Config().Resources.Requests["memory"]; got.String() != "128Mi" {
+		t.Errorf("got %v, want 128Mi for the memory request", got.String())
+	}
+	assertEventsSent(t, proxies)
+
+	// Deleting the ConfigMap restores the defaults from the command line.
+	err = cl.Delete(ctx, cm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.updater.Config().Equal(c.base) {
+		t.Errorf("got %v, want the command line defaults", c.updater.Config())
+	}
+	assertEventsSent(t, proxies, "defaults")
+}
+
+func configMapControllerForTest(c client.Client, key types.NamespacedName) (*configMapController, chan event.GenericEvent, chan event.GenericEvent) {
+	proxies := make(chan event.GenericEvent, 10)
+	clusterProxies := make(chan event.GenericEvent, 10)
+	base := workload.DefaultConfig()
+	return &configMapController{
+		Client:         c,
+		updater:        workload.NewUpdaterWithConfig("cloud-sql-proxy-operator/dev", base),
+		base:           base,
+		key:            key,
+		proxies:        proxies,
+		clusterProxies: clusterProxies,
+	}, proxies, clusterProxies
+}
+
+func assertEventsSent(t *testing.T, ch chan event.GenericEvent, wantNames ...string) {
+	t.Helper()
+	var got []string
+	for len(ch) > 0 {
+		e := <-ch
+		got = append(got, e.Object.GetName())
+	}
+	if len(got) != len(wantNames) {
+		t.Errorf("got events for %v, want %v", got, wantNames)
+		return
+	}
+	for i := range got {
+		if got[i] != wantNames[i] {
+			t.Errorf("got events for %v, want %v", got, wantNames)
+			return
+		}
+	}
+}
@@ -16,8 +16,10 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -25,8 +27,11 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/json"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
@@ -91,8 +96,23 @@ func (a *PodAdmissionWebhook) handleCreatePodRequest(ctx context.Context, p core
 		return nil, nil
 	}
 
+	reason, msg, err := injectionDisabled(ctx, a.Client, a.updater, wl)
+	if err != nil {
+		return nil, err
+	}
+	if reason != "" {
+		l.Info("Not adding the proxy to pod: "+msg,
+			"kind", wl.Pod.Kind, "ns", wl.Pod.Namespace, "name", wl.Pod.Name)
+		return nil, nil
+	}
+
+	secrets, err := loadSecretVersions(ctx, a.Client, p.Namespace, proxies)
+	if err != nil {
+		return nil, err
+	}
+
 	// Configure the pod, adding containers for each of the proxies
-	wlConfigErr := a.updater.ConfigureWorkload(wl, proxies)
+	wlConfigErr := a.updater.ConfigureWorkload(wl, proxies, secrets)
 
 	if wlConfigErr != nil {
 		l.Error(wlConfigErr, "Unable to reconcile workload result in webhook: "+wlConfigErr.Error(),
@@ -104,43 +124,159 @@ func (a *PodAdmissionWebhook) handleCreatePodRequest(ctx context.Context, p core
 }
 
 // findMatchingProxies lists all AuthProxyWorkloads that are related to this pod
-// or its owners.
-func findMatchingProxies(ctx context.Context, c client.Client, u *workload.Updater, wl *workload.PodWorkload) ([]*cloudsqlapi.AuthProxyWorkload, error) {
+// or its owners. The extraOwners are treated as owners of the pod in addition
+// to the owners in the pod's OwnerReferences. When none match, it returns the
+// AuthProxyWorkload requested by the pod's proxy annotations, if any.
+func findMatchingProxies(ctx context.Context, c client.Reader, u *workload.Updater, wl *workload.PodWorkload, extraOwners ...workload.Workload) ([]*cloudsqlapi.AuthProxyWorkload, error) {
 	var (
-		instList = &cloudsqlapi.AuthProxyWorkloadList{}
-		proxies  []*cloudsqlapi.AuthProxyWorkload
-		l        = logf.FromContext(ctx)
+		proxies []*cloudsqlapi.AuthProxyWorkload
+		l       = logf.FromContext(ctx)
 	)
 
-	// List all the AuthProxyWorkloads in the same namespace.
-	// To avoid privilege escalation, the operator requires that the AuthProxyWorkload
-	// may only affect pods in the same namespace.
-	err := c.List(ctx, instList, client.InNamespace(wl.Object().GetNamespace()))
-	if err != nil {
-		l.Error(err, "Unable to list CloudSqlClient resources in webhook",
-			"kind", wl.Pod.Kind, "ns", wl.Pod.Namespace, "name", wl.Pod.Name)
-		return nil, fmt.Errorf("unable to list AuthProxyWorkloads, %v", err)
-	}
-
 	// List the owners of this pod.
 	owners, err := listOwners(ctx, c, wl.Object())
 	if err != nil {
 		return nil, fmt.Errorf("there is an AuthProxyWorkloadConfiguration error reconciling this workload %v", err)
 	}
+	owners = append(extraOwners, owners...)
+
+	// List the AuthProxyWorkloads in the same namespace, and the
+	// ClusterAuthProxyWorkloads whose namespaceSelector selects the pod's
+	// namespace, that select a Pod or the kind of one of the pod's owners.
+	instList, err := listProxies(ctx, c, wl.Object().GetNamespace(), ownerKinds(owners)...)
+	if err != nil {
+		l.Error(err, "Unable to list AuthProxyWorkload resources in webhook",
+			"kind", wl.Pod.Kind, "ns", wl.Pod.Namespace, "name", wl.Pod.Name)
+		return nil, err
+	}
 
 	// Find matching AuthProxyWorkloads for this pod
 	proxies = u.FindMatchingAuthProxyWorkloads(instList, wl, owners)
-	if len(proxies) == 0 {
+	if len(proxies) > 0 {
+		return proxies, nil
+	}
+
+	// AuthProxyWorkloads take precedence over the proxy annotations on the
+	// pod, so the annotations are only read when none match.
+	p, err := annotationProxy(ctx, c, u, wl)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
 		return nil, nil // no change
 	}
+	return []*cloudsqlapi.AuthProxyWorkload{p}, nil
+
+}
+
+// listProxies lists the AuthProxyWorkloads in namespace ns and the
+// ClusterAuthProxyWorkloads that select ns. To avoid privilege escalation, the
+// operator requires that an AuthProxyWorkload may only affect pods in the same
+// namespace. When kinds are set, only the resources that select a workload of
+// one of the kinds are listed, using the workloadKindField index.
+func listProxies(ctx context.Context, c client.Reader, ns string, kinds ...string) (*cloudsqlapi.AuthProxyWorkloadList, error) {
+	pl := &cloudsqlapi.AuthProxyWorkloadList{}
+	for _, opts := range kindListOptions(kinds) {
+		l := &cloudsqlapi.AuthProxyWorkloadList{}
+		err := c.List(ctx, l, append(opts, client.InNamespace(ns))...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list AuthProxyWorkloads, %v", err)
+		}
+		pl.Items = append(pl.Items, l.Items...)
+	}
+
+	clusterItems, err := listClusterProxiesForNamespace(ctx, c, ns, kinds)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list ClusterAuthProxyWorkloads, %v", err)
+	}
+	pl.Items = append(pl.Items, clusterItems...)
+	return pl, nil
+}
+
+// kindListOptions returns the list options for each of kinds that select the
+// resources of that kind using the workloadKindField index. With no kinds, it
+// returns a single empty set of options that lists all resources.
+func kindListOptions(kinds []string) [][]client.ListOption {
+	if len(kinds) == 0 {
+		return [][]client.ListOption{nil}
+	}
+	opts := make([][]client.ListOption, 0, len(kinds))
+	for _, k := range kinds {
+		opts = append(opts, []client.ListOption{client.MatchingFields{workloadKindField: k}})
+	}
+	return opts
+}
+
+// ownerKinds returns the kind Pod, followed by the distinct kinds of owners.
+func ownerKinds(owners []workload.Workload) []string {
+	kinds := []string{"Pod"}
+	for _, o := range owners {
+		k := o.Object().GetObjectKind().GroupVersionKind().Kind
+		if k != "" && !slices.Contains(kinds, k) {
+			kinds = append(kinds, k)
+		}
+	}
+	return kinds
+}
+
+// loadSecretVersions reads the metadata of the credentials file Secrets used
+// by proxies from namespace ns. Secrets that do not exist are left out of the
+// result.
+func loadSecretVersions(ctx context.Context, c client.Reader, ns string, proxies []*cloudsqlapi.AuthProxyWorkload) (workload.SecretVersions, error) {
+	secrets := workload.SecretVersions{}
+	for _, p := range proxies {
+		name := workload.CredentialsFileSecretName(p)
+		if name == "" {
+			continue
+		}
+		if _, ok := secrets[name]; ok {
+			continue
+		}
+
+		s := &metav1.PartialObjectMetadata{}
+		s.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Secret"))
+		err := c.Get(ctx, client.ObjectKey{Namespace: ns, Name: name}, s)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read secret %s/%s, %v", ns, name, err)
+		}
+		secrets[name] = s.GetResourceVersion()
+	}
+	return secrets, nil
+}
 
-	return proxies, nil
+// listClusterProxiesForNamespace returns the ClusterAuthProxyWorkloads that
+// select namespace ns and one of kinds, converted to cluster-scoped
+// AuthProxyWorkloads. With no kinds, it returns all that select ns.
+func listClusterProxiesForNamespace(ctx context.Context, c client.Reader, ns string, kinds []string) ([]cloudsqlapi.AuthProxyWorkload, error) {
+	cpl := &cloudsqlapi.ClusterAuthProxyWorkloadList{}
+	for _, opts := range kindListOptions(kinds) {
+		l := &cloudsqlapi.ClusterAuthProxyWorkloadList{}
+		err := c.List(ctx, l, opts...)
+		if err != nil {
+			return nil, err
+		}
+		cpl.Items = append(cpl.Items, l.Items...)
+	}
+	if len(cpl.Items) == 0 {
+		return nil, nil
+	}
 
+	nsObj := &corev1.Namespace{}
+	err := c.Get(ctx, client.ObjectKey{Name: ns}, nsObj)
+	if err != nil {
+		return nil, err
+	}
+	return workload.ClusterAuthProxyWorkloadsForNamespace(cpl, nsObj), nil
 }
 
 // listOwners returns the list of this object's owners and its extended owners.
+// The owners are read from the operator's cache of the workload kinds, which
+// the AuthProxyWorkload controller already watches.
 // Warning: this is a recursive function
-func listOwners(ctx context.Context, c client.Client, object client.Object) ([]workload.Workload, error) {
+func listOwners(ctx context.Context, c client.Reader, object client.Object) ([]workload.Workload, error) {
 	l := logf.FromContext(ctx)
 	var owners []workload.Workload
 
@@ -202,13 +338,101 @@ func newPodDeleteController(mgr ctrl.Manager, u *workload.Updater) (*podDeleteCo
 }
 
 // setupWithManager adds this AuthProxyWorkload controller to the controller-runtime
-// manager.
+// manager. Only the events of failing pods in namespaces that may have proxies
+// are reconciled.
 func (r *podDeleteController) setupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.Pod{}).
+		For(&corev1.Pod{}, builder.WithPredicates(
+			podFailedPredicate(),
+			predicate.NewPredicateFuncs(r.mayHaveProxies))).
 		Complete(r)
 }
 
+// podFailedPredicate passes the events of pods that handlePodChanged may need
+// to delete: a pod that already has failed containers when it is added to
+// the cache, and a pod whose containers start failing. No other pod change can
+// make CheckWorkloadContainers report an error.
+func podFailedPredicate() predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return podFailed(e.Object)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return !podFailed(e.ObjectOld) && podFailed(e.ObjectNew)
+		},
+		DeleteFunc: func(event.DeleteEvent) bool {
+			return false
+		},
+		GenericFunc: func(event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+// podFailed returns true when o is a pod with failed containers.
+func podFailed(o client.Object) bool {
+	pod, ok := o.(*corev1.Pod)
+	return ok && workload.HasFailedContainers(pod)
+}
+
+// mayHaveProxies returns true when the pod was configured from its
+// annotations, or when its namespace has an AuthProxyWorkload or is selected
+// by a ClusterAuthProxyWorkload. When the AuthProxyWorkloads can't be listed
+// the event is passed on, and Reconcile reports the error.
+func (r *podDeleteController) mayHaveProxies(o client.Object) bool {
+	if _, ok := o.GetAnnotations()[cloudsqlapi.InstancesAnnotation]; ok {
+		return true
+	}
+
+	ctx := context.Background()
+	l := &cloudsqlapi.AuthProxyWorkloadList{}
+	err := r.Client.List(ctx, l, client.InNamespace(o.GetNamespace()), client.Limit(1))
+	if err != nil || len(l.Items) > 0 {
+		return true
+	}
+
+	cl, err := listClusterProxiesForNamespace(ctx, r.Client, o.GetNamespace(), nil)
+	return err != nil || len(cl) > 0
+}
+
+// TrimPod is a cache transform that drops the fields of a pod that the
+// operator never reads: the managed fields, the pod conditions except
+// Ready, the addresses, and all of a container status except its name and
+// state. The operator reads the pod's spec to preview and check the proxy
+// containers, so the spec is kept. Pods read from the cache must not be
+// written back to the API.
+func TrimPod(o interface{}) (interface{}, error) {
+	pod, ok := o.(*corev1.Pod)
+	if !ok {
+		return o, nil
+	}
+	pod.ManagedFields = nil
+	var conds []corev1.PodCondition
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			conds = append(conds, corev1.PodCondition{Type: c.Type, Status: c.Status})
+		}
+	}
+	pod.Status = corev1.PodStatus{
+		Phase:                 pod.Status.Phase,
+		Conditions:            conds,
+		InitContainerStatuses: trimContainerStatuses(pod.Status.InitContainerStatuses),
+		ContainerStatuses:     trimContainerStatuses(pod.Status.ContainerStatuses),
+	}
+	return pod, nil
+}
+
+func trimContainerStatuses(statuses []corev1.ContainerStatus) []corev1.ContainerStatus {
+	if statuses == nil {
+		return nil
+	}
+	trimmed := make([]corev1.ContainerStatus, len(statuses))
+	for i, cs := range statuses {
+		trimmed[i] = corev1.ContainerStatus{Name: cs.Name, State: cs.State}
+	}
+	return trimmed
+}
+
 func (r *podDeleteController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	// Read the ReplicaSet
 	pod := &corev1.Pod{}
@@ -248,13 +472,36 @@ func (r *podDeleteController) handlePodChanged(ctx context.Context, pod *corev1.
 		return nil
 	}
 
+	// The proxy is not added to this pod on purpose, ignore this event.
+	reason, _, err := injectionDisabled(ctx, r.Client, r.updater, wl)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		return nil
+	}
+
+	secrets, err := loadSecretVersions(ctx, r.Client, pod.Namespace, proxies)
+	if err != nil {
+		return err
+	}
+
 	// Check if this pod is in an error or waiting state and is missing
 	// proxy containers.
-	wlConfigErr := r.updater.CheckWorkloadContainers(wl, proxies)
+	wlConfigErr := r.updater.CheckWorkloadContainers(wl, proxies, secrets)
+
+	l := logf.FromContext(ctx)
+
+	// A missing credentials Secret can't be fixed by recreating the pod.
+	// The pod will be updated when the Secret is created.
+	if errors.Is(wlConfigErr, workload.ErrMissingCredentialsSecret) {
+		l.Info("Pod uses a credentials secret that does not exist.",
+			"Namespace", pod.Namespace, "Name", pod.Name, "Error", wlConfigErr.Error())
+		return nil
+	}
 
 	// If the pod is misconfigured, attempt to delete it.
 	if wlConfigErr != nil {
-		l := logf.FromContext(ctx)
 		l.Info("Pod configured incorrectly. Deleting.",
 			"Namespace", pod.Namespace, "Name", pod.Name, "Status", pod.Status)
 		err = r.Client.Delete(ctx, pod)
@@ -16,6 +16,9 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
 	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
@@ -24,11 +27,17 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
@@ -145,6 +154,320 @@ func TestPodWebhookWithDeploymentOwners(t *testing.T) {
 
 }
 
+func TestPodWebhookWithClusterAuthProxyWorkload(t *testing.T) {
+	cp := &cloudsqlapi.ClusterAuthProxyWorkload{
+		ObjectMeta: v1.ObjectMeta{Name: "cluster-test", Generation: 1},
+		Spec: cloudsqlapi.AuthProxyWorkloadSpec{
+			Workload: cloudsqlapi.WorkloadSelectorSpec{
+				Kind:     "Deployment",
+				Selector: &v1.LabelSelector{MatchLabels: map[string]string{"app": "webapp"}},
+				NamespaceSelector: &v1.LabelSelector{
+					MatchLabels: map[string]string{"env": "prod"},
+				},
+			},
+			Instances: []cloudsqlapi.InstanceSpec{{ConnectionString: "project:region:db"}},
+		},
+	}
+
+	// Namespaced proxy for the same instance that overrides the cluster proxy
+	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{
+		Namespace: "default",
+		Name:      "test",
+	}, "project:region:db")
+	addFinalizers(p)
+	addSelectorWorkload(p, "Deployment", "app", "webapp")
+
+	// Namespaced proxy for another instance, added next to the cluster proxy
+	other := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{
+		Namespace: "default",
+		Name:      "other",
+	}, "project:region:db2")
+	addFinalizers(other)
+	addSelectorWorkload(other, "Deployment", "app", "webapp")
+
+	data := []struct {
+		name           string
+		nsLabels       map[string]string
+		objs           []client.Object
+		wantContainers []string
+	}{
+		{
+			name:           "namespace selected by cluster proxy",
+			nsLabels:       map[string]string{"env": "prod"},
+			objs:           []client.Object{cp},
+			wantContainers: []string{workload.ContainerName(cp.AuthProxyWorkload())},
+		},
+		{
+			name:     "namespace not selected by cluster proxy",
+			nsLabels: map[string]string{"env": "dev"},
+			objs:     []client.Object{cp},
+		},
+		{
+			name:           "namespaced proxy takes precedence",
+			nsLabels:       map[string]string{"env": "prod"},
+			objs:           []client.Object{cp, p},
+			wantContainers: []string{workload.ContainerName(p)},
+		},
+		{
+			name:           "namespaced proxy for another instance",
+			nsLabels:       map[string]string{"env": "prod"},
+			objs:           []client.Object{cp, other},
+			wantContainers: []string{workload.ContainerName(cp.AuthProxyWorkload()), workload.ContainerName(other)},
+		},
+	}
+	for _, tc := range data {
+		t.Run(tc.name, func(t *testing.T) {
+			cb, scheme, err := clientBuilder()
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "test"}, "webapp")
+			d.ObjectMeta.Labels = map[string]string{"app": "webapp"}
+			rs, hash, err := testhelpers.BuildDeploymentReplicaSet(d, scheme)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pods, err := testhelpers.BuildDeploymentReplicaSetPods(d, rs, hash, scheme)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ns := &corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "default", Labels: tc.nsLabels}}
+
+			c := cb.WithObjects(tc.objs...).WithObjects(ns, rs, d).Build()
+			wh, ctx, err := podWebhookController(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pod, err := wh.handleCreatePodRequest(ctx, *pods[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tc.wantContainers) == 0 {
+				if pod != nil {
+					t.Fatal("got non-nil pod, want nil indicating no pod updates")
+				}
+				return
+			}
+			if pod == nil {
+				t.Fatal("got nil, want not nil pod indicating pod updates")
+			}
+			var names []string
+			for _, c := range pod.Spec.Containers[1:] {
+				names = append(names, c.Name)
+			}
+			if !reflect.DeepEqual(names, tc.wantContainers) {
+				t.Errorf("got proxy containers %v, want %v", names, tc.wantContainers)
+			}
+		})
+	}
+}
+
+func TestPodWebhookInjectionDisabled(t *testing.T) {
+	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{
+		Namespace: "default",
+		Name:      "test",
+	}, "project:region:db")
+	addFinalizers(p)
+	addSelectorWorkload(p, "Deployment", "app", "webapp")
+
+	disabled := map[string]string{cloudsqlapi.DisableInjectionAnnotation: "true"}
+	data := []struct {
+		name       string
+		annotate   func(d *appsv1.Deployment, ns *corev1.Namespace)
+		pause      bool
+		wantUpdate bool
+	}{
+		{
+			name:       "not disabled",
+			annotate:   func(d *appsv1.Deployment, ns *corev1.Namespace) {},
+			wantUpdate: true,
+		},
+		{
+			name: "deployment annotation",
+			annotate: func(d *appsv1.Deployment, ns *corev1.Namespace) {
+				d.Annotations = disabled
+			},
+		},
+		{
+			name: "pod template annotation",
+			annotate: func(d *appsv1.Deployment, ns *corev1.Namespace) {
+				d.Spec.Template.Annotations = disabled
+			},
+		},
+		{
+			name: "namespace annotation",
+			annotate: func(d *appsv1.Deployment, ns *corev1.Namespace) {
+				ns.Annotations = disabled
+			},
+		},
+		{
+			name: "annotation set to false",
+			annotate: func(d *appsv1.Deployment, ns *corev1.Namespace) {
+				d.Annotations = map[string]string{cloudsqlapi.DisableInjectionAnnotation: "false"}
+			},
+			wantUpdate: true,
+		},
+		{
+			name:     "injection paused",
+			annotate: func(d *appsv1.Deployment, ns *corev1.Namespace) {},
+			pause:    true,
+		},
+	}
+	for _, tc := range data {
+		t.Run(tc.name, func(t *testing.T) {
+			cb, scheme, err := clientBuilder()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "test"}, "webapp")
+			d.Labels = map[string]string{"app": "webapp"}
+			ns := &corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "default"}}
+			tc.annotate(d, ns)
+
+			rs, hash, err := testhelpers.BuildDeploymentReplicaSet(d, scheme)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pods, err := testhelpers.BuildDeploymentReplicaSetPods(d, rs, hash, scheme)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := cb.WithObjects(p, rs, d, ns).Build()
+			wh, ctx, err := podWebhookController(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.pause {
+				cfg := wh.updater.Config()
+				cfg.PauseInjection = true
+				wh.updater.SetConfig(cfg)
+			}
+
+			pod, err := wh.handleCreatePodRequest(ctx, *pods[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantUpdate && pod == nil {
+				t.Fatal("got nil, want the pod to be updated")
+			}
+			if !tc.wantUpdate && pod != nil {
+				t.Fatal("got an updated pod, want no pod updates")
+			}
+		})
+	}
+}
+
+func TestPodWebhookAnnotationInjection(t *testing.T) {
+	requested := map[string]string{cloudsqlapi.InstancesAnnotation: "project:region:db=5432"}
+	data := []struct {
+		name          string
+		annotations   map[string]string
+		nsLabels      map[string]string
+		policy        string
+		withProxy     bool
+		wantContainer string
+		wantErr       bool
+	}{
+		{
+			name:          "allowed in namespace",
+			annotations:   requested,
+			nsLabels:      map[string]string{"team": "a"},
+			policy:        "team=a",
+			wantContainer: "csql-default-" + cloudsqlapi.AnnotationProxyName,
+		},
+		{
+			name:        "annotation injection off",
+			annotations: requested,
+		},
+		{
+			name:        "namespace not selected",
+			annotations: requested,
+			nsLabels:    map[string]string{"team": "b"},
+			policy:      "team=a",
+		},
+		{
+			name:          "AuthProxyWorkload takes precedence",
+			annotations:   requested,
+			policy:        "kubernetes.io/metadata.name",
+			nsLabels:      map[string]string{"kubernetes.io/metadata.name": "default"},
+			withProxy:     true,
+			wantContainer: "csql-default-test",
+		},
+		{
+			name:        "invalid annotation",
+			annotations: map[string]string{cloudsqlapi.InstancesAnnotation: "project:region:db"},
+			nsLabels:    map[string]string{"team": "a"},
+			policy:      "team=a",
+			wantErr:     true,
+		},
+	}
+	for _, tc := range data {
+		t.Run(tc.name, func(t *testing.T) {
+			cb, _, err := clientBuilder()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ns := &corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "default", Labels: tc.nsLabels}}
+			objs := []client.Object{ns}
+			if tc.withProxy {
+				p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: "test"}, "project:region:other")
+				addFinalizers(p)
+				addSelectorWorkload(p, "Pod", "app", "webapp")
+				objs = append(objs, p)
+			}
+			c := cb.WithObjects(objs...).Build()
+			wh, ctx, err := podWebhookController(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := wh.updater.Config()
+			cfg.AnnotationInjectionNamespaces = tc.policy
+			wh.updater.SetConfig(cfg)
+
+			pod := corev1.Pod{
+				TypeMeta: v1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+				ObjectMeta: v1.ObjectMeta{
+					Namespace:   "default",
+					Name:        "webapp",
+					Labels:      map[string]string{"app": "webapp"},
+					Annotations: tc.annotations,
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "busybox"}}},
+			}
+
+			got, err := wh.handleCreatePodRequest(ctx, pod)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("got no error, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantContainer == "" {
+				if got != nil {
+					t.Fatalf("got an updated pod, want no pod updates")
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("got nil, want the pod to be updated")
+			}
+			var names []string
+			for _, c := range got.Spec.Containers {
+				names = append(names, c.Name)
+			}
+			if len(names) != 2 || names[1] != tc.wantContainer {
+				t.Errorf("got containers %v, want app and %s", names, tc.wantContainer)
+			}
+		})
+	}
+}
+
 func podWebhookController(cb client.Client) (*PodAdmissionWebhook, context.Context, error) {
 	ctx := log.IntoContext(context.Background(), logger)
 	d := admission.NewDecoder(cb.Scheme())
@@ -180,6 +503,10 @@ func TestPodDeleteController(t *testing.T) {
 	}, "webapp")
 	dNoMatch.ObjectMeta.Labels = map[string]string{"app": "other"}
 
+	// Deployment that matches the proxy, but is excluded from proxy injection
+	dExcluded := dMatch.DeepCopy()
+	dExcluded.Annotations = map[string]string{cloudsqlapi.DisableInjectionAnnotation: "true"}
+
 	data := []struct {
 		name                 string
 		d                    *appsv1.Deployment
@@ -193,6 +520,12 @@ func TestPodDeleteController(t *testing.T) {
 			setPodError:  true,
 			wantNotFound: true,
 		},
+		{
+			name:         "excluded matching pod with error",
+			d:            dExcluded,
+			setPodError:  true,
+			wantNotFound: false,
+		},
 		{
 			name:         "matching pod with no error",
 			d:            dMatch,
@@ -261,7 +594,7 @@ func TestPodDeleteController(t *testing.T) {
 			c := cb.Build()
 			h, ctx := podDeleteControllerForTest(c)
 			if tc.setSidecarContainers {
-				h.updater.ConfigureWorkload(&workload.PodWorkload{Pod: pods[0]}, []*cloudsqlapi.AuthProxyWorkload{p})
+				h.updater.ConfigureWorkload(&workload.PodWorkload{Pod: pods[0]}, []*cloudsqlapi.AuthProxyWorkload{p}, nil)
 			}
 
 			req := ctrl.Request{
@@ -297,3 +630,414 @@ func podDeleteControllerForTest(c client.Client) (*podDeleteController, context.
 	}
 	return r, ctx
 }
+
+func TestPodFailedPredicate(t *testing.T) {
+	running := &corev1.Pod{Status: corev1.PodStatus{
+		ContainerStatuses: []corev1.ContainerStatus{{
+			Name:  "app",
+			State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+		}},
+	}}
+	crashing := &corev1.Pod{Status: corev1.PodStatus{
+		ContainerStatuses: []corev1.ContainerStatus{{
+			Name:  "app",
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+		}},
+	}}
+	initError := &corev1.Pod{Status: corev1.PodStatus{
+		InitContainerStatuses: []corev1.ContainerStatus{{
+			Name:  "init",
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Error"}},
+		}},
+	}}
+	completed := &corev1.Pod{Status: corev1.PodStatus{
+		ContainerStatuses: []corev1.ContainerStatus{{
+			Name:  "app",
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Completed"}},
+		}},
+	}}
+
+	pred := podFailedPredicate()
+
+	createTests := []struct {
+		name string
+		pod  *corev1.Pod
+		want bool
+	}{
+		{name: "running", pod: running, want: false},
+		{name: "completed", pod: completed, want: false},
+		{name: "crashing", pod: crashing, want: true},
+		{name: "init container error", pod: initError, want: true},
+	}
+	for _, tc := range createTests {
+		t.Run("create "+tc.name, func(t *testing.T) {
+			if got := pred.Create(event.CreateEvent{Object: tc.pod}); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	updateTests := []struct {
+		name     string
+		old, new *corev1.Pod
+		want     bool
+	}{
+		{name: "running to crashing", old: running, new: crashing, want: true},
+		{name: "running to init container error", old: running, new: initError, want: true},
+		{name: "running to completed", old: running, new: completed, want: false},
+		{name: "still crashing", old: crashing, new: crashing, want: false},
+		{name: "crashing to running", old: crashing, new: running, want: false},
+	}
+	for _, tc := range updateTests {
+		t.Run("update "+tc.name, func(t *testing.T) {
+			if got := pred.Update(event.UpdateEvent{ObjectOld: tc.old, ObjectNew: tc.new}); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	if pred.Delete(event.DeleteEvent{Object: crashing}) {
+		t.Error("got delete event passed, want ignored")
+	}
+}
+
+func TestPodDeleteControllerMayHaveProxies(t *testing.T) {
+	p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{
+		Namespace: "default",
+		Name:      "test",
+	}, "project:region:db")
+	addFinalizers(p)
+	addSelectorWorkload(p, "Deployment", "app", "webapp")
+
+	cp := &cloudsqlapi.ClusterAuthProxyWorkload{
+		ObjectMeta: v1.ObjectMeta{Name: "cluster-test", Generation: 1},
+		Spec: cloudsqlapi.AuthProxyWorkloadSpec{
+			Workload: cloudsqlapi.WorkloadSelectorSpec{
+				Kind:     "Deployment",
+				Selector: &v1.LabelSelector{MatchLabels: map[string]string{"app": "webapp"}},
+				NamespaceSelector: &v1.LabelSelector{
+					MatchLabels: map[string]string{"env": "prod"},
+				},
+			},
+			Instances: []cloudsqlapi.InstanceSpec{{ConnectionString: "project:region:db"}},
+		},
+	}
+
+	data := []struct {
+		name        string
+		nsLabels    map[string]string
+		objs        []client.Object
+		annotations map[string]string
+		want        bool
+	}{
+		{
+			name: "namespace without proxies",
+			want: false,
+		},
+		{
+			name: "namespace with AuthProxyWorkload",
+			objs: []client.Object{p},
+			want: true,
+		},
+		{
+			name:     "namespace selected by cluster proxy",
+			nsLabels: map[string]string{"env": "prod"},
+			objs:     []client.Object{cp},
+			want:     true,
+		},
+		{
+			name:     "namespace not selected by cluster proxy",
+			nsLabels: map[string]string{"env": "dev"},
+			objs:     []client.Object{cp},
+			want:     false,
+		},
+		{
+			name:        "pod with instances annotation",
+			annotations: map[string]string{cloudsqlapi.InstancesAnnotation: "project:region:db"},
+			want:        true,
+		},
+	}
+
+	for _, tc := range data {
+		t.Run(tc.name, func(t *testing.T) {
+			cb, _, err := clientBuilder()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ns := &corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "default", Labels: tc.nsLabels}}
+			c := cb.WithObjects(ns).WithObjects(tc.objs...).Build()
+			r, _ := podDeleteControllerForTest(c)
+
+			pod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{
+				Namespace:   "default",
+				Name:        "pod",
+				Annotations: tc.annotations,
+			}}
+			if got := r.mayHaveProxies(pod); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTrimPod(t *testing.T) {
+	state := corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}
+	pod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Namespace:     "default",
+			Name:          "pod",
+			Labels:        map[string]string{"app": "webapp"},
+			Annotations:   map[string]string{"a": "b"},
+			ManagedFields: []v1.ManagedFieldsEntry{{Manager: "kubelet"}},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app:1"}}},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			Conditions: []corev1.PodCondition{
+				{Type: corev1.PodScheduled, Status: corev1.ConditionTrue},
+				{Type: corev1.PodReady, Status: corev1.ConditionTrue, Reason: "Ready", LastTransitionTime: v1.Now()},
+			},
+			PodIP: "10.0.0.1",
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:         "app",
+				State:        state,
+				RestartCount: 3,
+				Image:        "app:1",
+			}},
+		},
+	}
+	want := pod.DeepCopy()
+	want.ManagedFields = nil
+	want.Status = corev1.PodStatus{
+		Phase:             corev1.PodRunning,
+		Conditions:        []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		ContainerStatuses: []corev1.ContainerStatus{{Name: "app", State: state}},
+	}
+
+	got, err := TrimPod(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Other objects are not changed
+	rs := &appsv1.ReplicaSet{ObjectMeta: v1.ObjectMeta{ManagedFields: []v1.ManagedFieldsEntry{{Manager: "kubelet"}}}}
+	gotRS, err := TrimPod(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotRS.(*appsv1.ReplicaSet).ManagedFields) != 1 {
+		t.Errorf("got ReplicaSet managed fields removed, want unchanged")
+	}
+}
+
+// BenchmarkFindMatchingProxies measures how the pod webhook finds the
+// AuthProxyWorkloads for a pod of a Deployment, in a namespace with many
+// AuthProxyWorkloads for other workloads. The "unindexed" case lists all the
+// AuthProxyWorkloads in the namespace and parses every workload selector, as
+// the webhook did before the indexes. The
+// "webhook" case runs the whole pod webhook, which also checks whether
+// injection is disabled and configures the pod.
+func BenchmarkFindMatchingProxies(b *testing.B) {
+	for _, n := range []int{10, 100, 500} {
+		c, pod := benchmarkWebhookClient(b, n)
+		wl := &workload.PodWorkload{Pod: pod}
+		ctx := context.Background()
+		u := workload.NewUpdater("cloud-sql-proxy-operator/dev", workload.DefaultProxyImage)
+
+		b.Run(fmt.Sprintf("indexed/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				proxies, err := findMatchingProxies(ctx, c, u, wl)
+				if err != nil || len(proxies) != 1 {
+					b.Fatalf("got %d proxies, error %v, want 1 proxy", len(proxies), err)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("unindexed/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				pl, err := listProxies(ctx, c, pod.Namespace)
+				if err != nil {
+					b.Fatal(err)
+				}
+				owners, err := listOwners(ctx, c, pod)
+				if err != nil {
+					b.Fatal(err)
+				}
+				u := workload.NewUpdater("cloud-sql-proxy-operator/dev", workload.DefaultProxyImage)
+				if proxies := u.FindMatchingAuthProxyWorkloads(pl, wl, owners); len(proxies) != 1 {
+					b.Fatalf("got %d proxies, want 1 proxy", len(proxies))
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("webhook/%d", n), func(b *testing.B) {
+			a := &PodAdmissionWebhook{Client: c, updater: u}
+			for i := 0; i < b.N; i++ {
+				got, err := a.handleCreatePodRequest(ctx, *pod.DeepCopy())
+				if err != nil || got == nil || len(got.Spec.Containers) != 2 {
+					b.Fatalf("got pod %v, error %v, want a pod with a proxy container", got, err)
+				}
+			}
+		})
+	}
+}
+
+// benchmarkWebhookClient returns a client holding a Deployment, its
+// ReplicaSet, an AuthProxyWorkload for the Deployment, and n other
+// AuthProxyWorkloads for workloads of various kinds. It also returns a pod of
+// the Deployment.
+func benchmarkWebhookClient(b *testing.B, n int) (client.Client, *corev1.Pod) {
+	b.Helper()
+	cb, scheme, err := clientBuilder()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "default", Name: "webapp"}, "webapp")
+	d.Labels = map[string]string{"app": "webapp"}
+	rs, hash, err := testhelpers.BuildDeploymentReplicaSet(d, scheme)
+	if err != nil {
+		b.Fatal(err)
+	}
+	pods, err := testhelpers.BuildDeploymentReplicaSetPods(d, rs, hash, scheme)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	objs := []client.Object{d, rs}
+	kinds := []string{"StatefulSet", "DaemonSet", "Job", "Deployment"}
+	for i := 0; i <= n; i++ {
+		name := fmt.Sprintf("proxy-%d", i)
+		p := testhelpers.BuildAuthProxyWorkload(types.NamespacedName{Namespace: "default", Name: name}, "project:region:"+name)
+		p.UID = types.UID(name)
+		if i == n {
+			addSelectorWorkload(p, "Deployment", "app", "webapp")
+		} else {
+			addSelectorWorkload(p, kinds[i%len(kinds)], "app", name)
+		}
+		objs = append(objs, p)
+	}
+
+	c, err := newInformerClient(cb.Build(), objs...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return c, pods[0]
+}
+
+// informerClient serves Get and List from client-go indexers with the
+// operator's field indexes, the way the manager's cache does. The fake client
+// serializes every object it reads, so it would hide the cost of the copies
+// that the indexes avoid.
+type informerClient struct {
+	client.Client
+	stores map[schema.GroupVersionKind]toolscache.Indexer
+}
+
+func newInformerClient(c client.Client, objs ...client.Object) (*informerClient, error) {
+	ic := &informerClient{
+		Client: c,
+		stores: map[schema.GroupVersionKind]toolscache.Indexer{},
+	}
+	for _, o := range objs {
+		gvk, err := apiutil.GVKForObject(o, c.Scheme())
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := ic.stores[gvk]; !ok {
+			indexers := toolscache.Indexers{toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc}
+			for _, fi := range fieldIndexes() {
+				if fgvk, _ := apiutil.GVKForObject(fi.obj, c.Scheme()); fgvk == gvk {
+					indexers["field:"+fi.field] = namespacedIndexFunc(fi.extract)
+				}
+			}
+			ic.stores[gvk] = toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, indexers)
+		}
+		if err := ic.stores[gvk].Add(o.DeepCopyObject()); err != nil {
+			return nil, err
+		}
+	}
+	return ic, nil
+}
+
+// namespacedIndexFunc stores the values of a field index with and without
+// the object's namespace, like the manager's cache.
+func namespacedIndexFunc(extract client.IndexerFunc) toolscache.IndexFunc {
+	return func(obj interface{}) ([]string, error) {
+		o := obj.(client.Object)
+		var vals []string
+		for _, v := range extract(o) {
+			vals = append(vals, o.GetNamespace()+"/"+v, "__all_namespaces/"+v)
+		}
+		return vals, nil
+	}
+}
+
+func (c *informerClient) Get(_ context.Context, key client.ObjectKey, obj client.Object, _ ...client.GetOption) error {
+	store, gvk, err := c.store(obj)
+	if err != nil {
+		return err
+	}
+	item, ok, err := store.GetByKey(key.String())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.NewNotFound(schema.GroupResource{Group: gvk.Group, Resource: gvk.Kind}, key.Name)
+	}
+	reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(item.(runtime.Object).DeepCopyObject()).Elem())
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	return nil
+}
+
+func (c *informerClient) List(_ context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	gvk, err := apiutil.GVKForObject(list, c.Scheme())
+	if err != nil {
+		return err
+	}
+	gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+	store, ok := c.stores[gvk]
+	if !ok {
+		return nil
+	}
+
+	lo := client.ListOptions{}
+	lo.ApplyOptions(opts)
+	var items []interface{}
+	switch {
+	case lo.FieldSelector != nil:
+		ns := lo.Namespace
+		if ns == "" {
+			ns = "__all_namespaces"
+		}
+		req := lo.FieldSelector.Requirements()[0]
+		items, err = store.ByIndex("field:"+req.Field, ns+"/"+req.Value)
+	case lo.Namespace != "":
+		items, err = store.ByIndex(toolscache.NamespaceIndex, lo.Namespace)
+	default:
+		items = store.List()
+	}
+	if err != nil {
+		return err
+	}
+	objs := make([]runtime.Object, 0, len(items))
+	for _, item := range items {
+		objs = append(objs, item.(runtime.Object).DeepCopyObject())
+	}
+	return apimeta.SetList(list, objs)
+}
+
+// store returns the indexer that holds objects like obj.
+func (c *informerClient) store(obj client.Object) (toolscache.Indexer, schema.GroupVersionKind, error) {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return nil, gvk, err
+	}
+	if s, ok := c.stores[gvk]; ok {
+		return s, gvk, nil
+	}
+	return toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, toolscache.Indexers{}), gvk, nil
+}
@@ -0,0 +1,178 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
+	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/workload"
+)
+
+// WorkloadPreview shows how the pod webhook configures the pods of a workload
+// with the AuthProxyWorkloads that match it.
+type WorkloadPreview struct {
+	// Workload is the workload.
+	Workload workload.Workload
+
+	// AuthProxyWorkloads are the AuthProxyWorkloads that match the workload.
+	AuthProxyWorkloads []*cloudsqlapi.AuthProxyWorkload
+
+	// Pod is a pod created from the workload's pod template, before it is
+	// configured by the operator.
+	Pod *corev1.Pod
+
+	// ConfiguredPod is the Pod as configured by the operator. It is nil when
+	// there are Errors.
+	ConfiguredPod *corev1.Pod
+
+	// Errors are the details of the ConfigError returned when the
+	// AuthProxyWorkloads can't be applied to the pod.
+	Errors []workload.ConfigErrorDetail
+
+	// MissingSecrets are the names of the credentials file Secrets used by
+	// the AuthProxyWorkloads that don't exist. The pods can't start until the
+	// Secrets are created.
+	MissingSecrets []string
+
+	// ExcludedReason is set when the operator does not add the proxy to the
+	// workload's pods, even though AuthProxyWorkloads match it. It is
+	// ReasonWorkloadExcluded or ReasonInjectionPaused. ExcludedMessage
+	// describes why.
+	ExcludedReason  string
+	ExcludedMessage string
+}
+
+// ProxyContainer returns the proxy container that the operator adds to the
+// pod for the AuthProxyWorkload r, or nil if there is none.
+func (p *WorkloadPreview) ProxyContainer(r *cloudsqlapi.AuthProxyWorkload) *corev1.Container {
+	if p.ConfiguredPod == nil {
+		return nil
+	}
+	name := workload.ContainerName(r)
+	for _, cs := range [][]corev1.Container{p.ConfiguredPod.Spec.Containers, p.ConfiguredPod.Spec.InitContainers} {
+		for i := range cs {
+			if cs[i].Name == name {
+				return cs[i].DeepCopy()
+			}
+		}
+	}
+	return nil
+}
+
+// PodAnnotationValue returns the value of the pod annotation of the
+// AuthProxyWorkload r on the configured pod, the hash of the proxy
+// configuration for r. It is empty when the operator does not add the proxy
+// for r to the pod.
+func (p *WorkloadPreview) PodAnnotationValue(r *cloudsqlapi.AuthProxyWorkload) string {
+	if p.ConfiguredPod == nil || p.ExcludedReason != "" {
+		return ""
+	}
+	return p.ConfiguredPod.Annotations[workload.PodAnnotationKey(r)]
+}
+
+// InstancePorts returns the ports that the proxy listens on for the instances
+// of the AuthProxyWorkload r, in the order of r's instances.
+func (p *WorkloadPreview) InstancePorts(r *cloudsqlapi.AuthProxyWorkload) []cloudsqlapi.InstancePort {
+	if p.ConfiguredPod == nil {
+		return nil
+	}
+	ports := workload.PortAllocationFromAnnotations(p.ConfiguredPod.Annotations)
+	var l []cloudsqlapi.InstancePort
+	for _, is := range r.Spec.Instances {
+		if port, ok := ports.Port(r, is.ConnectionString); ok {
+			l = append(l, cloudsqlapi.InstancePort{ConnectionString: is.ConnectionString, Port: port})
+		}
+	}
+	return l
+}
+
+// PreviewWorkload renders the pods of the workload wl with the
+// AuthProxyWorkloads in c that match it, the same way the pod webhook does.
+// It does not change anything in c.
+func PreviewWorkload(ctx context.Context, c client.Reader, u *workload.Updater, wl workload.Workload) (*WorkloadPreview, error) {
+	pod := workload.TemplatePod(wl)
+	p := &WorkloadPreview{
+		Workload: wl,
+		Pod:      pod.Pod.DeepCopy(),
+	}
+
+	owners, err := templatePodOwners(ctx, c, wl)
+	if err != nil {
+		return nil, err
+	}
+
+	proxies, err := findMatchingProxies(ctx, c, u, pod, owners...)
+	if err != nil {
+		return nil, err
+	}
+	p.AuthProxyWorkloads = proxies
+	if len(proxies) == 0 {
+		p.ConfiguredPod = pod.Pod
+		return p, nil
+	}
+
+	p.ExcludedReason, p.ExcludedMessage, err = injectionDisabled(ctx, c, u, wl)
+	if err != nil {
+		return nil, err
+	}
+	if p.ExcludedReason != "" {
+		p.ConfiguredPod = pod.Pod
+		return p, nil
+	}
+
+	secrets, err := loadSecretVersions(ctx, c, pod.Pod.Namespace, proxies)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range proxies {
+		name := workload.CredentialsFileSecretName(r)
+		if _, ok := secrets[name]; name != "" && !ok {
+			p.MissingSecrets = append(p.MissingSecrets, name)
+		}
+	}
+
+	err = u.ConfigureWorkload(pod, proxies, secrets)
+	var cfgErr *workload.ConfigError
+	if errors.As(err, &cfgErr) {
+		p.Errors = cfgErr.DetailedErrors()
+		return p, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to configure workload %s/%s, %v",
+			wl.Object().GetNamespace(), wl.Object().GetName(), err)
+	}
+	p.ConfiguredPod = pod.Pod
+	return p, nil
+}
+
+// templatePodOwners returns the owners of a pod created from the pod template
+// of wl. The pod does not exist yet, so it has no owners. The workload and its
+// owners would be the owners of the pod.
+func templatePodOwners(ctx context.Context, c client.Reader, wl workload.Workload) ([]workload.Workload, error) {
+	if _, isPod := wl.(*workload.PodWorkload); isPod {
+		return nil, nil
+	}
+	owners, err := listOwners(ctx, c, wl.Object())
+	if err != nil {
+		return nil, err
+	}
+	return append([]workload.Workload{wl}, owners...), nil
+}
@@ -26,9 +26,10 @@ import (
 // upgradeDefaultProxyOnStartup is a LeaderElectionRunnable task that will run
 // as soon as ControllerRuntime is initialized. It will list all AuthProxyWorkload
 // resources in the cluster, and force an update on all resources with the
-// default proxy image. If the operator has a different DefaultProxyImage than
-// the one used when that AuthProxyWorkload was last reconciled, then Reconcile
-// will update the associated workloads in accordance with the RolloutStrategy.
+// default proxy image. If the operator has a different DefaultProxyImage, or
+// DefaultAlloyDBProxyImage for an AlloyDB proxy, than the one used when that
+// AuthProxyWorkload was last reconciled, then Reconcile will update the
+// associated workloads in accordance with the RolloutStrategy.
 type upgradeDefaultProxyOnStartup struct {
 	c client.Client
 }
@@ -64,12 +65,35 @@ func (c *upgradeDefaultProxyOnStartup) Start(ctx context.Context) error {
 				err = c.c.Update(ctx, &p)
 			}
 			if l.Continue == "" {
-				return nil
+				return c.upgradeClusterAuthProxyWorkloads(ctx)
 			}
 		}
 	}
 }
 
+// upgradeClusterAuthProxyWorkloads triggers the update on the
+// ClusterAuthProxyWorkload resources with a default proxy image.
+func (c *upgradeDefaultProxyOnStartup) upgradeClusterAuthProxyWorkloads(ctx context.Context) error {
+	l := &cloudsqlapi.ClusterAuthProxyWorkloadList{}
+	err := c.c.List(ctx, l)
+	if err != nil {
+		return fmt.Errorf("can't list ClusterAuthProxyWorkload on startup, %v", err)
+	}
+	for _, p := range l.Items {
+		if p.Spec.AuthProxyContainer != nil && p.Spec.AuthProxyContainer.Image != "" {
+			continue
+		}
+		log.FromContext(ctx).Info(fmt.Sprintf("Upgrading workload default images for %s", p.Name))
+		err = c.c.Update(ctx, &p)
+		if err != nil {
+			// Like the AuthProxyWorkloads above, a resource that can't be
+			// updated must not stop the upgrade of the others.
+			log.FromContext(ctx).Error(err, fmt.Sprintf("Unable to upgrade workload default images for %s", p.Name))
+		}
+	}
+	return nil
+}
+
 func (c *upgradeDefaultProxyOnStartup) NeedLeaderElection() bool {
 	return true // only run on the leader
 }
@@ -0,0 +1,131 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
+	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/workload"
+)
+
+// restartWorkloads restarts the pods of the matching workloads once when the
+// RestartRolloutAnnotation has a new value, the way `kubectl rollout restart`
+// does. It sets the value in the RestartAnnotationKey annotation of the
+// workload's pod template, together with the current proxy configuration.
+// The restarts count towards the operator's MaxConcurrentRollouts limit.
+//
+// When all the workloads were restarted, the value is recorded in
+// Status.Restarted, so that the workloads that match later are not
+// restarted. It returns the number of workloads restarted by this call.
+func (r *AuthProxyWorkloadReconciler) restartWorkloads(ctx context.Context, resource *cloudsqlapi.AuthProxyWorkload, workloads []workload.Workload) (int, error) {
+	v, ok := resource.GetAnnotations()[cloudsqlapi.RestartRolloutAnnotation]
+	if !ok || v == resource.Status.Restarted {
+		return 0, nil
+	}
+
+	l := log.FromContext(ctx)
+	var pending, restarted int
+	for _, wl := range workloads {
+		// The new pods of a workload with configuration errors would be
+		// rejected.
+		if !needsRestart(resource, wl) || hasConfigError(resource, wl) || isExcluded(resource, wl) {
+			continue
+		}
+		pending++
+		if !r.startRollout(resource, wl) {
+			continue
+		}
+
+		err := r.patchWorkloadRestart(ctx, resource, wl, v)
+		if err != nil {
+			return 0, fmt.Errorf("unable to restart workload %s/%s: %v",
+				wl.Object().GetNamespace(), wl.Object().GetName(), err)
+		}
+		l.Info("Restarted workload pods",
+			"AuthProxyWorkload", proxyDisplayName(resource.Namespace, resource.Name),
+			"Workload", workloadDisplayName(wl), "Restart", v)
+		restarted++
+	}
+
+	if pending == 0 {
+		resource.Status.Restarted = v
+	}
+	return restarted, nil
+}
+
+// patchWorkloadRestart sets the restart value v and the current proxy
+// configuration on the workload's pod template.
+func (r *AuthProxyWorkloadReconciler) patchWorkloadRestart(ctx context.Context, resource *cloudsqlapi.AuthProxyWorkload, wl workload.Workload, v string) error {
+	mpt, ok := wl.(workload.WithMutablePodTemplate)
+	if !ok {
+		return nil
+	}
+	pv, err := PreviewWorkload(ctx, r.Client, r.updater, wl)
+	if err != nil {
+		return err
+	}
+	hash := podAnnotationValue(resource, findStatus(resource.Status.WorkloadStatus, newStatus(wl)))
+
+	_, err = controllerutil.CreateOrPatch(ctx, r.Client, wl.Object(), func() error {
+		an := wl.PodTemplateAnnotations()
+		if an == nil {
+			an = make(map[string]string)
+		}
+		an[workload.RestartAnnotationKey(resource)] = v
+		if hash != "" {
+			an[workload.PodAnnotationKey(resource)] = hash
+		}
+		mpt.SetPodTemplateAnnotations(an)
+		updatePortsAnnotation(wl, pv)
+		return nil
+	})
+	return err
+}
+
+// needsRestart returns true when the RestartRolloutAnnotation requests a
+// restart of the workload's pods that was not done yet.
+func needsRestart(resource *cloudsqlapi.AuthProxyWorkload, wl workload.Workload) bool {
+	if _, ok := wl.(workload.WithMutablePodTemplate); !ok {
+		return false
+	}
+	if !resource.GetDeletionTimestamp().IsZero() {
+		return false
+	}
+	v, ok := resource.GetAnnotations()[cloudsqlapi.RestartRolloutAnnotation]
+	if !ok || v == resource.Status.Restarted {
+		return false
+	}
+	return wl.PodTemplateAnnotations()[workload.RestartAnnotationKey(resource)] != v
+}
+
+// outOfDateWorkloads returns the workloads whose pods don't run the current
+// proxy configuration because the RolloutStrategy is None, as
+// `Kind namespace/name`.
+func outOfDateWorkloads(resource *cloudsqlapi.AuthProxyWorkload) []string {
+	var names []string
+	for _, s := range resource.Status.WorkloadStatus {
+		c := findCondition(s.Conditions, cloudsqlapi.ConditionWorkloadUpToDate)
+		if c != nil && c.Status == metav1.ConditionFalse && c.Reason == cloudsqlapi.ReasonOutOfDate {
+			names = append(names, statusDisplayName(s))
+		}
+	}
+	return names
+}
@@ -0,0 +1,288 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
+	"github.com/go-logr/logr"
+)
+
+// defaultRevisionHistoryLimit is the number of previous revisions kept when
+// the resource's RevisionHistoryLimit is not set.
+const defaultRevisionHistoryLimit = 10
+
+// recordRevision records the spec of the resource in a ControllerRevision
+// owned by the resource, and deletes the oldest revisions beyond the
+// resource's RevisionHistoryLimit. When the spec is the same as a previous
+// revision, that revision gets a new number instead, the way a Deployment
+// reuses its ReplicaSets. It returns the number of the revision of the
+// current spec.
+//
+// A ClusterAuthProxyWorkload does not keep a revision history, because the
+// ControllerRevisions are namespaced. It returns 0 for those.
+func (r *AuthProxyWorkloadReconciler) recordRevision(ctx context.Context, resource *cloudsqlapi.AuthProxyWorkload) (int64, error) {
+	if resource.IsClusterScoped() {
+		return 0, nil
+	}
+	data, err := revisionData(&resource.Spec)
+	if err != nil {
+		return 0, err
+	}
+	revs, err := r.listRevisions(ctx, r.Client, resource)
+	if err != nil {
+		return 0, err
+	}
+	if lastRevision(revs) < resource.Status.Revision {
+		// The cache does not have the revision recorded by the previous
+		// reconcile yet. Read the revisions from the API server, so that the
+		// next revision number is larger than all of them.
+		revs, err = r.listRevisions(ctx, r.apiReader, resource)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	name := revisionName(resource, data)
+	i := slices.IndexFunc(revs, func(rev appsv1.ControllerRevision) bool {
+		return rev.Name == name
+	})
+	last := lastRevision(revs)
+
+	switch {
+	case i < 0:
+		rev := &appsv1.ControllerRevision{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: resource.Namespace,
+				Name:      name,
+				Labels:    map[string]string{cloudsqlapi.RevisionOwnerLabel: string(resource.UID)},
+			},
+			Data:     runtime.RawExtension{Raw: data},
+			Revision: last + 1,
+		}
+		err = controllerutil.SetControllerReference(resource, rev, r.Client.Scheme())
+		if err != nil {
+			return 0, err
+		}
+		err = r.Create(ctx, rev)
+		switch {
+		case apierrors.IsAlreadyExists(err):
+			// The revision was created after the revisions were listed, by
+			// an earlier reconcile. Use that revision.
+			err = r.apiReader.Get(ctx, client.ObjectKeyFromObject(rev), rev)
+			if err != nil {
+				return 0, fmt.Errorf("unable to get revision %s/%s: %v", rev.Namespace, rev.Name, err)
+			}
+			if !metav1.IsControlledBy(rev, resource) {
+				return 0, fmt.Errorf("revision %s/%s is not owned by %s", rev.Namespace, rev.Name,
+					proxyDisplayName(resource.Namespace, resource.Name))
+			}
+		case err != nil:
+			return 0, fmt.Errorf("unable to create revision %s/%s: %v", rev.Namespace, rev.Name, err)
+		default:
+			log.FromContext(ctx).Info("Recorded AuthProxyWorkload revision",
+				"AuthProxyWorkload", proxyDisplayName(resource.Namespace, resource.Name),
+				"ControllerRevision", rev.Name, "Revision", rev.Revision)
+		}
+		revs = append(revs, *rev)
+
+	case revs[i].Revision != last:
+		// The spec was changed back to a previous revision, for example by a
+		// rollback. Make that revision the newest.
+		rev := revs[i].DeepCopy()
+		rev.Revision = last + 1
+		err = r.Update(ctx, rev)
+		if err != nil {
+			return 0, fmt.Errorf("unable to update revision %s/%s: %v", rev.Namespace, rev.Name, err)
+		}
+		revs = append(slices.Delete(revs, i, i+1), *rev)
+	}
+
+	current := revs[len(revs)-1].Revision
+	return current, r.pruneRevisions(ctx, resource, revs[:len(revs)-1])
+}
+
+// pruneRevisions deletes the oldest of the previous revisions old, so that
+// no more than the resource's RevisionHistoryLimit remain.
+func (r *AuthProxyWorkloadReconciler) pruneRevisions(ctx context.Context, resource *cloudsqlapi.AuthProxyWorkload, old []appsv1.ControllerRevision) error {
+	for i := 0; i < len(old)-revisionHistoryLimit(resource); i++ {
+		err := r.Delete(ctx, &old[i])
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("unable to delete revision %s/%s: %v", old[i].Namespace, old[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// listRevisions returns the ControllerRevisions owned by the resource, oldest
+// first, read with c.
+func (r *AuthProxyWorkloadReconciler) listRevisions(ctx context.Context, c client.Reader, resource *cloudsqlapi.AuthProxyWorkload) ([]appsv1.ControllerRevision, error) {
+	l := &appsv1.ControllerRevisionList{}
+	err := c.List(ctx, l, client.InNamespace(resource.Namespace),
+		client.MatchingLabels{cloudsqlapi.RevisionOwnerLabel: string(resource.UID)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list revisions of %s: %v", proxyDisplayName(resource.Namespace, resource.Name), err)
+	}
+
+	revs := slices.DeleteFunc(l.Items, func(rev appsv1.ControllerRevision) bool {
+		return !metav1.IsControlledBy(&rev, resource)
+	})
+	sort.Slice(revs, func(i, j int) bool {
+		return revs[i].Revision < revs[j].Revision
+	})
+	return revs, nil
+}
+
+// lastRevision returns the number of the newest of revs, or 0 when revs is
+// empty.
+func lastRevision(revs []appsv1.ControllerRevision) int64 {
+	if len(revs) == 0 {
+		return 0
+	}
+	return revs[len(revs)-1].Revision
+}
+
+// rollback restores the spec of the revision requested by the
+// RollbackAnnotation, removes the annotation and saves the resource. The
+// next reconcile records the restored spec as the newest revision and rolls
+// it out. When the revision can't be restored, a warning Event explains why,
+// and the annotation is removed too.
+func (r *AuthProxyWorkloadReconciler) rollback(ctx context.Context, l logr.Logger, resource *cloudsqlapi.AuthProxyWorkload) (ctrl.Result, error) {
+	v := resource.GetAnnotations()[cloudsqlapi.RollbackAnnotation]
+
+	var revs []appsv1.ControllerRevision
+	if !resource.IsClusterScoped() {
+		var err error
+		revs, err = r.listRevisions(ctx, r.Client, resource)
+		if err != nil {
+			return requeueWithDelay, err
+		}
+	}
+
+	rev, err := rollbackRevision(resource, revs, v)
+	if err == nil {
+		err = restoreRevision(resource, rev)
+	}
+	if err != nil {
+		r.recorder.Eventf(eventObject(resource), corev1.EventTypeWarning, cloudsqlapi.ReasonRollbackFailed,
+			"Unable to roll back to revision %q: %v", v, err)
+	} else {
+		r.recorder.Eventf(eventObject(resource), corev1.EventTypeNormal, cloudsqlapi.ReasonRolledBack,
+			"Rolled back to revision %d", rev.Revision)
+		l.Info("Rolled back AuthProxyWorkload",
+			"AuthProxyWorkload", proxyDisplayName(resource.Namespace, resource.Name),
+			"Revision", rev.Revision)
+	}
+
+	delete(resource.Annotations, cloudsqlapi.RollbackAnnotation)
+	if err := r.updateResource(ctx, resource); err != nil {
+		return requeueNow, err
+	}
+	return requeueNow, nil
+}
+
+// rollbackRevision finds the revision for the RollbackAnnotation value v
+// among the resource's revisions revs. The value 0 selects the newest
+// revision that does not have the current spec.
+func rollbackRevision(resource *cloudsqlapi.AuthProxyWorkload, revs []appsv1.ControllerRevision, v string) (*appsv1.ControllerRevision, error) {
+	if resource.IsClusterScoped() {
+		return nil, fmt.Errorf("a ClusterAuthProxyWorkload does not keep a revision history")
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("got %q, want a revision number", v)
+	}
+
+	if n == 0 {
+		data, err := revisionData(&resource.Spec)
+		if err != nil {
+			return nil, err
+		}
+		current := revisionName(resource, data)
+		for i := len(revs) - 1; i >= 0; i-- {
+			if revs[i].Name != current {
+				return &revs[i], nil
+			}
+		}
+		return nil, fmt.Errorf("no previous revision found")
+	}
+
+	for i := range revs {
+		if revs[i].Revision == n {
+			return &revs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("revision %d not found", n)
+}
+
+// restoreRevision replaces the spec of the resource with the spec recorded
+// in rev. The resource keeps its current RevisionHistoryLimit.
+func restoreRevision(resource *cloudsqlapi.AuthProxyWorkload, rev *appsv1.ControllerRevision) error {
+	spec := cloudsqlapi.AuthProxyWorkloadSpec{}
+	if err := json.Unmarshal(rev.Data.Raw, &spec); err != nil {
+		return fmt.Errorf("unable to read revision %d: %v", rev.Revision, err)
+	}
+	spec.RevisionHistoryLimit = resource.Spec.RevisionHistoryLimit
+	resource.Spec = spec
+	return nil
+}
+
+// revisionData returns the data recorded in a revision for spec. The
+// RevisionHistoryLimit is not part of the revision, so that changing it
+// does not create a new revision.
+func revisionData(spec *cloudsqlapi.AuthProxyWorkloadSpec) ([]byte, error) {
+	s := spec.DeepCopy()
+	s.RevisionHistoryLimit = nil
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal AuthProxyWorkload spec, %v", err)
+	}
+	return b, nil
+}
+
+// revisionName returns the name of the ControllerRevision of resource that
+// records data. Like the revisions of a StatefulSet, the name ends with a
+// hash of the data, so that the same spec always has the same revision.
+func revisionName(resource *cloudsqlapi.AuthProxyWorkload, data []byte) string {
+	h := fnv.New32a()
+	h.Write(data)
+	return fmt.Sprintf("%s-%x", resource.Name, h.Sum32())
+}
+
+// revisionHistoryLimit returns the number of previous revisions to keep for
+// the resource.
+func revisionHistoryLimit(resource *cloudsqlapi.AuthProxyWorkload) int {
+	if resource.Spec.RevisionHistoryLimit == nil {
+		return defaultRevisionHistoryLimit
+	}
+	return int(*resource.Spec.RevisionHistoryLimit)
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"slices"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
+	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/workload"
+)
+
+// rolloutKey identifies the rollout of an AuthProxyWorkload's configuration
+// to a workload.
+type rolloutKey struct {
+	proxy                 types.NamespacedName
+	kind, namespace, name string
+}
+
+// newRolloutKey returns the rolloutKey for resource and wl.
+func newRolloutKey(resource *cloudsqlapi.AuthProxyWorkload, wl workload.Workload) rolloutKey {
+	s := newStatus(wl)
+	return rolloutKey{
+		proxy:     types.NamespacedName{Namespace: resource.Namespace, Name: resource.Name},
+		kind:      s.Kind,
+		namespace: s.Namespace,
+		name:      s.Name,
+	}
+}
+
+// rolloutLimiter limits the number of workloads that roll out a new proxy
+// configuration at the same time, across all AuthProxyWorkloads, so that the
+// new proxies don't all request certificates from the Admin API together.
+// Workloads that can't start rolling out wait in a queue in the order they
+// asked. The zero value is ready to use.
+//
+// The state is kept in memory. After the operator restarts, the workloads
+// that are still rolling out are tracked again as they are reconciled.
+type rolloutLimiter struct {
+	mu      sync.Mutex
+	rolling map[rolloutKey]bool
+	queue   []rolloutKey
+}
+
+// acquire returns true when the rollout k may start, because fewer than max
+// rollouts are in progress and no rollout queued earlier is waiting for the
+// free slots. Otherwise k is queued and acquire returns its position in the
+// queue, starting at 1. When max is 0, there is no limit.
+func (l *rolloutLimiter) acquire(k rolloutKey, max int) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rolling[k] {
+		return true, 0
+	}
+
+	i := slices.Index(l.queue, k)
+	if i < 0 {
+		l.queue = append(l.queue, k)
+		i = len(l.queue) - 1
+	}
+	if max > 0 && i >= max-len(l.rolling) {
+		return false, i + 1
+	}
+
+	l.queue = slices.Delete(l.queue, i, i+1)
+	l.setRolling(k)
+	return true, 0
+}
+
+// track records that the rollout k is in progress, even when that exceeds
+// the limit.
+func (l *rolloutLimiter) track(k rolloutKey) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if i := slices.Index(l.queue, k); i >= 0 {
+		l.queue = slices.Delete(l.queue, i, i+1)
+	}
+	l.setRolling(k)
+}
+
+// release removes the rollout k, freeing its slot or its place in the queue.
+func (l *rolloutLimiter) release(k rolloutKey) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.rolling, k)
+	if i := slices.Index(l.queue, k); i >= 0 {
+		l.queue = slices.Delete(l.queue, i, i+1)
+	}
+}
+
+// releaseProxy removes all the rollouts of the AuthProxyWorkload proxy.
+func (l *rolloutLimiter) releaseProxy(proxy types.NamespacedName) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k := range l.rolling {
+		if k.proxy == proxy {
+			delete(l.rolling, k)
+		}
+	}
+	l.queue = slices.DeleteFunc(l.queue, func(k rolloutKey) bool {
+		return k.proxy == proxy
+	})
+}
+
+func (l *rolloutLimiter) setRolling(k rolloutKey) {
+	if l.rolling == nil {
+		l.rolling = map[rolloutKey]bool{}
+	}
+	l.rolling[k] = true
+}
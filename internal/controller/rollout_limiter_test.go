@@ -0,0 +1,63 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestRolloutLimiter(t *testing.T) {
+	p1 := types.NamespacedName{Namespace: "default", Name: "p1"}
+	p2 := types.NamespacedName{Namespace: "default", Name: "p2"}
+	key := func(proxy types.NamespacedName, name string) rolloutKey {
+		return rolloutKey{proxy: proxy, kind: "Deployment", namespace: "default", name: name}
+	}
+	var l rolloutLimiter
+	wantAcquire := func(k rolloutKey, max int, want bool, wantPos int) {
+		t.Helper()
+		got, pos := l.acquire(k, max)
+		if got != want || pos != wantPos {
+			t.Errorf("got acquire %s %v at %d, want %v at %d", k.name, got, pos, want, wantPos)
+		}
+	}
+
+	wantAcquire(key(p1, "a"), 2, true, 0)
+	wantAcquire(key(p1, "b"), 2, true, 0)
+	wantAcquire(key(p1, "c"), 2, false, 1)
+	wantAcquire(key(p2, "d"), 2, false, 2)
+
+	// A rollout in progress may ask again.
+	wantAcquire(key(p1, "a"), 2, true, 0)
+
+	// The free slot goes to the first rollout in the queue.
+	l.release(key(p1, "a"))
+	wantAcquire(key(p2, "d"), 2, false, 2)
+	wantAcquire(key(p1, "c"), 2, true, 0)
+	wantAcquire(key(p2, "d"), 2, false, 1)
+
+	// Rollouts tracked after a restart count toward the limit.
+	l.track(key(p2, "e"))
+	l.release(key(p1, "b"))
+	wantAcquire(key(p2, "d"), 2, false, 1)
+
+	// Deleting an AuthProxyWorkload frees its slots.
+	l.releaseProxy(p1)
+	wantAcquire(key(p2, "d"), 2, true, 0)
+
+	// No limit.
+	wantAcquire(key(p2, "f"), 0, true, 0)
+}
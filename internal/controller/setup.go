@@ -18,6 +18,7 @@ import (
 	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
 	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/workload"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -39,13 +40,17 @@ func InitScheme(scheme *runtime.Scheme) {
 
 // SetupManagers was moved out of ../main.go here so that it can be invoked
 // from the testintegration tests AND from the actual operator.
-func SetupManagers(mgr manager.Manager, userAgent, defaultProxyImage string) error {
-	u := workload.NewUpdater(userAgent, defaultProxyImage)
+//
+// The defaults configure the proxy containers. When configMap is set, the
+// operator applies the values in that ConfigMap on top of the defaults, and
+// watches it for changes.
+func SetupManagers(mgr manager.Manager, userAgent string, defaults workload.Config, configMap types.NamespacedName) error {
+	u := workload.NewUpdaterWithConfig(userAgent, defaults)
 
 	setupLog.Info("Configuring reconcilers...")
 	var err error
 
-	_, err = NewAuthProxyWorkloadReconciler(mgr, u)
+	r, err := NewAuthProxyWorkloadReconciler(mgr, u)
 	if err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "AuthProxyWorkload")
 		// Kubebuilder won't properly write the contents of this file. It will want
@@ -55,13 +60,31 @@ func SetupManagers(mgr manager.Manager, userAgent, defaultProxyImage string) err
 		return err
 	}
 
+	if configMap.Name != "" {
+		_, err = newConfigMapController(mgr, u, defaults, configMap, r)
+		if err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "operator-config")
+			return err
+		}
+	}
+
+	// The validating webhooks check new AuthProxyWorkloads against the other
+	// AuthProxyWorkloads that match the same workloads.
+	v := &authProxyWorkloadValidator{r: r}
 	wh := &cloudsqlapi.AuthProxyWorkload{}
-	err = wh.SetupWebhookWithManager(mgr)
+	err = wh.SetupWebhookWithManager(mgr, v)
 	if err != nil {
 		setupLog.Error(err, "unable to create webhook", "webhook", "AuthProxyWorkload")
 		return err
 	}
 
+	cwh := &cloudsqlapi.ClusterAuthProxyWorkload{}
+	err = cwh.SetupWebhookWithManager(mgr, v)
+	if err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "ClusterAuthProxyWorkload")
+		return err
+	}
+
 	//+kubebuilder:scaffold:builder
 	// kubebuilder to scaffold additional controller here.
 	// When kubebuilder scaffolds a new controller here, please
@@ -0,0 +1,182 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
+	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/workload"
+)
+
+// updateWorkloadBatch rolls out the proxy configuration to the workloads in
+// batches when the RolloutStrategy is Staged. The next batch is updated when
+// all the workloads updated so far are available. When an updated workload
+// fails, the rollout is paused until it is resumed with the
+// ResumeRolloutAnnotation. The progress is recorded in the resource's
+// Status.Rollout.
+//
+// It returns the number of workloads that still needed an update before this
+// batch was updated.
+func (r *AuthProxyWorkloadReconciler) updateWorkloadBatch(ctx context.Context, resource *cloudsqlapi.AuthProxyWorkload, workloads []workload.Workload) (int, error) {
+	rs := rolloutStatus(resource)
+
+	var pending []workload.Workload
+	var pendingValues []string
+	var updated, available, inFlight int
+	var failed []string
+	for _, wl := range sortWorkloads(workloads) {
+		// Workloads that can't be updated are not part of the rollout.
+		if _, ok := wl.(workload.WithMutablePodTemplate); !ok {
+			continue
+		}
+		if hasConfigError(resource, wl) || isExcluded(resource, wl) {
+			continue
+		}
+
+		s := findStatus(resource.Status.WorkloadStatus, newStatus(wl))
+		if v := podAnnotationValue(resource, s); r.needsAnnotationUpdate(wl, resource, v) {
+			pending = append(pending, wl)
+			pendingValues = append(pendingValues, v)
+			continue
+		}
+
+		updated++
+		name := workloadDisplayName(wl)
+		var c *metav1.Condition
+		if s != nil {
+			c = findCondition(s.Conditions, cloudsqlapi.ConditionWorkloadUpToDate)
+		}
+		switch {
+		case slices.Contains(rs.FailedWorkloads, name):
+			// The rollout was resumed after this workload failed. Don't wait
+			// for it.
+		case c != nil && c.Reason == cloudsqlapi.ReasonRolloutFailed:
+			failed = append(failed, name)
+		case c != nil && c.Status == metav1.ConditionTrue:
+			available++
+		default:
+			inFlight++
+		}
+	}
+
+	batchSize := stagedBatchSize(resource, updated+len(pending))
+	if len(failed) > 0 {
+		rs.Paused = true
+		rs.FailedWorkloads = append(rs.FailedWorkloads, failed...)
+		rs.Message = fmt.Sprintf("Paused because %d workloads failed: %s. Set the %s annotation to a new value to resume",
+			len(failed), strings.Join(failed, ", "), cloudsqlapi.ResumeRolloutAnnotation)
+	}
+
+	// Update the next batch when the workloads updated so far are available.
+	toUpdate := pending
+	if len(toUpdate) > batchSize {
+		toUpdate = toUpdate[:batchSize]
+	}
+	if rs.Paused || inFlight > 0 {
+		toUpdate = nil
+	}
+	var started int
+	for i, wl := range toUpdate {
+		if !r.startRollout(resource, wl) {
+			continue
+		}
+		err := r.patchWorkloadAnnotations(ctx, resource, wl, pendingValues[i])
+		if err != nil {
+			return 0, fmt.Errorf("reconciled %d matching workloads. Error updating workload %v: %v", len(workloads), wl.Object().GetName(), err)
+		}
+		started++
+	}
+
+	rs.BatchSize = int32(batchSize)
+	rs.TotalWorkloads = int32(updated + len(pending))
+	rs.UpdatedWorkloads = int32(updated + started)
+	rs.AvailableWorkloads = int32(available)
+	resource.Status.Rollout = rs
+
+	return len(pending), nil
+}
+
+// rolloutStatus returns the resource's RolloutStatus for the current proxy
+// configuration. A new rollout starts when the ConfigGeneration changes. A
+// paused rollout is resumed when the ResumeRolloutAnnotation has a new value.
+func rolloutStatus(resource *cloudsqlapi.AuthProxyWorkload) *cloudsqlapi.RolloutStatus {
+	rs := &cloudsqlapi.RolloutStatus{}
+	if resource.Status.Rollout != nil {
+		rs = resource.Status.Rollout.DeepCopy()
+	}
+	if rs.ConfigGeneration != resource.Status.ConfigGeneration {
+		rs = &cloudsqlapi.RolloutStatus{
+			ConfigGeneration: resource.Status.ConfigGeneration,
+			Resumed:          rs.Resumed,
+		}
+	}
+	if v, ok := resource.GetAnnotations()[cloudsqlapi.ResumeRolloutAnnotation]; ok && v != rs.Resumed {
+		rs.Resumed = v
+		rs.Paused = false
+		rs.Message = ""
+	}
+	return rs
+}
+
+// stagedBatchSize returns the number of workloads to update in each batch
+// out of total workloads.
+func stagedBatchSize(resource *cloudsqlapi.AuthProxyWorkload, total int) int {
+	bs := intstr.FromInt32(1)
+	if sr := resource.Spec.AuthProxyContainer.StagedRollout; sr != nil && sr.BatchSize != nil {
+		bs = *sr.BatchSize
+	}
+	n, err := intstr.GetScaledValueFromIntOrPercent(&bs, total, true)
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+// sortWorkloads returns the workloads in order of namespace, kind and name,
+// the order in which a Staged rollout updates them.
+func sortWorkloads(workloads []workload.Workload) []workload.Workload {
+	sorted := slices.Clone(workloads)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := newStatus(sorted[i]), newStatus(sorted[j])
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	return sorted
+}
+
+// workloadDisplayName returns a name for a workload to use in messages and
+// in RolloutStatus.FailedWorkloads.
+func workloadDisplayName(wl workload.Workload) string {
+	return statusDisplayName(newStatus(wl))
+}
+
+// statusDisplayName returns the workloadDisplayName of the workload with the
+// status s.
+func statusDisplayName(s *cloudsqlapi.WorkloadStatus) string {
+	return s.Kind + " " + s.Namespace + "/" + s.Name
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
+)
+
+func TestStagedBatchSize(t *testing.T) {
+	data := []struct {
+		name      string
+		batchSize string
+		total     int
+		want      int
+	}{
+		{name: "default", total: 40, want: 1},
+		{name: "number", batchSize: "5", total: 40, want: 5},
+		{name: "percentage", batchSize: "25%", total: 40, want: 10},
+		{name: "percentage rounds up", batchSize: "10%", total: 15, want: 2},
+		{name: "percentage of few workloads", batchSize: "10%", total: 3, want: 1},
+	}
+	for _, tc := range data {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := &cloudsqlapi.StagedRolloutSpec{}
+			if tc.batchSize != "" {
+				bs := intstr.Parse(tc.batchSize)
+				spec.BatchSize = &bs
+			}
+			p := &cloudsqlapi.AuthProxyWorkload{Spec: cloudsqlapi.AuthProxyWorkloadSpec{
+				AuthProxyContainer: &cloudsqlapi.AuthProxyContainerSpec{
+					RolloutStrategy: cloudsqlapi.StagedStrategy,
+					StagedRollout:   spec,
+				},
+			}}
+			if got := stagedBatchSize(p, tc.total); got != tc.want {
+				t.Errorf("got %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
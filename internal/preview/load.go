@@ -0,0 +1,257 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
+	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/workload"
+)
+
+// objectKey identifies an object of any kind.
+type objectKey struct {
+	kind string
+	types.NamespacedName
+}
+
+// objectSet holds the objects used to render the preview. An object added
+// later replaces an object with the same kind, namespace and name.
+type objectSet struct {
+	scheme  *runtime.Scheme
+	objects map[objectKey]client.Object
+	order   []objectKey
+}
+
+func newObjectSet(scheme *runtime.Scheme) *objectSet {
+	return &objectSet{scheme: scheme, objects: map[objectKey]client.Object{}}
+}
+
+// add adds an object to the set. Its TypeMeta must be set.
+func (s *objectSet) add(o client.Object) {
+	k := objectKey{
+		kind:           o.GetObjectKind().GroupVersionKind().Kind,
+		NamespacedName: client.ObjectKeyFromObject(o),
+	}
+	if _, ok := s.objects[k]; !ok {
+		s.order = append(s.order, k)
+	}
+	s.objects[k] = o
+}
+
+// list returns the objects in the order they were first added.
+func (s *objectSet) list() []client.Object {
+	l := make([]client.Object, 0, len(s.order))
+	for _, k := range s.order {
+		l = append(l, s.objects[k])
+	}
+	return l
+}
+
+// isPreviewKind returns true for the kinds of objects used to render the
+// preview. Other objects in the manifests are ignored.
+func isPreviewKind(kind string) bool {
+	switch kind {
+	case "Namespace", "Secret", "ConfigMap", "AuthProxyWorkload", "ClusterAuthProxyWorkload":
+		return true
+	}
+	for _, k := range workload.WorkloadKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// isClusterScoped returns true for the kinds of objects without a namespace.
+func isClusterScoped(kind string) bool {
+	return kind == "Namespace" || kind == "ClusterAuthProxyWorkload"
+}
+
+// addManifests reads the YAML or JSON documents in r and adds the objects
+// used by the preview to the set. Namespaced objects without a namespace are
+// put in namespace ns.
+func (s *objectSet) addManifests(r io.Reader, ns string) error {
+	d := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		u := &unstructured.Unstructured{}
+		err := d.Decode(&u.Object)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read manifest, %v", err)
+		}
+		if len(u.Object) == 0 {
+			continue
+		}
+
+		if u.IsList() {
+			err = u.EachListItem(func(o runtime.Object) error {
+				return s.addUnstructured(o.(*unstructured.Unstructured), ns)
+			})
+		} else {
+			err = s.addUnstructured(u, ns)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *objectSet) addUnstructured(u *unstructured.Unstructured, ns string) error {
+	gvk := u.GroupVersionKind()
+	if !isPreviewKind(gvk.Kind) {
+		return nil
+	}
+	o, err := s.scheme.New(gvk)
+	if err != nil {
+		return fmt.Errorf("unable to read %s %s, %v", gvk.Kind, u.GetName(), err)
+	}
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, o)
+	if err != nil {
+		return fmt.Errorf("unable to read %s %s, %v", gvk.Kind, u.GetName(), err)
+	}
+	co := o.(client.Object)
+	co.GetObjectKind().SetGroupVersionKind(gvk)
+	if co.GetNamespace() == "" && !isClusterScoped(gvk.Kind) {
+		co.SetNamespace(ns)
+	}
+	s.add(co)
+	return nil
+}
+
+// addCluster reads the objects used by the preview from the cluster. When
+// ns is not empty, only the namespaced objects in ns are read. When
+// defaults is set, the ConfigMap with the operator defaults is also read.
+func (s *objectSet) addCluster(ctx context.Context, c client.Reader, ns string, defaults types.NamespacedName) error {
+	// Read the namespaces, so that the namespaceSelector of
+	// ClusterAuthProxyWorkloads can be applied.
+	if ns != "" {
+		n := &corev1.Namespace{}
+		err := c.Get(ctx, client.ObjectKey{Name: ns}, n)
+		if err != nil {
+			return fmt.Errorf("unable to read namespace %s, %v", ns, err)
+		}
+		s.addTyped(n)
+	} else {
+		err := s.addList(ctx, c, &corev1.NamespaceList{}, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.addList(ctx, c, &cloudsqlapi.ClusterAuthProxyWorkloadList{}, "")
+	if err != nil {
+		return err
+	}
+
+	lists := []client.ObjectList{&cloudsqlapi.AuthProxyWorkloadList{}}
+	for _, kind := range workload.WorkloadKinds {
+		wl, err := workload.WorkloadListForKind(kind)
+		if err != nil {
+			return err
+		}
+		lists = append(lists, wl.List())
+	}
+	for _, l := range lists {
+		err := s.addList(ctx, c, l, ns)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Only the metadata of the Secrets is needed, the preview does not read
+	// their data.
+	sl := &metav1.PartialObjectMetadataList{}
+	sl.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("SecretList"))
+	err = c.List(ctx, sl, client.InNamespace(ns))
+	if err != nil {
+		return fmt.Errorf("unable to list secrets, %v", err)
+	}
+	for i := range sl.Items {
+		secret := &corev1.Secret{ObjectMeta: sl.Items[i].ObjectMeta}
+		s.addTyped(secret)
+	}
+
+	if defaults.Name != "" {
+		cm := &corev1.ConfigMap{}
+		err = c.Get(ctx, defaults, cm)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("unable to read ConfigMap %v, %v", defaults, err)
+		}
+		if err == nil {
+			s.addTyped(cm)
+		}
+	}
+	return nil
+}
+
+// addList lists objects from the cluster and adds them to the set.
+func (s *objectSet) addList(ctx context.Context, c client.Reader, l client.ObjectList, ns string) error {
+	err := c.List(ctx, l, client.InNamespace(ns))
+	if err != nil {
+		return fmt.Errorf("unable to list %T, %v", l, err)
+	}
+	items, err := meta.ExtractList(l)
+	if err != nil {
+		return err
+	}
+	for _, o := range items {
+		s.addTyped(o.(client.Object))
+	}
+	return nil
+}
+
+// addTyped adds a typed object, setting its TypeMeta from the scheme.
+func (s *objectSet) addTyped(o client.Object) {
+	gvks, _, err := s.scheme.ObjectKinds(o)
+	if err == nil && len(gvks) > 0 {
+		o.GetObjectKind().SetGroupVersionKind(gvks[0])
+	}
+	s.add(o)
+}
+
+// addMissingNamespaces adds a Namespace without labels for each namespace
+// that is used by an object in the set, but is not itself in the set.
+func (s *objectSet) addMissingNamespaces() {
+	for _, o := range s.list() {
+		ns := o.GetNamespace()
+		if ns == "" {
+			continue
+		}
+		k := objectKey{kind: "Namespace", NamespacedName: types.NamespacedName{Name: ns}}
+		if _, ok := s.objects[k]; ok {
+			continue
+		}
+		n := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}}
+		n.SetGroupVersionKind(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"})
+		s.add(n)
+	}
+}
@@ -0,0 +1,428 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package preview implements the `preview` command of the operator binary. It
+// shows which workloads match the AuthProxyWorkloads, and how the operator
+// would configure their pods, without changing anything in the cluster.
+package preview
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/controller"
+	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/workload"
+)
+
+// Output formats supported by Options.Output.
+const (
+	OutputDiff = "diff"
+	OutputJSON = "json"
+)
+
+// ErrConfigProblems is returned by Run when the preview found configuration
+// errors or missing Secrets in at least one workload.
+var ErrConfigProblems = errors.New("the preview found configuration problems")
+
+// Options configure the preview.
+type Options struct {
+	// Files are the manifest files to read. "-" reads from stdin.
+	Files []string
+
+	// Cluster reads the existing resources from the cluster. The resources in
+	// Files take precedence over the ones in the cluster.
+	Cluster bool
+
+	// Namespace is the namespace of resources in Files that don't have one.
+	// With Cluster, only namespaced resources in this namespace are read.
+	Namespace string
+
+	// DefaultsConfigMap is the namespace and name of the ConfigMap holding the
+	// operator defaults, as set with the operator's --defaults-config-map flag.
+	DefaultsConfigMap types.NamespacedName
+
+	// Output is the output format, OutputDiff or OutputJSON.
+	Output string
+}
+
+// Result shows how the operator configures the pods of one workload.
+type Result struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+
+	// AuthProxyWorkloads are the namespace/name of the matching
+	// AuthProxyWorkloads. ClusterAuthProxyWorkloads have no namespace.
+	AuthProxyWorkloads []string `json:"authProxyWorkloads"`
+
+	// ProxyContainers are the proxy containers added to the pods.
+	ProxyContainers []corev1.Container `json:"proxyContainers,omitempty"`
+
+	// Errors are the configuration errors that prevent the pods from being
+	// created.
+	Errors []ErrorDetail `json:"errors,omitempty"`
+
+	// MissingSecrets are the credentials file Secrets that don't exist.
+	MissingSecrets []string `json:"missingSecrets,omitempty"`
+
+	// Excluded describes why the operator does not add the proxy to the pods
+	// of this workload, when the workload is excluded from proxy injection.
+	Excluded string `json:"excluded,omitempty"`
+
+	// Diff is the unified diff between the workload's pod template and the
+	// pods configured by the operator.
+	Diff string `json:"diff,omitempty"`
+}
+
+// ErrorDetail describes a workload.ConfigErrorDetail.
+type ErrorDetail struct {
+	ErrorCode         string `json:"errorCode"`
+	Description       string `json:"description"`
+	AuthProxyWorkload string `json:"authProxyWorkload"`
+}
+
+// Main runs the preview command with the command line arguments args and
+// returns the exit code.
+func Main(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("preview", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: manager preview [flags]\n\n"+
+			"Shows the workloads that match the AuthProxyWorkloads and the changes the\n"+
+			"operator makes to their pods, without changing anything.\n\n"+
+			"Exits with status 2 when a workload has configuration errors.\n\n")
+		fs.PrintDefaults()
+	}
+
+	var opts Options
+	var defaults string
+	fs.Func("f", "A manifest file with AuthProxyWorkloads, workloads, and other resources. \"-\" reads stdin. May be repeated.", func(v string) error {
+		opts.Files = append(opts.Files, v)
+		return nil
+	})
+	fs.BoolVar(&opts.Cluster, "cluster", false, "Read the existing resources from the cluster in the kubeconfig. Resources in the manifests take precedence.")
+	fs.StringVar(&opts.Namespace, "namespace", "", "The namespace of manifest resources that don't have one, and the namespace read from the cluster. Defaults to all namespaces in the cluster, and \"default\" for manifests.")
+	fs.StringVar(&defaults, "defaults-config-map", "", "The namespace/name of the ConfigMap with the operator defaults, in the manifests or the cluster.")
+	fs.StringVar(&opts.Output, "o", OutputDiff, "The output format: diff or json.")
+	err := fs.Parse(args)
+	if err != nil {
+		return 1
+	}
+
+	if defaults != "" {
+		ns, name, ok := strings.Cut(defaults, "/")
+		if !ok || ns == "" || name == "" {
+			fmt.Fprintf(stderr, "invalid -defaults-config-map %q, want namespace/name\n", defaults)
+			return 1
+		}
+		opts.DefaultsConfigMap = types.NamespacedName{Namespace: ns, Name: name}
+	}
+	if len(opts.Files) == 0 && !opts.Cluster {
+		fmt.Fprintln(stderr, "set -f or -cluster")
+		fs.Usage()
+		return 1
+	}
+
+	scheme := runtime.NewScheme()
+	controller.InitScheme(scheme)
+
+	var c client.Reader
+	if opts.Cluster {
+		cfg, err := ctrl.GetConfig()
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		c, err = client.New(cfg, client.Options{Scheme: scheme})
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+
+	err = Run(context.Background(), opts, scheme, c, stdout)
+	if errors.Is(err, ErrConfigProblems) {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// Run renders the preview and writes it to w. When opts.Cluster is set, the
+// existing resources are read from cluster. Run returns ErrConfigProblems
+// after writing the preview when a workload has configuration errors.
+func Run(ctx context.Context, opts Options, scheme *runtime.Scheme, cluster client.Reader, w io.Writer) error {
+	objs := newObjectSet(scheme)
+	if opts.Cluster {
+		err := objs.addCluster(ctx, cluster, opts.Namespace, opts.DefaultsConfigMap)
+		if err != nil {
+			return err
+		}
+	}
+
+	ns := opts.Namespace
+	if ns == "" {
+		ns = "default"
+	}
+	for _, f := range opts.Files {
+		err := addFile(objs, f, ns)
+		if err != nil {
+			return err
+		}
+	}
+	objs.addMissingNamespaces()
+
+	// The preview runs the operator's code on an in-memory copy of the
+	// resources, so that nothing in the cluster is changed.
+	c := &objectReader{s: objs}
+
+	u, err := newUpdater(ctx, c, opts.DefaultsConfigMap)
+	if err != nil {
+		return err
+	}
+
+	results, err := previewAll(ctx, c, u, objs)
+	if err != nil {
+		return err
+	}
+
+	switch opts.Output {
+	case OutputJSON:
+		err = writeJSON(w, results)
+	case OutputDiff, "":
+		err = writeDiff(w, results)
+	default:
+		err = fmt.Errorf("unknown output format %q", opts.Output)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, r := range results {
+		if len(r.Errors) > 0 || len(r.MissingSecrets) > 0 {
+			return ErrConfigProblems
+		}
+	}
+	return nil
+}
+
+func addFile(objs *objectSet, name, ns string) error {
+	if name == "-" {
+		return objs.addManifests(os.Stdin, ns)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = objs.addManifests(f, ns)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// newUpdater returns an Updater with the operator defaults from the
+// ConfigMap, if it was set and exists.
+func newUpdater(ctx context.Context, c client.Reader, key types.NamespacedName) (*workload.Updater, error) {
+	cfg := workload.DefaultConfig()
+	if key.Name != "" {
+		cm := &corev1.ConfigMap{}
+		err := c.Get(ctx, key, cm)
+		if client.IgnoreNotFound(err) != nil {
+			return nil, err
+		}
+		if err == nil {
+			cfg, err = cfg.Apply(cm.Data)
+			if err != nil {
+				return nil, fmt.Errorf("invalid operator defaults in ConfigMap %v, %v", key, err)
+			}
+		}
+	}
+	return workload.NewUpdaterWithConfig("cloud-sql-proxy-operator/preview", cfg), nil
+}
+
+// previewAll renders the preview of each workload in objs that matches an
+// AuthProxyWorkload. Workloads created by another workload, like the
+// ReplicaSets of a Deployment, are left out.
+func previewAll(ctx context.Context, c client.Reader, u *workload.Updater, objs *objectSet) ([]*Result, error) {
+	var results []*Result
+	for _, o := range objs.list() {
+		if ownedByWorkload(o) {
+			continue
+		}
+		wl, err := workload.WorkloadForKind(o.GetObjectKind().GroupVersionKind().Kind)
+		if err != nil {
+			continue // not a workload
+		}
+		err = c.Get(ctx, client.ObjectKeyFromObject(o), wl.Object())
+		if err != nil {
+			return nil, err
+		}
+		wl.Object().GetObjectKind().SetGroupVersionKind(o.GetObjectKind().GroupVersionKind())
+
+		pv, err := controller.PreviewWorkload(ctx, c, u, wl)
+		if err != nil {
+			return nil, err
+		}
+		if len(pv.AuthProxyWorkloads) == 0 {
+			continue
+		}
+		r, err := newResult(pv)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	return results, nil
+}
+
+// ownedByWorkload returns true when the object is controlled by a workload,
+// which creates it from its own template.
+func ownedByWorkload(o client.Object) bool {
+	ref := metav1.GetControllerOf(o)
+	if ref == nil {
+		return false
+	}
+	_, err := workload.WorkloadForKind(ref.Kind)
+	return err == nil
+}
+
+func newResult(pv *controller.WorkloadPreview) (*Result, error) {
+	o := pv.Workload.Object()
+	r := &Result{
+		Kind:           o.GetObjectKind().GroupVersionKind().Kind,
+		Namespace:      o.GetNamespace(),
+		Name:           o.GetName(),
+		MissingSecrets: pv.MissingSecrets,
+		Excluded:       pv.ExcludedMessage,
+	}
+
+	sort.Slice(pv.AuthProxyWorkloads, func(i, j int) bool {
+		return workload.ContainerName(pv.AuthProxyWorkloads[i]) < workload.ContainerName(pv.AuthProxyWorkloads[j])
+	})
+	for _, p := range pv.AuthProxyWorkloads {
+		r.AuthProxyWorkloads = append(r.AuthProxyWorkloads, p.GetNamespace()+"/"+p.GetName())
+		if c := pv.ProxyContainer(p); c != nil {
+			r.ProxyContainers = append(r.ProxyContainers, *c)
+		}
+	}
+
+	for _, e := range pv.Errors {
+		r.Errors = append(r.Errors, ErrorDetail{
+			ErrorCode:         e.ErrorCode,
+			Description:       e.Description,
+			AuthProxyWorkload: e.AuthProxyNamespace + "/" + e.AuthProxyName,
+		})
+	}
+
+	if pv.ConfiguredPod != nil {
+		before, err := podTemplateYAML(pv.Pod)
+		if err != nil {
+			return nil, err
+		}
+		after, err := podTemplateYAML(pv.ConfiguredPod)
+		if err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("%s %s/%s", r.Kind, r.Namespace, r.Name)
+		r.Diff = unifiedDiff(name+" pod template", name+" configured pod", before, after)
+	}
+	return r, nil
+}
+
+// podTemplateYAML returns the parts of the pod that the operator changes, as
+// YAML.
+func podTemplateYAML(p *corev1.Pod) (string, error) {
+	t := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Annotations: p.Annotations},
+		Spec:       p.Spec,
+	}
+	b, err := yaml.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func writeJSON(w io.Writer, results []*Result) error {
+	if results == nil {
+		results = []*Result{}
+	}
+	b, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+func writeDiff(w io.Writer, results []*Result) error {
+	if len(results) == 0 {
+		_, err := fmt.Fprintln(w, "No workloads match the AuthProxyWorkloads.")
+		return err
+	}
+	sb := &strings.Builder{}
+	for i, r := range results {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(sb, "%s %s/%s\n", r.Kind, r.Namespace, r.Name)
+		fmt.Fprintf(sb, "  AuthProxyWorkloads: %s\n", strings.Join(r.AuthProxyWorkloads, ", "))
+		if r.Excluded != "" {
+			fmt.Fprintf(sb, "  Excluded: %s\n", r.Excluded)
+		}
+		for _, e := range r.Errors {
+			fmt.Fprintf(sb, "  Error %s from AuthProxyWorkload %s: %s\n", e.ErrorCode, e.AuthProxyWorkload, e.Description)
+		}
+		for _, s := range r.MissingSecrets {
+			fmt.Fprintf(sb, "  Error: credentials file Secret %s/%s not found, the pods can't start\n", r.Namespace, s)
+		}
+		if len(r.Errors) > 0 {
+			sb.WriteString("  The pods will be rejected until the errors are fixed.\n")
+		}
+		sb.WriteString(r.Diff)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
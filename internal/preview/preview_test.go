@@ -0,0 +1,317 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preview
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
+	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/controller"
+	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/testhelpers"
+)
+
+const deploymentYAML = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: webapp
+  labels:
+    app: webapp
+spec:
+  selector:
+    matchLabels:
+      app: webapp
+  template:
+    metadata:
+      labels:
+        app: webapp
+    spec:
+      containers:
+      - name: app
+        image: example.com/app
+        ports:
+        - containerPort: 5000
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: webapp
+spec:
+  ports:
+  - port: 80
+`
+
+const proxyYAML = `
+apiVersion: cloudsql.cloud.google.com/v1
+kind: AuthProxyWorkload
+metadata:
+  name: proxy
+spec:
+  workloadSelector:
+    kind: Deployment
+    name: webapp
+  instances:
+  - connectionString: project:region:db
+    portEnvName: DB_PORT
+`
+
+const conflictYAML = `
+apiVersion: cloudsql.cloud.google.com/v1
+kind: AuthProxyWorkload
+metadata:
+  name: conflict
+spec:
+  workloadSelector:
+    kind: Deployment
+    selector:
+      matchLabels:
+        app: webapp
+  instances:
+  - connectionString: project:region:db2
+    port: 5000
+`
+
+func TestRunDiff(t *testing.T) {
+	opts := Options{Files: writeManifests(t, deploymentYAML, proxyYAML)}
+	out := &bytes.Buffer{}
+	err := Run(context.Background(), opts, scheme(t), nil, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := out.String()
+	for _, want := range []string{
+		"Deployment default/webapp\n",
+		"  AuthProxyWorkloads: default/proxy\n",
+		"--- Deployment default/webapp pod template\n",
+		"+  - args:\n",
+		"+    - project:region:db?port=5001\n",
+		"+    name: csql-default-proxy\n",
+		"+    - name: DB_PORT\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got output\n%s\nwant it to contain %q", got, want)
+		}
+	}
+	// The Service is not a workload.
+	if strings.Contains(got, "Service") {
+		t.Errorf("got output\n%s\nwant no Service", got)
+	}
+}
+
+func TestRunConfigError(t *testing.T) {
+	opts := Options{
+		Files:  writeManifests(t, deploymentYAML, proxyYAML+"---\n"+conflictYAML),
+		Output: OutputJSON,
+	}
+	out := &bytes.Buffer{}
+	err := Run(context.Background(), opts, scheme(t), nil, out)
+	if !errors.Is(err, ErrConfigProblems) {
+		t.Fatalf("got %v, want %v", err, ErrConfigProblems)
+	}
+
+	var results []Result
+	err = json.Unmarshal(out.Bytes(), &results)
+	if err != nil {
+		t.Fatalf("unable to parse output %s, %v", out.String(), err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	r := results[0]
+	if len(r.Errors) != 1 || r.Errors[0].ErrorCode != cloudsqlapi.ErrorCodePortConflict {
+		t.Errorf("got errors %v, want one %v", r.Errors, cloudsqlapi.ErrorCodePortConflict)
+	}
+	if r.Errors[0].AuthProxyWorkload != "default/conflict" {
+		t.Errorf("got error for %v, want default/conflict", r.Errors[0].AuthProxyWorkload)
+	}
+	if r.Diff != "" || len(r.ProxyContainers) != 0 {
+		t.Errorf("got diff %q and containers %v, want none when there are errors", r.Diff, r.ProxyContainers)
+	}
+}
+
+func TestRunMissingSecret(t *testing.T) {
+	withSecret := proxyYAML + `
+  authProxyContainer:
+    authentication:
+      credentialsFileSecret:
+        name: creds
+        key: key.json
+`
+	opts := Options{
+		Files:  writeManifests(t, deploymentYAML, withSecret),
+		Output: OutputJSON,
+	}
+	out := &bytes.Buffer{}
+	err := Run(context.Background(), opts, scheme(t), nil, out)
+	if !errors.Is(err, ErrConfigProblems) {
+		t.Fatalf("got %v, want %v", err, ErrConfigProblems)
+	}
+	var results []Result
+	err = json.Unmarshal(out.Bytes(), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || len(results[0].MissingSecrets) != 1 || results[0].MissingSecrets[0] != "creds" {
+		t.Errorf("got %v, want missing secret creds", results)
+	}
+}
+
+func TestRunCluster(t *testing.T) {
+	s := scheme(t)
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps", Labels: map[string]string{"env": "prod"}}}
+	d := testhelpers.BuildDeployment(types.NamespacedName{Namespace: "apps", Name: "webapp"}, "app")
+	ctrlTrue := true
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "apps", Name: "webapp-1234",
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "Deployment", Name: "webapp", Controller: &ctrlTrue,
+			}},
+		},
+		Spec: appsv1.ReplicaSetSpec{Template: d.Spec.Template},
+	}
+	cl := fake.NewClientBuilder().WithScheme(s).WithObjects(ns, d, rs).Build()
+
+	// A ClusterAuthProxyWorkload in the manifests applies to the workloads
+	// in the cluster.
+	clusterProxy := `
+apiVersion: cloudsql.cloud.google.com/v1
+kind: ClusterAuthProxyWorkload
+metadata:
+  name: shared
+spec:
+  workloadSelector:
+    kind: Deployment
+    name: webapp
+    namespaceSelector:
+      matchLabels:
+        env: prod
+  instances:
+  - connectionString: project:region:db
+`
+	opts := Options{
+		Files:   writeManifests(t, clusterProxy),
+		Cluster: true,
+		Output:  OutputJSON,
+	}
+	out := &bytes.Buffer{}
+	err := Run(context.Background(), opts, s, cl, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var results []Result
+	err = json.Unmarshal(out.Bytes(), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The ReplicaSet is left out because it belongs to the Deployment.
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1: %v", len(results), results)
+	}
+	r := results[0]
+	if r.Kind != "Deployment" || r.Namespace != "apps" || r.Name != "webapp" {
+		t.Errorf("got %s %s/%s, want Deployment apps/webapp", r.Kind, r.Namespace, r.Name)
+	}
+	if len(r.AuthProxyWorkloads) != 1 || r.AuthProxyWorkloads[0] != "/shared" {
+		t.Errorf("got %v, want the ClusterAuthProxyWorkload", r.AuthProxyWorkloads)
+	}
+	if len(r.ProxyContainers) != 1 {
+		t.Errorf("got %d proxy containers, want 1", len(r.ProxyContainers))
+	}
+}
+
+func TestRunDefaultsConfigMap(t *testing.T) {
+	cm := `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: defaults
+  namespace: operator
+data:
+  proxyImage: example.com/proxy:1
+`
+	opts := Options{
+		Files:             writeManifests(t, deploymentYAML, proxyYAML, cm),
+		DefaultsConfigMap: types.NamespacedName{Namespace: "operator", Name: "defaults"},
+		Output:            OutputJSON,
+	}
+	out := &bytes.Buffer{}
+	err := Run(context.Background(), opts, scheme(t), nil, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []Result
+	err = json.Unmarshal(out.Bytes(), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || len(results[0].ProxyContainers) != 1 {
+		t.Fatalf("got %v, want 1 result with 1 proxy container", results)
+	}
+	if got := results[0].ProxyContainers[0].Image; got != "example.com/proxy:1" {
+		t.Errorf("got image %v, want example.com/proxy:1", got)
+	}
+}
+
+func TestRunNoMatches(t *testing.T) {
+	opts := Options{Files: writeManifests(t, deploymentYAML)}
+	out := &bytes.Buffer{}
+	err := Run(context.Background(), opts, scheme(t), nil, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "No workloads match the AuthProxyWorkloads.\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func scheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	s := runtime.NewScheme()
+	controller.InitScheme(s)
+	return s
+}
+
+// writeManifests writes each manifest to a file, and returns the file names.
+func writeManifests(t *testing.T, manifests ...string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var files []string
+	for i, m := range manifests {
+		f := filepath.Join(dir, string(rune('a'+i))+".yaml")
+		err := os.WriteFile(f, []byte(m), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	return files
+}
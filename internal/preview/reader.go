@@ -0,0 +1,143 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preview
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/controller"
+)
+
+// objectReader reads the objects of an objectSet. The preview runs the
+// operator's code on it, so that the preview works on an in-memory copy of
+// the resources. Like the operator's cache, it returns copies of the objects,
+// and it lists resources by the operator's field indexes.
+type objectReader struct {
+	s *objectSet
+}
+
+var _ client.Reader = &objectReader{}
+
+// Get implements client.Reader.
+func (r *objectReader) Get(_ context.Context, key client.ObjectKey, obj client.Object, _ ...client.GetOption) error {
+	gvk, err := apiutil.GVKForObject(obj, r.s.scheme)
+	if err != nil {
+		return err
+	}
+	o, ok := r.s.objects[objectKey{kind: gvk.Kind, NamespacedName: key}]
+	if !ok {
+		gvr, _ := meta.UnsafeGuessKindToResource(gvk)
+		return apierrors.NewNotFound(gvr.GroupResource(), key.Name)
+	}
+	return copyObject(o, obj)
+}
+
+// List implements client.Reader. It supports the namespace, the label
+// selector, and field selectors on the operator's field indexes.
+func (r *objectReader) List(_ context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	gvk, err := apiutil.GVKForObject(list, r.s.scheme)
+	if err != nil {
+		return err
+	}
+	kind := strings.TrimSuffix(gvk.Kind, "List")
+	lo := &client.ListOptions{}
+	lo.ApplyOptions(opts)
+
+	_, partial := list.(*metav1.PartialObjectMetadataList)
+	var items []runtime.Object
+	for _, o := range r.s.list() {
+		if o.GetObjectKind().GroupVersionKind().Kind != kind {
+			continue
+		}
+		ok, err := matchesListOptions(o, lo)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		var item client.Object
+		if partial {
+			item = &metav1.PartialObjectMetadata{}
+		} else {
+			item = o.DeepCopyObject().(client.Object)
+		}
+		err = copyObject(o, item)
+		if err != nil {
+			return err
+		}
+		items = append(items, item)
+	}
+	return meta.SetList(list, items)
+}
+
+// matchesListOptions returns true when the object o is selected by the
+// namespace, label selector and field selector of lo.
+func matchesListOptions(o client.Object, lo *client.ListOptions) (bool, error) {
+	if lo.Namespace != "" && o.GetNamespace() != lo.Namespace {
+		return false, nil
+	}
+	if lo.LabelSelector != nil && !lo.LabelSelector.Matches(labels.Set(o.GetLabels())) {
+		return false, nil
+	}
+	if lo.FieldSelector == nil {
+		return true, nil
+	}
+	for _, req := range lo.FieldSelector.Requirements() {
+		if req.Operator != selection.Equals && req.Operator != selection.DoubleEquals {
+			return false, fmt.Errorf("unsupported field selector %v", req)
+		}
+		values, ok := controller.FieldIndexValues(o, req.Field)
+		if !ok {
+			return false, fmt.Errorf("no field index %s for %T", req.Field, o)
+		}
+		if !slices.Contains(values, req.Value) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// copyObject copies the object src to dst, which is either an object of the
+// same type or a PartialObjectMetadata that receives only the metadata.
+func copyObject(src, dst client.Object) error {
+	if m, ok := dst.(*metav1.PartialObjectMetadata); ok {
+		om, ok := src.(metav1.ObjectMetaAccessor).GetObjectMeta().(*metav1.ObjectMeta)
+		if !ok {
+			return fmt.Errorf("unable to read the metadata of %T", src)
+		}
+		m.ObjectMeta = *om.DeepCopy()
+		m.SetGroupVersionKind(src.GetObjectKind().GroupVersionKind())
+		return nil
+	}
+	if reflect.TypeOf(src) != reflect.TypeOf(dst) {
+		return fmt.Errorf("unable to read %T into %T", src, dst)
+	}
+	reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(src.DeepCopyObject()).Elem())
+	return nil
+}
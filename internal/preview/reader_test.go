@@ -0,0 +1,100 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preview
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
+)
+
+const statefulSetProxyYAML = `
+apiVersion: cloudsql.cloud.google.com/v1
+kind: AuthProxyWorkload
+metadata:
+  name: statefulset-proxy
+spec:
+  workloadSelector:
+    kind: StatefulSet
+    name: db-client
+  instances:
+  - connectionString: project:region:db2
+    portEnvName: DB_PORT
+`
+
+func TestObjectReader(t *testing.T) {
+	ctx := context.Background()
+	objs := newObjectSet(scheme(t))
+	err := objs.addManifests(strings.NewReader(deploymentYAML+"---"+proxyYAML+"---"+statefulSetProxyYAML), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &objectReader{s: objs}
+
+	// Get returns a copy of the object.
+	d := &appsv1.Deployment{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "webapp"}, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Labels["app"] = "changed"
+	err = r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "webapp"}, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Labels["app"]; got != "webapp" {
+		t.Errorf("got label %q, want %q", got, "webapp")
+	}
+
+	// Get reads only the metadata into a PartialObjectMetadata.
+	m := &metav1.PartialObjectMetadata{}
+	m.SetGroupVersionKind(appsv1.SchemeGroupVersion.WithKind("Deployment"))
+	err = r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "webapp"}, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Labels["app"]; got != "webapp" {
+		t.Errorf("got label %q on metadata, want %q", got, "webapp")
+	}
+
+	err = r.Get(ctx, types.NamespacedName{Namespace: "other", Name: "webapp"}, d)
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("got %v, want a NotFound error", err)
+	}
+
+	// List selects the AuthProxyWorkloads by the operator's field index.
+	l := &cloudsqlapi.AuthProxyWorkloadList{}
+	err = r.List(ctx, l, client.InNamespace("default"),
+		client.MatchingFields{"spec.workloadSelector.kind": "StatefulSet"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Items) != 1 || l.Items[0].Name != "statefulset-proxy" {
+		t.Errorf("got %v, want only statefulset-proxy", l.Items)
+	}
+
+	err = r.List(ctx, l, client.MatchingFields{"spec.unknown": "x"})
+	if err == nil {
+		t.Error("got no error, want an error for a field without an index")
+	}
+}
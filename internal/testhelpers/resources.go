@@ -18,6 +18,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"math/rand"
 	"strconv"
 	"time"
@@ -622,6 +623,7 @@ func BuildDeploymentReplicaSetPods(d *appsv1.Deployment, rs *appsv1.ReplicaSet,
 					"enablewait":        "yes",
 					"pod-template-hash": podTemplateHash,
 				},
+				Annotations: maps.Clone(d.Spec.Template.Annotations),
 			},
 			Spec: d.Spec.Template.Spec,
 		}
@@ -270,6 +270,13 @@ func TestUpdateWorkloadContainerWhenDefaultProxyImageChanges(t *testing.T) {
 		}
 	}
 
+	// Record the proxy configuration hash of the pods before the upgrade
+	wantK := workload.PodAnnotationKey(p)
+	var before string
+	if len(pods.Items) > 0 {
+		before = pods.Items[0].Annotations[wantK]
+	}
+
 	// Restart the manager with a new default proxy image
 	const newDefault = "gcr.io/cloud-sql-connectors/cloud-sql-proxy:999.9.9"
 	th.StopManager()
@@ -278,15 +285,14 @@ func TestUpdateWorkloadContainerWhenDefaultProxyImageChanges(t *testing.T) {
 		t.Fatal("can't restart container", err)
 	}
 
-	// Get the related deployment. Make sure that annotations were
-	// set on the pod template
+	// Get the related deployment. Make sure that the annotation on the pod
+	// template was changed to the hash of the new proxy configuration.
 	err = testhelpers.RetryUntilSuccess(24, testhelpers.DefaultRetryInterval, func() error {
 		ud := &appsv1.Deployment{}
 		err = tcc.Client.Get(ctx, client.ObjectKeyFromObject(d), ud)
-		wantK, wantV := workload.PodAnnotation(p, newDefault)
 		gotV := ud.Spec.Template.Annotations[wantK]
-		if gotV != wantV {
-			return fmt.Errorf("got %s, want %s for podspec annotation on deployment", gotV, wantV)
+		if gotV == "" || gotV == before {
+			return fmt.Errorf("got %q, want a new value for podspec annotation on deployment", gotV)
 		}
 		return nil
 	})
@@ -33,10 +33,13 @@ import (
 	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/workload"
 	"github.com/go-logr/logr"
 	"go.uber.org/zap/zapcore"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -215,6 +218,11 @@ func (h *EnvTestHarness) StartManager(proxyImage string) error {
 	o := &h.testEnv.WebhookInstallOptions
 	mgr, err := ctrl.NewManager(h.cfg, ctrl.Options{
 		Scheme: h.s,
+		Cache: cache.Options{
+			ByObject: map[client.Object]cache.ByObject{
+				&corev1.Pod{}: {Transform: controller.TrimPod},
+			},
+		},
 		Metrics: metricsserver.Options{
 			BindAddress: "0",
 		},
@@ -233,7 +241,9 @@ func (h *EnvTestHarness) StartManager(proxyImage string) error {
 	h.Manager = mgr
 
 	// Initialize the controller-runtime manager.
-	err = controller.SetupManagers(mgr, "cloud-sql-proxy-operator/dev", proxyImage)
+	defaults := workload.DefaultConfig()
+	defaults.ProxyImage = proxyImage
+	err = controller.SetupManagers(mgr, "cloud-sql-proxy-operator/dev", defaults, types.NamespacedName{})
 	if err != nil {
 		return fmt.Errorf("unable to start kuberenetes envtest %v", err)
 	}
@@ -0,0 +1,259 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workload
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	apivalidation "k8s.io/apimachinery/pkg/util/validation"
+
+	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
+)
+
+// Keys used to set the operator defaults in a ConfigMap or on the operator's
+// command line. See Config.Apply.
+const (
+	ConfigProxyImage        = "proxyImage"
+	ConfigAlloyDBProxyImage = "alloyDBProxyImage"
+	ConfigRequests          = "requests"
+	ConfigLimits            = "limits"
+	ConfigFirstPort         = "firstPort"
+	ConfigHealthCheckPort   = "healthCheckPort"
+	ConfigAdminPort         = "adminPort"
+	ConfigEnv               = "env"
+	ConfigPauseInjection    = "pauseInjection"
+
+	ConfigAnnotationInjectionNamespaces = "annotationInjectionNamespaces"
+	ConfigMaxConcurrentRollouts         = "maxConcurrentRollouts"
+)
+
+// Config holds the operator-level defaults used to configure the proxy
+// container when the AuthProxyWorkload does not set a value.
+type Config struct {
+	// ProxyImage is the default Cloud SQL Auth Proxy image.
+	ProxyImage string `json:"proxyImage"`
+
+	// AlloyDBProxyImage is the default AlloyDB Auth Proxy image.
+	AlloyDBProxyImage string `json:"alloyDBProxyImage"`
+
+	// Resources are the default resource requests and limits of the proxy
+	// container.
+	Resources corev1.ResourceRequirements `json:"resources"`
+
+	// FirstPort is the first port number chosen for an instance listener.
+	FirstPort int32 `json:"firstPort"`
+
+	// HealthCheckPort is the default port for the proxy's health checks and
+	// telemetry.
+	HealthCheckPort int32 `json:"healthCheckPort"`
+
+	// AdminPort is the default port for the proxy's admin server.
+	AdminPort int32 `json:"adminPort"`
+
+	// Env are env vars added to every proxy container. Env vars set by the
+	// operator from the AuthProxyWorkload take precedence.
+	Env []corev1.EnvVar `json:"env"`
+
+	// PauseInjection stops the operator from adding proxies to new pods and
+	// from updating workloads. It is an emergency switch for when the proxy
+	// itself is broken.
+	PauseInjection bool `json:"pauseInjection"`
+
+	// AnnotationInjectionNamespaces is a label selector for the namespaces
+	// where pods may request a proxy with annotations, see
+	// cloudsqlapi.InstancesAnnotation. When it is empty, annotation injection
+	// is turned off.
+	AnnotationInjectionNamespaces string `json:"annotationInjectionNamespaces"`
+
+	// MaxConcurrentRollouts is the maximum number of workloads that the
+	// operator rolls out a new proxy configuration to at the same time, across
+	// all AuthProxyWorkloads. The other workloads wait in a queue. When it is
+	// 0, there is no limit.
+	MaxConcurrentRollouts int32 `json:"maxConcurrentRollouts"`
+}
+
+// DefaultConfig returns the operator defaults built into this release of the
+// operator.
+func DefaultConfig() Config {
+	return Config{
+		ProxyImage:        DefaultProxyImage,
+		AlloyDBProxyImage: DefaultAlloyDBProxyImage,
+		Resources:         *defaultContainerResources.DeepCopy(),
+		FirstPort:         DefaultFirstPort,
+		HealthCheckPort:   DefaultHealthCheckPort,
+		AdminPort:         DefaultAdminPort,
+	}
+}
+
+// DeepCopy returns a copy of c that shares no memory with c.
+func (c Config) DeepCopy() Config {
+	n := c
+	n.Resources = *c.Resources.DeepCopy()
+	if c.Env != nil {
+		n.Env = make([]corev1.EnvVar, len(c.Env))
+		for i := range c.Env {
+			c.Env[i].DeepCopyInto(&n.Env[i])
+		}
+	}
+	return n
+}
+
+// Equal returns true when c and o hold the same defaults.
+func (c Config) Equal(o Config) bool {
+	return equality.Semantic.DeepEqual(c, o)
+}
+
+// Apply returns a copy of c with the values in data applied to it. The keys
+// of data are the Config* constants. Requests and limits are lists of
+// resources like `cpu=100m,memory=128Mi`. Env is a list of `NAME=value` env
+// vars, one per line.
+func (c Config) Apply(data map[string]string) (Config, error) {
+	n := c.DeepCopy()
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := strings.TrimSpace(data[k])
+		var err error
+		switch k {
+		case ConfigProxyImage:
+			n.ProxyImage = v
+		case ConfigAlloyDBProxyImage:
+			n.AlloyDBProxyImage = v
+		case ConfigRequests:
+			n.Resources.Requests, err = parseResourceList(v)
+		case ConfigLimits:
+			n.Resources.Limits, err = parseResourceList(v)
+		case ConfigFirstPort:
+			n.FirstPort, err = parsePort(v)
+		case ConfigHealthCheckPort:
+			n.HealthCheckPort, err = parsePort(v)
+		case ConfigAdminPort:
+			n.AdminPort, err = parsePort(v)
+		case ConfigEnv:
+			n.Env, err = parseEnv(v)
+		case ConfigPauseInjection:
+			n.PauseInjection, err = strconv.ParseBool(v)
+		case ConfigAnnotationInjectionNamespaces:
+			_, err = labels.Parse(v)
+			n.AnnotationInjectionNamespaces = v
+		case ConfigMaxConcurrentRollouts:
+			n.MaxConcurrentRollouts, err = parseCount(v)
+		default:
+			err = fmt.Errorf("unknown setting")
+		}
+		if err != nil {
+			return c, fmt.Errorf("invalid value for %s: %v", k, err)
+		}
+	}
+
+	if n.ProxyImage == "" || n.AlloyDBProxyImage == "" {
+		return c, fmt.Errorf("the default proxy images may not be empty")
+	}
+	return n, nil
+}
+
+func parseResourceList(v string) (corev1.ResourceList, error) {
+	if v == "" {
+		return nil, nil
+	}
+	rl := corev1.ResourceList{}
+	for _, item := range strings.Split(v, ",") {
+		name, q, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not in the format name=quantity", item)
+		}
+		qty, err := resource.ParseQuantity(q)
+		if err != nil {
+			return nil, fmt.Errorf("%q has an invalid quantity, %v", item, err)
+		}
+		rl[corev1.ResourceName(name)] = qty
+	}
+	return rl, nil
+}
+
+func parsePort(v string) (int32, error) {
+	p, err := strconv.ParseInt(v, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	if errs := apivalidation.IsValidPortNum(int(p)); len(errs) > 0 {
+		return 0, fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return int32(p), nil
+}
+
+// parseCount parses a number that may not be negative.
+func parseCount(v string) (int32, error) {
+	n, err := strconv.ParseInt(v, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("must not be negative")
+	}
+	return int32(n), nil
+}
+
+func parseEnv(v string) ([]corev1.EnvVar, error) {
+	var env []corev1.EnvVar
+	for _, line := range strings.Split(v, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not in the format NAME=value", line)
+		}
+		if errs := apivalidation.IsEnvVarName(name); len(errs) > 0 {
+			return nil, fmt.Errorf("%q is not a valid env var name, %s", name, strings.Join(errs, ", "))
+		}
+		env = append(env, corev1.EnvVar{Name: name, Value: value})
+	}
+	return env, nil
+}
+
+// AllowsAnnotationInjection returns true when pods in namespace ns may request
+// a proxy with annotations.
+func (c Config) AllowsAnnotationInjection(ns *corev1.Namespace) bool {
+	if c.AnnotationInjectionNamespaces == "" {
+		return false
+	}
+	sel, err := labels.Parse(c.AnnotationInjectionNamespaces)
+	if err != nil {
+		// Apply does not accept an invalid selector.
+		return false
+	}
+	return sel.Matches(labels.Set(ns.GetLabels()))
+}
+
+// image returns the default proxy image for the ProxyType of r.
+func (c Config) image(r *cloudsqlapi.AuthProxyWorkload) string {
+	if isAlloyDB(r) {
+		return c.AlloyDBProxyImage
+	}
+	return c.ProxyImage
+}
@@ -0,0 +1,294 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workload_test
+
+import (
+	"fmt"
+	"testing"
+
+	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
+	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/workload"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConfigApply(t *testing.T) {
+	tcs := []struct {
+		desc    string
+		data    map[string]string
+		wantErr bool
+		check   func(c workload.Config) error
+	}{
+		{
+			desc: "empty data keeps the defaults",
+			check: func(c workload.Config) error {
+				if !c.Equal(workload.DefaultConfig()) {
+					return fmt.Errorf("got %v, want the default config", c)
+				}
+				if c.AllowsAnnotationInjection(&corev1.Namespace{}) {
+					return fmt.Errorf("got annotation injection allowed, want it turned off by default")
+				}
+				return nil
+			},
+		},
+		{
+			desc: "all values",
+			data: map[string]string{
+				workload.ConfigProxyImage:        "example.com/proxy:1",
+				workload.ConfigAlloyDBProxyImage: "example.com/alloydb:1",
+				workload.ConfigRequests:          "cpu=100m, memory=128Mi",
+				workload.ConfigLimits:            "memory=256Mi",
+				workload.ConfigFirstPort:         "6000",
+				workload.ConfigHealthCheckPort:   "9900",
+				workload.ConfigAdminPort:         "9990",
+				workload.ConfigEnv:               "HTTPS_PROXY=http://proxy:3128\nCSQL_PROXY_DEBUG_LOGS=true\n",
+			},
+			check: func(c workload.Config) error {
+				if c.ProxyImage != "example.com/proxy:1" || c.AlloyDBProxyImage != "example.com/alloydb:1" {
+					return fmt.Errorf("got images %v and %v", c.ProxyImage, c.AlloyDBProxyImage)
+				}
+				if got := c.Resources.Requests["cpu"]; got.Cmp(resource.MustParse("100m")) != 0 {
+					return fmt.Errorf("got cpu request %v, want 100m", got.String())
+				}
+				if got := c.Resources.Limits["memory"]; got.Cmp(resource.MustParse("256Mi")) != 0 {
+					return fmt.Errorf("got memory limit %v, want 256Mi", got.String())
+				}
+				if c.FirstPort != 6000 || c.HealthCheckPort != 9900 || c.AdminPort != 9990 {
+					return fmt.Errorf("got ports %v, %v, %v", c.FirstPort, c.HealthCheckPort, c.AdminPort)
+				}
+				if len(c.Env) != 2 || c.Env[0].Name != "HTTPS_PROXY" || c.Env[0].Value != "http://proxy:3128" {
+					return fmt.Errorf("got env %v", c.Env)
+				}
+				return nil
+			},
+		},
+		{
+			desc: "pause injection",
+			data: map[string]string{workload.ConfigPauseInjection: "true"},
+			check: func(c workload.Config) error {
+				if !c.PauseInjection {
+					return fmt.Errorf("got PauseInjection false, want true")
+				}
+				return nil
+			},
+		},
+		{
+			desc:    "bad pause injection",
+			data:    map[string]string{workload.ConfigPauseInjection: "sometimes"},
+			wantErr: true,
+		},
+		{
+			desc: "max concurrent rollouts",
+			data: map[string]string{workload.ConfigMaxConcurrentRollouts: "10"},
+			check: func(c workload.Config) error {
+				if c.MaxConcurrentRollouts != 10 {
+					return fmt.Errorf("got MaxConcurrentRollouts %d, want 10", c.MaxConcurrentRollouts)
+				}
+				return nil
+			},
+		},
+		{
+			desc:    "negative max concurrent rollouts",
+			data:    map[string]string{workload.ConfigMaxConcurrentRollouts: "-1"},
+			wantErr: true,
+		},
+		{
+			desc: "annotation injection namespaces",
+			data: map[string]string{workload.ConfigAnnotationInjectionNamespaces: "team in (a, b)"},
+			check: func(c workload.Config) error {
+				for ns, want := range map[string]bool{"a": true, "b": true, "c": false} {
+					n := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"team": ns}}}
+					if got := c.AllowsAnnotationInjection(n); got != want {
+						return fmt.Errorf("got %v for team %s, want %v", got, ns, want)
+					}
+				}
+				return nil
+			},
+		},
+		{
+			desc:    "bad annotation injection namespaces",
+			data:    map[string]string{workload.ConfigAnnotationInjectionNamespaces: "team in a"},
+			wantErr: true,
+		},
+		{
+			desc:    "unknown key",
+			data:    map[string]string{"proxyimage": "example.com/proxy:1"},
+			wantErr: true,
+		},
+		{
+			desc:    "bad port",
+			data:    map[string]string{workload.ConfigAdminPort: "70000"},
+			wantErr: true,
+		},
+		{
+			desc:    "bad quantity",
+			data:    map[string]string{workload.ConfigRequests: "cpu=lots"},
+			wantErr: true,
+		},
+		{
+			desc:    "bad env var",
+			data:    map[string]string{workload.ConfigEnv: "1BAD"},
+			wantErr: true,
+		},
+		{
+			desc:    "empty image",
+			data:    map[string]string{workload.ConfigProxyImage: ""},
+			wantErr: true,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			c, err := workload.DefaultConfig().Apply(tc.data)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("got no error, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tc.check(c); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestConfigureWorkloadWithConfig(t *testing.T) {
+	cfg, err := workload.DefaultConfig().Apply(map[string]string{
+		workload.ConfigProxyImage:      "example.com/proxy:1",
+		workload.ConfigRequests:        "cpu=100m,memory=128Mi",
+		workload.ConfigFirstPort:       "6000",
+		workload.ConfigHealthCheckPort: "9900",
+		workload.ConfigAdminPort:       "9990",
+		workload.ConfigEnv:             "HTTPS_PROXY=http://proxy:3128\nCSQL_PROXY_HEALTH_CHECK=false",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := workload.NewUpdaterWithConfig("cloud-sql-proxy-operator/dev", cfg)
+	wl := podWorkload()
+	p := simpleAuthProxy("instance1", "project:server:db")
+
+	err = configureProxies(u, wl, []*cloudsqlapi.AuthProxyWorkload{p})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := findContainer(wl, workload.ContainerName(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Image != "example.com/proxy:1" {
+		t.Errorf("got %v, want %v for image", c.Image, "example.com/proxy:1")
+	}
+	if got := c.Resources.Requests["memory"]; got.Cmp(resource.MustParse("128Mi")) != 0 {
+		t.Errorf("got %v, want 128Mi for memory request", got.String())
+	}
+	assertContainerArgsContains(t, c.Args, []string{"project:server:db?port=6000"})
+
+	wantEnv := map[string]string{
+		"CSQL_PROXY_HTTP_PORT":  "9900",
+		"CSQL_PROXY_ADMIN_PORT": "9990",
+		"HTTPS_PROXY":           "http://proxy:3128",
+		// the operator's value takes precedence over the default env var
+		"CSQL_PROXY_HEALTH_CHECK": "true",
+	}
+	for name, want := range wantEnv {
+		got, err := findEnvVar(wl, c.Name, name)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got.Value != want {
+			t.Errorf("got %v, want %v for env var %v", got.Value, want, name)
+		}
+	}
+}
+
+func TestPodAnnotationWithConfig(t *testing.T) {
+	p := simpleAuthProxy("instance1", "project:server:db")
+	p.Generation = 1
+	u := workload.NewUpdater("cloud-sql-proxy-operator/dev", workload.DefaultProxyImage)
+	annotation := func() string {
+		t.Helper()
+		wl := podWorkload()
+		if err := configureProxies(u, wl, []*cloudsqlapi.AuthProxyWorkload{p}); err != nil {
+			t.Fatal(err)
+		}
+		return wl.Pod.Annotations[workload.PodAnnotationKey(p)]
+	}
+	want := annotation()
+
+	// The annotation changes when the defaults change.
+	cfg, err := u.Config().Apply(map[string]string{workload.ConfigRequests: "cpu=100m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.SetConfig(cfg) {
+		t.Fatal("got false, want SetConfig to report a change")
+	}
+	got := annotation()
+	if got == want {
+		t.Errorf("got %v, want the annotation to change with the defaults", got)
+	}
+
+	// Setting the same defaults again is not a change.
+	if u.SetConfig(cfg) {
+		t.Error("got true, want SetConfig to report no change")
+	}
+	if again := annotation(); again != got {
+		t.Errorf("got %v, want %v", again, got)
+	}
+
+	// Pausing injection does not change the annotation, so it does not roll
+	// out the workloads.
+	paused, err := cfg.Apply(map[string]string{workload.ConfigPauseInjection: "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.SetConfig(paused) {
+		t.Fatal("got false, want SetConfig to report a change")
+	}
+	if again := annotation(); again != got {
+		t.Errorf("got %v, want %v", again, got)
+	}
+
+	// Neither does the rollout limit.
+	limited, err := paused.Apply(map[string]string{workload.ConfigMaxConcurrentRollouts: "5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.SetConfig(limited) {
+		t.Fatal("got false, want SetConfig to report a change")
+	}
+	if again := annotation(); again != got {
+		t.Errorf("got %v, want %v", again, got)
+	}
+
+	// Nor does the AlloyDB image, which is not used by this proxy.
+	alloy, err := limited.Apply(map[string]string{workload.ConfigAlloyDBProxyImage: "example.com/alloydb-auth-proxy:1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.SetConfig(alloy) {
+		t.Fatal("got false, want SetConfig to report a change")
+	}
+	if again := annotation(); again != got {
+		t.Errorf("got %v, want %v", again, got)
+	}
+}
@@ -26,12 +26,20 @@ import (
 // by this operator.
 const ContainerPrefix = "csql-"
 
+// CredentialsMountDir is the directory in the proxy container where the
+// credentials file volumes are mounted.
+const CredentialsMountDir = "/var/run/secrets/cloudsql.cloud.google.com"
+
 // ContainerName generates a valid name for a corev1.Container object that
 // implements this cloudsql instance. Names must be 63 characters or fewer and
 // adhere to the rfc1035/rfc1123 label (DNS_LABEL) format.  r.ObjectMeta.Name
 // already is required to be 63 characters or less because it is a name. Because
 // we are prepending 'csql-' ContainerPrefix as a marker, the generated name with
 // the prefix could be longer than 63 characters.
+//
+// A cluster-scoped AuthProxyWorkload has an empty namespace, so its container
+// is named `csql--<name>`. This can't collide with the container of a
+// namespaced AuthProxyWorkload.
 func ContainerName(r *cloudsqlapi.AuthProxyWorkload) string {
 	return SafePrefixedName(ContainerPrefix, r.GetNamespace()+"-"+r.GetName())
 }
@@ -43,6 +51,12 @@ func VolumeName(r *cloudsqlapi.AuthProxyWorkload, inst *cloudsqlapi.InstanceSpec
 	return SafePrefixedName(ContainerPrefix, r.GetName()+"-"+mountType+"-"+connName)
 }
 
+// CredentialsVolumeName generates a unique, valid name for the volume holding
+// the credentials file for the AuthProxyWorkload.
+func CredentialsVolumeName(r *cloudsqlapi.AuthProxyWorkload) string {
+	return SafePrefixedName(ContainerPrefix, r.GetNamespace()+"-"+r.GetName()+"-credentials")
+}
+
 // SafePrefixedName adds a prefix to a name and shortens it while preserving its uniqueness
 // so that it fits the 63 character limit imposed by kubernetes.
 // Kubernetes names must follow the DNS Label format for all names.
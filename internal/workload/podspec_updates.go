@@ -15,10 +15,16 @@
 package workload
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"path"
+	"reflect"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
@@ -41,6 +47,11 @@ const (
 	// when the Cloud SQL Auth Proxy releases a new version.
 	DefaultProxyImage = "gcr.io/cloud-sql-connectors/cloud-sql-proxy:2.13.0"
 
+	// DefaultAlloyDBProxyImage is the latest version of the AlloyDB Auth Proxy
+	// as of the release of this operator. This is managed as a dependency,
+	// like DefaultProxyImage.
+	DefaultAlloyDBProxyImage = "gcr.io/alloydb-connectors/alloydb-auth-proxy:1.11.0"
+
 	// DefaultFirstPort is the first port number chose for an instance listener by the
 	// proxy.
 	DefaultFirstPort int32 = 5000
@@ -56,30 +67,101 @@ const (
 
 var l = logf.Log.WithName("internal.workload")
 
-// PodAnnotation returns the annotation (key, value) that should be added to
-// pods that are configured with this AuthProxyWorkload resource. This takes
-// into account whether the AuthProxyWorkload exists or was recently deleted.
-// The defaultProxyImage is part of the annotation value.
-func PodAnnotation(r *cloudsqlapi.AuthProxyWorkload, defaultProxyImage string) (string, string) {
-	img := defaultProxyImage
-	if r.Spec.AuthProxyContainer != nil && r.Spec.AuthProxyContainer.Image != "" {
-		img = ""
+// EnvAnnotation is the pod annotation that records the names of the env vars
+// that the operator added to the pod's containers and init containers, other
+// than the proxy containers. The operator reads it back when it configures
+// the pod again, so that it removes the env vars of the proxies that no
+// longer apply to a container.
+const EnvAnnotation = "env." + cloudsqlapi.AnnotationPrefix + "/names"
+
+// PodAnnotationKey returns the key of the annotation that the operator adds
+// to pods that are configured with this AuthProxyWorkload resource. Its value
+// is a hash of the proxy configuration that the operator added to the pod,
+// so a workload's pods only need to be recreated when the hash changes. See
+// ConfigureWorkload.
+func PodAnnotationKey(r *cloudsqlapi.AuthProxyWorkload) string {
+	prefix := cloudsqlapi.AnnotationPrefix
+	if r.IsClusterScoped() {
+		prefix = cloudsqlapi.ClusterAnnotationPrefix
+	}
+	return fmt.Sprintf("%s/%s", prefix, r.Name)
+}
+
+// RestartAnnotationKey returns the key of the pod template annotation that
+// the operator sets to the value of r's RestartRolloutAnnotation, so that the
+// workload restarts its pods.
+func RestartAnnotationKey(r *cloudsqlapi.AuthProxyWorkload) string {
+	return "restart." + PodAnnotationKey(r)
+}
+
+// DeletedPodAnnotationValue returns the PodAnnotationKey value that the
+// operator sets on the pod template of the workloads of r after r was deleted,
+// so that the workloads recreate their pods without the proxy.
+func DeletedPodAnnotationValue(r *cloudsqlapi.AuthProxyWorkload) string {
+	return fmt.Sprintf("%d-deleted-%s", r.Generation, r.GetDeletionTimestamp().Format(time.RFC3339))
+}
+
+// ConfigGeneration returns the generation of r that last changed the proxy
+// configuration. It is the Status.ConfigGeneration recorded by the reconciler
+// when the spec still has the same ConfigHash, and r.Generation otherwise.
+// This way a change to only the workload selector or the rollout strategy
+// does not start a new Staged rollout.
+func ConfigGeneration(r *cloudsqlapi.AuthProxyWorkload) (int64, error) {
+	h, err := ConfigHash(r)
+	if err != nil {
+		return 0, err
 	}
-	k := fmt.Sprintf("%s/%s", cloudsqlapi.AnnotationPrefix, r.Name)
-	v := fmt.Sprintf("%d,%s", r.Generation, img)
-	// if r was deleted, use a different value
-	if !r.GetDeletionTimestamp().IsZero() {
-		v = fmt.Sprintf("%d-deleted-%s,%s", r.Generation, r.GetDeletionTimestamp().Format(time.RFC3339), img)
+	if r.Status.ConfigGeneration != 0 && r.Status.ConfigHash == h {
+		return r.Status.ConfigGeneration, nil
 	}
+	return r.Generation, nil
+}
 
-	return k, v
+// ConfigHash returns a hash of the spec of r without spec.workload,
+// spec.revisionHistoryLimit, spec.authProxyContainer.rolloutStrategy,
+// spec.authProxyContainer.stagedRollout and
+// spec.authProxyContainer.evictRollout.
+func ConfigHash(r *cloudsqlapi.AuthProxyWorkload) (string, error) {
+	s := r.Spec.DeepCopy()
+	s.Workload = cloudsqlapi.WorkloadSelectorSpec{}
+	s.RevisionHistoryLimit = nil
+	if s.AuthProxyContainer != nil {
+		s.AuthProxyContainer.RolloutStrategy = ""
+		s.AuthProxyContainer.StagedRollout = nil
+		s.AuthProxyContainer.EvictRollout = nil
+		if reflect.DeepEqual(s.AuthProxyContainer, &cloudsqlapi.AuthProxyContainerSpec{}) {
+			s.AuthProxyContainer = nil
+		}
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal AuthProxyWorkload spec, %v", err)
+	}
+	h := fnv.New32a()
+	h.Write(b)
+	return fmt.Sprintf("%x", h.Sum32()), nil
 }
 
-// PodAnnotation returns the annotation (key, value) that should be added to
-// pods that are configured with this AuthProxyWorkload resource. This takes
-// into account whether the AuthProxyWorkload exists or was recently deleted.
-func (u *Updater) PodAnnotation(r *cloudsqlapi.AuthProxyWorkload) (string, string) {
-	return PodAnnotation(r, u.defaultProxyImage)
+// SecretVersions holds the resourceVersion of the credentials file Secrets
+// referenced by AuthProxyWorkloads, keyed by the Secret name. The Secrets are
+// always in the same namespace as the workload. A Secret that does not exist
+// has no entry.
+type SecretVersions map[string]string
+
+// ErrMissingCredentialsSecret is returned by Updater.CheckWorkloadContainers
+// when a credentials file Secret referenced by an AuthProxyWorkload does not
+// exist in the workload's namespace.
+var ErrMissingCredentialsSecret = errors.New("credentials file secret not found")
+
+// CredentialsFileSecretName returns the name of the Secret holding the proxy's
+// credentials file, or an empty string when it is not configured.
+func CredentialsFileSecretName(r *cloudsqlapi.AuthProxyWorkload) string {
+	if r.Spec.AuthProxyContainer == nil ||
+		r.Spec.AuthProxyContainer.Authentication == nil ||
+		r.Spec.AuthProxyContainer.Authentication.CredentialsFileSecret == nil {
+		return ""
+	}
+	return r.Spec.AuthProxyContainer.Authentication.CredentialsFileSecret.Name
 }
 
 // Updater holds global state used while reconciling workloads.
@@ -87,14 +169,48 @@ type Updater struct {
 	// userAgent is the userAgent of the operator
 	userAgent string
 
-	// defaultProxyImage is the current default proxy image for the operator
-	defaultProxyImage string
+	// mu protects config, which may be changed while the operator runs.
+	mu sync.RWMutex
+
+	// config holds the current operator defaults for the proxy container.
+	config Config
+
+	// selectors holds the compiled workload selectors of AuthProxyWorkloads.
+	selectors selectorCache
 }
 
 // NewUpdater creates a new instance of Updater with a supplier
 // that loads the default proxy impage from the public docker registry
 func NewUpdater(userAgent string, defaultProxyImage string) *Updater {
-	return &Updater{userAgent: userAgent, defaultProxyImage: defaultProxyImage}
+	c := DefaultConfig()
+	c.ProxyImage = defaultProxyImage
+	return NewUpdaterWithConfig(userAgent, c)
+}
+
+// NewUpdaterWithConfig creates a new instance of Updater that uses the
+// operator defaults in c.
+func NewUpdaterWithConfig(userAgent string, c Config) *Updater {
+	return &Updater{userAgent: userAgent, config: c.DeepCopy()}
+}
+
+// Config returns a copy of the current operator defaults.
+func (u *Updater) Config() Config {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.config.DeepCopy()
+}
+
+// SetConfig replaces the operator defaults, returning true when they changed.
+// Workloads are updated with the new defaults the next time they are
+// reconciled.
+func (u *Updater) SetConfig(c Config) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.config.Equal(c) {
+		return false
+	}
+	u.config = c.DeepCopy()
+	return true
 }
 
 // ConfigError is an error with extra details about why an AuthProxyWorkload
@@ -120,17 +236,14 @@ func (e *ConfigError) Error() string {
 		e.details)
 }
 
-func (e *ConfigError) add(errorCode, description string, p *cloudsqlapi.AuthProxyWorkload) {
-	e.details = append(e.details,
-		ConfigErrorDetail{
-			WorkloadKind:       e.workloadKind,
-			WorkloadName:       e.workloadName,
-			WorkloadNamespace:  e.workloadNamespace,
-			AuthProxyNamespace: p.GetNamespace(),
-			AuthProxyName:      p.GetName(),
-			ErrorCode:          errorCode,
-			Description:        description,
-		})
+// add records the error d of the AuthProxyWorkload p on the workload.
+func (e *ConfigError) add(d ConfigErrorDetail, p *cloudsqlapi.AuthProxyWorkload) {
+	d.WorkloadKind = e.workloadKind
+	d.WorkloadName = e.workloadName
+	d.WorkloadNamespace = e.workloadNamespace
+	d.AuthProxyNamespace = p.GetNamespace()
+	d.AuthProxyName = p.GetName()
+	e.details = append(e.details, d)
 }
 
 // ConfigErrorDetail is an error that contains details about specific kinds of errors that caused
@@ -141,6 +254,12 @@ type ConfigErrorDetail struct {
 	AuthProxyName      string
 	AuthProxyNamespace string
 
+	// Port is the port that is already in use, for a PortConflict error.
+	Port int32
+	// EnvName is the name of the env var that is set more than once, for an
+	// EnvVarConflict error.
+	EnvName string
+
 	WorkloadKind      schema.GroupVersionKind
 	WorkloadName      string
 	WorkloadNamespace string
@@ -165,8 +284,13 @@ var defaultContainerResources = corev1.ResourceRequirements{
 	},
 }
 
-// ConfigurePodProxies finds all AuthProxyWorkload resources matching this workload and then
-// updates the workload's containers. This does not save the updated workload.
+// FindMatchingAuthProxyWorkloads finds all AuthProxyWorkload resources matching
+// this workload or its owners. The list may contain cluster-scoped
+// AuthProxyWorkloads from ClusterAuthProxyWorkloadsForNamespace.
+//
+// Namespaced AuthProxyWorkloads take precedence over ClusterAuthProxyWorkloads.
+// A cluster-scoped AuthProxyWorkload is not returned when a matching namespaced
+// AuthProxyWorkload overrides it, see OverridingAuthProxyWorkload.
 func (u *Updater) FindMatchingAuthProxyWorkloads(pl *cloudsqlapi.AuthProxyWorkloadList, wl *PodWorkload, owners []Workload) []*cloudsqlapi.AuthProxyWorkload {
 
 	// starting with this pod, traverse the pod and its owners, and
@@ -182,28 +306,79 @@ func (u *Updater) FindMatchingAuthProxyWorkloads(pl *cloudsqlapi.AuthProxyWorklo
 	for _, w := range wls {
 		m[w.GetNamespace()+"/"+w.GetName()] = w
 	}
+	var namespaced []*cloudsqlapi.AuthProxyWorkload
+	for _, w := range m {
+		if !w.IsClusterScoped() {
+			namespaced = append(namespaced, w)
+		}
+	}
 	wls = make([]*cloudsqlapi.AuthProxyWorkload, 0, len(m))
 	for _, w := range m {
+		if w.IsClusterScoped() && OverridingAuthProxyWorkload(w, namespaced) != nil {
+			continue
+		}
 		wls = append(wls, w)
 	}
+
+	// Sort the result so that every pod of a workload is configured in the
+	// same order, and gets the same ports.
+	sort.Slice(wls, func(i, j int) bool {
+		if wls[i].Namespace != wls[j].Namespace {
+			return wls[i].Namespace < wls[j].Namespace
+		}
+		return wls[i].Name < wls[j].Name
+	})
 	// if this was updated return matching DBInstances
 	return wls
 }
 
+// OverridingAuthProxyWorkload returns the namespaced AuthProxyWorkload in
+// proxies that takes precedence over the cluster-scoped AuthProxyWorkload p,
+// or nil if there is none. A namespaced AuthProxyWorkload only takes
+// precedence when the two would conflict on the same pod: when they use the
+// same instance, or their proxy containers have the same name.
+func OverridingAuthProxyWorkload(p *cloudsqlapi.AuthProxyWorkload, proxies []*cloudsqlapi.AuthProxyWorkload) *cloudsqlapi.AuthProxyWorkload {
+	if !p.IsClusterScoped() {
+		return nil
+	}
+	for _, o := range proxies {
+		if o.IsClusterScoped() {
+			continue
+		}
+		if ContainerName(o) == ContainerName(p) {
+			return o
+		}
+		for _, inst := range p.Spec.Instances {
+			if slices.ContainsFunc(o.Spec.Instances, func(oi cloudsqlapi.InstanceSpec) bool {
+				return oi.ConnectionString == inst.ConnectionString
+			}) {
+				return o
+			}
+		}
+	}
+	return nil
+}
+
 // filterMatchingInstances returns a list of AuthProxyWorkload whose selectors match
 // the workload.
 func (u *Updater) filterMatchingInstances(pl *cloudsqlapi.AuthProxyWorkloadList, wl client.Object) []*cloudsqlapi.AuthProxyWorkload {
 	matchingAuthProxyWorkloads := make([]*cloudsqlapi.AuthProxyWorkload, 0, len(pl.Items))
 	for i := range pl.Items {
 		p := &pl.Items[i]
-		if workloadMatches(wl, p.Spec.Workload, p.Namespace) {
-			// if this is pending deletion, exclude it.
-			if !p.ObjectMeta.DeletionTimestamp.IsZero() {
-				continue
-			}
+		if !workloadNameMatches(wl, p.Spec.Workload, p.Namespace) {
+			continue
+		}
+		sel, err := u.selectors.get(p)
+		if err != nil || !labelsMatch(wl, sel) {
+			continue
+		}
 
-			matchingAuthProxyWorkloads = append(matchingAuthProxyWorkloads, p)
+		// if this is pending deletion, exclude it.
+		if !p.ObjectMeta.DeletionTimestamp.IsZero() {
+			continue
 		}
+
+		matchingAuthProxyWorkloads = append(matchingAuthProxyWorkloads, p)
 	}
 	return matchingAuthProxyWorkloads
 }
@@ -211,19 +386,33 @@ func (u *Updater) filterMatchingInstances(pl *cloudsqlapi.AuthProxyWorkloadList,
 // CheckWorkloadContainers determines if a pod is configured incorrectly and
 // therefore needs to be deleted. Pods must be (1) missing one or more proxy
 // sidecar containers and (2) have a terminated container.
-func (u *Updater) CheckWorkloadContainers(wl *PodWorkload, matches []*cloudsqlapi.AuthProxyWorkload) error {
+//
+// CheckWorkloadContainers also returns an error wrapping
+// ErrMissingCredentialsSecret when a credentials file Secret is not found in
+// secrets. Deleting the pod will not fix that error.
+func (u *Updater) CheckWorkloadContainers(wl *PodWorkload, matches []*cloudsqlapi.AuthProxyWorkload, secrets SecretVersions) error {
+	for _, p := range matches {
+		name := CredentialsFileSecretName(p)
+		if name == "" {
+			continue
+		}
+		if _, ok := secrets[name]; !ok {
+			return fmt.Errorf("%w: secret %s/%s for AuthProxyWorkload %s",
+				ErrMissingCredentialsSecret, wl.Pod.Namespace, name, p.Name)
+		}
+	}
 
 	// Find the names of all AuthProxyWorkload resources that should have a
-	// container on this pod, but there is no container.
+	// container on this pod, but there is no container. The proxy may be
+	// either a regular container or a native sidecar init container, so that
+	// pods created before a change to SidecarType are not treated as missing
+	// their proxy while the workload is rolled out.
+	podSpec := wl.PodSpec()
 	var missing []string
 	for _, p := range matches {
 		wantName := ContainerName(p)
-		var found bool
-		for _, c := range wl.PodSpec().Containers {
-			if c.Name == wantName {
-				found = true
-			}
-		}
+		found := hasContainer(podSpec.Containers, wantName) ||
+			hasContainer(podSpec.InitContainers, wantName)
 		if !found {
 			missing = append(missing, p.Name)
 			break
@@ -238,26 +427,135 @@ func (u *Updater) CheckWorkloadContainers(wl *PodWorkload, matches []*cloudsqlap
 	missingSidecars := strings.Join(missing, ", ")
 
 	// Some proxy containers are missing. Are the remaining pod containers failing?
-	for _, cs := range wl.Pod.Status.ContainerStatuses {
+	if state := failedState(wl.Pod); state != "" {
+		return fmt.Errorf("pod is in %s state and missing sidecar containers %v", state, missingSidecars)
+	}
+
+	// Pod's other containers are not in an error state. Operator should not
+	// interrupt running containers.
+	return nil
+}
+
+// HasFailedContainers returns true when one of the pod's containers
+// terminated with an error or is in CrashLoopBackOff. CheckWorkloadContainers
+// only reports an error for such a pod.
+func HasFailedContainers(pod *corev1.Pod) bool {
+	return failedState(pod) != ""
+}
+
+// failedState describes the state of the first of the pod's containers that
+// terminated with an error or is in CrashLoopBackOff. It returns an empty
+// string when there is no such container.
+func failedState(pod *corev1.Pod) string {
+	statuses := append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, cs := range statuses {
 		if cs.State.Terminated != nil && cs.State.Terminated.Reason == "Error" {
-			return fmt.Errorf("pod is in an error state and missing sidecar containers %v", missingSidecars)
+			return "an error"
 		}
 		if cs.State.Waiting != nil && cs.State.Waiting.Reason == "CrashLoopBackOff" {
-			return fmt.Errorf("pod is in a CrashLoopBackOff state and missing sidecar containers %v", missingSidecars)
+			return "a CrashLoopBackOff"
 		}
 	}
+	return ""
+}
 
-	// Pod's other containers are not in an error state. Operator should not
-	// interrupt running containers.
-	return nil
+// hasContainer returns true when a container with the name exists in the list.
+func hasContainer(containers []corev1.Container, name string) bool {
+	for i := range containers {
+		if containers[i].Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// removeContainer returns a copy of containers without the container named name.
+func removeContainer(containers []corev1.Container, name string) []corev1.Container {
+	if !hasContainer(containers, name) {
+		return containers
+	}
+	var result []corev1.Container
+	for i := range containers {
+		if containers[i].Name != name {
+			result = append(result, containers[i])
+		}
+	}
+	return result
+}
+
+// replaceContainer replaces the container with the same name as c in
+// containers. It returns false when there is no container with that name.
+func replaceContainer(containers []corev1.Container, c corev1.Container) ([]corev1.Container, bool) {
+	for i := range containers {
+		if containers[i].Name == c.Name {
+			containers[i] = c
+			return containers, true
+		}
+	}
+	return containers, false
+}
+
+// removeStaleProxyContainers returns a copy of containers without the proxy
+// containers whose names are not in proxyNames.
+func removeStaleProxyContainers(containers []corev1.Container, proxyNames map[string]bool) []corev1.Container {
+	var result []corev1.Container
+	for i := range containers {
+		name := containers[i].Name
+		if strings.HasPrefix(name, ContainerPrefix) && !proxyNames[name] {
+			continue
+		}
+		result = append(result, containers[i])
+	}
+	return result
+}
+
+// isNativeSidecar returns true when the proxy for this AuthProxyWorkload
+// should be added as a native sidecar init container.
+func isNativeSidecar(p *cloudsqlapi.AuthProxyWorkload) bool {
+	return p.Spec.AuthProxyContainer != nil &&
+		p.Spec.AuthProxyContainer.SidecarType == cloudsqlapi.SidecarTypeInit
+}
+
+// isAlloyDB returns true when the proxy for this AuthProxyWorkload is the
+// AlloyDB Auth Proxy.
+func isAlloyDB(p *cloudsqlapi.AuthProxyWorkload) bool {
+	return p.Spec.AuthProxyContainer != nil &&
+		p.Spec.AuthProxyContainer.ProxyType == cloudsqlapi.ProxyTypeAlloyDB
+}
+
+// alloyDBEnvNames holds the AlloyDB Auth Proxy env vars whose names differ
+// from the Cloud SQL Auth Proxy env vars by more than the prefix. An empty
+// value means that the AlloyDB Auth Proxy has no equivalent setting.
+var alloyDBEnvNames = map[string]string{
+	"CSQL_PROXY_SQLADMIN_API_ENDPOINT": "ALLOYDB_PROXY_ALLOYDBADMIN_API_ENDPOINT",
+	"CSQL_PROXY_QUOTA_PROJECT":         "",
+}
+
+// proxyEnvName translates the name of a Cloud SQL Auth Proxy env var to the
+// name used by the proxy for this AuthProxyWorkload. Other env vars are not
+// changed.
+func proxyEnvName(p *cloudsqlapi.AuthProxyWorkload, k string) string {
+	if !isAlloyDB(p) || !strings.HasPrefix(k, "CSQL_PROXY_") {
+		return k
+	}
+	if n, ok := alloyDBEnvNames[k]; ok {
+		return n
+	}
+	return "ALLOYDB_PROXY_" + strings.TrimPrefix(k, "CSQL_PROXY_")
 }
 
 // ConfigureWorkload applies the proxy containers from all of the
-// instances listed in matchingAuthProxyWorkloads to the workload
-func (u *Updater) ConfigureWorkload(wl *PodWorkload, matches []*cloudsqlapi.AuthProxyWorkload) error {
+// instances listed in matchingAuthProxyWorkloads to the workload. The secrets
+// hold the versions of the credentials file Secrets used by the matching
+// AuthProxyWorkloads.
+func (u *Updater) ConfigureWorkload(wl *PodWorkload, matches []*cloudsqlapi.AuthProxyWorkload, secrets SecretVersions) error {
+	config := u.Config()
 	state := updateState{
 		updater:    u,
-		nextDBPort: DefaultFirstPort,
+		config:     config,
+		secrets:    secrets,
+		nextDBPort: config.FirstPort,
 		err: ConfigError{
 			workloadKind:      wl.Object().GetObjectKind().GroupVersionKind(),
 			workloadName:      wl.Object().GetName(),
@@ -299,6 +597,15 @@ type updateState struct {
 	mods       workloadMods
 	nextDBPort int32
 	updater    *Updater
+	config     Config
+	secrets    SecretVersions
+
+	// ports is the port allocation recorded on the workload before this
+	// update. reservedPorts are the ports that the operator must not choose
+	// for a new instance because they are set in an InstanceSpec or were
+	// allocated to an existing instance.
+	ports         PortAllocation
+	reservedPorts map[int32]bool
 }
 
 // workloadMods holds all modifications to this workload done by the operator so
@@ -307,8 +614,10 @@ type workloadMods struct {
 	DBInstances  []*proxyInstanceID `json:"dbInstances"`
 	EnvVars      []*managedEnvVar   `json:"envVars"`
 	VolumeMounts []*managedVolume   `json:"volumeMounts"`
-	Ports        []*managedPort     `json:"ports"`
-	AdminPorts   []int32            `json:"adminPorts"`
+	// CredentialVolumes are only mounted in the proxy containers.
+	CredentialVolumes []corev1.Volume `json:"credentialVolumes"`
+	Ports             []*managedPort  `json:"ports"`
+	AdminPorts        []int32         `json:"adminPorts"`
 }
 
 func (s *updateState) addWorkloadPort(p int32) {
@@ -328,6 +637,31 @@ func (s *updateState) addProxyPort(port int32, p *cloudsqlapi.AuthProxyWorkload)
 	})
 }
 
+// reservePorts reserves the ports that are set on the instances of the
+// AuthProxyWorkloads, and the ports that were allocated to the instances
+// before, so that a new instance does not take them.
+func (s *updateState) reservePorts(pl []*cloudsqlapi.AuthProxyWorkload) {
+	s.reservedPorts = map[int32]bool{}
+	for _, p := range pl {
+		for _, is := range p.Spec.Instances {
+			if is.UnixSocketPath != "" {
+				continue
+			}
+			if is.Port != nil {
+				s.reservedPorts[*is.Port] = true
+			} else if port, ok := s.ports.Port(p, is.ConnectionString); ok {
+				s.reservedPorts[port] = true
+			}
+		}
+	}
+}
+
+// isPortAvailable checks if the operator may choose the port for a new
+// instance or proxy.
+func (s *updateState) isPortAvailable(p int32) bool {
+	return !s.isPortInUse(p) && !s.reservedPorts[p]
+}
+
 // isPortInUse checks if the port is in use.
 func (s *updateState) isPortInUse(p int32) bool {
 	for i := 0; i < len(s.mods.Ports); i++ {
@@ -358,30 +692,29 @@ func (s *updateState) useInstancePort(p *cloudsqlapi.AuthProxyWorkload, is *clou
 	if proxyPort != nil {
 		if is.Port != nil && proxyPort.Port != *is.Port {
 			if s.isPortInUse(*is.Port) {
-				s.addError(cloudsqlapi.ErrorCodePortConflict,
-					fmt.Sprintf("proxy port %d for instance %s is already in use",
-						*is.Port, is.ConnectionString), p)
+				s.addPortConflict(*is.Port, is, p)
 			}
 			proxyPort.Port = *is.Port
 		}
 		return proxyPort.Port
 	}
 
-	// Since this is a new workload+instance, figure out the port number
+	// Since this is a new workload+instance, figure out the port number. Keep
+	// the port that was allocated to this instance before, if it is still free.
 	var port int32
 	if is.Port != nil {
 		port = *is.Port
+	} else if prev, ok := s.ports.Port(p, is.ConnectionString); ok && !s.isPortInUse(prev) {
+		port = prev
 	} else {
-		for s.isPortInUse(s.nextDBPort) {
+		for !s.isPortAvailable(s.nextDBPort) {
 			s.nextDBPort++
 		}
 		port = s.nextDBPort
 	}
 
 	if s.isPortInUse(port) {
-		s.addError(cloudsqlapi.ErrorCodePortConflict,
-			fmt.Sprintf("proxy port %d for instance %s is already in use",
-				port, is.ConnectionString), p)
+		s.addPortConflict(port, is, p)
 	}
 
 	s.addPort(port, proxyInstanceID{
@@ -431,7 +764,32 @@ func (s *updateState) addPort(p int32, instance proxyInstanceID) {
 	}
 }
 
+// addDefaultEnvVars adds the env vars from the operator defaults to the proxy
+// container, unless the operator already set an env var with the same name.
+func (s *updateState) addDefaultEnvVars(p *cloudsqlapi.AuthProxyWorkload) {
+	containerName := ContainerName(p)
+	for _, ev := range s.config.Env {
+		var found bool
+		name := proxyEnvName(p, ev.Name)
+		for _, v := range s.mods.EnvVars {
+			if (v.ContainerName == containerName || v.ContainerName == "") &&
+				v.OperatorManagedValue.Name == name {
+				found = true
+			}
+		}
+		if !found {
+			s.addProxyContainerEnvVar(p, ev.Name, ev.Value)
+		}
+	}
+}
+
+// addProxyContainerEnvVar adds a Cloud SQL Auth Proxy env var to the proxy
+// container, translating its name when the proxy is the AlloyDB Auth Proxy.
 func (s *updateState) addProxyContainerEnvVar(p *cloudsqlapi.AuthProxyWorkload, k, v string) {
+	k = proxyEnvName(p, k)
+	if k == "" {
+		return
+	}
 	s.addEnvVar(p, managedEnvVar{
 		Instance: proxyInstanceID{
 			AuthProxyWorkload: types.NamespacedName{
@@ -462,10 +820,12 @@ func (s *updateState) addEnvVar(p *cloudsqlapi.AuthProxyWorkload, v managedEnvVa
 		oldEnv := s.mods.EnvVars[i]
 		// if the values don't match and either one is global, or its set twice
 		if isEnvVarConflict(oldEnv, v) {
-			s.addError(cloudsqlapi.ErrorCodeEnvConflict,
-				fmt.Sprintf("environment variable named %s is set more than once",
+			s.err.add(ConfigErrorDetail{
+				ErrorCode: cloudsqlapi.ErrorCodeEnvConflict,
+				Description: fmt.Sprintf("environment variable named %s is set more than once",
 					oldEnv.OperatorManagedValue.Name),
-				p)
+				EnvName: oldEnv.OperatorManagedValue.Name,
+			}, p)
 			return
 		}
 	}
@@ -514,6 +874,7 @@ func (s *updateState) update(wl *PodWorkload, matches []*cloudsqlapi.AuthProxyWo
 	s.initState(matches)
 	podSpec := wl.PodSpec()
 	containers := podSpec.Containers
+	initContainers := podSpec.InitContainers
 
 	var nonAuthProxyContainers []corev1.Container
 	for i := 0; i < len(containers); i++ {
@@ -534,35 +895,133 @@ func (s *updateState) update(wl *PodWorkload, matches []*cloudsqlapi.AuthProxyWo
 	for k, v := range wl.PodTemplateAnnotations() {
 		ann[k] = v
 	}
+	s.ports = PortAllocationFromAnnotations(ann)
+	s.reservePorts(matches)
+
+	// Remove the proxy containers of AuthProxyWorkloads that no longer match.
+	proxyNames := make(map[string]bool, len(matches))
+	for _, inst := range matches {
+		proxyNames[ContainerName(inst)] = true
+	}
+	containers = removeStaleProxyContainers(containers, proxyNames)
+	initContainers = removeStaleProxyContainers(initContainers, proxyNames)
 
-	// add all new containers and update existing containers
+	// Add new proxy containers, and replace existing proxy containers in
+	// place, so that applying the same configuration again changes nothing.
+	var sidecars []corev1.Container
 	for i := range matches {
 		inst := matches[i]
 
 		newContainer := corev1.Container{}
 		s.updateContainer(inst, &newContainer)
-		containers = append(containers, newContainer)
 
-		// Add pod annotation for each instance
-		k, v := s.updater.PodAnnotation(inst)
-		ann[k] = v
+		// A proxy container may have been moved between the pod's containers
+		// and init containers when SidecarType changed. Remove it from the
+		// other list so that the pod never has two containers with the same name.
+		if isNativeSidecar(inst) {
+			always := corev1.ContainerRestartPolicyAlways
+			newContainer.RestartPolicy = &always
+			containers = removeContainer(containers, newContainer.Name)
+			var replaced bool
+			initContainers, replaced = replaceContainer(initContainers, newContainer)
+			if !replaced {
+				sidecars = append(sidecars, newContainer)
+			}
+		} else {
+			initContainers = removeContainer(initContainers, newContainer.Name)
+			var replaced bool
+			containers, replaced = replaceContainer(containers, newContainer)
+			if !replaced {
+				containers = append(containers, newContainer)
+			}
+		}
 	}
 	// Add the envvar containing the proxy quit urls to the workloads
 	s.addQuitEnvVar()
 
+	// Record the instance ports, so that they are kept the next time the
+	// workload is configured.
+	ports := PortAllocation{}
+	for _, mp := range s.mods.Ports {
+		if mp.Instance.ConnectionString != "" {
+			ports.set(mp.Instance, mp.Port)
+		}
+	}
+	if len(ports) > 0 {
+		v, err := ports.annotationValue()
+		if err != nil {
+			return err
+		}
+		ann[PortsAnnotation] = v
+	} else {
+		delete(ann, PortsAnnotation)
+	}
+
 	podSpec.Containers = containers
 
-	if len(ann) != 0 {
-		wl.SetPodTemplateAnnotations(ann)
-	}
+	// Native sidecars go first in the list of init containers so that they
+	// are started before the workload's own init containers.
+	podSpec.InitContainers = append(sidecars, initContainers...)
+
+	// The env vars that the operator added to the workload's containers the
+	// last time the pod was configured. The ones that no longer apply are
+	// removed.
+	oldEnv := envNamesFromAnnotations(ann)
+	env := map[string]bool{}
 
 	for i := range podSpec.Containers {
 		c := &podSpec.Containers[i]
-		s.updateContainerEnv(c)
+		s.updateContainerEnv(c, oldEnv, env, func(v *managedEnvVar) bool {
+			return v.ContainerName == c.Name || v.ContainerName == ""
+		})
 		s.applyContainerVolumes(c)
 	}
+
+	// When a proxy runs as a native sidecar, the init containers that start
+	// after it may also connect to the database, so they get the env vars and
+	// volume mounts of that proxy. The init containers that start before it
+	// don't. A native sidecar only gets its own proxy's configuration.
+	sidecarProxies := map[string]types.NamespacedName{}
+	for _, inst := range matches {
+		if isNativeSidecar(inst) {
+			sidecarProxies[ContainerName(inst)] = types.NamespacedName{Namespace: inst.Namespace, Name: inst.Name}
+		}
+	}
+	started := map[types.NamespacedName]bool{}
+	for i := range podSpec.InitContainers {
+		c := &podSpec.InitContainers[i]
+		proxies := started
+		if id, ok := sidecarProxies[c.Name]; ok {
+			started[id] = true
+			proxies = map[types.NamespacedName]bool{id: true}
+		}
+		s.updateContainerEnv(c, oldEnv, env, func(v *managedEnvVar) bool {
+			return v.ContainerName == c.Name ||
+				(v.ContainerName == "" && proxies[v.Instance.AuthProxyWorkload])
+		})
+		s.applyInitContainerVolumes(c, proxies)
+	}
 	s.applyVolumes(&podSpec)
 
+	if len(env) > 0 {
+		ann[EnvAnnotation] = envAnnotationValue(env)
+	} else {
+		delete(ann, EnvAnnotation)
+	}
+
+	// Add the pod annotation for each instance, holding the hash of the
+	// proxy configuration applied to the pod.
+	for _, inst := range matches {
+		h, err := s.proxyConfigHash(inst, &podSpec)
+		if err != nil {
+			return err
+		}
+		ann[PodAnnotationKey(inst)] = h
+	}
+	if len(ann) != 0 || len(wl.PodTemplateAnnotations()) != 0 {
+		wl.SetPodTemplateAnnotations(ann)
+	}
+
 	// only return ConfigError if there were reported
 	// errors during processing.
 	if len(s.err.details) > 0 {
@@ -574,6 +1033,51 @@ func (s *updateState) update(wl *PodWorkload, matches []*cloudsqlapi.AuthProxyWo
 	return nil
 }
 
+// proxyConfigHash returns a hash of the proxy configuration applied to the
+// pod for the AuthProxyWorkload p: its proxy container, the env vars and
+// volumes that the operator added to the pod's containers for p, and the
+// version of p's credentials file Secret. A change to p or to the operator
+// defaults that renders the same configuration has the same hash.
+func (s *updateState) proxyConfigHash(p *cloudsqlapi.AuthProxyWorkload, ps *corev1.PodSpec) (string, error) {
+	id := types.NamespacedName{Namespace: p.Namespace, Name: p.Name}
+	name := ContainerName(p)
+	state := struct {
+		Container     *corev1.Container `json:"container"`
+		EnvVars       []*managedEnvVar  `json:"envVars"`
+		VolumeMounts  []*managedVolume  `json:"volumeMounts"`
+		SecretVersion string            `json:"secretVersion,omitempty"`
+	}{}
+	for _, cs := range [][]corev1.Container{ps.Containers, ps.InitContainers} {
+		for i := range cs {
+			if cs[i].Name == name {
+				state.Container = &cs[i]
+			}
+		}
+	}
+	// The env vars of the proxy container are already in the container.
+	for _, ev := range s.mods.EnvVars {
+		if ev.Instance.AuthProxyWorkload == id && ev.ContainerName != name {
+			state.EnvVars = append(state.EnvVars, ev)
+		}
+	}
+	for _, vm := range s.mods.VolumeMounts {
+		if vm.Instance.AuthProxyWorkload == id {
+			state.VolumeMounts = append(state.VolumeMounts, vm)
+		}
+	}
+	if secret := CredentialsFileSecretName(p); secret != "" {
+		state.SecretVersion = s.secrets[secret]
+	}
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal proxy configuration, %v", err)
+	}
+	h := fnv.New64a()
+	h.Write(b)
+	return fmt.Sprintf("%x", h.Sum64()), nil
+}
+
 // updateContainer Creates or updates the proxy container in the workload's PodSpec
 func (s *updateState) updateContainer(p *cloudsqlapi.AuthProxyWorkload, c *corev1.Container) {
 	// if the c was fully overridden, just use that c.
@@ -591,7 +1095,7 @@ func (s *updateState) updateContainer(p *cloudsqlapi.AuthProxyWorkload, c *corev
 	s.addAdminServer(p)
 
 	// configure container authentication
-	s.addAuthentication(p)
+	s.addAuthentication(p, c)
 
 	// add the user agent
 	s.addProxyContainerEnvVar(p, "CSQL_PROXY_USER_AGENT", s.updater.userAgent)
@@ -703,6 +1207,9 @@ func (s *updateState) updateContainer(p *cloudsqlapi.AuthProxyWorkload, c *corev
 
 	}
 	c.Args = cliArgs
+
+	// add the operator's default env vars
+	s.addDefaultEnvVars(p)
 }
 
 // applyContainerSpec applies settings from cloudsqlapi.AuthProxyContainerSpec
@@ -710,11 +1217,11 @@ func (s *updateState) updateContainer(p *cloudsqlapi.AuthProxyWorkload, c *corev
 func (s *updateState) applyContainerSpec(p *cloudsqlapi.AuthProxyWorkload, c *corev1.Container) {
 	t := true
 	var f bool
-	c.Image = s.defaultProxyImage()
-	c.Resources = defaultContainerResources
+	c.Image = s.config.image(p)
+	c.Resources = *s.config.Resources.DeepCopy()
 	c.SecurityContext = &corev1.SecurityContext{
-		// The default Cloud SQL Auth Proxy image runs as the
-		// "nonroot" user and group (uid: 65532) by default.
+		// The default Cloud SQL Auth Proxy and AlloyDB Auth Proxy images run
+		// as the "nonroot" user and group (uid: 65532) by default.
 		RunAsNonRoot: &t,
 		// Use a read-only filesystem
 		ReadOnlyRootFilesystem: &t,
@@ -784,18 +1291,40 @@ func (s *updateState) applyTelemetrySpec(p *cloudsqlapi.AuthProxyWorkload) {
 	return
 }
 
-// updateContainerEnv applies global container state to all containers
-func (s *updateState) updateContainerEnv(c *corev1.Container) {
+// updateContainerEnv applies the env vars for which applies returns true to
+// the container c. On the workload's own containers, it removes the env vars
+// named in oldEnv that no longer apply, and adds the names of the env vars it
+// set to env.
+func (s *updateState) updateContainerEnv(c *corev1.Container, oldEnv, env map[string]bool, applies func(v *managedEnvVar) bool) {
+	isProxy := strings.HasPrefix(c.Name, ContainerPrefix)
+	want := map[string]bool{}
+	for _, v := range s.mods.EnvVars {
+		if applies(v) {
+			want[v.OperatorManagedValue.Name] = true
+		}
+	}
+	if !isProxy {
+		var kept []corev1.EnvVar
+		for _, ev := range c.Env {
+			if oldEnv[ev.Name] && !want[ev.Name] {
+				continue
+			}
+			kept = append(kept, ev)
+		}
+		c.Env = kept
+	}
+
 	for i := 0; i < len(s.mods.EnvVars); i++ {
 		var found bool
 		v := s.mods.EnvVars[i]
 		operatorEnv := v.OperatorManagedValue
 
-		// If this EnvVar is not for this container and not for all containers
-		// don't add it to this container.
-		if v.ContainerName != c.Name && v.ContainerName != "" {
+		if !applies(v) {
 			continue
 		}
+		if !isProxy {
+			env[operatorEnv.Name] = true
+		}
 
 		for j := 0; j < len(c.Env); j++ {
 			if operatorEnv.Name == c.Env[j].Name {
@@ -810,6 +1339,29 @@ func (s *updateState) updateContainerEnv(c *corev1.Container) {
 
 }
 
+// envNamesFromAnnotations reads the names of the env vars recorded in
+// EnvAnnotation.
+func envNamesFromAnnotations(an map[string]string) map[string]bool {
+	names := map[string]bool{}
+	for _, n := range strings.Split(an[EnvAnnotation], ",") {
+		if n != "" {
+			names[n] = true
+		}
+	}
+	return names
+}
+
+// envAnnotationValue returns the value for EnvAnnotation. The names are
+// sorted, so the value is the same for the same env vars.
+func envAnnotationValue(env map[string]bool) string {
+	names := make([]string, 0, len(env))
+	for n := range env {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
 // addHealthCheck adds the health check declaration to this workload.
 func (s *updateState) addHealthCheck(p *cloudsqlapi.AuthProxyWorkload, c *corev1.Container) int32 {
 	var portPtr *int32
@@ -824,7 +1376,7 @@ func (s *updateState) addHealthCheck(p *cloudsqlapi.AuthProxyWorkload, c *corev1
 		}
 	}
 
-	port := s.usePort(portPtr, DefaultHealthCheckPort, p)
+	port := s.usePort(portPtr, s.config.HealthCheckPort, p)
 
 	c.StartupProbe = &corev1.Probe{
 		ProbeHandler: corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{
@@ -866,7 +1418,7 @@ func (s *updateState) addHealthCheck(p *cloudsqlapi.AuthProxyWorkload, c *corev1
 	if cs != nil && cs.AdminServer != nil && cs.AdminServer.Port != 0 {
 		adminPortPtr = &cs.AdminServer.Port
 	}
-	adminPort := s.usePort(adminPortPtr, DefaultAdminPort, p)
+	adminPort := s.usePort(adminPortPtr, s.config.AdminPort, p)
 	s.addAdminPort(adminPort)
 	s.addProxyContainerEnvVar(p, "CSQL_PROXY_QUITQUITQUIT", "true")
 	s.addProxyContainerEnvVar(p, "CSQL_PROXY_ADMIN_PORT", fmt.Sprintf("%d", adminPort))
@@ -900,7 +1452,7 @@ func (s *updateState) addAdminServer(p *cloudsqlapi.AuthProxyWorkload) {
 
 }
 
-func (s *updateState) addAuthentication(p *cloudsqlapi.AuthProxyWorkload) {
+func (s *updateState) addAuthentication(p *cloudsqlapi.AuthProxyWorkload, c *corev1.Container) {
 	if p.Spec.AuthProxyContainer == nil || p.Spec.AuthProxyContainer.Authentication == nil {
 		return
 	}
@@ -909,6 +1461,25 @@ func (s *updateState) addAuthentication(p *cloudsqlapi.AuthProxyWorkload) {
 		s.addProxyContainerEnvVar(p, "CSQL_PROXY_IMPERSONATE_SERVICE_ACCOUNT", strings.Join(as.ImpersonationChain, ","))
 	}
 
+	if ref := as.CredentialsFileSecret; ref != nil {
+		// Mount only the credentials file, read-only, and only in the proxy
+		// container.
+		name := CredentialsVolumeName(p)
+		mountPath := path.Join(CredentialsMountDir, name)
+		c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{
+			Name:      name,
+			ReadOnly:  true,
+			MountPath: mountPath,
+		})
+		s.mods.CredentialVolumes = append(s.mods.CredentialVolumes, corev1.Volume{
+			Name: name,
+			VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{
+				SecretName: ref.Name,
+				Items:      []corev1.KeyToPath{{Key: ref.Key, Path: ref.Key}},
+			}},
+		})
+		s.addProxyContainerEnvVar(p, "CSQL_PROXY_CREDENTIALS_FILE", path.Join(mountPath, ref.Key))
+	}
 }
 
 func (s *updateState) addVolumeMount(p *cloudsqlapi.AuthProxyWorkload, is *cloudsqlapi.InstanceSpec, m corev1.VolumeMount, v corev1.Volume) {
@@ -950,6 +1521,42 @@ func (s *updateState) applyContainerVolumes(c *corev1.Container) {
 	c.VolumeMounts = applyVolumeThings[corev1.VolumeMount](s, c.VolumeMounts, nameAccessor, thingAccessor)
 }
 
+// applyInitContainerVolumes applies the VolumeMounts of the proxies in
+// started to the init container c, and removes the other proxy VolumeMounts
+// from c.
+func (s *updateState) applyInitContainerVolumes(c *corev1.Container, started map[types.NamespacedName]bool) {
+	want := map[string]bool{}
+	for _, v := range s.mods.VolumeMounts {
+		if started[v.Instance.AuthProxyWorkload] {
+			want[v.VolumeMount.Name] = true
+		}
+	}
+	var mounts []corev1.VolumeMount
+	for _, m := range c.VolumeMounts {
+		if strings.HasPrefix(m.Name, ContainerPrefix) && !want[m.Name] {
+			continue
+		}
+		mounts = append(mounts, m)
+	}
+
+	for _, v := range s.mods.VolumeMounts {
+		if !started[v.Instance.AuthProxyWorkload] {
+			continue
+		}
+		var found bool
+		for j := range mounts {
+			if mounts[j].Name == v.VolumeMount.Name {
+				found = true
+				mounts[j] = v.VolumeMount
+			}
+		}
+		if !found {
+			mounts = append(mounts, v.VolumeMount)
+		}
+	}
+	c.VolumeMounts = mounts
+}
+
 // applyVolumes applies all volumes to this PodSpec.
 func (s *updateState) applyVolumes(ps *corev1.PodSpec) {
 	nameAccessor := func(v corev1.Volume) string {
@@ -959,6 +1566,19 @@ func (s *updateState) applyVolumes(ps *corev1.PodSpec) {
 		return v.Volume
 	}
 	ps.Volumes = applyVolumeThings[corev1.Volume](s, ps.Volumes, nameAccessor, thingAccessor)
+
+	for _, v := range s.mods.CredentialVolumes {
+		var found bool
+		for i := range ps.Volumes {
+			if ps.Volumes[i].Name == v.Name {
+				ps.Volumes[i] = v
+				found = true
+			}
+		}
+		if !found {
+			ps.Volumes = append(ps.Volumes, v)
+		}
+	}
 }
 
 // applyVolumeThings modifies a slice of Volume/VolumeMount, to include all the
@@ -970,6 +1590,8 @@ func applyVolumeThings[T corev1.VolumeMount | corev1.Volume](
 	nameAccessor func(T) string,
 	thingAccessor func(*managedVolume) T) []T {
 
+	newVols = removeStaleVolumeThings(s, newVols, nameAccessor)
+
 	// add or replace items for all new volume mounts
 	for i := 0; i < len(s.mods.VolumeMounts); i++ {
 		var found bool
@@ -987,12 +1609,47 @@ func applyVolumeThings[T corev1.VolumeMount | corev1.Volume](
 	return newVols
 }
 
+// removeStaleVolumeThings returns a copy of the Volume/VolumeMount slice without
+// the proxy volumes that are not used by the current configuration, such as
+// the volumes of an AuthProxyWorkload that was deleted.
+func removeStaleVolumeThings[T corev1.VolumeMount | corev1.Volume](
+	s *updateState,
+	vols []T,
+	nameAccessor func(T) string) []T {
+
+	inUse := map[string]bool{}
+	for _, v := range s.mods.VolumeMounts {
+		inUse[v.Volume.Name] = true
+		inUse[v.VolumeMount.Name] = true
+	}
+	for _, v := range s.mods.CredentialVolumes {
+		inUse[v.Name] = true
+	}
+
+	var result []T
+	for _, v := range vols {
+		name := nameAccessor(v)
+		if strings.HasPrefix(name, ContainerPrefix) && !inUse[name] {
+			continue
+		}
+		result = append(result, v)
+	}
+	return result
+}
+
 func (s *updateState) addError(errorCode, description string, p *cloudsqlapi.AuthProxyWorkload) {
-	s.err.add(errorCode, description, p)
+	s.err.add(ConfigErrorDetail{ErrorCode: errorCode, Description: description}, p)
 }
 
-func (s *updateState) defaultProxyImage() string {
-	return s.updater.defaultProxyImage
+// addPortConflict records that port, the port of the instance is of the
+// AuthProxyWorkload p, is already in use.
+func (s *updateState) addPortConflict(port int32, is *cloudsqlapi.InstanceSpec, p *cloudsqlapi.AuthProxyWorkload) {
+	s.err.add(ConfigErrorDetail{
+		ErrorCode: cloudsqlapi.ErrorCodePortConflict,
+		Description: fmt.Sprintf("proxy port %d for instance %s is already in use",
+			port, is.ConnectionString),
+		Port: port,
+	}, p)
 }
 
 func (s *updateState) usePort(configValue *int32, defaultValue int32, p *cloudsqlapi.AuthProxyWorkload) int32 {
@@ -1003,7 +1660,7 @@ func (s *updateState) usePort(configValue *int32, defaultValue int32, p *cloudsq
 
 	port := defaultValue
 	if configValue == nil {
-		for s.isPortInUse(port) {
+		for !s.isPortAvailable(port) {
 			port++
 		}
 	}
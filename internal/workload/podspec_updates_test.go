@@ -15,8 +15,11 @@
 package workload_test
 
 import (
+	"errors"
 	"fmt"
+	"math/rand"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
@@ -27,6 +30,8 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/yaml"
 )
 
@@ -129,7 +134,7 @@ func configureProxies(u *workload.Updater, wl *workload.PodWorkload, proxies []*
 		l.Items[i] = *proxies[i]
 	}
 	apws := u.FindMatchingAuthProxyWorkloads(l, wl, nil)
-	err := u.ConfigureWorkload(wl, apws)
+	err := u.ConfigureWorkload(wl, apws, nil)
 	return err
 }
 
@@ -887,10 +892,8 @@ func TestPodTemplateAnnotations(t *testing.T) {
 	var (
 		now = metav1.Now()
 
-		wantAnnotations = map[string]string{
-			"cloudsql.cloud.google.com/instance1": "1," + workload.DefaultProxyImage,
-			"cloudsql.cloud.google.com/instance2": "2," + workload.DefaultProxyImage,
-		}
+		wantPorts = `{"default/instance1":{"project:server:db":5000},` +
+			`"default/instance2":{"project:server2:db2":5001}}`
 
 		u = workload.NewUpdater("cloud-sql-proxy-operator/dev", workload.DefaultProxyImage)
 	)
@@ -918,10 +921,23 @@ func TestPodTemplateAnnotations(t *testing.T) {
 	}
 
 	// test that annotation was set properly
-	if !reflect.DeepEqual(wl.PodTemplateAnnotations(), wantAnnotations) {
-		t.Errorf("got %v, want %v for proxy container command", wl.PodTemplateAnnotations(), wantAnnotations)
+	an := wl.PodTemplateAnnotations()
+	if got := an[workload.PortsAnnotation]; got != wantPorts {
+		t.Errorf("got %v, want %v for ports annotation", got, wantPorts)
+	}
+	v1, v2 := an["cloudsql.cloud.google.com/instance1"], an["cloudsql.cloud.google.com/instance2"]
+	if v1 == "" || v2 == "" || v1 == v2 {
+		t.Errorf("got %q and %q, want a different hash for each proxy", v1, v2)
+	}
+	if v, ok := an["cloudsql.cloud.google.com/instance3"]; ok {
+		t.Errorf("got %v, want no annotation for the deleted proxy", v)
+	}
+	if got, want := an[workload.EnvAnnotation], "CSQL_PROXY_QUIT_URLS"; got != want {
+		t.Errorf("got %q, want %q for env annotation", got, want)
+	}
+	if want, got := 4, len(an); want != got {
+		t.Errorf("got %v, want %v annotations", got, want)
 	}
-
 }
 
 func TestTelemetryAddsTelemetryContainerPort(t *testing.T) {
@@ -1074,38 +1090,207 @@ func TestPreStopHook(t *testing.T) {
 	}
 }
 
-func TestPodAnnotation(t *testing.T) {
+func TestPodAnnotationKey(t *testing.T) {
 	now := metav1.Now()
 	server := &cloudsqlapi.AuthProxyWorkload{ObjectMeta: metav1.ObjectMeta{Name: "instance1", Generation: 1}}
 	deletedServer := &cloudsqlapi.AuthProxyWorkload{ObjectMeta: metav1.ObjectMeta{Name: "instance2", Generation: 2, DeletionTimestamp: &now}}
+	clusterServer := (&cloudsqlapi.ClusterAuthProxyWorkload{ObjectMeta: metav1.ObjectMeta{Name: "instance1", Generation: 3}}).AuthProxyWorkload()
 
 	var testcases = []struct {
 		name  string
 		r     *cloudsqlapi.AuthProxyWorkload
 		wantK string
-		wantV string
 	}{
 		{
 			name:  "instance1",
 			r:     server,
 			wantK: "cloudsql.cloud.google.com/instance1",
-			wantV: fmt.Sprintf("1,%s", workload.DefaultProxyImage),
 		}, {
 			name:  "instance2",
 			r:     deletedServer,
 			wantK: "cloudsql.cloud.google.com/instance2",
-			wantV: fmt.Sprintf("2-deleted-%s,%s", now.Format(time.RFC3339), workload.DefaultProxyImage),
+		}, {
+			name:  "cluster instance1",
+			r:     clusterServer,
+			wantK: "cluster.cloudsql.cloud.google.com/instance1",
 		},
 	}
 
 	for _, tc := range testcases {
-		gotK, gotV := workload.PodAnnotation(tc.r, workload.DefaultProxyImage)
-		if tc.wantK != gotK {
+		if gotK := workload.PodAnnotationKey(tc.r); tc.wantK != gotK {
 			t.Errorf("got %v, want %v for key", gotK, tc.wantK)
 		}
-		if tc.wantV != gotV {
-			t.Errorf("got %v, want %v for value", gotV, tc.wantV)
+	}
+
+	wantV := fmt.Sprintf("2-deleted-%s", now.Format(time.RFC3339))
+	if gotV := workload.DeletedPodAnnotationValue(deletedServer); gotV != wantV {
+		t.Errorf("got %v, want %v for deleted value", gotV, wantV)
+	}
+}
+
+func TestPodAnnotationHash(t *testing.T) {
+	u := workload.NewUpdater("cloud-sql-proxy-operator/dev", workload.DefaultProxyImage)
+	p := simpleAuthProxy("instance1", "project:server:db")
+	p.Generation = 1
+
+	annotation := func(p *cloudsqlapi.AuthProxyWorkload) string {
+		t.Helper()
+		wl := podWorkload()
+		err := configureProxies(u, wl, []*cloudsqlapi.AuthProxyWorkload{p})
+		if err != nil {
+			t.Fatal(err)
 		}
+		return wl.Pod.Annotations[workload.PodAnnotationKey(p)]
+	}
+	want := annotation(p)
+	if want == "" {
+		t.Fatal("got no pod annotation, want the hash of the proxy configuration")
+	}
+
+	var testcases = []struct {
+		name     string
+		update   func(p *cloudsqlapi.AuthProxyWorkload)
+		wantSame bool
+	}{
+		{
+			name:     "new generation without spec changes",
+			update:   func(p *cloudsqlapi.AuthProxyWorkload) { p.Generation = 2 },
+			wantSame: true,
+		},
+		{
+			name: "image set to the default image",
+			update: func(p *cloudsqlapi.AuthProxyWorkload) {
+				p.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{Image: workload.DefaultProxyImage}
+			},
+			wantSame: true,
+		},
+		{
+			name: "rollout strategy changed",
+			update: func(p *cloudsqlapi.AuthProxyWorkload) {
+				p.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{RolloutStrategy: cloudsqlapi.NoneStrategy}
+			},
+			wantSame: true,
+		},
+		{
+			name: "image changed",
+			update: func(p *cloudsqlapi.AuthProxyWorkload) {
+				p.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{Image: "example.com/proxy:1.0"}
+			},
+		},
+		{
+			name:   "instance port changed",
+			update: func(p *cloudsqlapi.AuthProxyWorkload) { p.Spec.Instances[0].Port = ptr(int32(5002)) },
+		},
+		{
+			name: "env var added to the application container",
+			update: func(p *cloudsqlapi.AuthProxyWorkload) {
+				p.Spec.Instances[0].PortEnvName = "DB_PORT"
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := p.DeepCopy()
+			tc.update(c)
+			got := annotation(c)
+			if tc.wantSame && got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			if !tc.wantSame && got == want {
+				t.Errorf("got %v, want the pod annotation to change", got)
+			}
+		})
+	}
+}
+
+func TestConfigGeneration(t *testing.T) {
+	p := simpleAuthProxy("instance1", "project:server:db")
+	p.Generation = 1
+	p.Status.ConfigGeneration = 1
+	h, err := workload.ConfigHash(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Status.ConfigHash = h
+
+	var testcases = []struct {
+		name   string
+		change func(p *cloudsqlapi.AuthProxyWorkload)
+		want   int64
+	}{
+		{
+			name: "workload selector changed",
+			change: func(p *cloudsqlapi.AuthProxyWorkload) {
+				p.Spec.Workload = cloudsqlapi.WorkloadSelectorSpec{Kind: "StatefulSet", Name: "other"}
+			},
+			want: 1,
+		},
+		{
+			name: "rollout strategy changed",
+			change: func(p *cloudsqlapi.AuthProxyWorkload) {
+				p.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{RolloutStrategy: cloudsqlapi.NoneStrategy}
+			},
+			want: 1,
+		},
+		{
+			name: "evict rollout settings changed",
+			change: func(p *cloudsqlapi.AuthProxyWorkload) {
+				mu := intstr.FromInt32(2)
+				p.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{
+					RolloutStrategy: cloudsqlapi.EvictStrategy,
+					EvictRollout:    &cloudsqlapi.EvictRolloutSpec{MaxUnavailable: &mu},
+				}
+			},
+			want: 1,
+		},
+		{
+			name: "revision history limit changed",
+			change: func(p *cloudsqlapi.AuthProxyWorkload) {
+				limit := int32(3)
+				p.Spec.RevisionHistoryLimit = &limit
+			},
+			want: 1,
+		},
+		{
+			name: "instances changed",
+			change: func(p *cloudsqlapi.AuthProxyWorkload) {
+				p.Spec.Instances[0].ConnectionString = "project:server:db2"
+			},
+			want: 2,
+		},
+		{
+			name: "container changed",
+			change: func(p *cloudsqlapi.AuthProxyWorkload) {
+				p.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{
+					RolloutStrategy: cloudsqlapi.WorkloadStrategy,
+					Image:           "example.com/proxy:1.0",
+				}
+			},
+			want: 2,
+		},
+		{
+			name: "no recorded status",
+			change: func(p *cloudsqlapi.AuthProxyWorkload) {
+				p.Status = cloudsqlapi.AuthProxyWorkloadStatus{}
+			},
+			want: 2,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			np := p.DeepCopy()
+			np.Generation = 2
+			tc.change(np)
+			got, err := workload.ConfigGeneration(np)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got %d, want %d", got, tc.want)
+			}
+		})
 	}
 }
 
@@ -1198,6 +1383,104 @@ func TestWorkloadUnixVolume(t *testing.T) {
 
 }
 
+func TestCredentialsFileSecret(t *testing.T) {
+	var (
+		u    = workload.NewUpdater("authproxyworkload/dev", workload.DefaultProxyImage)
+		wl   = podWorkload()
+		csql = authProxyWorkloadFromSpec("instance1", cloudsqlapi.AuthProxyWorkloadSpec{
+			Workload: cloudsqlapi.WorkloadSelectorSpec{Kind: "Pod", Name: "hello"},
+			AuthProxyContainer: &cloudsqlapi.AuthProxyContainerSpec{
+				Authentication: &cloudsqlapi.AuthenticationSpec{
+					CredentialsFileSecret: &cloudsqlapi.SecretKeyRef{Name: "creds", Key: "key.json"},
+				},
+			},
+			Instances: []cloudsqlapi.InstanceSpec{{ConnectionString: "project:server:db"}},
+		})
+		wantVolName = workload.CredentialsVolumeName(csql)
+		wantFile    = workload.CredentialsMountDir + "/" + wantVolName + "/key.json"
+	)
+
+	err := u.ConfigureWorkload(wl, []*cloudsqlapi.AuthProxyWorkload{csql}, workload.SecretVersions{"creds": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// test that the pod has the secret volume
+	if want, got := 1, len(wl.Pod.Spec.Volumes); want != got {
+		t.Fatalf("got %v, wants %v. PodSpec.Volumes", got, want)
+	}
+	vol := wl.Pod.Spec.Volumes[0]
+	if vol.Name != wantVolName || vol.Secret == nil || vol.Secret.SecretName != "creds" {
+		t.Fatalf("got %v, wants secret volume %v for secret creds", vol, wantVolName)
+	}
+
+	// test that the volume is mounted read-only only in the proxy container
+	csqlContainer, err := findContainer(wl, workload.ContainerName(csql))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(csqlContainer.VolumeMounts); want != got {
+		t.Fatalf("got %v, wants %v. Proxy Container.VolumeMounts", got, want)
+	}
+	if m := csqlContainer.VolumeMounts[0]; m.Name != wantVolName || !m.ReadOnly {
+		t.Errorf("got %v, wants read-only mount of volume %v", m, wantVolName)
+	}
+	busyboxContainer, err := findContainer(wl, "busybox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 0, len(busyboxContainer.VolumeMounts); want != got {
+		t.Errorf("got %v, wants %v. Busybox Container.VolumeMounts", got, want)
+	}
+
+	// test that the proxy uses the credentials file
+	gotEnvVar, err := findEnvVar(wl, workload.ContainerName(csql), "CSQL_PROXY_CREDENTIALS_FILE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotEnvVar.Value != wantFile {
+		t.Errorf("got %v, wants %v. CSQL_PROXY_CREDENTIALS_FILE", gotEnvVar.Value, wantFile)
+	}
+
+	// test that the pod annotation changes when the secret is rotated
+	k := workload.PodAnnotationKey(csql)
+	before := wl.Pod.Annotations[k]
+	err = u.ConfigureWorkload(wl, []*cloudsqlapi.AuthProxyWorkload{csql}, workload.SecretVersions{"creds": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after := wl.Pod.Annotations[k]; after == before {
+		t.Errorf("got %v, wants pod annotation to change after the secret was rotated", after)
+	}
+	if want, got := 1, len(wl.Pod.Spec.Volumes); want != got {
+		t.Errorf("got %v, wants %v. PodSpec.Volumes after the update", got, want)
+	}
+}
+
+func TestCheckWorkloadContainersMissingSecret(t *testing.T) {
+	u := workload.NewUpdater("cloud-sql-proxy-operator/dev", workload.DefaultProxyImage)
+	csql := simpleAuthProxy("instance1", "project:server:db")
+	csql.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{
+		Authentication: &cloudsqlapi.AuthenticationSpec{
+			CredentialsFileSecret: &cloudsqlapi.SecretKeyRef{Name: "creds", Key: "key.json"},
+		},
+	}
+	wl := podWorkload()
+	matches := []*cloudsqlapi.AuthProxyWorkload{csql}
+	err := u.ConfigureWorkload(wl, matches, workload.SecretVersions{"creds": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := u.CheckWorkloadContainers(wl, matches, workload.SecretVersions{"creds": "1"}); err != nil {
+		t.Errorf("got %v, wants no error when the secret exists", err)
+	}
+	err = u.CheckWorkloadContainers(wl, matches, workload.SecretVersions{})
+	if !errors.Is(err, workload.ErrMissingCredentialsSecret) {
+		t.Errorf("got %v, wants %v", err, workload.ErrMissingCredentialsSecret)
+	}
+}
+
 func TestUpdater_CheckWorkloadContainers(t *testing.T) {
 	var (
 		wantsInstanceName = "project:server:db"
@@ -1267,7 +1550,7 @@ func TestUpdater_CheckWorkloadContainers(t *testing.T) {
 	// containers.
 	wlCfgOutOfDateError := podWorkload()
 	// Only configure 1 of the 2 expected AuthProxyWorkload sidecar containers
-	err = configureProxies(u, wlCfgWait, []*cloudsqlapi.AuthProxyWorkload{p1})
+	err = configureProxies(u, wlCfgOutOfDateError, []*cloudsqlapi.AuthProxyWorkload{p1})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1292,7 +1575,7 @@ func TestUpdater_CheckWorkloadContainers(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			// update the containers
-			err := u.CheckWorkloadContainers(test.wl, csqls)
+			err := u.CheckWorkloadContainers(test.wl, csqls, nil)
 
 			if test.wantErr && err == nil {
 				t.Fatal("want not nil, got nil. err")
@@ -1304,3 +1587,714 @@ func TestUpdater_CheckWorkloadContainers(t *testing.T) {
 		})
 	}
 }
+
+func TestNativeSidecar(t *testing.T) {
+	var (
+		wantsInstanceName = "project:server:db"
+		u                 = workload.NewUpdater("cloud-sql-proxy-operator/dev", workload.DefaultProxyImage)
+	)
+
+	// Create a pod with an init container
+	wl := podWorkload()
+	wl.Pod.Spec.InitContainers = []corev1.Container{{Name: "migrate", Image: "busybox"}}
+
+	// Create a AuthProxyWorkload that uses a native sidecar
+	p := authProxyWorkload("instance1", []cloudsqlapi.InstanceSpec{{
+		ConnectionString: wantsInstanceName,
+		PortEnvName:      "DB_PORT",
+	}})
+	p.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{
+		SidecarType: cloudsqlapi.SidecarTypeInit,
+	}
+	wantName := workload.ContainerName(p)
+
+	err := configureProxies(u, wl, []*cloudsqlapi.AuthProxyWorkload{p})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// test that the proxy was not added to the containers
+	if want, got := 1, len(wl.Pod.Spec.Containers); want != got {
+		t.Fatalf("got %v, want %v containers", got, want)
+	}
+
+	// test that the proxy is the first init container, with restartPolicy Always
+	if want, got := 2, len(wl.Pod.Spec.InitContainers); want != got {
+		t.Fatalf("got %v, want %v init containers", got, want)
+	}
+	sidecar := wl.Pod.Spec.InitContainers[0]
+	if sidecar.Name != wantName {
+		t.Errorf("got %v, want %v for first init container name", sidecar.Name, wantName)
+	}
+	if sidecar.RestartPolicy == nil || *sidecar.RestartPolicy != corev1.ContainerRestartPolicyAlways {
+		t.Errorf("got %v, want Always for proxy init container restartPolicy", sidecar.RestartPolicy)
+	}
+
+	// test that the app's init container and containers got the port env var
+	for _, c := range []corev1.Container{wl.Pod.Spec.InitContainers[1], wl.Pod.Spec.Containers[0]} {
+		var found bool
+		for _, e := range c.Env {
+			if e.Name == "DB_PORT" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("container %s is missing env var DB_PORT", c.Name)
+		}
+	}
+}
+
+func TestNativeSidecarConfiguresLaterInitContainers(t *testing.T) {
+	u := workload.NewUpdater("cloud-sql-proxy-operator/dev", workload.DefaultProxyImage)
+
+	sidecar := authProxyWorkload("sidecar", []cloudsqlapi.InstanceSpec{{
+		ConnectionString: "project:server:db1",
+		PortEnvName:      "DB1_PORT",
+	}})
+	sidecar.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{
+		SidecarType: cloudsqlapi.SidecarTypeInit,
+	}
+	plain := authProxyWorkload("plain", []cloudsqlapi.InstanceSpec{{
+		ConnectionString: "project:server:db2",
+		PortEnvName:      "DB2_PORT",
+	}})
+
+	// Create a pod with an init container that starts before the native
+	// sidecar, and one that starts after it.
+	wl := podWorkload()
+	wl.Pod.Spec.InitContainers = []corev1.Container{
+		{Name: "before", Image: "busybox"},
+		{Name: workload.ContainerName(sidecar), Image: "proxy"},
+		{Name: "after", Image: "busybox"},
+	}
+	err := configureProxies(u, wl, []*cloudsqlapi.AuthProxyWorkload{sidecar, plain})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hasEnv := func(c *corev1.Container, name string) bool {
+		for _, e := range c.Env {
+			if e.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+	tcs := []struct {
+		container string
+		env       string
+		want      bool
+	}{
+		{container: "before", env: "DB1_PORT", want: false},
+		{container: "before", env: "DB2_PORT", want: false},
+		{container: "after", env: "DB1_PORT", want: true},
+		{container: "after", env: "DB2_PORT", want: false},
+		{container: "busybox", env: "DB1_PORT", want: true},
+		{container: "busybox", env: "DB2_PORT", want: true},
+	}
+	for _, tc := range tcs {
+		if got := hasEnv(proxyContainer(wl, tc.container), tc.env); got != tc.want {
+			t.Errorf("got %v, want %v for env var %s on container %s", got, tc.want, tc.env, tc.container)
+		}
+	}
+
+	// Change the native sidecar back to a regular container. The init
+	// containers no longer get its env vars.
+	sidecar.Spec.AuthProxyContainer = nil
+	err = configureProxies(u, wl, []*cloudsqlapi.AuthProxyWorkload{sidecar, plain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range wl.Pod.Spec.InitContainers {
+		if len(c.Env) != 0 {
+			t.Errorf("got env %v on init container %s, want none", c.Env, c.Name)
+		}
+	}
+}
+
+func TestAlloyDBProxy(t *testing.T) {
+	var (
+		wantsInstanceName = "projects/proj/locations/us-central1/clusters/c1/instances/i1"
+		u                 = workload.NewUpdater("cloud-sql-proxy-operator/dev", workload.DefaultProxyImage)
+	)
+
+	wl := podWorkload()
+	p := authProxyWorkload("instance1", []cloudsqlapi.InstanceSpec{{
+		ConnectionString: wantsInstanceName,
+		PortEnvName:      "DB_PORT",
+		PSC:              ptr(true),
+	}})
+	p.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{
+		ProxyType:           cloudsqlapi.ProxyTypeAlloyDB,
+		SQLAdminAPIEndpoint: "https://example.com",
+		Telemetry: &cloudsqlapi.TelemetrySpec{
+			TelemetryProject: ptr("telemetry-project"),
+			QuotaProject:     ptr("quota-project"),
+		},
+	}
+
+	err := configureProxies(u, wl, []*cloudsqlapi.AuthProxyWorkload{p})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := findContainer(wl, workload.ContainerName(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// test that the proxy uses the AlloyDB Auth Proxy image
+	if c.Image != workload.DefaultAlloyDBProxyImage {
+		t.Errorf("got %v, want %v for proxy image", c.Image, workload.DefaultAlloyDBProxyImage)
+	}
+
+	// test that the instance uri is passed to the proxy
+	assertContainerArgsContains(t, c.Args, []string{wantsInstanceName + "?port=5000&psc=true"})
+
+	// test that the proxy env vars were translated
+	wantEnv := map[string]string{
+		"ALLOYDB_PROXY_HEALTH_CHECK":              "true",
+		"ALLOYDB_PROXY_STRUCTURED_LOGS":           "true",
+		"ALLOYDB_PROXY_TELEMETRY_PROJECT":         "telemetry-project",
+		"ALLOYDB_PROXY_ALLOYDBADMIN_API_ENDPOINT": "https://example.com",
+	}
+	for name, want := range wantEnv {
+		got, err := findEnvVar(wl, c.Name, name)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got.Value != want {
+			t.Errorf("got %v, want %v for env var %v", got.Value, want, name)
+		}
+	}
+	// CSQL_PROXY_QUIT_URLS is set by the operator on all containers, it is
+	// not read by the proxy.
+	for _, e := range c.Env {
+		if strings.HasPrefix(e.Name, "CSQL_PROXY_") && e.Name != "CSQL_PROXY_QUIT_URLS" {
+			t.Errorf("got env var %v, want no Cloud SQL Auth Proxy env vars", e.Name)
+		}
+	}
+
+	// test that the pod annotation changes with the AlloyDB default image
+	k := workload.PodAnnotationKey(p)
+	before := wl.Pod.Annotations[k]
+	cfg := u.Config()
+	cfg.AlloyDBProxyImage = "example.com/alloydb-auth-proxy:1.0"
+	u.SetConfig(cfg)
+	wl = podWorkload()
+	err = configureProxies(u, wl, []*cloudsqlapi.AuthProxyWorkload{p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after := wl.Pod.Annotations[k]; before == "" || after == before {
+		t.Errorf("got %q before and %q after, want the pod annotation to change with the AlloyDB image", before, after)
+	}
+}
+
+func TestNativeSidecarMigration(t *testing.T) {
+	var (
+		wantsInstanceName = "project:server:db"
+		u                 = workload.NewUpdater("cloud-sql-proxy-operator/dev", workload.DefaultProxyImage)
+	)
+
+	// Configure a pod with the proxy as a regular container
+	wl := podWorkload()
+	p := simpleAuthProxy("instance1", wantsInstanceName)
+	p.Spec.Instances[0].Port = ptr(int32(5000))
+	wantName := workload.ContainerName(p)
+	err := configureProxies(u, wl, []*cloudsqlapi.AuthProxyWorkload{p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = findContainer(wl, wantName); err != nil {
+		t.Fatal(err)
+	}
+
+	// Change the AuthProxyWorkload to use a native sidecar and apply it again
+	p.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{
+		SidecarType: cloudsqlapi.SidecarTypeInit,
+	}
+	err = configureProxies(u, wl, []*cloudsqlapi.AuthProxyWorkload{p})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = findContainer(wl, wantName); err == nil {
+		t.Errorf("got proxy container %s in containers, want it moved to init containers", wantName)
+	}
+	if want, got := 1, len(wl.Pod.Spec.InitContainers); want != got {
+		t.Fatalf("got %v, want %v init containers", got, want)
+	}
+	if got := wl.Pod.Spec.InitContainers[0].Name; got != wantName {
+		t.Errorf("got %v, want %v for init container name", got, wantName)
+	}
+
+	// Pods that still have the proxy as a regular container must not be
+	// reported as misconfigured during the migration.
+	old := podWorkload()
+	p.Spec.AuthProxyContainer = nil
+	err = configureProxies(u, old, []*cloudsqlapi.AuthProxyWorkload{p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{
+		SidecarType: cloudsqlapi.SidecarTypeInit,
+	}
+	old.Pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name:  old.Pod.Spec.Containers[0].Name,
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+	}}
+	if err := u.CheckWorkloadContainers(old, []*cloudsqlapi.AuthProxyWorkload{p}, nil); err != nil {
+		t.Errorf("got %v, want no error for pod with proxy in containers", err)
+	}
+	wl.Pod.Status = old.Pod.Status
+	if err := u.CheckWorkloadContainers(wl, []*cloudsqlapi.AuthProxyWorkload{p}, nil); err != nil {
+		t.Errorf("got %v, want no error for pod with proxy in init containers", err)
+	}
+}
+
+func TestFindMatchingAuthProxyWorkloadsClusterPrecedence(t *testing.T) {
+	u := workload.NewUpdater("cloud-sql-proxy-operator/dev", workload.DefaultProxyImage)
+	wl := podWorkload()
+	wl.Pod.TypeMeta = metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"}
+
+	sel := cloudsqlapi.WorkloadSelectorSpec{
+		Kind: "Pod",
+		Selector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{"app": "hello"},
+		},
+	}
+	nsProxy := simpleAuthProxy("ns-proxy", "project:server:db")
+	nsProxy.Spec.Workload = sel
+	clusterProxy := (&cloudsqlapi.ClusterAuthProxyWorkload{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-proxy", Generation: 1},
+		Spec: cloudsqlapi.AuthProxyWorkloadSpec{
+			Workload:  sel,
+			Instances: []cloudsqlapi.InstanceSpec{{ConnectionString: "project:server:db2"}},
+		},
+	}).AuthProxyWorkload()
+
+	otherProxy := simpleAuthProxy("other-proxy", "project:server:db2")
+	otherProxy.Spec.Workload = sel
+
+	tcs := []struct {
+		desc  string
+		items []cloudsqlapi.AuthProxyWorkload
+		want  []string
+	}{
+		{
+			desc:  "only cluster proxy matches",
+			items: []cloudsqlapi.AuthProxyWorkload{*clusterProxy},
+			want:  []string{"cluster-proxy"},
+		},
+		{
+			desc:  "namespaced proxy for other instances does not take precedence",
+			items: []cloudsqlapi.AuthProxyWorkload{*clusterProxy, *nsProxy},
+			want:  []string{"cluster-proxy", "ns-proxy"},
+		},
+		{
+			desc:  "namespaced proxy for the same instance takes precedence",
+			items: []cloudsqlapi.AuthProxyWorkload{*clusterProxy, *nsProxy, *otherProxy},
+			want:  []string{"ns-proxy", "other-proxy"},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := u.FindMatchingAuthProxyWorkloads(&cloudsqlapi.AuthProxyWorkloadList{Items: tc.items}, wl, nil)
+			var gotNames []string
+			for _, p := range got {
+				gotNames = append(gotNames, p.Name)
+			}
+			if !reflect.DeepEqual(gotNames, tc.want) {
+				t.Errorf("got proxies %v, want %v", gotNames, tc.want)
+			}
+		})
+	}
+}
+
+func TestFindMatchingAuthProxyWorkloadsSelectorChanged(t *testing.T) {
+	u := workload.NewUpdater("cloud-sql-proxy-operator/dev", workload.DefaultProxyImage)
+	wl := podWorkload()
+	wl.Pod.TypeMeta = metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"}
+
+	p := simpleAuthProxy("instance1", "project:server:db")
+	p.UID = "instance1-uid"
+	p.Spec.Workload.Kind = "Pod"
+	pl := &cloudsqlapi.AuthProxyWorkloadList{Items: []cloudsqlapi.AuthProxyWorkload{*p}}
+	if got := u.FindMatchingAuthProxyWorkloads(pl, wl, nil); len(got) != 1 {
+		t.Fatalf("got %d matching proxies, want 1", len(got))
+	}
+
+	// The compiled selector is replaced when the selector changes.
+	pl.Items[0].Spec.Workload.Selector.MatchLabels = map[string]string{"app": "other"}
+	if got := u.FindMatchingAuthProxyWorkloads(pl, wl, nil); len(got) != 0 {
+		t.Errorf("got %d matching proxies, want 0 after the selector changed", len(got))
+	}
+}
+
+// BenchmarkFindMatchingAuthProxyWorkloads measures matching a pod to many
+// AuthProxyWorkloads with and without their compiled selectors cached. The
+// AuthProxyWorkloads without a UID are not cached.
+func BenchmarkFindMatchingAuthProxyWorkloads(b *testing.B) {
+	wl := podWorkload()
+	wl.Pod.TypeMeta = metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"}
+	for _, cached := range []bool{true, false} {
+		pl := &cloudsqlapi.AuthProxyWorkloadList{}
+		for i := 0; i < 500; i++ {
+			p := simpleAuthProxy(fmt.Sprintf("instance%d", i), "project:server:db")
+			p.Spec.Workload.Kind = "Pod"
+			p.Spec.Workload.Selector.MatchExpressions = []metav1.LabelSelectorRequirement{{
+				Key: "tier", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"batch", "test"},
+			}}
+			if cached {
+				p.UID = types.UID(p.Name)
+			}
+			pl.Items = append(pl.Items, *p)
+		}
+		u := workload.NewUpdater("cloud-sql-proxy-operator/dev", workload.DefaultProxyImage)
+		b.Run(fmt.Sprintf("cached=%v", cached), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if got := u.FindMatchingAuthProxyWorkloads(pl, wl, nil); len(got) != 500 {
+					b.Fatalf("got %d matching proxies, want 500", len(got))
+				}
+			}
+		})
+	}
+}
+
+// randomPodWorkload returns a pod with a random set of application
+// containers and init containers.
+func randomPodWorkload(r *rand.Rand) *workload.PodWorkload {
+	wl := podWorkload()
+	wl.Pod.Spec.Containers = nil
+	for i := 0; i < 1+r.Intn(3); i++ {
+		c := corev1.Container{
+			Name:  fmt.Sprintf("app-%d", i),
+			Image: "busybox",
+			Env:   []corev1.EnvVar{{Name: "APP_INDEX", Value: strconv.Itoa(i)}},
+		}
+		if r.Intn(2) == 0 {
+			c.Ports = []corev1.ContainerPort{{ContainerPort: int32(8080 + i)}}
+		}
+		wl.Pod.Spec.Containers = append(wl.Pod.Spec.Containers, c)
+	}
+	for i := 0; i < r.Intn(2); i++ {
+		wl.Pod.Spec.InitContainers = append(wl.Pod.Spec.InitContainers,
+			corev1.Container{Name: fmt.Sprintf("init-%d", i), Image: "busybox"})
+	}
+	return wl
+}
+
+// randomAuthProxyWorkloads returns up to 3 AuthProxyWorkloads with random
+// instances, sidecar types and credentials that can all be applied to the
+// same pod without errors.
+func randomAuthProxyWorkloads(r *rand.Rand) ([]*cloudsqlapi.AuthProxyWorkload, workload.SecretVersions) {
+	var (
+		proxies []*cloudsqlapi.AuthProxyWorkload
+		secrets = workload.SecretVersions{}
+	)
+	for i := 0; i < 1+r.Intn(3); i++ {
+		var instances []cloudsqlapi.InstanceSpec
+		for j := 0; j < 1+r.Intn(2); j++ {
+			is := cloudsqlapi.InstanceSpec{
+				ConnectionString: fmt.Sprintf("project:region:db%d%d", i, j),
+			}
+			switch r.Intn(3) {
+			case 0:
+				is.UnixSocketPath = fmt.Sprintf("/csql/db%d%d", i, j)
+				is.UnixSocketPathEnvName = fmt.Sprintf("DB%d%d_SOCKET", i, j)
+			case 1:
+				is.PortEnvName = fmt.Sprintf("DB%d%d_PORT", i, j)
+				is.HostEnvName = fmt.Sprintf("DB%d%d_HOST", i, j)
+			}
+			instances = append(instances, is)
+		}
+
+		p := authProxyWorkload(fmt.Sprintf("proxy-%d", i), instances)
+		p.Spec.AuthProxyContainer = &cloudsqlapi.AuthProxyContainerSpec{}
+		if r.Intn(2) == 0 {
+			p.Spec.AuthProxyContainer.SidecarType = cloudsqlapi.SidecarTypeInit
+		}
+		if r.Intn(3) == 0 {
+			name := fmt.Sprintf("creds-%d", i)
+			p.Spec.AuthProxyContainer.Authentication = &cloudsqlapi.AuthenticationSpec{
+				CredentialsFileSecret: &cloudsqlapi.SecretKeyRef{Name: name, Key: "key.json"},
+			}
+			secrets[name] = "1"
+		}
+		proxies = append(proxies, p)
+	}
+	return proxies, secrets
+}
+
+// assertProxyContainers checks that the container names in the pod are unique
+// and that there is exactly one proxy container for each AuthProxyWorkload.
+func assertProxyContainers(t *testing.T, wl *workload.PodWorkload, proxies []*cloudsqlapi.AuthProxyWorkload) {
+	t.Helper()
+	names := map[string]int{}
+	var proxyCount int
+	for _, cs := range [][]corev1.Container{wl.Pod.Spec.Containers, wl.Pod.Spec.InitContainers} {
+		for _, c := range cs {
+			names[c.Name]++
+			if strings.HasPrefix(c.Name, workload.ContainerPrefix) {
+				proxyCount++
+			}
+		}
+	}
+	for name, count := range names {
+		if count != 1 {
+			t.Errorf("got %d containers named %s, want 1", count, name)
+		}
+	}
+	for _, p := range proxies {
+		if names[workload.ContainerName(p)] != 1 {
+			t.Errorf("got no proxy container for %s", p.Name)
+		}
+	}
+	if proxyCount != len(proxies) {
+		t.Errorf("got %d proxy containers, want %d", proxyCount, len(proxies))
+	}
+}
+
+// assertNoStaleVolumes checks that the pod only has proxy volumes and
+// volume mounts that belong to the AuthProxyWorkloads.
+func assertNoStaleVolumes(t *testing.T, wl *workload.PodWorkload, proxies []*cloudsqlapi.AuthProxyWorkload) {
+	t.Helper()
+	want := map[string]bool{}
+	for _, p := range proxies {
+		want[workload.CredentialsVolumeName(p)] = true
+		for i := range p.Spec.Instances {
+			want[workload.VolumeName(p, &p.Spec.Instances[i], "unix")] = true
+		}
+	}
+	for _, v := range wl.Pod.Spec.Volumes {
+		if strings.HasPrefix(v.Name, workload.ContainerPrefix) && !want[v.Name] {
+			t.Errorf("got stale volume %s", v.Name)
+		}
+	}
+	for _, cs := range [][]corev1.Container{wl.Pod.Spec.Containers, wl.Pod.Spec.InitContainers} {
+		for _, c := range cs {
+			for _, m := range c.VolumeMounts {
+				if strings.HasPrefix(m.Name, workload.ContainerPrefix) && !want[m.Name] {
+					t.Errorf("got stale volume mount %s on container %s", m.Name, c.Name)
+				}
+			}
+		}
+	}
+}
+
+func TestConfigureWorkloadIsIdempotent(t *testing.T) {
+	u := workload.NewUpdater("cloud-sql-proxy-operator/dev", workload.DefaultProxyImage)
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 200; i++ {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			wl := randomPodWorkload(r)
+			proxies, secrets := randomAuthProxyWorkloads(r)
+
+			err := u.ConfigureWorkload(wl, proxies, secrets)
+			if err != nil {
+				logPodSpec(t, wl)
+				t.Fatalf("got %v, want no error", err)
+			}
+			assertProxyContainers(t, wl, proxies)
+
+			// Applying the same configuration to the configured pod, as the
+			// webhook does when it is invoked again, changes nothing.
+			once := wl.Pod.DeepCopy()
+			err = u.ConfigureWorkload(wl, proxies, secrets)
+			if err != nil {
+				t.Fatalf("got %v, want no error on second run", err)
+			}
+			if !reflect.DeepEqual(once, wl.Pod) {
+				logPodSpec(t, wl)
+				t.Errorf("got a different pod when configured twice")
+			}
+		})
+	}
+}
+
+func TestConfigureWorkloadRemovesStaleProxies(t *testing.T) {
+	u := workload.NewUpdater("cloud-sql-proxy-operator/dev", workload.DefaultProxyImage)
+	r := rand.New(rand.NewSource(2))
+
+	for i := 0; i < 200; i++ {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			wl := randomPodWorkload(r)
+			fresh := wl.Pod.DeepCopy()
+			proxies, secrets := randomAuthProxyWorkloads(r)
+
+			err := u.ConfigureWorkload(wl, proxies, secrets)
+			if err != nil {
+				t.Fatalf("got %v, want no error", err)
+			}
+
+			// Delete some of the AuthProxyWorkloads, and change the sidecar
+			// type of some of the others.
+			var remaining []*cloudsqlapi.AuthProxyWorkload
+			for _, p := range proxies {
+				if r.Intn(2) == 0 {
+					continue
+				}
+				if r.Intn(3) == 0 {
+					p = p.DeepCopy()
+					if p.Spec.AuthProxyContainer.SidecarType == cloudsqlapi.SidecarTypeInit {
+						p.Spec.AuthProxyContainer.SidecarType = cloudsqlapi.SidecarTypeContainer
+					} else {
+						p.Spec.AuthProxyContainer.SidecarType = cloudsqlapi.SidecarTypeInit
+					}
+				}
+				remaining = append(remaining, p)
+			}
+
+			err = u.ConfigureWorkload(wl, remaining, secrets)
+			if err != nil {
+				t.Fatalf("got %v, want no error on second run", err)
+			}
+			assertProxyContainers(t, wl, remaining)
+			assertNoStaleVolumes(t, wl, remaining)
+
+			// The proxy containers are the same as the ones on a pod that
+			// was only configured with the remaining AuthProxyWorkloads,
+			// keeping the ports allocated to their instances.
+			fresh.Annotations = map[string]string{
+				workload.PortsAnnotation: wl.Pod.Annotations[workload.PortsAnnotation],
+			}
+			want := &workload.PodWorkload{Pod: fresh}
+			err = u.ConfigureWorkload(want, remaining, secrets)
+			if err != nil {
+				t.Fatalf("got %v, want no error on fresh pod", err)
+			}
+			for _, p := range remaining {
+				name := workload.ContainerName(p)
+				if got, want := proxyContainer(wl, name), proxyContainer(want, name); !reflect.DeepEqual(got, want) {
+					t.Errorf("got container %v, want %v", got, want)
+				}
+			}
+			assertNoStaleEnv(t, wl, want)
+		})
+	}
+}
+
+// assertNoStaleEnv checks that the workload's containers and init containers
+// have the same env vars as the ones on the pod want, which was only
+// configured with the current AuthProxyWorkloads.
+func assertNoStaleEnv(t *testing.T, wl, want *workload.PodWorkload) {
+	t.Helper()
+	envNames := func(c *corev1.Container) []string {
+		var names []string
+		for _, e := range c.Env {
+			names = append(names, e.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	for _, cs := range [][]corev1.Container{wl.Pod.Spec.Containers, wl.Pod.Spec.InitContainers} {
+		for i := range cs {
+			c := &cs[i]
+			if strings.HasPrefix(c.Name, workload.ContainerPrefix) {
+				continue
+			}
+			if got, want := envNames(c), envNames(proxyContainer(want, c.Name)); !reflect.DeepEqual(got, want) {
+				t.Errorf("got env vars %v on container %s, want %v", got, c.Name, want)
+			}
+		}
+	}
+}
+
+// proxyContainer returns the container or init container with this name.
+func proxyContainer(wl *workload.PodWorkload, name string) *corev1.Container {
+	for _, cs := range [][]corev1.Container{wl.Pod.Spec.Containers, wl.Pod.Spec.InitContainers} {
+		for i := range cs {
+			if cs[i].Name == name {
+				return &cs[i]
+			}
+		}
+	}
+	return nil
+}
+
+func TestPortAllocationIsDeterministic(t *testing.T) {
+	u := workload.NewUpdater("cloud-sql-proxy-operator/dev", workload.DefaultProxyImage)
+	p1 := simpleAuthProxy("instance1", "project:server:db1")
+	p2 := simpleAuthProxy("instance2", "project:server:db2")
+	p3 := simpleAuthProxy("instance3", "project:server:db3")
+
+	// Pods configured with the same AuthProxyWorkloads, listed in a different
+	// order, get the same ports.
+	want := podWorkload()
+	err := configureProxies(u, want, []*cloudsqlapi.AuthProxyWorkload{p1, p2, p3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, order := range [][]*cloudsqlapi.AuthProxyWorkload{{p3, p2, p1}, {p2, p3, p1}} {
+		got := podWorkload()
+		err := configureProxies(u, got, order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Pod, want.Pod) {
+			logPodSpec(t, got)
+			t.Errorf("got a different pod for AuthProxyWorkloads in order %s, %s, %s",
+				order[0].Name, order[1].Name, order[2].Name)
+		}
+	}
+}
+
+func TestPortAllocationIsStable(t *testing.T) {
+	u := workload.NewUpdater("cloud-sql-proxy-operator/dev", workload.DefaultProxyImage)
+	p2 := simpleAuthProxy("instance2", "project:server:db2")
+	p3 := simpleAuthProxy("instance3", "project:server:db3")
+
+	// Configure a pod with 2 AuthProxyWorkloads
+	wl := podWorkload()
+	err := configureProxies(u, wl, []*cloudsqlapi.AuthProxyWorkload{p2, p3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertArgs := func(wl *workload.PodWorkload, p *cloudsqlapi.AuthProxyWorkload, want string) {
+		t.Helper()
+		c, err := findContainer(wl, workload.ContainerName(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContainerArgsContains(t, c.Args, []string{want})
+	}
+	assertArgs(wl, p2, "project:server:db2?port=5000")
+	assertArgs(wl, p3, "project:server:db3?port=5001")
+
+	// Add an AuthProxyWorkload that is configured before the others, and
+	// remove one. A new pod from the same template keeps the ports of the
+	// existing instances.
+	p1 := simpleAuthProxy("instance1", "project:server:db1")
+	next := podWorkload()
+	next.Pod.Annotations = map[string]string{
+		workload.PortsAnnotation: wl.Pod.Annotations[workload.PortsAnnotation],
+	}
+	err = configureProxies(u, next, []*cloudsqlapi.AuthProxyWorkload{p1, p3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertArgs(next, p1, "project:server:db1?port=5000")
+	assertArgs(next, p3, "project:server:db3?port=5001")
+
+	wantAnnotation := `{"default/instance1":{"project:server:db1":5000},"default/instance3":{"project:server:db3":5001}}`
+	if got := next.Pod.Annotations[workload.PortsAnnotation]; got != wantAnnotation {
+		t.Errorf("got %v, want %v", got, wantAnnotation)
+	}
+
+	// An instance keeps its port when it is added ahead of instances that
+	// were allocated ports before.
+	next = podWorkload()
+	next.Pod.Annotations = map[string]string{
+		workload.PortsAnnotation: wl.Pod.Annotations[workload.PortsAnnotation],
+	}
+	err = configureProxies(u, next, []*cloudsqlapi.AuthProxyWorkload{p1, p2, p3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertArgs(next, p1, "project:server:db1?port=5002")
+	assertArgs(next, p2, "project:server:db2?port=5000")
+	assertArgs(next, p3, "project:server:db3?port=5001")
+}
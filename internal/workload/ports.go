@@ -0,0 +1,82 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workload
+
+import (
+	"encoding/json"
+	"fmt"
+
+	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// PortsAnnotation is the pod annotation that records the ports that the
+// operator chose for the instances of the AuthProxyWorkloads. The operator
+// reads it back the next time it configures a pod from the same pod template,
+// so that instances keep their ports when the configuration changes.
+//
+// The prefix keeps this annotation from colliding with the annotation of an
+// AuthProxyWorkload, see PodAnnotationKey.
+const PortsAnnotation = "ports." + cloudsqlapi.AnnotationPrefix + "/instances"
+
+// PortAllocation holds the ports of the proxy instances, keyed by the
+// AuthProxyWorkload's namespace/name and then by the instance's connection
+// string. A ClusterAuthProxyWorkload is keyed by its name.
+type PortAllocation map[string]map[string]int32
+
+// PortAllocationFromAnnotations reads the PortAllocation recorded in
+// PortsAnnotation. It returns an empty PortAllocation when the annotation is
+// missing or can't be read.
+func PortAllocationFromAnnotations(an map[string]string) PortAllocation {
+	a := PortAllocation{}
+	v, ok := an[PortsAnnotation]
+	if !ok {
+		return a
+	}
+	if err := json.Unmarshal([]byte(v), &a); err != nil {
+		l.Info("ignoring invalid port allocation annotation", "value", v, "error", err.Error())
+		return PortAllocation{}
+	}
+	return a
+}
+
+// Port returns the port allocated to the instance with connection string cs
+// of the AuthProxyWorkload r.
+func (a PortAllocation) Port(r *cloudsqlapi.AuthProxyWorkload, cs string) (int32, bool) {
+	return a.port(types.NamespacedName{Namespace: r.Namespace, Name: r.Name}, cs)
+}
+
+func (a PortAllocation) port(n types.NamespacedName, cs string) (int32, bool) {
+	p, ok := a[n.String()][cs]
+	return p, ok
+}
+
+func (a PortAllocation) set(id proxyInstanceID, port int32) {
+	k := id.AuthProxyWorkload.String()
+	if a[k] == nil {
+		a[k] = map[string]int32{}
+	}
+	a[k][id.ConnectionString] = port
+}
+
+// annotationValue returns the value for PortsAnnotation. The keys are sorted,
+// so the value is the same for the same allocation.
+func (a PortAllocation) annotationValue() (string, error) {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal port allocation, %v", err)
+	}
+	return string(b), nil
+}
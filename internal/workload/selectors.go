@@ -0,0 +1,78 @@
+// Copyright 2024 Google LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workload
+
+import (
+	"reflect"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+
+	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
+)
+
+// selectorCache holds the compiled workload label selectors of
+// AuthProxyWorkloads by UID, so that the pod webhook does not parse the
+// selector of every AuthProxyWorkload in the namespace for every pod. An
+// entry is compiled again when the selector changes.
+type selectorCache struct {
+	mu sync.RWMutex
+	m  map[types.UID]compiledSelector
+}
+
+type compiledSelector struct {
+	src *metav1.LabelSelector
+	sel labels.Selector
+	err error
+}
+
+// get returns the compiled workload label selector of p. AuthProxyWorkloads
+// without a UID, like the ones built from pod annotations, are not cached.
+func (c *selectorCache) get(p *cloudsqlapi.AuthProxyWorkload) (labels.Selector, error) {
+	if p.UID == "" {
+		return p.Spec.Workload.LabelsSelector()
+	}
+
+	c.mu.RLock()
+	e, ok := c.m[p.UID]
+	c.mu.RUnlock()
+	if ok && reflect.DeepEqual(e.src, p.Spec.Workload.Selector) {
+		return e.sel, e.err
+	}
+
+	sel, err := p.Spec.Workload.LabelsSelector()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = map[types.UID]compiledSelector{}
+	}
+	c.m[p.UID] = compiledSelector{src: p.Spec.Workload.Selector.DeepCopy(), sel: sel, err: err}
+	return sel, err
+}
+
+// forget removes the entry for uid.
+func (c *selectorCache) forget(uid types.UID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.m, uid)
+}
+
+// ForgetAuthProxyWorkload drops the cached data for an AuthProxyWorkload
+// that was deleted.
+func (u *Updater) ForgetAuthProxyWorkload(r *cloudsqlapi.AuthProxyWorkload) {
+	u.selectors.forget(r.UID)
+}
@@ -21,6 +21,7 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -141,6 +142,10 @@ func WorkloadListForKind(kind string) (WorkloadList, error) {
 	}
 }
 
+// WorkloadKinds lists the kinds of workloads supported by WorkloadForKind and
+// WorkloadListForKind.
+var WorkloadKinds = []string{"Deployment", "Pod", "StatefulSet", "ReplicaSet", "DaemonSet", "Job", "CronJob"}
+
 // WorkloadForKind returns a workload for a particular Kind
 func WorkloadForKind(kind string) (Workload, error) {
 	_, gk := schema.ParseKindArg(kind)
@@ -166,6 +171,19 @@ func WorkloadForKind(kind string) (Workload, error) {
 
 // workloadMatches tests if a workload matches a modifier based on its name, kind, and selectors.
 func workloadMatches(wl client.Object, workloadSelector cloudsqlapi.WorkloadSelectorSpec, ns string) bool {
+	if !workloadNameMatches(wl, workloadSelector, ns) {
+		return false
+	}
+	sel, err := workloadSelector.LabelsSelector()
+	if err != nil {
+		return false
+	}
+	return labelsMatch(wl, sel)
+}
+
+// workloadNameMatches tests if a workload matches the kind and name of a
+// modifier, and is in namespace ns.
+func workloadNameMatches(wl client.Object, workloadSelector cloudsqlapi.WorkloadSelectorSpec, ns string) bool {
 	if workloadSelector.Kind != "" && wl.GetObjectKind().GroupVersionKind().Kind != workloadSelector.Kind {
 		return false
 	}
@@ -175,16 +193,192 @@ func workloadMatches(wl client.Object, workloadSelector cloudsqlapi.WorkloadSele
 	if ns != "" && wl.GetNamespace() != ns {
 		return false
 	}
+	return true
+}
 
-	sel, err := workloadSelector.LabelsSelector()
-	if err != nil {
+// labelsMatch tests if the labels of a workload match sel. An empty selector
+// matches all workloads.
+func labelsMatch(wl client.Object, sel labels.Selector) bool {
+	return sel.Empty() || sel.Matches(labels.Set(wl.GetLabels()))
+}
+
+// AuthProxyWorkloadMatches returns true when the workload selector of p
+// matches wl, a workload of the specified kind. This is used when the
+// workload's TypeMeta may be empty, as it is for items loaded with client.List().
+func AuthProxyWorkloadMatches(wl client.Object, kind string, p *cloudsqlapi.AuthProxyWorkload) bool {
+	_, gk := schema.ParseKindArg(p.Spec.Workload.Kind)
+	if gk.Kind != kind {
 		return false
 	}
-	if !sel.Empty() && !sel.Matches(labels.Set(wl.GetLabels())) {
+	sel := p.Spec.Workload
+	sel.Kind = ""
+	return workloadMatches(wl, sel, p.Namespace)
+}
+
+// NamespaceMatches returns true when the namespace selector of the
+// ClusterAuthProxyWorkload selects the namespace.
+func NamespaceMatches(r *cloudsqlapi.ClusterAuthProxyWorkload, ns *corev1.Namespace) bool {
+	sel, err := r.Spec.Workload.NamespaceLabelsSelector()
+	if err != nil {
 		return false
 	}
+	return sel.Empty() || sel.Matches(labels.Set(ns.GetLabels()))
+}
+
+// ClusterAuthProxyWorkloadsForNamespace returns the ClusterAuthProxyWorkload
+// resources in cpl that select the namespace ns, converted to cluster-scoped
+// AuthProxyWorkloads so that they can be added to the list passed to
+// Updater.FindMatchingAuthProxyWorkloads.
+func ClusterAuthProxyWorkloadsForNamespace(cpl *cloudsqlapi.ClusterAuthProxyWorkloadList, ns *corev1.Namespace) []cloudsqlapi.AuthProxyWorkload {
+	var result []cloudsqlapi.AuthProxyWorkload
+	for i := range cpl.Items {
+		if NamespaceMatches(&cpl.Items[i], ns) {
+			result = append(result, *cpl.Items[i].AuthProxyWorkload())
+		}
+	}
+	return result
+}
+
+// PodSelector returns the label selector for the pods that belong to wl. It
+// returns nil when the workload does not select its pods using labels, as is
+// the case for a Pod or a CronJob.
+func PodSelector(wl Workload) (labels.Selector, error) {
+	var sel *metav1.LabelSelector
+	switch w := wl.(type) {
+	case *DeploymentWorkload:
+		sel = w.Deployment.Spec.Selector
+	case *StatefulSetWorkload:
+		sel = w.StatefulSet.Spec.Selector
+	case *DaemonSetWorkload:
+		sel = w.DaemonSet.Spec.Selector
+	case *ReplicaSetWorkload:
+		sel = w.ReplicaSet.Spec.Selector
+	case *JobWorkload:
+		sel = w.Job.Spec.Selector
+	}
+	if sel == nil {
+		return nil, nil
+	}
+	return metav1.LabelSelectorAsSelector(sel)
+}
+
+// TemplatePod returns a pod like the ones the workload's controller creates
+// from the workload's pod template. For a Pod workload, it returns a copy of
+// the pod.
+func TemplatePod(wl Workload) *PodWorkload {
+	var t *corev1.PodTemplateSpec
+	switch w := wl.(type) {
+	case *PodWorkload:
+		return &PodWorkload{Pod: w.Pod.DeepCopy()}
+	case *DeploymentWorkload:
+		t = &w.Deployment.Spec.Template
+	case *StatefulSetWorkload:
+		t = &w.StatefulSet.Spec.Template
+	case *DaemonSetWorkload:
+		t = &w.DaemonSet.Spec.Template
+	case *ReplicaSetWorkload:
+		t = &w.ReplicaSet.Spec.Template
+	case *JobWorkload:
+		t = &w.Job.Spec.Template
+	case *CronJobWorkload:
+		t = &w.CronJob.Spec.JobTemplate.Spec.Template
+	default:
+		t = &corev1.PodTemplateSpec{Spec: wl.PodSpec()}
+	}
 
-	return true
+	t = t.DeepCopy()
+	pod := &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: t.ObjectMeta,
+		Spec:       t.Spec,
+	}
+	pod.Namespace = wl.Object().GetNamespace()
+	pod.Name = wl.Object().GetName()
+	return &PodWorkload{Pod: pod}
+}
+
+// RolloutStatus shows the progress of the workload controller rolling out
+// changes to the workload's pod template.
+type RolloutStatus struct {
+	// ObservedGeneration is the generation of the workload last seen by the
+	// workload's controller.
+	ObservedGeneration int64
+	// Replicas is the desired number of pods.
+	Replicas int32
+	// UpdatedReplicas is the number of pods running the latest pod template.
+	UpdatedReplicas int32
+	// AvailableReplicas is the number of available pods.
+	AvailableReplicas int32
+	// Complete is true when the workload's controller observed the latest
+	// generation and all desired pods are updated and available.
+	Complete bool
+	// Failed is true when the workload's controller reports that the rollout
+	// can't make progress. This is only set for Deployments.
+	Failed bool
+}
+
+// WorkloadRolloutStatus returns the rollout status of Deployment, StatefulSet
+// and DaemonSet workloads. It returns false for the other kinds of workloads,
+// which have no rollout.
+func WorkloadRolloutStatus(wl Workload) (RolloutStatus, bool) {
+	var rs RolloutStatus
+	var gen int64
+	switch w := wl.(type) {
+	case *DeploymentWorkload:
+		d := w.Deployment
+		gen = d.Generation
+		rs = RolloutStatus{
+			ObservedGeneration: d.Status.ObservedGeneration,
+			Replicas:           replicasOrDefault(d.Spec.Replicas),
+			UpdatedReplicas:    d.Status.UpdatedReplicas,
+			AvailableReplicas:  d.Status.AvailableReplicas,
+		}
+		// Old pods must also be gone for a Deployment rollout to be complete.
+		rs.Complete = d.Status.Replicas == rs.UpdatedReplicas
+		for _, c := range d.Status.Conditions {
+			if c.Type == appsv1.DeploymentProgressing && c.Status == corev1.ConditionFalse &&
+				c.Reason == "ProgressDeadlineExceeded" {
+				rs.Failed = true
+			}
+		}
+	case *StatefulSetWorkload:
+		ss := w.StatefulSet
+		gen = ss.Generation
+		rs = RolloutStatus{
+			ObservedGeneration: ss.Status.ObservedGeneration,
+			Replicas:           replicasOrDefault(ss.Spec.Replicas),
+			UpdatedReplicas:    ss.Status.UpdatedReplicas,
+			AvailableReplicas:  ss.Status.AvailableReplicas,
+		}
+		rs.Complete = true
+	case *DaemonSetWorkload:
+		ds := w.DaemonSet
+		gen = ds.Generation
+		rs = RolloutStatus{
+			ObservedGeneration: ds.Status.ObservedGeneration,
+			Replicas:           ds.Status.DesiredNumberScheduled,
+			UpdatedReplicas:    ds.Status.UpdatedNumberScheduled,
+			AvailableReplicas:  ds.Status.NumberAvailable,
+		}
+		rs.Complete = true
+	default:
+		return rs, false
+	}
+
+	rs.Complete = rs.Complete &&
+		rs.ObservedGeneration >= gen &&
+		rs.UpdatedReplicas == rs.Replicas &&
+		rs.AvailableReplicas >= rs.Replicas
+	return rs, true
+}
+
+// replicasOrDefault returns the number of replicas, or 1, the Kubernetes
+// default, when replicas is not set.
+func replicasOrDefault(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
 }
 
 type DeploymentWorkload struct {
@@ -211,6 +211,112 @@ func TestWorkloadMatches(t *testing.T) {
 
 }
 
+func TestClusterAuthProxyWorkloadsForNamespace(t *testing.T) {
+	prod := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "prod", Labels: map[string]string{"env": "prod"}}}
+	dev := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dev", Labels: map[string]string{"env": "dev"}}}
+
+	all := cloudsqlapi.ClusterAuthProxyWorkload{ObjectMeta: metav1.ObjectMeta{Name: "all"}}
+	prodOnly := cloudsqlapi.ClusterAuthProxyWorkload{ObjectMeta: metav1.ObjectMeta{Name: "prod-only"}}
+	prodOnly.Spec.Workload.NamespaceSelector = &metav1.LabelSelector{
+		MatchLabels: map[string]string{"env": "prod"},
+	}
+	cpl := &cloudsqlapi.ClusterAuthProxyWorkloadList{Items: []cloudsqlapi.ClusterAuthProxyWorkload{all, prodOnly}}
+
+	tcs := []struct {
+		ns   *corev1.Namespace
+		want []string
+	}{
+		{ns: prod, want: []string{"all", "prod-only"}},
+		{ns: dev, want: []string{"all"}},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.ns.Name, func(t *testing.T) {
+			got := ClusterAuthProxyWorkloadsForNamespace(cpl, tc.ns)
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %d proxies, want %d", len(got), len(tc.want))
+			}
+			for i := range got {
+				if got[i].Name != tc.want[i] {
+					t.Errorf("got %v, want %v", got[i].Name, tc.want[i])
+				}
+				if !got[i].IsClusterScoped() {
+					t.Errorf("got namespaced proxy %v, want cluster-scoped", got[i].Name)
+				}
+			}
+		})
+	}
+}
+
+func TestWorkloadRolloutStatus(t *testing.T) {
+	three := int32(3)
+	tcs := []struct {
+		desc         string
+		wl           Workload
+		wantOk       bool
+		wantComplete bool
+	}{
+		{
+			desc: "deployment complete",
+			wl: &DeploymentWorkload{Deployment: &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Generation: 2},
+				Spec:       appsv1.DeploymentSpec{Replicas: &three},
+				Status:     appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3},
+			}},
+			wantOk:       true,
+			wantComplete: true,
+		},
+		{
+			desc: "deployment generation not observed",
+			wl: &DeploymentWorkload{Deployment: &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Generation: 3},
+				Spec:       appsv1.DeploymentSpec{Replicas: &three},
+				Status:     appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3},
+			}},
+			wantOk: true,
+		},
+		{
+			desc: "deployment old pods still running",
+			wl: &DeploymentWorkload{Deployment: &appsv1.Deployment{
+				Spec:   appsv1.DeploymentSpec{Replicas: &three},
+				Status: appsv1.DeploymentStatus{Replicas: 4, UpdatedReplicas: 3, AvailableReplicas: 4},
+			}},
+			wantOk: true,
+		},
+		{
+			desc: "statefulset partially updated",
+			wl: &StatefulSetWorkload{StatefulSet: &appsv1.StatefulSet{
+				Spec:   appsv1.StatefulSetSpec{Replicas: &three},
+				Status: appsv1.StatefulSetStatus{Replicas: 3, UpdatedReplicas: 1, AvailableReplicas: 3},
+			}},
+			wantOk: true,
+		},
+		{
+			desc: "daemonset complete",
+			wl: &DaemonSetWorkload{DaemonSet: &appsv1.DaemonSet{
+				Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 2, UpdatedNumberScheduled: 2, NumberAvailable: 2},
+			}},
+			wantOk:       true,
+			wantComplete: true,
+		},
+		{
+			desc:   "pod has no rollout",
+			wl:     &PodWorkload{Pod: &corev1.Pod{}},
+			wantOk: false,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			rs, ok := WorkloadRolloutStatus(tc.wl)
+			if ok != tc.wantOk {
+				t.Fatalf("got %v, want %v for ok", ok, tc.wantOk)
+			}
+			if rs.Complete != tc.wantComplete {
+				t.Errorf("got %v, want %v for complete", rs.Complete, tc.wantComplete)
+			}
+		})
+	}
+}
+
 // workload is shorthand to create workload test inputs
 func workload(t *testing.T, kind, ns, name string, l ...string) Workload {
 	var v Workload
@@ -18,8 +18,11 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"strings"
 
+	cloudsqlapi "github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/api/v1"
 	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/controller"
+	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/preview"
 	"github.com/GoogleCloudPlatform/cloud-sql-proxy-operator/internal/workload"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
@@ -27,8 +30,16 @@ import (
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
@@ -47,10 +58,46 @@ func init() {
 	controller.InitScheme(scheme)
 }
 
+// defaultsFlags maps the command line flags for the proxy container defaults
+// and the other operator settings to the keys used in the defaults ConfigMap.
+var defaultsFlags = []struct {
+	name, key, usage string
+}{
+	{"default-proxy-image", workload.ConfigProxyImage, "The default Cloud SQL Auth Proxy image."},
+	{"default-alloydb-proxy-image", workload.ConfigAlloyDBProxyImage, "The default AlloyDB Auth Proxy image."},
+	{"default-proxy-requests", workload.ConfigRequests, "The default resource requests of the proxy container, like cpu=100m,memory=128Mi."},
+	{"default-proxy-limits", workload.ConfigLimits, "The default resource limits of the proxy container, like cpu=1,memory=1Gi."},
+	{"default-first-port", workload.ConfigFirstPort, "The first port assigned to database instances."},
+	{"default-health-check-port", workload.ConfigHealthCheckPort, "The default port for the proxy's health checks."},
+	{"default-admin-port", workload.ConfigAdminPort, "The default port for the proxy's admin server."},
+	{"pause-injection", workload.ConfigPauseInjection, "Set to true to stop adding proxies to new pods and updating workloads."},
+	{"annotation-injection-namespaces", workload.ConfigAnnotationInjectionNamespaces, "A label selector for the namespaces where pods may request a proxy with annotations. Empty turns annotation injection off."},
+	{"max-concurrent-rollouts", workload.ConfigMaxConcurrentRollouts, "The maximum number of workloads that roll out a new proxy configuration at the same time. 0 means no limit. The rollouts in progress and the queue are kept in memory, so the limit may be exceeded for a while after the operator restarts or the leader changes."},
+}
+
 func main() {
+	// The preview command shows how the operator would configure workloads,
+	// without running the operator.
+	if len(os.Args) > 1 && os.Args[1] == "preview" {
+		os.Exit(preview.Main(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var defaultsConfigMap string
+	defaults := map[string]*string{}
+	for _, f := range defaultsFlags {
+		defaults[f.key] = flag.String(f.name, "", f.usage)
+	}
+	var defaultEnv []string
+	flag.Func("default-proxy-env", "An env var NAME=value added to every proxy container. May be repeated.", func(v string) error {
+		defaultEnv = append(defaultEnv, v)
+		return nil
+	})
+	flag.StringVar(&defaultsConfigMap, "defaults-config-map", "",
+		"The namespace/name of a ConfigMap holding the proxy container defaults. "+
+			"Values in the ConfigMap take precedence over the command line, and changes are rolled out to workloads.")
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
@@ -67,8 +114,58 @@ func main() {
 	ctrl.Log.Info(fmt.Sprintf("Version: %v Build: %v", version, buildID))
 	ctrl.Log.Info(fmt.Sprintf("Runtime: %v %v/%v", runtime.Version(), runtime.GOOS, runtime.GOARCH))
 
+	// Read the proxy container defaults from the command line.
+	data := map[string]string{}
+	for k, v := range defaults {
+		if *v != "" {
+			data[k] = *v
+		}
+	}
+	if len(defaultEnv) > 0 {
+		data[workload.ConfigEnv] = strings.Join(defaultEnv, "\n")
+	}
+	cfg, err := workload.DefaultConfig().Apply(data)
+	if err != nil {
+		setupLog.Error(err, "invalid proxy container defaults")
+		os.Exit(1)
+	}
+
+	// Only cache the ControllerRevisions that record the revisions of
+	// AuthProxyWorkloads, not the ones of StatefulSets and DaemonSets.
+	revisionOwner, err := labels.NewRequirement(cloudsqlapi.RevisionOwnerLabel, selection.Exists, nil)
+	if err != nil {
+		setupLog.Error(err, "invalid revision label selector")
+		os.Exit(1)
+	}
+
+	var configMapKey types.NamespacedName
+	cacheOpts := cache.Options{
+		ByObject: map[client.Object]cache.ByObject{
+			&corev1.Pod{}: {Transform: controller.TrimPod},
+			&appsv1.ControllerRevision{}: {
+				Label: labels.NewSelector().Add(*revisionOwner),
+			},
+		},
+	}
+	if defaultsConfigMap != "" {
+		ns, name, ok := strings.Cut(defaultsConfigMap, "/")
+		if !ok || ns == "" || name == "" {
+			setupLog.Error(fmt.Errorf("got %q, want namespace/name", defaultsConfigMap), "invalid --defaults-config-map")
+			os.Exit(1)
+		}
+		configMapKey = types.NamespacedName{Namespace: ns, Name: name}
+
+		// Only cache the defaults ConfigMap, the operator doesn't read any
+		// other ConfigMaps.
+		cacheOpts.ByObject[&corev1.ConfigMap{}] = cache.ByObject{
+			Namespaces: map[string]cache.Config{ns: {}},
+			Field:      fields.OneTermEqualSelector("metadata.name", name),
+		}
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: scheme,
+		Cache:  cacheOpts,
 		Metrics: metricsserver.Options{
 			BindAddress: metricsAddr,
 		},
@@ -95,7 +192,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	err = controller.SetupManagers(mgr, userAgent, workload.DefaultProxyImage)
+	err = controller.SetupManagers(mgr, userAgent, cfg, configMapKey)
 	if err != nil {
 		setupLog.Error(err, "unable to set up the controllers")
 		os.Exit(1)